@@ -0,0 +1,155 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// how often --threads auto reconsiders the number of concurrent lookups
+const autoscaleInterval = time.Second
+
+// the share of lookups timing out above which the resolvers are taken to be
+// overloaded
+const autoscaleTimeoutRate = 0.05
+
+// workerGate limits how many of the worker goroutines may run a lookup at
+// once. Under --threads auto, the limit follows the observed timeouts,
+// latency, and backlog of inputs. All methods are no-ops on a nil
+// *workerGate, which leaves every worker free to run.
+type workerGate struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	min  int
+	max  int
+
+	limit  int
+	active int
+
+	// observed since the last adjustment
+	completed  int
+	timeouts   int
+	latency    time.Duration
+	backlogged bool
+
+	// the lowest mean latency seen so far, taken as that of a resolver
+	// that isn't overloaded
+	baseline time.Duration
+}
+
+func newWorkerGate(min int, max int) *workerGate {
+	g := &workerGate{min: min, max: max, limit: min}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// acquire blocks until the worker may run a lookup for the input it holds.
+// A worker having to wait means inputs are queuing up for capacity.
+func (g *workerGate) acquire() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	for g.active >= g.limit {
+		g.backlogged = true
+		g.cond.Wait()
+	}
+	g.active++
+	g.mu.Unlock()
+}
+
+func (g *workerGate) release() {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.active--
+	g.mu.Unlock()
+	g.cond.Signal()
+}
+
+// record notes the outcome of a lookup.
+func (g *workerGate) record(status Status, latency time.Duration) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	g.completed++
+	if status == STATUS_TIMEOUT || status == STATUS_ITER_TIMEOUT {
+		g.timeouts++
+	}
+	g.latency += latency
+	g.mu.Unlock()
+}
+
+// nextLimit decides the number of concurrent lookups for the next interval:
+// fewer when timeouts spike, more when inputs are waiting and latency hasn't
+// grown well beyond its baseline, and otherwise the same.
+func nextLimit(limit int, min int, max int, completed int, timeouts int, mean time.Duration, baseline time.Duration, backlogged bool) int {
+	switch {
+	case completed > 0 && float64(timeouts)/float64(completed) > autoscaleTimeoutRate:
+		limit = limit * 3 / 4
+	case backlogged && (baseline == 0 || mean <= 2*baseline):
+		step := limit / 4
+		if step < 1 {
+			step = 1
+		}
+		limit += step
+	}
+	if limit < min {
+		limit = min
+	}
+	if limit > max {
+		limit = max
+	}
+	return limit
+}
+
+func (g *workerGate) adjust() {
+	g.mu.Lock()
+	var mean time.Duration
+	if g.completed > 0 {
+		mean = g.latency / time.Duration(g.completed)
+	}
+	limit := nextLimit(g.limit, g.min, g.max, g.completed, g.timeouts, mean, g.baseline, g.backlogged)
+	if g.completed > 0 && (g.baseline == 0 || mean < g.baseline) {
+		g.baseline = mean
+	}
+	if limit != g.limit {
+		log.Debug("--threads auto: ", g.completed, " lookups with ", g.timeouts, " timeouts and mean latency ", mean,
+			", changing concurrent lookups from ", g.limit, " to ", limit)
+	}
+	g.limit = limit
+	g.completed, g.timeouts, g.latency, g.backlogged = 0, 0, 0, false
+	g.mu.Unlock()
+	g.cond.Broadcast()
+}
+
+// run adjusts the limit every autoscaleInterval until stop is closed.
+func (g *workerGate) run(stop <-chan struct{}) {
+	ticker := time.NewTicker(autoscaleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			g.adjust()
+		case <-stop:
+			return
+		}
+	}
+}
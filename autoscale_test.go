@@ -0,0 +1,78 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextLimit(t *testing.T) {
+	ms := time.Millisecond
+	cases := []struct {
+		desc       string
+		limit      int
+		completed  int
+		timeouts   int
+		mean       time.Duration
+		baseline   time.Duration
+		backlogged bool
+		expected   int
+	}{
+		{"backlog ramps up", 100, 1000, 0, 20 * ms, 20 * ms, true, 125},
+		{"first interval ramps up", 10, 0, 0, 0, 0, true, 12},
+		{"small limits grow by one", 3, 10, 0, 20 * ms, 20 * ms, true, 10},
+		{"no backlog holds", 100, 1000, 0, 20 * ms, 20 * ms, false, 100},
+		{"latency growth holds", 100, 1000, 0, 50 * ms, 20 * ms, true, 100},
+		{"timeouts back off", 100, 1000, 100, 20 * ms, 20 * ms, true, 75},
+		{"few timeouts are tolerated", 100, 1000, 10, 20 * ms, 20 * ms, true, 125},
+		{"never above the maximum", 900, 1000, 0, 20 * ms, 20 * ms, true, 1000},
+		{"never below the minimum", 11, 1000, 500, 20 * ms, 20 * ms, false, 10},
+	}
+	for _, c := range cases {
+		if limit := nextLimit(c.limit, 10, 1000, c.completed, c.timeouts, c.mean, c.baseline, c.backlogged); limit != c.expected {
+			t.Errorf("%s: got %d, expected %d", c.desc, limit, c.expected)
+		}
+	}
+}
+
+func TestWorkerGate(t *testing.T) {
+	g := newWorkerGate(1, 2)
+	g.acquire()
+	acquired := make(chan struct{})
+	go func() {
+		g.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired a slot beyond the limit")
+	case <-time.After(10 * time.Millisecond):
+	}
+	// the waiting worker is a backlog, so the limit grows and lets it run
+	g.adjust()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("raising the limit didn't let the waiting worker run")
+	}
+	g.release()
+	g.release()
+
+	var nilGate *workerGate
+	nilGate.acquire()
+	nilGate.record(STATUS_TIMEOUT, time.Second)
+	nilGate.release()
+}
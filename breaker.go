@@ -0,0 +1,247 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	// the cooldown is over, and a single probe query decides whether the
+	// breaker closes or opens again
+	breakerHalfOpen
+)
+
+// breaker tracks the outcomes of the latest queries sent to one name server.
+type breaker struct {
+	mu    sync.Mutex
+	state breakerState
+	// ring of the latest outcomes, true for a failure
+	outcomes []bool
+	next     int
+	filled   bool
+	failures int
+	// when an open breaker may let a probe through, or when an unanswered
+	// probe is given up on
+	until   time.Time
+	probing bool
+}
+
+// reset forgets the outcomes recorded so far.
+func (b *breaker) reset() {
+	for i := range b.outcomes {
+		b.outcomes[i] = false
+	}
+	b.next, b.filled, b.failures = 0, false, 0
+}
+
+// CircuitBreaker stops picking name servers whose recent queries mostly
+// failed. Once more than a threshold of the latest queries sent to a server
+// timed out or got SERVFAIL, its breaker opens and the server is skipped for
+// a cooldown. A single probe query is then let through: the breaker closes if
+// it succeeds, and opens again if it fails. It is safe for use by all worker
+// goroutines.
+type CircuitBreaker struct {
+	threshold float64
+	cooldown  time.Duration
+	now       func() time.Time
+	// populated once at construction and read-only afterwards
+	breakers map[string]*breaker
+}
+
+// NewCircuitBreaker tracks the last window queries sent to each of
+// nameServers, opening a server's breaker for cooldown when the fraction of
+// them that failed reaches threshold.
+func NewCircuitBreaker(nameServers []string, threshold float64, window int, cooldown time.Duration) *CircuitBreaker {
+	c := &CircuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		breakers:  make(map[string]*breaker, len(nameServers)),
+	}
+	for _, ns := range nameServers {
+		c.breakers[ns] = &breaker{outcomes: make([]bool, window)}
+	}
+	return c
+}
+
+// claim reports whether a query may be sent to nameServer: its breaker is
+// closed, or its cooldown is over and nobody has claimed the probe yet. In
+// the latter case, probe is set, and the caller is the one to send it.
+func (c *CircuitBreaker) claim(nameServer string) (ok bool, probe bool) {
+	b, tracked := c.breakers[nameServer]
+	if !tracked {
+		return true, false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed {
+		return true, false
+	}
+	if c.now().Before(b.until) {
+		return false, false
+	}
+	c.startProbe(b, nameServer)
+	return true, true
+}
+
+// startProbe lets a single probe through the breaker of nameServer, whose
+// cooldown is over. b.mu must be held.
+func (c *CircuitBreaker) startProbe(b *breaker, nameServer string) {
+	if b.state == breakerOpen {
+		log.Infof("circuit breaker for %s is half-open, probing it", nameServer)
+	}
+	// a probe that never reports back is given up on after another cooldown
+	b.state = breakerHalfOpen
+	b.probing = true
+	b.until = c.now().Add(c.cooldown)
+}
+
+// Pick narrows servers, and their weights if any, to those whose breaker lets
+// queries through. A server whose breaker is due a probe is claimed for it
+// and returned on its own, so that concurrent callers can't all send it one.
+// If every breaker is open, all servers are kept, so that lookups carry on.
+// Pick is a no-op on a nil *CircuitBreaker.
+func (c *CircuitBreaker) Pick(servers []string, weights []int) ([]string, []int) {
+	if c == nil {
+		return servers, weights
+	}
+	weighted := len(weights) == len(servers)
+	var open []int
+	for i, ns := range servers {
+		ok, probe := c.claim(ns)
+		if probe {
+			if weighted {
+				return servers[i : i+1], weights[i : i+1]
+			}
+			return servers[i : i+1], nil
+		}
+		if !ok {
+			open = append(open, i)
+		}
+	}
+	if len(open) == 0 || len(open) == len(servers) {
+		return servers, weights
+	}
+	picked := make([]string, 0, len(servers)-len(open))
+	var pickedWeights []int
+	for i, ns := range servers {
+		if len(open) > 0 && open[0] == i {
+			open = open[1:]
+			continue
+		}
+		picked = append(picked, ns)
+		if weighted {
+			pickedWeights = append(pickedWeights, weights[i])
+		}
+	}
+	return picked, pickedWeights
+}
+
+// Skipping reports whether the breaker of nameServer is open and its cooldown
+// isn't over yet, without claiming a probe. Skipping is false on a nil
+// *CircuitBreaker.
+func (c *CircuitBreaker) Skipping(nameServer string) bool {
+	if c == nil {
+		return false
+	}
+	b, ok := c.breakers[nameServer]
+	if !ok {
+		return false
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == breakerOpen && c.now().Before(b.until)
+}
+
+// Sending tells the breaker of nameServer that a query is about to be sent
+// to it. If the breaker's cooldown is over and Pick hasn't claimed the probe,
+// the query becomes its probe.
+func (c *CircuitBreaker) Sending(nameServer string) {
+	if c == nil {
+		return
+	}
+	b, ok := c.breakers[nameServer]
+	if !ok {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == breakerClosed || c.now().Before(b.until) {
+		return
+	}
+	c.startProbe(b, nameServer)
+}
+
+// Record adds the status of a query sent to nameServer to its breaker.
+// Servers that weren't configured (e.g., authoritative servers discovered
+// during iteration) aren't tracked. Record is a no-op on a nil
+// *CircuitBreaker.
+func (c *CircuitBreaker) Record(nameServer string, status Status) {
+	if c == nil {
+		return
+	}
+	b, ok := c.breakers[nameServer]
+	if !ok {
+		return
+	}
+	failed := status == STATUS_TIMEOUT || status == STATUS_TEMPORARY || status == STATUS_SERVFAIL
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		// queries sent before the breaker opened don't count
+		return
+	case breakerHalfOpen:
+		if !b.probing {
+			return
+		}
+		b.probing = false
+		if failed {
+			b.state = breakerOpen
+			b.until = c.now().Add(c.cooldown)
+			log.Warnf("circuit breaker for %s reopened: the probe got %s, pausing it for %s", nameServer, status, c.cooldown)
+			return
+		}
+		b.state = breakerClosed
+		b.reset()
+		log.Infof("circuit breaker for %s closed: the probe succeeded", nameServer)
+		return
+	}
+	if b.outcomes[b.next] {
+		b.failures--
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+	if b.next == 0 {
+		b.filled = true
+	}
+	if b.filled && float64(b.failures) >= c.threshold*float64(len(b.outcomes)) {
+		log.Warnf("circuit breaker for %s opened: %d of the last %d queries failed, pausing it for %s", nameServer, b.failures, len(b.outcomes), c.cooldown)
+		b.state = breakerOpen
+		b.until = c.now().Add(c.cooldown)
+		b.reset()
+	}
+}
@@ -0,0 +1,121 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := NewCircuitBreaker([]string{"192.0.2.1:53", "192.0.2.2:53"}, 0.5, 4, time.Minute)
+	c.now = func() time.Time { return now }
+	servers := []string{"192.0.2.1:53", "192.0.2.2:53"}
+
+	// a single failure out of four doesn't open the breaker
+	for _, status := range []Status{STATUS_TIMEOUT, STATUS_NOERROR, STATUS_NOERROR, STATUS_NXDOMAIN} {
+		c.Record("192.0.2.1:53", status)
+	}
+	if picked, _ := c.Pick(servers, nil); len(picked) != 2 {
+		t.Fatalf("breaker opened early: %v", picked)
+	}
+	c.Record("192.0.2.1:53", STATUS_SERVFAIL)
+	if picked, _ := c.Pick(servers, nil); len(picked) != 2 {
+		t.Fatalf("breaker opened at 2 failures of 4: %v", picked)
+	}
+	c.Record("192.0.2.1:53", STATUS_TEMPORARY)
+	if !c.Skipping("192.0.2.1:53") || c.Skipping("192.0.2.2:53") {
+		t.Fatal("expected only the failing server to be skipped")
+	}
+	picked, weights := c.Pick(servers, []int{3, 1})
+	if !reflect.DeepEqual(picked, []string{"192.0.2.2:53"}) || !reflect.DeepEqual(weights, []int{1}) {
+		t.Fatalf("open server wasn't skipped: %v %v", picked, weights)
+	}
+
+	// once the cooldown is over, a single probe is let through
+	now = now.Add(time.Minute)
+	picked, weights = c.Pick(servers, []int{3, 1})
+	if !reflect.DeepEqual(picked, []string{"192.0.2.1:53"}) || !reflect.DeepEqual(weights, []int{3}) {
+		t.Fatalf("server wasn't probed after the cooldown: %v %v", picked, weights)
+	}
+	if c.Skipping("192.0.2.1:53") {
+		t.Fatal("server is still skipped after the cooldown")
+	}
+	c.Sending("192.0.2.1:53")
+	if picked, _ := c.Pick(servers, nil); !reflect.DeepEqual(picked, []string{"192.0.2.2:53"}) {
+		t.Fatalf("more than one probe was let through: %v", picked)
+	}
+	c.Record("192.0.2.1:53", STATUS_TIMEOUT)
+	if picked, _ := c.Pick(servers, nil); len(picked) != 1 {
+		t.Fatalf("breaker didn't reopen after a failed probe: %v", picked)
+	}
+	now = now.Add(time.Minute)
+	c.Sending("192.0.2.1:53")
+	c.Record("192.0.2.1:53", STATUS_NOERROR)
+	if picked, _ := c.Pick(servers, nil); len(picked) != 2 {
+		t.Fatalf("breaker didn't close after a successful probe: %v", picked)
+	}
+
+	// with every breaker open, all servers are still picked
+	for _, ns := range servers {
+		for i := 0; i < 4; i++ {
+			c.Record(ns, STATUS_TIMEOUT)
+		}
+	}
+	if picked, _ := c.Pick(servers, nil); len(picked) != 2 {
+		t.Errorf("servers were dropped with every breaker open: %v", picked)
+	}
+
+	var none *CircuitBreaker
+	if picked, _ := none.Pick(servers, nil); len(picked) != 2 || none.Skipping("192.0.2.1:53") {
+		t.Error("nil circuit breaker dropped servers")
+	}
+	none.Record("192.0.2.1:53", STATUS_TIMEOUT)
+}
+
+func TestCircuitBreakerConcurrentProbe(t *testing.T) {
+	now := time.Unix(0, 0)
+	servers := []string{"192.0.2.1:53", "192.0.2.2:53"}
+	c := NewCircuitBreaker(servers, 0.5, 2, time.Minute)
+	c.now = func() time.Time { return now }
+	c.Record("192.0.2.1:53", STATUS_TIMEOUT)
+	c.Record("192.0.2.1:53", STATUS_TIMEOUT)
+	now = now.Add(time.Minute)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	probes := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			picked, _ := c.Pick(servers, nil)
+			for _, ns := range picked {
+				if ns == "192.0.2.1:53" {
+					mu.Lock()
+					probes++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if probes != 1 {
+		t.Errorf("expected a single probe, %d callers picked the server", probes)
+	}
+}
@@ -57,6 +57,7 @@ func (c *CacheHash) Add(k interface{}, v interface{}) bool {
 		kv := e.Value.(keyValue)
 		kv.Key = k
 		kv.Value = v
+		e.Value = kv
 		c.l.MoveToFront(e)
 	} else {
 		if c.len >= c.maxLen {
@@ -125,6 +126,14 @@ func (c *CacheHash) Delete(k interface{}) (interface{}, bool) {
 	return kv.Value, true
 }
 
+// Range calls f for each entry, from the least to the most recently used.
+func (c *CacheHash) Range(f func(interface{}, interface{})) {
+	for e := c.l.Back(); e != nil; e = e.Prev() {
+		kv := e.Value.(keyValue)
+		f(kv.Key, kv.Value)
+	}
+}
+
 func (c *CacheHash) Len() int {
 	return c.len
 }
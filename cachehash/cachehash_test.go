@@ -113,3 +113,29 @@ func TestEject(t *testing.T) {
 		t.Error("Ejected element not removed from hash")
 	}
 }
+
+func TestAddUpdates(t *testing.T) {
+	ch := new(CacheHash)
+	ch.Init(5)
+	ch.Add("key1", "value1")
+	ch.Add("key1", "value2")
+	if v, ok := ch.Get("key1"); !ok || v != "value2" {
+		t.Error("value not updated on add")
+	}
+}
+
+func TestRange(t *testing.T) {
+	ch := new(CacheHash)
+	ch.Init(5)
+	ch.Add("key1", "value1")
+	ch.Add("key2", "value2")
+	ch.Add("key3", "value3")
+	ch.Get("key1")
+	var keys []interface{}
+	ch.Range(func(k interface{}, v interface{}) {
+		keys = append(keys, k)
+	})
+	if len(keys) != 3 || keys[0] != "key2" || keys[1] != "key3" || keys[2] != "key1" {
+		t.Error("range not in least to most recently used order: ", keys)
+	}
+}
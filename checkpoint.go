@@ -0,0 +1,101 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Checkpoint is the on-disk record of scan progress. Every input line before
+// Lines has been fully processed, as have the (out of order) lines listed in
+// Completed. Line numbers start at zero.
+type Checkpoint struct {
+	Lines     int   `json:"lines"`
+	Completed []int `json:"completed,omitempty"`
+}
+
+type checkpointer struct {
+	mu        sync.Mutex
+	path      string
+	lines     int
+	completed map[int]bool
+}
+
+func newCheckpointer(path string) *checkpointer {
+	return &checkpointer{
+		path:      path,
+		completed: make(map[int]bool),
+	}
+}
+
+// loadCheckpointer restores progress from a previously written checkpoint.
+func loadCheckpointer(path string) (*checkpointer, error) {
+	c := newCheckpointer(path)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cp Checkpoint
+	if err := json.Unmarshal(raw, &cp); err != nil {
+		return nil, err
+	}
+	c.lines = cp.Lines
+	for _, line := range cp.Completed {
+		c.completed[line] = true
+	}
+	return c, nil
+}
+
+// skip reports whether a line was already processed in a previous run.
+func (c *checkpointer) skip(line int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return line < c.lines || c.completed[line]
+}
+
+// done marks a line as processed and its output as written.
+func (c *checkpointer) done(line int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.completed[line] = true
+	for c.completed[c.lines] {
+		delete(c.completed, c.lines)
+		c.lines++
+	}
+}
+
+// save atomically replaces the checkpoint file with the current progress.
+func (c *checkpointer) save() error {
+	c.mu.Lock()
+	cp := Checkpoint{Lines: c.lines}
+	for line := range c.completed {
+		cp.Completed = append(cp.Completed, line)
+	}
+	c.mu.Unlock()
+	sort.Ints(cp.Completed)
+	raw, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path)
+}
@@ -0,0 +1,67 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpointDone(t *testing.T) {
+	c := newCheckpointer("")
+	c.done(1)
+	c.done(3)
+	if c.lines != 0 {
+		t.Errorf("Expected no contiguous progress, got %d lines", c.lines)
+	}
+	c.done(0)
+	if c.lines != 2 {
+		t.Errorf("Expected 2 contiguous lines, got %d", c.lines)
+	}
+	if !c.skip(0) || !c.skip(1) || c.skip(2) || !c.skip(3) {
+		t.Error("Unexpected skip results")
+	}
+}
+
+func TestCheckpointSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zdns-checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "checkpoint.json")
+
+	c := newCheckpointer(path)
+	for _, line := range []int{0, 1, 2, 5, 7} {
+		c.done(line)
+	}
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := loadCheckpointer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.lines != 3 {
+		t.Errorf("Expected 3 contiguous lines, got %d", restored.lines)
+	}
+	for line, skip := range map[int]bool{2: true, 3: false, 5: true, 6: false, 7: true} {
+		if restored.skip(line) != skip {
+			t.Errorf("Unexpected skip result for line %d", line)
+		}
+	}
+}
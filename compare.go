@@ -0,0 +1,90 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+// NameServerSetter is implemented by lookups that can be pointed at a
+// particular name server, as --compare-servers needs.
+type NameServerSetter interface {
+	SetNameServer(nameServer string)
+}
+
+// CompareResult reports whether the --compare-servers agree on a name.
+type CompareResult struct {
+	// both servers returned the same status and, ignoring order, the same
+	// answers
+	Agree   bool            `json:"agree" groups:"short,normal,long,trace"`
+	Servers []ServerAnswers `json:"servers" groups:"short,normal,long,trace"`
+}
+
+// ServerAnswers is what one of the --compare-servers returned.
+type ServerAnswers struct {
+	Server  string   `json:"server" groups:"short,normal,long,trace"`
+	Status  string   `json:"status" groups:"short,normal,long,trace"`
+	Error   string   `json:"error,omitempty" groups:"short,normal,long,trace"`
+	Answers []string `json:"answers,omitempty" groups:"short,normal,long,trace"`
+	// the answers that the other server didn't return, if they disagree
+	Unique []string `json:"unique_answers,omitempty" groups:"short,normal,long,trace"`
+}
+
+// compareLookup looks name up against each of servers and compares the
+// answers, which are rendered as for --repeat. The status is NOERROR if any
+// server answered successfully and otherwise that of the last one, along with
+// its error. Traces are concatenated.
+func compareLookup(l NameServerSetter, lookup func(string) (interface{}, []interface{}, Status, error), name string, servers []string) (interface{}, []interface{}, Status, error) {
+	res := CompareResult{Agree: true}
+	var trace []interface{}
+	status := STATUS_ERROR
+	var err error
+	sets := make([]map[string]bool, len(servers))
+	for i, server := range servers {
+		l.SetNameServer(server)
+		innerRes, t, s, e := lookup(name)
+		trace = append(trace, t...)
+		if status != STATUS_NOERROR {
+			status, err = s, e
+		}
+		answers := ServerAnswers{Server: server, Status: string(s)}
+		if e != nil {
+			answers.Error = e.Error()
+		}
+		sets[i] = make(map[string]bool)
+		if s == STATUS_NOERROR {
+			for _, a := range answerSet(innerRes) {
+				if !sets[i][a] {
+					sets[i][a] = true
+					answers.Answers = append(answers.Answers, a)
+				}
+			}
+		}
+		res.Servers = append(res.Servers, answers)
+	}
+	for i := range res.Servers {
+		if res.Servers[i].Status != res.Servers[0].Status {
+			res.Agree = false
+		}
+		for _, a := range res.Servers[i].Answers {
+			for j := range sets {
+				if j != i && !sets[j][a] {
+					res.Servers[i].Unique = append(res.Servers[i].Unique, a)
+					break
+				}
+			}
+		}
+		if len(res.Servers[i].Unique) > 0 {
+			res.Agree = false
+		}
+	}
+	return res, trace, status, err
+}
@@ -0,0 +1,85 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fakeServerLookup struct {
+	server    string
+	responses map[string]fakeAnswers
+}
+
+func (l *fakeServerLookup) SetNameServer(nameServer string) {
+	l.server = nameServer
+}
+
+func (l *fakeServerLookup) lookup(name string) (interface{}, []interface{}, Status, error) {
+	answers, ok := l.responses[l.server]
+	if !ok {
+		return nil, nil, STATUS_TIMEOUT, errors.New("timeout")
+	}
+	return answers, []interface{}{l.server}, STATUS_NOERROR, nil
+}
+
+func TestCompareLookupAgree(t *testing.T) {
+	l := &fakeServerLookup{responses: map[string]fakeAnswers{
+		"1.1.1.1:53": {"192.0.2.1", "192.0.2.2"},
+		"8.8.8.8:53": {"192.0.2.2", "192.0.2.1", "192.0.2.1"},
+	}}
+	innerRes, trace, status, err := compareLookup(l, l.lookup, "example.com", []string{"1.1.1.1:53", "8.8.8.8:53"})
+	if status != STATUS_NOERROR || err != nil {
+		t.Errorf("expected NOERROR, got %s (%v)", status, err)
+	}
+	if len(trace) != 2 {
+		t.Errorf("expected 2 trace steps, got %d", len(trace))
+	}
+	res := innerRes.(CompareResult)
+	if !res.Agree {
+		t.Errorf("expected the servers to agree, got %+v", res)
+	}
+	for _, s := range res.Servers {
+		if !reflect.DeepEqual(s.Answers, []string{"192.0.2.1", "192.0.2.2"}) || s.Unique != nil {
+			t.Errorf("unexpected answers from %s: %+v", s.Server, s)
+		}
+	}
+}
+
+func TestCompareLookupDisagree(t *testing.T) {
+	l := &fakeServerLookup{responses: map[string]fakeAnswers{
+		"1.1.1.1:53": {"192.0.2.1", "192.0.2.2"},
+		"8.8.8.8:53": {"192.0.2.2", "198.51.100.1"},
+	}}
+	innerRes, _, _, _ := compareLookup(l, l.lookup, "example.com", []string{"1.1.1.1:53", "8.8.8.8:53"})
+	res := innerRes.(CompareResult)
+	if res.Agree {
+		t.Error("expected the servers to disagree")
+	}
+	if !reflect.DeepEqual(res.Servers[0].Unique, []string{"192.0.2.1"}) || !reflect.DeepEqual(res.Servers[1].Unique, []string{"198.51.100.1"}) {
+		t.Errorf("unexpected differences: %+v", res.Servers)
+	}
+
+	innerRes, _, status, err := compareLookup(l, l.lookup, "example.com", []string{"1.1.1.1:53", "9.9.9.9:53"})
+	res = innerRes.(CompareResult)
+	if status != STATUS_NOERROR || err != nil {
+		t.Errorf("expected NOERROR as one server answered, got %s (%v)", status, err)
+	}
+	if res.Agree || res.Servers[1].Status != "TIMEOUT" || res.Servers[1].Error != "timeout" {
+		t.Errorf("expected a failed server to disagree, got %+v", res)
+	}
+}
@@ -14,43 +14,202 @@
 
 package zdns
 
-import "time"
+import (
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+)
 
 type GlobalConf struct {
 	Threads             int
+	AutoThreads         bool
+	MinThreads          int
 	Timeout             time.Duration
 	IterationTimeout    time.Duration
 	Retries             int
+	RetryBackoff        time.Duration
+	RcodeRetryBackoff   time.Duration
+	RetryRcodes         []Status
+	TimeoutRetries      int
+	ServfailRetries     int
+	RefusedRetries      int
+	FilterStatuses      []Status
 	AlexaFormat         bool
 	IterativeResolution bool
+	DNSSECValidate      bool
+	QNameMinimization   bool
+	NoRecurse           bool
+	TrustAnchorFile     string
+	// header bits to set in every query
+	QueryFlags QueryFlags
 
 	ResultVerbosity string
 	IncludeInOutput string
 	OutputGroups    []string
+	// top-level result keys to rename in the output
+	FieldMap map[string]string
+	// bounds on the TTLs reported in the output. A MaxTTL of 0 means none
+	MinTTL uint32
+	MaxTTL uint32
+	// output this fraction of results, picked by hashing their names with
+	// SampleSeed
+	SampleRate float64
+	SampleSeed int64
 
 	MaxDepth             int
+	MaxQueriesPerName    int
 	CacheSize            int
+	CacheFile            string
+	WarmCache            int
 	GoMaxProcs           int
 	Verbosity            int
 	TimeFormat           string
-	PassedName           string
+	PassedNames          []string
 	NameServersSpecified bool
 	NameServers          []string
-	TCPOnly              bool
-	UDPOnly              bool
+	// relative share of queries sent to each name server. nil means uniform
+	NameServerWeights []int
+	RaceServers       int
+	TCPOnly           bool
+	UDPOnly           bool
+	// report truncated UDP responses instead of retrying them over TCP
+	NoTCPFallback bool
+	// tunnel TCP queries through this SOCKS5 proxy. UDP isn't proxied
+	SOCKS5 *SOCKS5Proxy
 
 	InputHandler  string
 	OutputHandler string
+	// the --output-handler list, each of which is sent every result
+	OutputHandlers []string
+	OutputFormat   string
+	// check that each result is valid JSON before the file output handler
+	// writes it
+	ValidateOutput bool
+	// how the file output handler compresses its stream: auto (gzip if
+	// OutputFilePath ends in .gz, zstd if it ends in .zst), none, gzip, or
+	// zstd
+	OutputCompression string
+
+	// have the file input handler take names from this 1-based column of
+	// delimited lines (e.g., CSV), passing the other columns through to the
+	// results if InputPassthrough is set. 0 reads whole lines
+	InputColumn      int
+	InputDelimiter   rune
+	InputPassthrough bool
 
 	InputFilePath    string
 	OutputFilePath   string
 	LogFilePath      string
 	MetadataFilePath string
 
+	RedisAddr        string
+	RedisPassword    string `json:"-"`
+	RedisKey         string
+	RedisIdleTimeout time.Duration
+
+	S3Input    string
+	S3Region   string
+	S3Endpoint string
+
+	HTTPInputURL string
+	// credentials for the http input handler, kept out of the metadata
+	HTTPInputBasicAuth string `json:"-"`
+	HTTPInputToken     string `json:"-"`
+
+	HTTPOutputURL     string
+	HTTPBatchSize     int
+	HTTPFlushInterval time.Duration
+	// how long the http output handler waits for each batch to be accepted
+	HTTPTimeout time.Duration
+
+	AvroCodec      string
+	AvroSchemaFile string
+
+	CheckpointFilePath string
+	Resume             bool
+	MaxRuntime         time.Duration
+	DedupeInput        bool
+	DedupeMaxNames     int
+	ShuffleInput       bool
+	ShuffleWindow      int
+	Progress           bool
+	// write results in input order, holding at most ReorderWindow of them
+	PreserveOrder bool
+	ReorderWindow int
+	// files of names that may or may not be looked up
+	NameAllowlist string
+	NameBlocklist string
+
+	// look each name up this many times and aggregate the answers
+	Repeat      int
+	RepeatDelay time.Duration
+	// look each name up against both of these servers and compare the answers
+	CompareServers []string
+	// send all queries for an input name to one server, picked by hash or
+	// round-robin
+	StickyServer string
+
+	// sign queries and zone transfers, and verify the responses
+	TSIG *TSIGKey `json:"-"`
+
+	// record the queries for the first few names instead of sending them
+	DryRun    bool
+	QueryPlan *QueryPlan `json:"-"`
+
+	// look up internationalized names as given, instead of converting
+	// them to A-labels
+	NoIDNA bool
+
 	NamePrefix string
+	// domain appended to input names that aren't absolute
+	NameSuffix string
+	// --prefixes entries, each looked up for every input name
+	NamePrefixes []string
 
 	Module string
 	Class  uint16
+
+	// module settings, keyed by flag name, for lookups run through a Resolver
+	ModuleOptions map[string]string `json:"-"`
+
+	ClientSubnet   *dns.EDNS0_SUBNET
+	UDPPayloadSize uint16
+	// raw options attached to every query (--edns-option)
+	EDNSOptions []*dns.EDNS0_LOCAL
+	// local addresses to send queries from, in turn
+	LocalAddrs []net.IP
+
+	DNSCookies bool
+	Cookies    *CookieJar `json:"-"`
+
+	// randomize the case of query names (DNS 0x20) and check it is echoed
+	RandomizeCase bool
+
+	// attach each response in wire format to its result
+	RawResponse bool
+	// attach the records of every answer section received for a name to
+	// its result
+	FullAnswer bool
+
+	MetricsListen string
+	Metrics       *Metrics `json:"-"`
+
+	MaxQPSPerServer float64
+	RateLimiter     *RateLimiter `json:"-"`
+
+	// stop picking name servers once this fraction of their last
+	// BreakerWindow queries failed, for BreakerCooldown. 0 disables it
+	BreakerThreshold float64
+	BreakerWindow    int
+	BreakerCooldown  time.Duration
+	CircuitBreaker   *CircuitBreaker `json:"-"`
+
+	TCPMaxIdle      int
+	TCPMaxPipelined int
+	TCPIdleTimeout  time.Duration
+	TCPFastOpen     bool
+	TCPPool         *ConnPool `json:"-"`
 }
 
 type Metadata struct {
@@ -62,16 +221,62 @@ type Metadata struct {
 	Timeout     int            `json:"timeout"`
 	Retries     int            `json:"retries"`
 	Conf        *GlobalConf    `json:"conf"`
+	ResumedFrom int            `json:"resumed_from,omitempty"`
+	// inputs read but not looked up because --max-runtime was reached
+	Skipped int `json:"skipped,omitempty"`
+	// --dedupe-max-names was reached, so some duplicates were looked up again
+	DedupeTruncated bool `json:"dedupe_truncated,omitempty"`
+	// the scan was stopped early by SIGINT or SIGTERM
+	Interrupted bool `json:"interrupted,omitempty"`
+	// errors of output handlers that failed while the others carried on
+	OutputErrors map[string]string `json:"output_errors,omitempty"`
+	// results written out of input order because --reorder-window was full
+	LateResults int `json:"late_results,omitempty"`
+	// results left out of the output by --sample-rate, which still count in
+	// names and statuses
+	SampledOut int `json:"sampled_out,omitempty"`
+	// results dropped by --validate-output because they weren't valid JSON
+	InvalidResults int `json:"invalid_results,omitempty"`
+	// how often the cache answered the queries of iterative lookups
+	Cache *CacheStats `json:"cache,omitempty"`
+}
+
+// CacheStats count the queries of iterative lookups answered from the cache
+// (hits) and those sent to a name server instead (misses).
+type CacheStats struct {
+	Hits    uint64  `json:"hits"`
+	Misses  uint64  `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// ResponseFlags are the header bits of a response, under the names dig gives
+// them.
+type ResponseFlags struct {
+	Authoritative      bool `json:"aa" groups:"flags"`
+	Truncated          bool `json:"tc" groups:"flags"`
+	RecursionDesired   bool `json:"rd" groups:"flags"`
+	RecursionAvailable bool `json:"ra" groups:"flags"`
+	AuthenticatedData  bool `json:"ad" groups:"flags"`
+	CheckingDisabled   bool `json:"cd" groups:"flags"`
 }
 
 type Result struct {
 	AlteredName string        `json:"altered_name,omitempty" groups:"short,normal,long,trace"`
+	Expansion   string        `json:"expansion,omitempty" groups:"short,normal,long,trace"`
 	Name        string        `json:"name,omitempty" groups:"short,normal,long,trace"`
+	UnicodeName string        `json:"unicode_name,omitempty" groups:"short,normal,long,trace"`
 	Nameserver  string        `json:"nameserver,omitempty" groups:"normal,long,trace"`
+	Proxy       string        `json:"proxy,omitempty" groups:"normal,long,trace"`
 	Class       string        `json:"class,omitempty" groups:"long,trace"`
 	AlexaRank   int           `json:"alexa_rank,omitempty" groups:"short,normal,long,trace"`
+	Columns     []string      `json:"columns,omitempty" groups:"short,normal,long,trace"`
 	Status      string        `json:"status,omitempty" groups:"short,normal,long,trace"`
 	Error       string        `json:"error,omitempty" groups:"short,normal,long,trace"`
+	ErrorDetail string        `json:"error_detail,omitempty" groups:"short,normal,long,trace"`
+	Queries     int           `json:"queries,omitempty" groups:"short,normal,long,trace"`
+	SOA         interface{}   `json:"soa,omitempty" groups:"short,normal,long,trace"`
+	Flags       interface{}   `json:"flags,omitempty" groups:"flags"`
+	FullAnswer  []interface{} `json:"full_answer,omitempty" groups:"short,normal,long,trace"`
 	Timestamp   string        `json:"timestamp,omitempty" groups:"short,normal,long,trace"`
 	Data        interface{}   `json:"data,omitempty" groups:"short,normal,long,trace"`
 	Trace       []interface{} `json:"trace,omitempty" groups:"trace"`
@@ -100,8 +305,29 @@ const (
 	STATUS_TRUNCATED     Status = "TRUNCATED"
 	STATUS_NXDOMAIN      Status = "NXDOMAIN"
 	STATUS_REFUSED       Status = "REFUSED"
+	STATUS_NO_SERVICE    Status = "NO_SERVICE"
+	STATUS_DUPLICATE     Status = "DUPLICATE"
+	STATUS_CASE_MISMATCH Status = "CASE_MISMATCH"
+	STATUS_CNAME_LOOP    Status = "CNAME_LOOP"
+	STATUS_NULL_MX       Status = "NULL_MX"
+	STATUS_MULTI_RECORD  Status = "MULTIPLE_RECORDS"
+	STATUS_SKIPPED       Status = "SKIPPED"
+	STATUS_DRY_RUN       Status = "DRY_RUN"
+	STATUS_TSIG_ERROR    Status = "TSIG_ERROR"
+	STATUS_NODATA        Status = "NODATA"
+	STATUS_ID_MISMATCH   Status = "ID_MISMATCH"
+	STATUS_QUERY_LIMIT   Status = "QUERY_LIMIT"
+	STATUS_QUIC_ERROR    Status = "QUIC_ERROR"
 )
 
+// statuses that lookups report besides the names of DNS response codes
+var otherStatuses = []Status{STATUS_ERROR, STATUS_AUTHFAIL, STATUS_NO_RECORD,
+	STATUS_BLACKLIST, STATUS_NO_OUTPUT, STATUS_NO_ANSWER, STATUS_ILLEGAL_INPUT,
+	STATUS_TIMEOUT, STATUS_ITER_TIMEOUT, STATUS_TEMPORARY, STATUS_TRUNCATED,
+	STATUS_DUPLICATE, STATUS_CASE_MISMATCH, STATUS_CNAME_LOOP, STATUS_NULL_MX,
+	STATUS_MULTI_RECORD, STATUS_SKIPPED, STATUS_DRY_RUN, STATUS_TSIG_ERROR,
+	STATUS_NODATA, STATUS_ID_MISMATCH, STATUS_QUERY_LIMIT, STATUS_QUIC_ERROR}
+
 var RootServers = [...]string{
 	"198.41.0.4:53",
 	"192.228.79.201:53",
@@ -0,0 +1,334 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrIDInUse is returned by PooledConn.Exchange for a query whose ID another
+// query on the same connection is still waiting on. Its response couldn't be
+// told apart, so the query has to go over another connection.
+var ErrIDInUse = errors.New("message ID is in use on the connection")
+
+// timeoutError is returned for a query whose response didn't arrive by its
+// deadline. Unlike a deadline on the connection, it leaves the connection
+// open for the other queries on it.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// PooledConn is a TCP connection to a name server that carries several
+// queries at once (RFC 7766, section 6.2.1.1). Each query is written as one
+// length-prefixed message, and a single reader hands the responses, which
+// may arrive in any order, to the queries with their IDs.
+type PooledConn struct {
+	conn net.Conn
+
+	// serializes writes, so that messages aren't interleaved
+	writeMu sync.Mutex
+
+	mu      sync.Mutex
+	pending map[uint16]chan []byte
+	// why the connection stopped working, once it has
+	err error
+
+	// guarded by the pool's mutex
+	inFlight  int
+	idleSince time.Time
+	// how long it may stay idle. 0 means until the server closes it
+	timeout time.Duration
+	// no further queries are sent, and it's closed once the last is done
+	closing bool
+}
+
+func newPooledConn(conn net.Conn) *PooledConn {
+	c := &PooledConn{conn: conn, pending: make(map[uint16]chan []byte)}
+	go c.read()
+	return c
+}
+
+// LocalAddr returns the local address of the connection.
+func (c *PooledConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// Exchange sends msg, a packed DNS message with the given ID, and returns
+// the response to it. It fails with a net.Error whose Timeout is true if
+// no response arrives by deadline (zero means no deadline).
+func (c *PooledConn) Exchange(ctx context.Context, id uint16, msg []byte, deadline time.Time) ([]byte, error) {
+	response := make(chan []byte, 1)
+	c.mu.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+	if _, ok := c.pending[id]; ok {
+		c.mu.Unlock()
+		return nil, ErrIDInUse
+	}
+	c.pending[id] = response
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.pending[id] == response {
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+	}()
+
+	// TCP messages are preceded by their length, in two bytes
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	c.writeMu.Lock()
+	c.conn.SetWriteDeadline(deadline)
+	_, err := c.conn.Write(frame)
+	c.writeMu.Unlock()
+	if err != nil {
+		// a partly written message leaves the stream unusable
+		c.fail(err)
+		return nil, err
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case r, ok := <-response:
+		if !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return nil, c.err
+		}
+		return r, nil
+	case <-timeout:
+		return nil, timeoutError{}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// read hands each response to the query waiting on its ID, until the
+// connection fails. Responses that nobody waits on any more, e.g., because
+// their query timed out, are dropped.
+func (c *PooledConn) read() {
+	var length [2]byte
+	for {
+		if _, err := io.ReadFull(c.conn, length[:]); err != nil {
+			c.fail(err)
+			return
+		}
+		msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(c.conn, msg); err != nil {
+			c.fail(err)
+			return
+		}
+		if len(msg) < 2 {
+			continue
+		}
+		id := binary.BigEndian.Uint16(msg)
+		c.mu.Lock()
+		response, ok := c.pending[id]
+		delete(c.pending, id)
+		c.mu.Unlock()
+		if ok {
+			response <- msg
+		}
+	}
+}
+
+// fail closes the connection and ends the queries waiting on it with err.
+func (c *PooledConn) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+		for id, response := range c.pending {
+			close(response)
+			delete(c.pending, id)
+		}
+	}
+	c.mu.Unlock()
+	c.conn.Close()
+}
+
+// broken reports whether the connection has failed, e.g., because the server
+// closed it.
+func (c *PooledConn) broken() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err != nil
+}
+
+// ConnPool keeps TCP connections to name servers open between queries
+// (RFC 7766, section 6.2.1), so that TCP scans don't pay for a handshake and
+// an ephemeral port on every query. Queries share the connections, up to
+// maxPipelined at once on each. It is safe for use by all worker goroutines.
+type ConnPool struct {
+	maxConns     int
+	maxPipelined int
+	idleTimeout  time.Duration
+
+	mu    sync.Mutex
+	conns map[string][]*PooledConn
+}
+
+// NewConnPool returns a pool that keeps up to maxConns connections open per
+// server, each carrying up to maxPipelined queries at once, and closes them
+// once idle for idleTimeout (0 keeps them until the server closes them).
+func NewConnPool(maxConns, maxPipelined int, idleTimeout time.Duration) *ConnPool {
+	return &ConnPool{
+		maxConns:     maxConns,
+		maxPipelined: maxPipelined,
+		idleTimeout:  idleTimeout,
+		conns:        make(map[string][]*PooledConn),
+	}
+}
+
+// Get returns a connection to server with room for another query, or nil if
+// there is none and a new one has to be dialed. Connections that failed or
+// have been idle for too long are closed. Every connection returned by Get
+// must be handed back with Put. Get returns nil on a nil *ConnPool.
+func (p *ConnPool) Get(server string) *PooledConn {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var picked *PooledConn
+	kept := p.conns[server][:0]
+	for _, c := range p.conns[server] {
+		if c.broken() {
+			continue
+		}
+		if c.inFlight == 0 && c.timeout > 0 && time.Since(c.idleSince) > c.timeout {
+			c.conn.Close()
+			continue
+		}
+		kept = append(kept, c)
+		// the busiest connection with room keeps the others idle, so that
+		// they time out when the load drops
+		if !c.closing && c.inFlight < p.maxPipelined && (picked == nil || c.inFlight > picked.inFlight) {
+			picked = c
+		}
+	}
+	if len(kept) == 0 {
+		delete(p.conns, server)
+	} else {
+		p.conns[server] = kept
+	}
+	if picked != nil {
+		picked.inFlight++
+	}
+	return picked
+}
+
+// Add wraps conn, newly dialed to server, for a query, and keeps it for
+// later ones unless server already has maxConns connections. It must be
+// handed back with Put like those returned by Get. On a nil *ConnPool, the
+// connection is closed once handed back.
+func (p *ConnPool) Add(server string, conn net.Conn) *PooledConn {
+	c := newPooledConn(conn)
+	c.inFlight = 1
+	if p == nil {
+		c.closing = true
+		return c
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.conns[server]) >= p.maxConns {
+		c.closing = true
+		return c
+	}
+	c.timeout = p.idleTimeout
+	p.conns[server] = append(p.conns[server], c)
+	return c
+}
+
+// Put hands back a connection once its query is done.
+func (p *ConnPool) Put(c *PooledConn) {
+	if p == nil {
+		c.conn.Close()
+		return
+	}
+	p.PutWithTimeout(c, p.idleTimeout)
+}
+
+// PutWithTimeout is like Put, but keeps the connection idle for at most
+// timeout instead of the pool's idle timeout, e.g., for as long as the server
+// asked with the EDNS0 TCP Keepalive option (RFC 7828).
+func (p *ConnPool) PutWithTimeout(c *PooledConn, timeout time.Duration) {
+	if p == nil {
+		c.conn.Close()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.timeout = timeout
+	p.release(c)
+}
+
+// Retire hands back a connection that mustn't carry further queries, e.g.,
+// because the server asked for it to be closed. It's closed once the other
+// queries on it are done.
+func (p *ConnPool) Retire(c *PooledConn) {
+	if p == nil {
+		c.conn.Close()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c.closing = true
+	p.release(c)
+}
+
+// release ends a query on c. The caller holds p.mu.
+func (p *ConnPool) release(c *PooledConn) {
+	c.inFlight--
+	if c.inFlight > 0 {
+		return
+	}
+	c.idleSince = time.Now()
+	if c.closing {
+		c.conn.Close()
+	}
+}
+
+// Close closes all connections, failing the queries still in flight.
+func (p *ConnPool) Close() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for server, conns := range p.conns {
+		for _, c := range conns {
+			c.conn.Close()
+		}
+		delete(p.conns, server)
+	}
+}
@@ -0,0 +1,245 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func pipeConn() (net.Conn, net.Conn) {
+	return net.Pipe()
+}
+
+func isClosed(c net.Conn) bool {
+	c.SetWriteDeadline(time.Now().Add(time.Millisecond))
+	_, err := c.Write([]byte{0})
+	return err == io.ErrClosedPipe
+}
+
+// query returns a DNS message of a header with the given ID.
+func query(id uint16) []byte {
+	msg := make([]byte, 12)
+	binary.BigEndian.PutUint16(msg, id)
+	return msg
+}
+
+// readQuery reads a length-prefixed message from the server side of a
+// connection, or returns nil if the connection failed.
+func readQuery(c net.Conn) []byte {
+	var length [2]byte
+	if _, err := io.ReadFull(c, length[:]); err != nil {
+		return nil
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(c, msg); err != nil {
+		return nil
+	}
+	return msg
+}
+
+func writeResponse(c net.Conn, msg []byte) {
+	frame := make([]byte, 2+len(msg))
+	binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+	copy(frame[2:], msg)
+	c.Write(frame)
+}
+
+func TestConnPoolReuse(t *testing.T) {
+	p := NewConnPool(1, 1, time.Minute)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Fatal("empty pool returned a connection")
+	}
+	a, _ := pipeConn()
+	b, _ := pipeConn()
+	pa := p.Add("192.0.2.1:53", a)
+	// over the limit of connections, so closed once its query is done
+	pb := p.Add("192.0.2.1:53", b)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("connection shared beyond its pipelining limit")
+	}
+	p.Put(pb)
+	if !isClosed(b) {
+		t.Error("connection over the limit wasn't closed")
+	}
+	p.Put(pa)
+	if p.Get("192.0.2.2:53") != nil {
+		t.Error("connection borrowed for the wrong server")
+	}
+	if c := p.Get("192.0.2.1:53"); c != pa {
+		t.Error("idle connection wasn't reused")
+	}
+	if isClosed(a) {
+		t.Error("pooled connection was closed")
+	}
+}
+
+func TestConnPoolPipelining(t *testing.T) {
+	p := NewConnPool(1, 2, time.Minute)
+	client, server := pipeConn()
+	first := p.Add("192.0.2.1:53", client)
+	second := p.Get("192.0.2.1:53")
+	if second != first {
+		t.Fatal("connection not shared by a second query")
+	}
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("connection shared beyond its pipelining limit")
+	}
+
+	// the server answers both queries, in reverse order
+	go func() {
+		q1 := readQuery(server)
+		q2 := readQuery(server)
+		writeResponse(server, q2)
+		writeResponse(server, q1)
+	}()
+	responses := make(chan uint16, 2)
+	for _, id := range []uint16{1, 2} {
+		go func(id uint16) {
+			r, err := first.Exchange(context.Background(), id, query(id), time.Now().Add(time.Second))
+			if err != nil {
+				t.Error(err)
+				responses <- 0
+				return
+			}
+			if got := binary.BigEndian.Uint16(r); got != id {
+				t.Errorf("query %d got the response to %d", id, got)
+			}
+			responses <- id
+		}(id)
+	}
+	<-responses
+	<-responses
+	p.Put(first)
+	p.Put(second)
+	if p.Get("192.0.2.1:53") != first {
+		t.Error("connection not reused after its queries")
+	}
+}
+
+func TestPooledConnIDInUse(t *testing.T) {
+	client, server := pipeConn()
+	c := newPooledConn(client)
+	go readQuery(server)
+	done := make(chan struct{})
+	go func() {
+		c.Exchange(context.Background(), 7, query(7), time.Now().Add(100*time.Millisecond))
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := c.Exchange(context.Background(), 7, query(7), time.Now().Add(time.Second)); err != ErrIDInUse {
+		t.Errorf("expected ErrIDInUse, got %v", err)
+	}
+	<-done
+}
+
+func TestPooledConnTimeout(t *testing.T) {
+	client, server := pipeConn()
+	c := newPooledConn(client)
+	go func() {
+		readQuery(server)
+		q := readQuery(server)
+		// the first query is never answered
+		writeResponse(server, q)
+	}()
+	_, err := c.Exchange(context.Background(), 1, query(1), time.Now().Add(10*time.Millisecond))
+	if nerr, ok := err.(net.Error); !ok || !nerr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	// the connection is still usable
+	if _, err := c.Exchange(context.Background(), 2, query(2), time.Now().Add(time.Second)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPooledConnClosed(t *testing.T) {
+	p := NewConnPool(1, 2, time.Minute)
+	client, server := pipeConn()
+	c := p.Add("192.0.2.1:53", client)
+	go func() {
+		readQuery(server)
+		server.Close()
+	}()
+	if _, err := c.Exchange(context.Background(), 1, query(1), time.Now().Add(time.Second)); err == nil {
+		t.Error("expected an error once the server closed the connection")
+	}
+	p.Put(c)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("closed connection reused")
+	}
+}
+
+func TestConnPoolIdleTimeout(t *testing.T) {
+	p := NewConnPool(2, 1, time.Millisecond)
+	client, _ := pipeConn()
+	p.Put(p.Add("192.0.2.1:53", client))
+	time.Sleep(5 * time.Millisecond)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("connection reused after its idle timeout")
+	}
+}
+
+func TestConnPoolPutWithTimeout(t *testing.T) {
+	p := NewConnPool(2, 1, time.Minute)
+	client, _ := pipeConn()
+	p.PutWithTimeout(p.Add("192.0.2.1:53", client), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("connection reused after the timeout it was returned with")
+	}
+	p = NewConnPool(2, 1, time.Millisecond)
+	client, _ = pipeConn()
+	c := p.Add("192.0.2.1:53", client)
+	p.PutWithTimeout(c, time.Minute)
+	time.Sleep(5 * time.Millisecond)
+	if p.Get("192.0.2.1:53") != c {
+		t.Error("connection not kept for the timeout it was returned with")
+	}
+}
+
+func TestConnPoolRetire(t *testing.T) {
+	p := NewConnPool(1, 2, time.Minute)
+	client, _ := pipeConn()
+	first := p.Add("192.0.2.1:53", client)
+	second := p.Get("192.0.2.1:53")
+	p.Retire(first)
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("retired connection reused")
+	}
+	if isClosed(client) {
+		t.Error("retired connection closed while a query was still on it")
+	}
+	p.Put(second)
+	if !isClosed(client) {
+		t.Error("retired connection wasn't closed after its last query")
+	}
+}
+
+func TestNilConnPool(t *testing.T) {
+	var p *ConnPool
+	if p.Get("192.0.2.1:53") != nil {
+		t.Error("nil pool returned a connection")
+	}
+	client, _ := pipeConn()
+	p.Put(p.Add("192.0.2.1:53", client))
+	if !isClosed(client) {
+		t.Error("nil pool didn't close the connection")
+	}
+	p.Close()
+}
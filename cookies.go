@@ -0,0 +1,65 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// CookieJar holds the DNS Cookies (RFC 7873) of a scan: a client cookie for
+// each name server, derived from a secret chosen at random for the scan, and
+// the server cookie each name server last returned. It is safe for use by all
+// worker goroutines. A nil jar sends no cookies.
+type CookieJar struct {
+	secret [16]byte
+
+	mu      sync.Mutex
+	servers map[string]string
+}
+
+func NewCookieJar() (*CookieJar, error) {
+	j := &CookieJar{servers: make(map[string]string)}
+	if _, err := rand.Read(j.secret[:]); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// ClientCookie returns the hex-encoded 8 byte client cookie for nameServer.
+// It differs between servers so that one server can't use it to spoof
+// responses from another.
+func (j *CookieJar) ClientCookie(nameServer string) string {
+	h := sha256.New()
+	h.Write(j.secret[:])
+	h.Write([]byte(nameServer))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// ServerCookie returns the hex-encoded server cookie last received from
+// nameServer, or "" if it hasn't sent one yet.
+func (j *CookieJar) ServerCookie(nameServer string) string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.servers[nameServer]
+}
+
+func (j *CookieJar) SetServerCookie(nameServer string, cookie string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.servers[nameServer] = cookie
+}
@@ -0,0 +1,73 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"strings"
+	"sync"
+)
+
+// deduper remembers the names read so far in a scan, for --dedupe-input. To
+// bound its memory, at most max names are remembered (0 means no limit);
+// names first seen after that are always looked up. It's safe for
+// concurrent use, as an interrupted scan reports it while input is still
+// being numbered.
+type deduper struct {
+	mu        sync.Mutex
+	names     map[string]struct{}
+	max       int
+	alexa     bool
+	truncated bool
+}
+
+func newDeduper(max int, alexa bool) *deduper {
+	return &deduper{names: make(map[string]struct{}), max: max, alexa: alexa}
+}
+
+// dedupeKey extracts the name from an input line and normalizes it so that
+// names differing only in case or a trailing dot count as the same.
+func dedupeKey(line string, alexa bool) string {
+	if alexa {
+		if i := strings.Index(line, ","); i >= 0 {
+			line = line[i+1:]
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(line), "."))
+}
+
+// duplicate reports whether the name on line was already seen, and remembers
+// it otherwise.
+func (d *deduper) duplicate(line string) bool {
+	key := dedupeKey(line, d.alexa)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.names[key]; ok {
+		return true
+	}
+	if d.max > 0 && len(d.names) >= d.max {
+		d.truncated = true
+		return false
+	}
+	d.names[key] = struct{}{}
+	return false
+}
+
+// wasTruncated reports whether names were left unremembered because the
+// limit was reached.
+func (d *deduper) wasTruncated() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.truncated
+}
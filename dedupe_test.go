@@ -0,0 +1,56 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import "testing"
+
+func TestDeduperDuplicate(t *testing.T) {
+	d := newDeduper(0, false)
+	for _, line := range []string{"example.com", "example.org"} {
+		if d.duplicate(line) {
+			t.Errorf("Unexpected duplicate on first sight of %s", line)
+		}
+	}
+	for _, line := range []string{"example.com", "EXAMPLE.com.", "example.org"} {
+		if !d.duplicate(line) {
+			t.Errorf("Expected %s to be a duplicate", line)
+		}
+	}
+	if d.wasTruncated() {
+		t.Error("Unexpected truncation without a limit")
+	}
+}
+
+func TestDeduperAlexa(t *testing.T) {
+	d := newDeduper(0, true)
+	if d.duplicate("1,example.com") || !d.duplicate("2,example.com") {
+		t.Error("Expected Alexa entries to be compared by name only")
+	}
+}
+
+func TestDeduperLimit(t *testing.T) {
+	d := newDeduper(2, false)
+	d.duplicate("a.example")
+	d.duplicate("b.example")
+	if d.duplicate("c.example") || d.duplicate("c.example") {
+		t.Error("Expected names beyond the limit not to be remembered")
+	}
+	if !d.wasTruncated() {
+		t.Error("Expected the set to be marked as truncated")
+	}
+	if !d.duplicate("a.example") {
+		t.Error("Expected names within the limit to still be recognized")
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import "sync"
+
+// how many input names --dry-run plans queries for
+const dryRunNames = 10
+
+// PlannedQuery is a query that --dry-run would have sent.
+type PlannedQuery struct {
+	Name      string `json:"name" groups:"short,normal,long,trace"`
+	Type      string `json:"type" groups:"short,normal,long,trace"`
+	Class     string `json:"class" groups:"short,normal,long,trace"`
+	Server    string `json:"server" groups:"short,normal,long,trace"`
+	Transport string `json:"transport" groups:"short,normal,long,trace"`
+	// the RD bit
+	Recursive bool `json:"recursion_desired" groups:"short,normal,long,trace"`
+}
+
+// QueryPlan collects the queries of a --dry-run in place of sending them.
+type QueryPlan struct {
+	mu      sync.Mutex
+	queries []PlannedQuery
+}
+
+func (p *QueryPlan) Record(q PlannedQuery) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queries = append(p.queries, q)
+}
+
+// take returns the queries recorded since the last call.
+func (p *QueryPlan) take() []PlannedQuery {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	queries := p.queries
+	p.queries = nil
+	return queries
+}
@@ -0,0 +1,38 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestQueryPlanTake(t *testing.T) {
+	p := new(QueryPlan)
+	if queries := p.take(); queries != nil {
+		t.Errorf("Expected no queries, got %v", queries)
+	}
+	a := PlannedQuery{Name: "example.com.", Type: "A", Class: "IN", Server: "1.1.1.1:53", Transport: "udp", Recursive: true}
+	aaaa := a
+	aaaa.Type = "AAAA"
+	p.Record(a)
+	p.Record(aaaa)
+	if queries := p.take(); !reflect.DeepEqual(queries, []PlannedQuery{a, aaaa}) {
+		t.Errorf("Unexpected queries %v", queries)
+	}
+	if queries := p.take(); queries != nil {
+		t.Errorf("Expected the queries to be taken only once, got %v", queries)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+
+	"github.com/miekg/dns"
+)
+
+// Specific causes of a failed lookup, reported as error_detail alongside the
+// coarser status. Failures caused by a DNS response code are reported as
+// rcode_ followed by the lowercase name of the code (e.g., rcode_servfail).
+const (
+	ERROR_DETAIL_CONNECTION_REFUSED   = "connection_refused"
+	ERROR_DETAIL_CONNECTION_RESET     = "connection_reset"
+	ERROR_DETAIL_NETWORK_UNREACHABLE  = "network_unreachable"
+	ERROR_DETAIL_HOST_UNREACHABLE     = "host_unreachable"
+	ERROR_DETAIL_IO_TIMEOUT           = "io_timeout"
+	ERROR_DETAIL_ITERATION_TIMEOUT    = "iteration_timeout"
+	ERROR_DETAIL_QUERY_LIMIT          = "query_limit"
+	ERROR_DETAIL_TEMPORARY            = "temporary_network_error"
+	ERROR_DETAIL_TRUNCATED            = "truncated"
+	ERROR_DETAIL_AUTHORITY_FAILURE    = "authority_failure"
+	ERROR_DETAIL_BLACKLISTED          = "blacklisted"
+	ERROR_DETAIL_ILLEGAL_INPUT        = "illegal_input"
+	ERROR_DETAIL_COOKIE_MISMATCH      = "cookie_mismatch"
+	ERROR_DETAIL_CASE_MISMATCH        = "case_mismatch"
+	ERROR_DETAIL_ID_MISMATCH          = "id_mismatch"
+	ERROR_DETAIL_CNAME_LOOP           = "cname_loop"
+	ERROR_DETAIL_DNSSEC_BOGUS         = "dnssec_bogus"
+	ERROR_DETAIL_DNSSEC_INDETERMINATE = "dnssec_indeterminate"
+	ERROR_DETAIL_TSIG_FAILURE         = "tsig_failure"
+	ERROR_DETAIL_QUIC_FAILURE         = "quic_failure"
+	ERROR_DETAIL_OTHER                = "other"
+)
+
+// DetailedError is an error that carries its own error detail, for failures
+// that can't be told apart by their status or underlying error alone.
+type DetailedError struct {
+	Detail string
+	Err    error
+}
+
+func NewDetailedError(detail string, err error) *DetailedError {
+	return &DetailedError{Detail: detail, Err: err}
+}
+
+func (e *DetailedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DetailedError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorDetailer is implemented by lookup results that can fail in ways their
+// status doesn't show, e.g., a NOERROR answer that fails DNSSEC validation.
+type ErrorDetailer interface {
+	ErrorDetail() string
+}
+
+// ErrorDetail classifies the failure behind a lookup's status and error.
+// Successful lookups, and those that merely found no records, have no
+// detail.
+func ErrorDetail(status Status, err error) string {
+	var detailed *DetailedError
+	if errors.As(err, &detailed) {
+		return detailed.Detail
+	}
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ERROR_DETAIL_CONNECTION_REFUSED
+	case errors.Is(err, syscall.ECONNRESET):
+		return ERROR_DETAIL_CONNECTION_RESET
+	case errors.Is(err, syscall.ENETUNREACH):
+		return ERROR_DETAIL_NETWORK_UNREACHABLE
+	case errors.Is(err, syscall.EHOSTUNREACH):
+		return ERROR_DETAIL_HOST_UNREACHABLE
+	}
+	switch status {
+	case STATUS_NOERROR, STATUS_NO_ANSWER, STATUS_NO_RECORD, STATUS_NO_OUTPUT, STATUS_DUPLICATE, STATUS_NULL_MX, STATUS_SKIPPED, STATUS_DRY_RUN, STATUS_NODATA:
+		return ""
+	case STATUS_TIMEOUT:
+		return ERROR_DETAIL_IO_TIMEOUT
+	case STATUS_ITER_TIMEOUT:
+		return ERROR_DETAIL_ITERATION_TIMEOUT
+	case STATUS_QUERY_LIMIT:
+		return ERROR_DETAIL_QUERY_LIMIT
+	case STATUS_TEMPORARY:
+		return ERROR_DETAIL_TEMPORARY
+	case STATUS_TRUNCATED:
+		return ERROR_DETAIL_TRUNCATED
+	case STATUS_AUTHFAIL:
+		return ERROR_DETAIL_AUTHORITY_FAILURE
+	case STATUS_BLACKLIST:
+		return ERROR_DETAIL_BLACKLISTED
+	case STATUS_ILLEGAL_INPUT:
+		return ERROR_DETAIL_ILLEGAL_INPUT
+	case STATUS_CASE_MISMATCH:
+		return ERROR_DETAIL_CASE_MISMATCH
+	case STATUS_ID_MISMATCH:
+		return ERROR_DETAIL_ID_MISMATCH
+	case STATUS_CNAME_LOOP:
+		return ERROR_DETAIL_CNAME_LOOP
+	case STATUS_TSIG_ERROR:
+		return ERROR_DETAIL_TSIG_FAILURE
+	case STATUS_QUIC_ERROR:
+		return ERROR_DETAIL_QUIC_FAILURE
+	}
+	if _, ok := dns.StringToRcode[string(status)]; ok {
+		return "rcode_" + strings.ToLower(string(status))
+	}
+	if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+		return ERROR_DETAIL_IO_TIMEOUT
+	}
+	if err != nil || status == STATUS_ERROR {
+		return ERROR_DETAIL_OTHER
+	}
+	return ""
+}
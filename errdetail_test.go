@@ -0,0 +1,52 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestErrorDetail(t *testing.T) {
+	refused := &net.OpError{Op: "read", Net: "udp", Err: os.NewSyscallError("recvfrom", syscall.ECONNREFUSED)}
+	cases := []struct {
+		status   Status
+		err      error
+		expected string
+	}{
+		{STATUS_NOERROR, nil, ""},
+		{STATUS_NO_ANSWER, nil, ""},
+		{STATUS_SERVFAIL, nil, "rcode_servfail"},
+		{STATUS_REFUSED, nil, "rcode_refused"},
+		{Status("FORMERR"), nil, "rcode_formerr"},
+		{Status("NOTIMP"), nil, "rcode_notimp"},
+		{STATUS_TIMEOUT, nil, ERROR_DETAIL_IO_TIMEOUT},
+		{STATUS_ITER_TIMEOUT, nil, ERROR_DETAIL_ITERATION_TIMEOUT},
+		{STATUS_TRUNCATED, nil, ERROR_DETAIL_TRUNCATED},
+		{STATUS_QUIC_ERROR, errors.New("idle timeout"), ERROR_DETAIL_QUIC_FAILURE},
+		{STATUS_ERROR, refused, ERROR_DETAIL_CONNECTION_REFUSED},
+		{STATUS_TEMPORARY, refused, ERROR_DETAIL_CONNECTION_REFUSED},
+		{STATUS_ERROR, NewDetailedError(ERROR_DETAIL_COOKIE_MISMATCH, errors.New("mismatch")), ERROR_DETAIL_COOKIE_MISMATCH},
+		{STATUS_ERROR, errors.New("something else"), ERROR_DETAIL_OTHER},
+	}
+	for _, c := range cases {
+		if detail := ErrorDetail(c.status, c.err); detail != c.expected {
+			t.Errorf("%s %v: expected %q, got %q", c.status, c.err, c.expected, detail)
+		}
+	}
+}
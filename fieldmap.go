@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// resultKeys returns the JSON keys of the top-level fields of a Result.
+func resultKeys() map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(Result{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			keys[name] = true
+		}
+	}
+	return keys
+}
+
+// ParseFieldMap parses a comma-separated list of from:to pairs that rename
+// top-level result keys. Each key may be renamed once, and no two keys of
+// the output, renamed or not, may end up with the same name.
+func ParseFieldMap(spec string) (map[string]string, error) {
+	keys := resultKeys()
+	fieldMap := make(map[string]string)
+	for _, pair := range strings.Split(spec, ",") {
+		fromTo := strings.SplitN(strings.TrimSpace(pair), ":", 2)
+		if len(fromTo) != 2 || fromTo[0] == "" || fromTo[1] == "" {
+			return nil, errors.New("expected from:to, got " + pair)
+		}
+		from, to := fromTo[0], fromTo[1]
+		if !keys[from] {
+			return nil, errors.New("unknown result field: " + from)
+		}
+		if _, ok := fieldMap[from]; ok {
+			return nil, errors.New("field renamed more than once: " + from)
+		}
+		fieldMap[from] = to
+	}
+	names := make(map[string]string)
+	for key := range keys {
+		name := key
+		if to, ok := fieldMap[key]; ok {
+			name = to
+		}
+		if other, ok := names[name]; ok {
+			return nil, errors.New("fields " + other + " and " + key + " would both be named " + name)
+		}
+		names[name] = key
+	}
+	return fieldMap, nil
+}
+
+// renameFields applies a field map to a result that sheriff has marshaled.
+func renameFields(data interface{}, fieldMap map[string]string) {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return
+	}
+	renamed := make(map[string]interface{}, len(fieldMap))
+	for from, to := range fieldMap {
+		if v, ok := m[from]; ok {
+			renamed[to] = v
+			delete(m, from)
+		}
+	}
+	for k, v := range renamed {
+		m[k] = v
+	}
+}
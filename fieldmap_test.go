@@ -0,0 +1,55 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFieldMap(t *testing.T) {
+	fieldMap, err := ParseFieldMap("name:domain, status:rcode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fieldMap, map[string]string{"name": "domain", "status": "rcode"}) {
+		t.Errorf("unexpected field map: %v", fieldMap)
+	}
+	// swapping two names doesn't collide
+	if _, err := ParseFieldMap("name:status,status:name"); err != nil {
+		t.Errorf("unexpected error for a swap: %v", err)
+	}
+	for _, bad := range []string{
+		"name",
+		"name:",
+		"bogus:x",
+		"name:a,name:b",
+		"name:status",
+		"name:x,class:x",
+	} {
+		if _, err := ParseFieldMap(bad); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestRenameFields(t *testing.T) {
+	data := map[string]interface{}{"name": "example.com", "status": "NOERROR", "data": 1}
+	renameFields(data, map[string]string{"name": "status", "status": "name"})
+	expected := map[string]interface{}{"name": "NOERROR", "status": "example.com", "data": 1}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("expected %v, got %v", expected, data)
+	}
+}
@@ -1,17 +1,33 @@
 module github.com/zmap/zdns
 
-go 1.13
+go 1.22
 
 require (
-	github.com/asergeyev/nradix v0.0.0-20170505151046-3872ab85bb56 // indirect
+	github.com/golang/snappy v1.0.0
 	github.com/hashicorp/go-version v1.2.0
-	github.com/kavu/go_reuseport v1.4.0 // indirect
+	github.com/klauspost/compress v1.18.0
 	github.com/liip/sheriff v0.0.0-20190308094614-91aa83a45a3d
 	github.com/miekg/dns v1.1.27
+	github.com/quic-go/quic-go v0.48.2
 	github.com/sirupsen/logrus v1.4.2
 	github.com/zmap/go-iptree v0.0.0-20170831022036-1948b1097e25
-	golang.org/x/crypto v0.0.0-20200117160349-530e935923ad // indirect
-	golang.org/x/net v0.0.0-20200114155413-6afb5195e5aa // indirect
+	golang.org/x/net v0.28.0
+)
+
+require (
+	github.com/asergeyev/nradix v0.0.0-20170505151046-3872ab85bb56 // indirect
+	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
+	github.com/google/pprof v0.0.0-20210407192527-94a9f03dee38 // indirect
+	github.com/kavu/go_reuseport v1.4.0 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/onsi/ginkgo/v2 v2.9.5 // indirect
+	go.uber.org/mock v0.4.0 // indirect
+	golang.org/x/crypto v0.26.0 // indirect
+	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842 // indirect
+	golang.org/x/mod v0.17.0 // indirect
+	golang.org/x/sys v0.23.0 // indirect
+	golang.org/x/text v0.17.0 // indirect
+	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
 )
 
 replace github.com/miekg/dns => github.com/zmap/dns v1.1.28-zmap
@@ -0,0 +1,52 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"fmt"
+
+	"golang.org/x/net/idna"
+)
+
+// idnaProfile converts names per IDNA2008, with the UTS #46 mapping that
+// browsers apply to names typed by users. Underscores are allowed, since
+// names such as _dmarc.example.com are valid in the DNS. The mapping is
+// nontransitional, which keeps ß, ς and ZWJ instead of mapping them as
+// IDNA2003 did. That is the default of idna.New: Transitional(false) is not
+// passed, since the x/net release in go.mod turns on transitional mapping for
+// either value.
+var idnaProfile = idna.New(idna.MapForLookup(), idna.BidiRule(), idna.StrictDomainName(false))
+
+// toASCIIName converts a name with non-ASCII characters to its A-label
+// (punycode) form. ASCII names are returned as is, with changed false.
+func toASCIIName(name string) (ascii string, changed bool, err error) {
+	if isASCII(name) {
+		return name, false, nil
+	}
+	ascii, err = idnaProfile.ToASCII(name)
+	if err != nil {
+		return "", false, fmt.Errorf("invalid internationalized name: %v", err)
+	}
+	return ascii, true, nil
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import "testing"
+
+func TestToASCIIName(t *testing.T) {
+	for name, expected := range map[string]string{
+		"bücher.de":        "xn--bcher-kva.de",
+		"_dmarc.bücher.de": "_dmarc.xn--bcher-kva.de",
+		"例え.jp":            "xn--r8jz45g.jp",
+		"straße.de":        "xn--strae-oqa.de",
+		"www.example.com":  "www.example.com",
+		"WWW.Example.COM.": "WWW.Example.COM.",
+	} {
+		ascii, changed, err := toASCIIName(name)
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", name, err)
+			continue
+		}
+		if ascii != expected || changed != (name != expected) {
+			t.Errorf("Expected %s for %s, got %s (changed %v)", expected, name, ascii, changed)
+		}
+	}
+	if _, _, err := toASCIIName("bad�.com"); err == nil {
+		t.Error("Expected an error for a name with a disallowed character")
+	}
+}
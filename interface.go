@@ -15,10 +15,13 @@
 package zdns
 
 import (
+	crand "crypto/rand"
+	"encoding/binary"
 	"flag"
 	"math/rand"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
@@ -49,6 +52,65 @@ type Lookup interface {
 	DoZonefileLookup(record *dns.Token) (interface{}, Status, error)
 }
 
+// ClassSetter is implemented by lookups that can query in a class other than
+// the global --class, as given on input lines of the form name,CLASS.
+type ClassSetter interface {
+	SetDNSClass(dnsClass uint16)
+}
+
+// QueryCounter is implemented by lookups that count the queries they send,
+// as --max-queries-per-name needs.
+type QueryCounter interface {
+	// ResetQueries starts counting the queries sent for a new name.
+	ResetQueries()
+	// Queries returns the number of queries sent since the last reset, and
+	// whether more were refused by --max-queries-per-name.
+	Queries() (int, bool)
+}
+
+// AnswerRecorder is implemented by lookups that can keep the answer section
+// of every response they receive, as --full-answer needs.
+type AnswerRecorder interface {
+	// ResetAnswers forgets the answers kept for the previous name.
+	ResetAnswers()
+	// Answers returns the records of the answer sections received since
+	// the last reset, in the order they arrived.
+	Answers() []interface{}
+}
+
+// NegativeSOARecorder is implemented by lookups that keep the SOA record of
+// the negative answers (NXDOMAIN or NODATA) they receive, which tells how
+// long the answer may be cached.
+type NegativeSOARecorder interface {
+	// ResetNegativeSOA forgets the SOA record kept for the previous name.
+	ResetNegativeSOA()
+	// NegativeSOA returns the SOA record of the last negative answer since
+	// the last reset, or nil if there was none.
+	NegativeSOA() interface{}
+}
+
+// NoDataRecorder is implemented by lookups that can tell whether the last
+// answer they received was NODATA, so that the statuses modules report for
+// names without the records they look for can be told apart from NXDOMAIN.
+type NoDataRecorder interface {
+	// ResetNoData forgets the answer received for the previous name.
+	ResetNoData()
+	// NoData reports whether the last answer since the last reset was
+	// NODATA.
+	NoData() bool
+}
+
+// FlagsRecorder is implemented by lookups that keep the header flags of the
+// last response they receive, which --include-fields flags reports for every
+// module.
+type FlagsRecorder interface {
+	// ResetResponseFlags forgets the flags kept for the previous name.
+	ResetResponseFlags()
+	// ResponseFlags returns the flags of the last response received since
+	// the last reset, or nil if there was none.
+	ResponseFlags() *ResponseFlags
+}
+
 type BaseLookup struct {
 }
 
@@ -106,6 +168,30 @@ type OutputHandler interface {
 	WriteResults(results <-chan string, wg *sync.WaitGroup) error
 }
 
+// OutputValidator is implemented by output handlers that check each result
+// before writing it, as --validate-output asks.
+type OutputValidator interface {
+	// InvalidResults returns the number of results that failed the check
+	// and weren't written. It's called once WriteResults has returned.
+	InvalidResults() int
+}
+
+// CacheReporter is implemented by global factories that cache answers across
+// lookups, to report how effective the cache was in the metadata.
+type CacheReporter interface {
+	// CacheStats returns nil if the cache wasn't used.
+	CacheStats() *CacheStats
+}
+
+// CacheWarmer is implemented by global factories that can fill their cache
+// before a scan starts, as --warm-cache asks.
+type CacheWarmer interface {
+	// WarmCache resolves the delegations of zones, with up to threads
+	// lookups at once. It returns early, once the lookups in flight are
+	// done, if stop is closed.
+	WarmCache(zones []string, threads int, stop <-chan struct{})
+}
+
 type BaseGlobalLookupFactory struct {
 	GlobalConf *GlobalConf
 }
@@ -116,6 +202,9 @@ func (f *BaseGlobalLookupFactory) Initialize(c *GlobalConf) error {
 }
 
 func (f *BaseGlobalLookupFactory) Finalize() error {
+	if f.GlobalConf != nil && f.GlobalConf.Metrics != nil {
+		return f.GlobalConf.Metrics.Close()
+	}
 	return nil
 }
 
@@ -130,11 +219,27 @@ func (f *BaseGlobalLookupFactory) RandomNameServer() string {
 	if f.GlobalConf == nil {
 		log.Fatal("no global conf initialized")
 	}
-	l := len(f.GlobalConf.NameServers)
-	if l == 0 {
+	if len(f.GlobalConf.NameServers) == 0 {
 		log.Fatal("No name servers specified")
 	}
-	return f.GlobalConf.NameServers[rand.Intn(l)]
+	// servers whose circuit breaker is open are skipped
+	servers, weights := f.GlobalConf.CircuitBreaker.Pick(f.GlobalConf.NameServers, f.GlobalConf.NameServerWeights)
+	l := len(servers)
+	if len(weights) == l {
+		return servers[pickWeighted(weights, rand.Intn(sumWeights(weights)))]
+	}
+	return servers[rand.Intn(l)]
+}
+
+// RandomSeed returns a seed for math/rand from crypto/rand. A seed taken
+// from the clock can be guessed from when a scan started, along with the
+// choices made with it.
+func RandomSeed() int64 {
+	var b [8]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return time.Now().UnixNano()
+	}
+	return int64(binary.BigEndian.Uint64(b[:]))
 }
 
 func (s *BaseGlobalLookupFactory) AllowStdIn() bool {
@@ -0,0 +1,164 @@
+package avro
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zdns"
+)
+
+// Avro object container files start with these four bytes
+var magic = []byte{'O', 'b', 'j', 1}
+
+// a block is written once it holds this many records or this many bytes
+const (
+	blockRecords = 1000
+	blockBytes   = 1 << 20
+)
+
+type OutputHandler struct {
+	filepath string
+	codec    string
+	schema   *avroType
+}
+
+func (h *OutputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.filepath = conf.OutputFilePath
+	h.codec = conf.AvroCodec
+	if conf.AvroSchemaFile != "" {
+		data, err := ioutil.ReadFile(conf.AvroSchemaFile)
+		if err != nil {
+			log.Fatal("unable to read avro schema: ", err.Error())
+		}
+		if h.schema, err = parseSchema(data); err != nil {
+			log.Fatal("invalid avro schema: ", err.Error())
+		}
+		if h.schema.kind != "record" {
+			log.Fatal("invalid avro schema: results must be written as a record")
+		}
+		return
+	}
+	// the JSON Schema of results, with its types as they'd be unmarshaled
+	var schema map[string]interface{}
+	b, _ := json.Marshal(zdns.ResultSchema(conf, zdns.GetLookup(conf.Module)))
+	json.Unmarshal(b, &schema)
+	h.schema = fromJSONSchema(schema, avroName(strings.ToLower(conf.Module)))
+}
+
+// header returns the header of a container file of records of t.
+func header(t *avroType, codec string, marker []byte) []byte {
+	schema, _ := json.Marshal(t.schemaJSON(make(map[string]bool)))
+	var buf bytes.Buffer
+	buf.Write(magic)
+	writeLong(&buf, 2)
+	writeBytes(&buf, []byte("avro.schema"))
+	writeBytes(&buf, schema)
+	writeBytes(&buf, []byte("avro.codec"))
+	writeBytes(&buf, []byte(codec))
+	writeLong(&buf, 0)
+	buf.Write(marker)
+	return buf.Bytes()
+}
+
+// compress encodes the data of a block with codec.
+func compress(codec string, data []byte) []byte {
+	switch codec {
+	case "deflate":
+		var buf bytes.Buffer
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	case "snappy":
+		// followed by the big-endian CRC-32 of the uncompressed data
+		var crc [4]byte
+		binary.BigEndian.PutUint32(crc[:], crc32.ChecksumIEEE(data))
+		return append(snappy.Encode(nil, data), crc[:]...)
+	}
+	return data
+}
+
+// writeBlock writes count records, encoded in data, as a block of the
+// container file.
+func writeBlock(w io.Writer, codec string, count int, data []byte, marker []byte) error {
+	data = compress(codec, data)
+	var buf bytes.Buffer
+	writeLong(&buf, int64(count))
+	writeLong(&buf, int64(len(data)))
+	buf.Write(data)
+	buf.Write(marker)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	var f *os.File
+	if h.filepath == "" || h.filepath == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		f, err = os.Create(h.filepath)
+		if err != nil {
+			return fmt.Errorf("unable to open output file: %v", err)
+		}
+		defer f.Close()
+	}
+	marker := make([]byte, 16)
+	rand.Read(marker)
+	if _, err := f.Write(header(h.schema, h.codec, marker)); err != nil {
+		return fmt.Errorf("unable to write avro output: %v", err)
+	}
+	var block bytes.Buffer
+	count := 0
+	for n := range results {
+		var result interface{}
+		d := json.NewDecoder(strings.NewReader(n))
+		d.UseNumber()
+		if err := d.Decode(&result); err != nil {
+			log.Warn("unable to decode result for avro output: ", err.Error())
+			continue
+		}
+		mark := block.Len()
+		if err := h.schema.encode(&block, result); err != nil {
+			block.Truncate(mark)
+			log.Warn("unable to encode result for avro output: ", err.Error())
+			continue
+		}
+		count++
+		if count >= blockRecords || block.Len() >= blockBytes {
+			if err := writeBlock(f, h.codec, count, block.Bytes(), marker); err != nil {
+				return fmt.Errorf("unable to write avro output: %v", err)
+			}
+			block.Reset()
+			count = 0
+		}
+	}
+	// results is closed once every lookup is done, so the last block
+	// finishes the file
+	if count > 0 {
+		if err := writeBlock(f, h.codec, count, block.Bytes(), marker); err != nil {
+			return fmt.Errorf("unable to write avro output: %v", err)
+		}
+	}
+	return nil
+}
+
+// register handler
+func init() {
+	out := new(OutputHandler)
+	zdns.RegisterOutputHandler("avro", out)
+}
@@ -0,0 +1,209 @@
+package avro
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func jsonValue(t *testing.T, s string) interface{} {
+	var v interface{}
+	d := json.NewDecoder(strings.NewReader(s))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func schemaString(t *avroType) string {
+	b, _ := json.Marshal(t.schemaJSON(make(map[string]bool)))
+	return string(b)
+}
+
+func TestFromJSONSchema(t *testing.T) {
+	schema := jsonValue(t, `{
+		"type": "object",
+		"required": ["name", "status"],
+		"properties": {
+			"name": {"type": "string"},
+			"status": {"type": "string"},
+			"timestamp": {"type": "string"},
+			"data": {
+				"type": "object",
+				"required": ["answers"],
+				"properties": {
+					"answers": {"type": ["array", "null"], "items": {"type": "object", "required": ["ttl"], "properties": {"ttl": {"type": "integer"}}}},
+					"flags": {"type": ["object", "null"], "additionalProperties": {"type": "boolean"}},
+					"extra": {}
+				}
+			}
+		}
+	}`).(map[string]interface{})
+	got := schemaString(fromJSONSchema(schema, "a"))
+	want := `{"fields":[` +
+		`{"default":null,"name":"data","type":["null",{"fields":[` +
+		`{"default":null,"name":"answers","type":["null",{"items":{"fields":[{"name":"ttl","type":"long"}],"name":"a_data_answers_item","type":"record"},"type":"array"}]},` +
+		`{"default":null,"name":"extra","type":["null","string"]},` +
+		`{"default":null,"name":"flags","type":["null",{"type":"map","values":"boolean"}]}` +
+		`],"name":"a_data","type":"record"}]},` +
+		`{"name":"name","type":"string"},` +
+		`{"name":"status","type":"string"},` +
+		`{"default":null,"name":"timestamp","type":["null","string"]}` +
+		`],"name":"a","type":"record"}`
+	if got != want {
+		t.Errorf("schema is\n%s\nexpected\n%s", got, want)
+	}
+}
+
+func TestAvroName(t *testing.T) {
+	for in, want := range map[string]string{"name": "name", "alt-name": "alt_name", "1st": "_st", "": "_"} {
+		if got := avroName(in); got != want {
+			t.Errorf("avroName(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	schema, err := parseSchema([]byte(`{
+		"type": "record", "name": "r", "fields": [
+			{"name": "name", "type": "string"},
+			{"name": "ttl", "type": "long"},
+			{"name": "ip", "type": ["null", "string"]},
+			{"name": "ips", "type": {"type": "array", "items": "string"}},
+			{"name": "rtt", "type": "double"},
+			{"name": "ok", "type": "boolean"}
+		]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := schema.encode(&buf, jsonValue(t, `{"name": "a.com", "ttl": 300, "ip": null, "ips": ["x", "y"], "ok": true}`)); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		10, 'a', '.', 'c', 'o', 'm', // name
+		0xd8, 0x04, // ttl, zigzag 600
+		0,                    // ip, null branch
+		4, 2, 'x', 2, 'y', 0, // ips, one block of two
+		0, 0, 0, 0, 0, 0, 0, 0, // rtt, missing
+		1, // ok
+	}
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("encoded as %v, expected %v", buf.Bytes(), want)
+	}
+
+	buf.Reset()
+	schema.fields[2].typ.encode(&buf, "1.2.3.4")
+	if want := append([]byte{2, 14}, "1.2.3.4"...); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("non-null union encoded as %v, expected %v", buf.Bytes(), want)
+	}
+}
+
+func TestEncodeJSONText(t *testing.T) {
+	typ := optional(&avroType{kind: "string", jsonText: true})
+	var buf bytes.Buffer
+	typ.encode(&buf, jsonValue(t, `{"a": [1]}`))
+	if want := append([]byte{2, 18}, `{"a":[1]}`...); !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("encoded as %q, expected %q", buf.Bytes(), want)
+	}
+}
+
+func TestParseSchema(t *testing.T) {
+	schema, err := parseSchema([]byte(`{
+		"type": "record", "name": "result", "namespace": "zdns", "fields": [
+			{"name": "status", "type": {"type": "enum", "name": "status", "symbols": ["NOERROR", "NXDOMAIN"]}},
+			{"name": "prev", "type": ["null", "status"]},
+			{"name": "ts", "type": {"type": "long", "logicalType": "timestamp-millis"}}
+		]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.name != "zdns.result" || schema.fields[1].typ.branches[1] != schema.fields[0].typ {
+		t.Errorf("named types weren't resolved: %s", schemaString(schema))
+	}
+	if schema.fields[2].typ.kind != "long" {
+		t.Errorf("logical type is %s, expected long", schema.fields[2].typ.kind)
+	}
+	var buf bytes.Buffer
+	if err := schema.encode(&buf, jsonValue(t, `{"status": "REFUSED"}`)); err == nil {
+		t.Error("unknown enum symbol was encoded")
+	}
+
+	for _, bad := range []string{`{"type": "record", "fields": []}`, `"nope"`, `[["null"]]`, `{`} {
+		if _, err := parseSchema([]byte(bad)); err == nil {
+			t.Errorf("%s parsed", bad)
+		}
+	}
+}
+
+func TestCompress(t *testing.T) {
+	data := []byte(strings.Repeat("example.com ", 100))
+	enc := compress("snappy", data)
+	if crc := binary.BigEndian.Uint32(enc[len(enc)-4:]); crc != crc32.ChecksumIEEE(data) {
+		t.Errorf("snappy block ends with %x, expected the CRC-32 %x", crc, crc32.ChecksumIEEE(data))
+	}
+	if out, err := snappy.Decode(nil, enc[:len(enc)-4]); err != nil || !bytes.Equal(out, data) {
+		t.Errorf("snappy round trip failed: %v", err)
+	}
+	out, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(compress("deflate", data))))
+	if err != nil || !bytes.Equal(out, data) {
+		t.Errorf("deflate round trip failed: %v", err)
+	}
+	if !bytes.Equal(compress("null", data), data) {
+		t.Error("null codec changed data")
+	}
+}
+
+func TestContainer(t *testing.T) {
+	typ := &avroType{kind: "record", name: "r", fields: []field{{name: "n", key: "n", typ: &avroType{kind: "long"}}}}
+	marker := bytes.Repeat([]byte{0xab}, 16)
+	var buf bytes.Buffer
+	buf.Write(header(typ, "null", marker))
+	writeBlock(&buf, "null", 2, []byte{2, 4}, marker)
+	b := buf.Bytes()
+
+	if !bytes.HasPrefix(b, []byte("Obj\x01")) {
+		t.Fatalf("file starts with %q", b[:4])
+	}
+	r := bytes.NewReader(b[4:])
+	readLong := func() int64 {
+		n, _ := binary.ReadVarint(r)
+		return n
+	}
+	readString := func() string {
+		s := make([]byte, readLong())
+		r.Read(s)
+		return string(s)
+	}
+	meta := make(map[string]string)
+	for n := readLong(); n > 0; n-- {
+		k := readString()
+		meta[k] = readString()
+	}
+	if readLong() != 0 {
+		t.Error("metadata map isn't terminated")
+	}
+	if meta["avro.schema"] != `{"fields":[{"name":"n","type":"long"}],"name":"r","type":"record"}` || meta["avro.codec"] != "null" {
+		t.Errorf("unexpected metadata %v", meta)
+	}
+	sync := make([]byte, 16)
+	r.Read(sync)
+	if !bytes.Equal(sync, marker) {
+		t.Errorf("header ends with %x, expected the sync marker", sync)
+	}
+	if count, size := readLong(), readLong(); count != 2 || size != 2 {
+		t.Errorf("block of %d records in %d bytes, expected 2 in 2", count, size)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	if want := append([]byte{2, 4}, marker...); !bytes.Equal(rest, want) {
+		t.Errorf("block ends with %v, expected %v", rest, want)
+	}
+}
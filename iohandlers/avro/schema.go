@@ -0,0 +1,491 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// avroType is a node of an Avro schema: a primitive type, or a record,
+// enum, array, map, union, or fixed type.
+type avroType struct {
+	kind string
+	// full name of records, enums, and fixed types
+	name     string
+	fields   []field
+	items    *avroType
+	branches []*avroType
+	symbols  []string
+	size     int
+	// a string holding the JSON encoding of a value whose shape isn't known
+	jsonText bool
+}
+
+type field struct {
+	name string
+	// the key of the value in a JSON result
+	key string
+	typ *avroType
+}
+
+var primitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// optional makes t nullable, with null as the first branch so that fields
+// can default to null.
+func optional(t *avroType) *avroType {
+	if t.kind == "null" {
+		return t
+	}
+	if t.kind == "union" {
+		for _, b := range t.branches {
+			if b.kind == "null" {
+				return t
+			}
+		}
+		return &avroType{kind: "union", branches: append([]*avroType{{kind: "null"}}, t.branches...)}
+	}
+	return &avroType{kind: "union", branches: []*avroType{{kind: "null"}, t}}
+}
+
+// avroName turns s into a valid Avro name, replacing invalid characters.
+func avroName(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if !(c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c == '_' || i > 0 && c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 {
+		return "_"
+	}
+	return string(b)
+}
+
+// schemaTypes returns the types that a JSON Schema allows.
+func schemaTypes(t interface{}) []string {
+	switch t := t.(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, s := range t {
+			if s, ok := s.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+// fromJSONSchema derives the Avro type of the values a JSON Schema
+// describes (see zdns.ResultSchema). Records are named after their path
+// from the root. Values that the JSON Schema leaves unconstrained are
+// written as JSON text.
+func fromJSONSchema(s map[string]interface{}, name string) *avroType {
+	nullable := false
+	kind := ""
+	for _, t := range schemaTypes(s["type"]) {
+		if t == "null" {
+			nullable = true
+		} else {
+			kind = t
+		}
+	}
+	var t *avroType
+	switch kind {
+	case "string":
+		t = &avroType{kind: "string"}
+	case "integer":
+		t = &avroType{kind: "long"}
+	case "number":
+		t = &avroType{kind: "double"}
+	case "boolean":
+		t = &avroType{kind: "boolean"}
+	case "array":
+		items, _ := s["items"].(map[string]interface{})
+		t = &avroType{kind: "array", items: fromJSONSchema(items, name+"_item")}
+	case "object":
+		if properties, ok := s["properties"].(map[string]interface{}); ok {
+			t = &avroType{kind: "record", name: name}
+			required := make(map[string]bool)
+			for _, r := range schemaTypes(s["required"]) {
+				required[r] = true
+			}
+			keys := make([]string, 0, len(properties))
+			for k := range properties {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				property, _ := properties[k].(map[string]interface{})
+				ft := fromJSONSchema(property, name+"_"+avroName(k))
+				if !required[k] {
+					ft = optional(ft)
+				}
+				t.fields = append(t.fields, field{name: avroName(k), key: k, typ: ft})
+			}
+		} else if values, ok := s["additionalProperties"].(map[string]interface{}); ok {
+			t = &avroType{kind: "map", items: fromJSONSchema(values, name+"_value")}
+		}
+	}
+	if t == nil {
+		return optional(&avroType{kind: "string", jsonText: true})
+	}
+	if nullable {
+		return optional(t)
+	}
+	return t
+}
+
+// parseSchema parses an Avro schema in its JSON form (e.g., an .avsc file).
+// Fields are matched to the keys of JSON results by name.
+func parseSchema(data []byte) (*avroType, error) {
+	var s interface{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, err
+	}
+	return parseType(s, "", make(map[string]*avroType))
+}
+
+func parseType(s interface{}, namespace string, named map[string]*avroType) (*avroType, error) {
+	switch s := s.(type) {
+	case string:
+		if primitives[s] {
+			return &avroType{kind: s}, nil
+		}
+		if t, ok := named[s]; ok {
+			return t, nil
+		}
+		if t, ok := named[namespace+"."+s]; ok && namespace != "" {
+			return t, nil
+		}
+		return nil, fmt.Errorf("unknown type %q", s)
+	case []interface{}:
+		t := &avroType{kind: "union"}
+		for _, b := range s {
+			bt, err := parseType(b, namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			if bt.kind == "union" {
+				return nil, errors.New("unions can't contain unions")
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+	case map[string]interface{}:
+		kind, _ := s["type"].(string)
+		switch kind {
+		case "record", "error", "enum", "fixed":
+			name, _ := s["name"].(string)
+			if name == "" {
+				return nil, fmt.Errorf("%s without a name", kind)
+			}
+			if ns, ok := s["namespace"].(string); ok {
+				namespace = ns
+			}
+			if !strings.Contains(name, ".") && namespace != "" {
+				name = namespace + "." + name
+			}
+			t := &avroType{kind: kind, name: name}
+			if kind == "error" {
+				t.kind = "record"
+			}
+			named[name] = t
+			if i := strings.LastIndex(name, "."); i >= 0 {
+				namespace = name[:i]
+				named[name[i+1:]] = t
+			}
+			switch kind {
+			case "enum":
+				for _, sym := range schemaTypes(s["symbols"]) {
+					t.symbols = append(t.symbols, sym)
+				}
+				if len(t.symbols) == 0 {
+					return nil, fmt.Errorf("enum %s without symbols", name)
+				}
+			case "fixed":
+				size, ok := s["size"].(float64)
+				if !ok || size < 0 {
+					return nil, fmt.Errorf("fixed %s without a size", name)
+				}
+				t.size = int(size)
+			default:
+				fields, _ := s["fields"].([]interface{})
+				for _, f := range fields {
+					fm, _ := f.(map[string]interface{})
+					fname, _ := fm["name"].(string)
+					if fname == "" {
+						return nil, fmt.Errorf("field of record %s without a name", name)
+					}
+					ft, err := parseType(fm["type"], namespace, named)
+					if err != nil {
+						return nil, fmt.Errorf("field %s of record %s: %v", fname, name, err)
+					}
+					t.fields = append(t.fields, field{name: fname, key: fname, typ: ft})
+				}
+			}
+			return t, nil
+		case "array":
+			items, err := parseType(s["items"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			return &avroType{kind: "array", items: items}, nil
+		case "map":
+			values, err := parseType(s["values"], namespace, named)
+			if err != nil {
+				return nil, err
+			}
+			return &avroType{kind: "map", items: values}, nil
+		default:
+			// a primitive type, possibly with a logical type, which is
+			// written as the underlying type
+			if primitives[kind] {
+				return &avroType{kind: kind}, nil
+			}
+			return parseType(s["type"], namespace, named)
+		}
+	}
+	return nil, fmt.Errorf("invalid schema %v", s)
+}
+
+// schemaJSON returns t in the JSON form of Avro schemas. Named types are
+// spelled out the first time they appear and referred to by name after.
+func (t *avroType) schemaJSON(defined map[string]bool) interface{} {
+	switch t.kind {
+	case "record", "enum", "fixed":
+		if defined[t.name] {
+			return t.name
+		}
+		defined[t.name] = true
+		s := map[string]interface{}{"type": t.kind, "name": t.name}
+		switch t.kind {
+		case "enum":
+			s["symbols"] = t.symbols
+		case "fixed":
+			s["size"] = t.size
+		default:
+			fields := make([]interface{}, 0, len(t.fields))
+			for _, f := range t.fields {
+				fs := map[string]interface{}{"name": f.name, "type": f.typ.schemaJSON(defined)}
+				if f.typ.kind == "union" && f.typ.branches[0].kind == "null" {
+					fs["default"] = nil
+				}
+				fields = append(fields, fs)
+			}
+			s["fields"] = fields
+		}
+		return s
+	case "array":
+		return map[string]interface{}{"type": "array", "items": t.items.schemaJSON(defined)}
+	case "map":
+		return map[string]interface{}{"type": "map", "values": t.items.schemaJSON(defined)}
+	case "union":
+		branches := make([]interface{}, len(t.branches))
+		for i, b := range t.branches {
+			branches[i] = b.schemaJSON(defined)
+		}
+		return branches
+	}
+	return t.kind
+}
+
+func writeLong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func writeBytes(buf *bytes.Buffer, b []byte) {
+	writeLong(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+// number converts a JSON value to a float, for the numeric types.
+func number(v interface{}) float64 {
+	switch v := v.(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return f
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+// integer converts a JSON value to an integer, keeping the precision that
+// floats would lose.
+func integer(v interface{}) int64 {
+	if n, ok := v.(json.Number); ok {
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+	}
+	return int64(number(v))
+}
+
+// text converts a JSON value to a string, writing anything but strings as
+// JSON.
+func text(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	if v == nil {
+		return ""
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// matches reports whether JSON value v has the shape of t, to pick the
+// branch of a union.
+func (t *avroType) matches(v interface{}) bool {
+	switch v.(type) {
+	case nil:
+		return t.kind == "null"
+	case bool:
+		return t.kind == "boolean"
+	case json.Number, float64:
+		switch t.kind {
+		case "int", "long":
+			_, err := strconv.ParseInt(fmt.Sprint(v), 10, 64)
+			return err == nil
+		case "float", "double":
+			return true
+		}
+	case string:
+		return t.kind == "string" || t.kind == "bytes" || t.kind == "fixed" || t.kind == "enum"
+	case []interface{}:
+		return t.kind == "array"
+	case map[string]interface{}:
+		return t.kind == "record" || t.kind == "map"
+	}
+	return t.kind == "string" && t.jsonText
+}
+
+// encode appends the binary encoding of JSON value v as type t. Missing
+// values of types that aren't nullable are written as their zero value.
+func (t *avroType) encode(buf *bytes.Buffer, v interface{}) error {
+	switch t.kind {
+	case "null":
+	case "boolean":
+		if b, _ := v.(bool); b {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case "int", "long":
+		writeLong(buf, integer(v))
+	case "float":
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(float32(number(v))))
+		buf.Write(b[:])
+	case "double":
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(number(v)))
+		buf.Write(b[:])
+	case "bytes":
+		// byte slices appear base64-encoded in JSON results
+		s := text(v)
+		if b, err := base64.StdEncoding.DecodeString(s); err == nil {
+			writeBytes(buf, b)
+		} else {
+			writeBytes(buf, []byte(s))
+		}
+	case "string":
+		if t.jsonText {
+			b, _ := json.Marshal(v)
+			writeBytes(buf, b)
+		} else {
+			writeBytes(buf, []byte(text(v)))
+		}
+	case "enum":
+		s := text(v)
+		for i, sym := range t.symbols {
+			if sym == s {
+				writeLong(buf, int64(i))
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not a symbol of enum %s", s, t.name)
+	case "fixed":
+		b := []byte(text(v))
+		if len(b) != t.size {
+			return fmt.Errorf("%q doesn't have the %d bytes of fixed %s", b, t.size, t.name)
+		}
+		buf.Write(b)
+	case "record":
+		m, _ := v.(map[string]interface{})
+		for _, f := range t.fields {
+			if err := f.typ.encode(buf, m[f.key]); err != nil {
+				return fmt.Errorf("%s: %v", f.name, err)
+			}
+		}
+	case "array":
+		items, _ := v.([]interface{})
+		if len(items) > 0 {
+			writeLong(buf, int64(len(items)))
+			for _, item := range items {
+				if err := t.items.encode(buf, item); err != nil {
+					return err
+				}
+			}
+		}
+		writeLong(buf, 0)
+	case "map":
+		m, _ := v.(map[string]interface{})
+		if len(m) > 0 {
+			keys := make([]string, 0, len(m))
+			for k := range m {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			writeLong(buf, int64(len(keys)))
+			for _, k := range keys {
+				writeBytes(buf, []byte(k))
+				if err := t.items.encode(buf, m[k]); err != nil {
+					return fmt.Errorf("%s: %v", k, err)
+				}
+			}
+		}
+		writeLong(buf, 0)
+	case "union":
+		branch := -1
+		for i, b := range t.branches {
+			if b.matches(v) {
+				branch = i
+				break
+			}
+		}
+		if branch < 0 {
+			// coerce the value to the first branch that can hold one
+			branch = 0
+			if v != nil && t.branches[0].kind == "null" && len(t.branches) > 1 {
+				branch = 1
+			}
+		}
+		writeLong(buf, int64(branch))
+		return t.branches[branch].encode(buf, v)
+	default:
+		return fmt.Errorf("unsupported type %s", t.kind)
+	}
+	return nil
+}
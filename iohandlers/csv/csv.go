@@ -0,0 +1,245 @@
+package csv
+
+import (
+	stdcsv "encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zdns"
+)
+
+// the number of results we'll hold on to while waiting for one that carries
+// data from which to derive the data columns of the header
+const maxHeaderBuffer = 1000
+
+// the data array that is expanded into one row per element when a result
+// contains more than one array (e.g., answers vs. authorities)
+const preferredArray = "answers"
+
+type OutputHandler struct {
+	filepath string
+	columns  []string
+}
+
+// topLevelColumns returns the JSON names of the zdns.Result fields that are
+// included in the given output groups, in struct order. The data object is
+// flattened into its own columns.
+func topLevelColumns(groups []string) []string {
+	enabled := make(map[string]bool)
+	for _, g := range groups {
+		enabled[strings.TrimSpace(g)] = true
+	}
+	var columns []string
+	t := reflect.TypeOf(zdns.Result{})
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" || name == "data" {
+			continue
+		}
+		for _, g := range strings.Split(field.Tag.Get("groups"), ",") {
+			if enabled[g] {
+				columns = append(columns, name)
+				break
+			}
+		}
+	}
+	return columns
+}
+
+func (h *OutputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.filepath = conf.OutputFilePath
+	h.columns = topLevelColumns(conf.OutputGroups)
+	for i, column := range h.columns {
+		if to, ok := conf.FieldMap[column]; ok {
+			h.columns[i] = to
+		}
+	}
+}
+
+func flatten(prefix string, v interface{}, out map[string]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range t {
+			flatten(prefix+"."+k, inner, out)
+		}
+	case []interface{}:
+		b, _ := json.Marshal(t)
+		out[prefix] = string(b)
+	case nil:
+		out[prefix] = ""
+	case string:
+		out[prefix] = t
+	case json.Number:
+		out[prefix] = t.String()
+	default:
+		b, _ := json.Marshal(t)
+		out[prefix] = string(b)
+	}
+}
+
+// expandedArray picks which array in data, if any, produces one row per element.
+func expandedArray(data map[string]interface{}) string {
+	if arr, ok := data[preferredArray].([]interface{}); ok && len(arr) > 0 {
+		return preferredArray
+	}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if arr, ok := data[k].([]interface{}); ok && len(arr) > 0 {
+			return k
+		}
+	}
+	return ""
+}
+
+// flattenResult converts a single JSON result into one or more flat rows keyed
+// by column name. Nested objects become dotted column names.
+func flattenResult(line string) ([]map[string]string, error) {
+	var record map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.UseNumber()
+	if err := dec.Decode(&record); err != nil {
+		return nil, err
+	}
+	base := make(map[string]string)
+	for k, v := range record {
+		if k != "data" {
+			flatten(k, v, base)
+		}
+	}
+	data, ok := record["data"].(map[string]interface{})
+	if !ok {
+		if record["data"] != nil {
+			flatten("data", record["data"], base)
+		}
+		return []map[string]string{base}, nil
+	}
+	expand := expandedArray(data)
+	for k, v := range data {
+		if k != expand {
+			flatten("data."+k, v, base)
+		}
+	}
+	if expand == "" {
+		return []map[string]string{base}, nil
+	}
+	var rows []map[string]string
+	for _, elem := range data[expand].([]interface{}) {
+		row := make(map[string]string, len(base))
+		for k, v := range base {
+			row[k] = v
+		}
+		flatten("data."+expand, elem, row)
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func dataColumns(rows []map[string]string) []string {
+	seen := make(map[string]bool)
+	var columns []string
+	for _, row := range rows {
+		for k := range row {
+			if strings.HasPrefix(k, "data.") && !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	var f *os.File
+	if h.filepath == "" || h.filepath == "-" {
+		f = os.Stdout
+	} else {
+		var err error
+		f, err = os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to open output file: %v", err)
+		}
+		defer f.Close()
+	}
+	w := stdcsv.NewWriter(f)
+	defer w.Flush()
+
+	var header []string
+	var buffered [][]map[string]string
+	inHeader := make(map[string]bool)
+	warned := false
+	writeRows := func(rows []map[string]string) {
+		for _, row := range rows {
+			record := make([]string, len(header))
+			for i, column := range header {
+				record[i] = row[column]
+			}
+			if !warned {
+				for k := range row {
+					if !inHeader[k] {
+						log.Warn("csv output: dropping fields not present in the header (e.g., ", k, ")")
+						warned = true
+						break
+					}
+				}
+			}
+			if err := w.Write(record); err != nil {
+				log.Fatal("unable to write CSV output: ", err)
+			}
+		}
+	}
+	writeHeader := func(dataRows []map[string]string) {
+		header = append(append([]string{}, h.columns...), dataColumns(dataRows)...)
+		for _, column := range header {
+			inHeader[column] = true
+		}
+		if err := w.Write(header); err != nil {
+			log.Fatal("unable to write CSV output: ", err)
+		}
+		for _, rows := range buffered {
+			writeRows(rows)
+		}
+		buffered = nil
+	}
+
+	for n := range results {
+		rows, err := flattenResult(n)
+		if err != nil {
+			log.Error("unable to parse result for CSV output: ", err)
+			continue
+		}
+		if header == nil {
+			buffered = append(buffered, rows)
+			if cols := dataColumns(rows); len(cols) > 0 {
+				writeHeader(rows)
+			} else if len(buffered) >= maxHeaderBuffer {
+				writeHeader(nil)
+			}
+			continue
+		}
+		writeRows(rows)
+	}
+	if header == nil {
+		writeHeader(nil)
+	}
+	return nil
+}
+
+// register handlers
+func init() {
+	out := new(OutputHandler)
+	zdns.RegisterOutputHandler("csv", out)
+}
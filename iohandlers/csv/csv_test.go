@@ -0,0 +1,57 @@
+package csv
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTopLevelColumns(t *testing.T) {
+	columns := topLevelColumns([]string{"short", ""})
+	expected := []string{"altered_name", "expansion", "name", "unicode_name", "alexa_rank", "columns", "status", "error", "error_detail", "queries", "soa", "full_answer", "timestamp"}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Unexpected short columns. Expected %v, got %v", expected, columns)
+	}
+	columns = topLevelColumns([]string{"long"})
+	expected = []string{"altered_name", "expansion", "name", "unicode_name", "nameserver", "proxy", "class", "alexa_rank", "columns", "status", "error", "error_detail", "queries", "soa", "full_answer", "timestamp"}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Unexpected long columns. Expected %v, got %v", expected, columns)
+	}
+}
+
+func TestFlattenResult(t *testing.T) {
+	line := `{"name":"example.com","status":"NOERROR","data":{"answers":[` +
+		`{"ttl":300,"type":"A","name":"example.com","answer":"192.0.2.1"},` +
+		`{"ttl":300,"type":"A","name":"example.com","answer":"192.0.2.2"}],` +
+		`"authorities":[],"protocol":"udp"}}`
+	rows, err := flattenResult(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("Expected one row per answer, got %d", len(rows))
+	}
+	if rows[1]["name"] != "example.com" || rows[1]["data.answers.answer"] != "192.0.2.2" {
+		t.Errorf("Unexpected row: %v", rows[1])
+	}
+	if rows[0]["data.answers.ttl"] != "300" || rows[0]["data.protocol"] != "udp" || rows[0]["data.authorities"] != "[]" {
+		t.Errorf("Unexpected row: %v", rows[0])
+	}
+
+	// scalar arrays expand into a single column
+	rows, err = flattenResult(`{"name":"example.com","data":{"ipv4_addresses":["192.0.2.1","192.0.2.2"]}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["data.ipv4_addresses"] != "192.0.2.1" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+
+	// results without data produce a single row
+	rows, err = flattenResult(`{"name":"example.com","status":"NXDOMAIN"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0]["status"] != "NXDOMAIN" {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+}
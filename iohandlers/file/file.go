@@ -2,26 +2,65 @@ package file
 
 import (
 	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"strings"
 	"sync"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zdns"
 )
 
+// gzip streams start with these two bytes (RFC 1952)
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decompress returns a reader over r that transparently decompresses gzip
+// data, recognized by a .gz extension or the gzip magic number.
+func decompress(r io.Reader, path string) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if strings.HasSuffix(path, ".gz") || bytes.Equal(magic, gzipMagic) {
+		return gzip.NewReader(br)
+	}
+	return br, nil
+}
+
 type InputHandler struct {
 	filepath string
+	// names passed on the command line, looked up instead of reading stdin
+	names []string
+	// 1-based column of delimited lines that holds the name, or 0 to read
+	// whole lines
+	column      int
+	delimiter   rune
+	passthrough bool
 }
 
 func (h *InputHandler) Initialize(conf *zdns.GlobalConf) {
 	h.filepath = conf.InputFilePath
+	h.names = conf.PassedNames
+	h.column = conf.InputColumn
+	h.delimiter = conf.InputDelimiter
+	h.passthrough = conf.InputPassthrough
 }
 
 func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
 	defer close(in)
 	defer (*wg).Done()
 
+	if len(h.names) > 0 {
+		for _, name := range h.names {
+			in <- name
+		}
+		return nil
+	}
 	var f *os.File
 	if h.filepath == "" || h.filepath == "-" {
 		f = os.Stdin
@@ -32,13 +71,19 @@ func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zo
 			log.Fatal("unable to open input file:", err.Error())
 		}
 	}
+	r, err := decompress(f, h.filepath)
+	if err != nil {
+		log.Fatal("unable to decompress input file:", err.Error())
+	}
 	if zonefileInput {
-		tokens := dns.ParseZone(f, ".", h.filepath)
+		tokens := dns.ParseZone(r, ".", h.filepath)
 		for t := range tokens {
 			in <- t
 		}
+	} else if h.column > 0 {
+		h.feedColumn(r, in)
 	} else {
-		s := bufio.NewScanner(f)
+		s := bufio.NewScanner(r)
 		for s.Scan() {
 			in <- s.Text()
 		}
@@ -49,12 +94,80 @@ func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zo
 	return nil
 }
 
+// feedColumn sends the names in the chosen column of the delimited lines of
+// r, quoted or not, along with their other columns with passthrough. Records
+// that don't parse or lack the column are logged and skipped.
+func (h *InputHandler) feedColumn(r io.Reader, in chan<- interface{}) {
+	cr := csv.NewReader(r)
+	cr.Comma = h.delimiter
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = !h.passthrough
+	for n := 1; ; n++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return
+		}
+		if perr, ok := err.(*csv.ParseError); ok {
+			log.Warnf("skipping input record %d (line %d): %v", n, perr.StartLine, perr.Err)
+			continue
+		} else if err != nil {
+			log.Fatal("input unable to read file", err)
+		}
+		if len(record) < h.column {
+			log.Warnf("skipping input record %d: it has only %d columns", n, len(record))
+			continue
+		}
+		name := strings.TrimSpace(record[h.column-1])
+		if !h.passthrough {
+			in <- name
+			continue
+		}
+		columns := make([]string, 0, len(record)-1)
+		columns = append(columns, record[:h.column-1]...)
+		columns = append(columns, record[h.column:]...)
+		in <- zdns.InputRecord{Name: name, Columns: columns}
+	}
+}
+
+// results longer than this are cut short when logged as invalid
+const maxLoggedResult = 200
+
 type OutputHandler struct {
 	filepath string
+	appendTo bool
+	format   string
+	// how the output stream is compressed: gzip, zstd, or "" for not at all
+	compression string
+	// drop results that aren't valid JSON, counting them in invalid
+	validate bool
+	invalid  int
 }
 
 func (h *OutputHandler) Initialize(conf *zdns.GlobalConf) {
 	h.filepath = conf.OutputFilePath
+	h.format = conf.OutputFormat
+	h.compression = outputCompression(conf.OutputCompression, conf.OutputFilePath)
+	h.validate = conf.ValidateOutput
+	// a resumed scan adds to the output of the interrupted one
+	h.appendTo = conf.Resume
+}
+
+// outputCompression returns how output to path is compressed under the
+// --output-compression setting. With auto, the extension decides.
+func outputCompression(compression string, path string) string {
+	switch compression {
+	case "gzip", "zstd":
+		return compression
+	case "none":
+		return ""
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		return "gzip"
+	case strings.HasSuffix(path, ".zst"):
+		return "zstd"
+	}
+	return ""
 }
 
 func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
@@ -65,18 +178,88 @@ func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup)
 		f = os.Stdout
 	} else {
 		var err error
-		f, err = os.OpenFile(h.filepath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if h.appendTo {
+			mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err = os.OpenFile(h.filepath, mode, 0644)
 		if err != nil {
-			log.Fatal("unable to open output file:", err.Error())
+			return fmt.Errorf("unable to open output file: %v", err)
 		}
 		defer f.Close()
 	}
-	for n := range results {
-		f.WriteString(n + "\n")
+	var w io.Writer = f
+	var zw io.WriteCloser
+	switch h.compression {
+	case "gzip":
+		zw = gzip.NewWriter(f)
+	case "zstd":
+		// the encoder only fails on invalid options
+		zw, _ = zstd.NewWriter(f)
+	}
+	if zw != nil {
+		w = zw
+	}
+	if h.validate {
+		results = h.validResults(results)
+	}
+	if h.format == "json-array" {
+		writeArray(w, results)
+	} else {
+		for n := range results {
+			io.WriteString(w, n+"\n")
+		}
+	}
+	if h.invalid > 0 {
+		log.Warnf("%d results weren't valid JSON and weren't written", h.invalid)
+	}
+	// the end of the stream is only written on Close
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return fmt.Errorf("unable to finish compressed output: %v", err)
+		}
 	}
 	return nil
 }
 
+// validResults passes on the results that are valid JSON. The others are
+// logged, counted, and dropped.
+func (h *OutputHandler) validResults(results <-chan string) <-chan string {
+	valid := make(chan string)
+	go func() {
+		defer close(valid)
+		for n := range results {
+			if json.Valid([]byte(n)) {
+				valid <- n
+				continue
+			}
+			h.invalid++
+			if len(n) > maxLoggedResult {
+				n = n[:maxLoggedResult] + "..."
+			}
+			log.Warn("dropping result that isn't valid JSON: ", n)
+		}
+	}()
+	return valid
+}
+
+// InvalidResults returns the number of results dropped by --validate-output.
+func (h *OutputHandler) InvalidResults() int {
+	return h.invalid
+}
+
+// writeArray streams results as the elements of a single JSON array, one
+// element per line. The closing bracket is written once results is closed.
+func writeArray(w io.Writer, results <-chan string) {
+	io.WriteString(w, "[")
+	sep := "\n"
+	for n := range results {
+		io.WriteString(w, sep+n)
+		sep = ",\n"
+	}
+	io.WriteString(w, "\n]\n")
+}
+
 // register handlers
 func init() {
 	in := new(InputHandler)
@@ -0,0 +1,203 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/zmap/zdns"
+)
+
+// zstd frames start with these four bytes (RFC 8878, section 3.1.1)
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+func TestDecompress(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("example.com\nexample.org\n"))
+	w.Close()
+
+	for _, path := range []string{"names.gz", "names.txt", "-"} {
+		r, err := decompress(bytes.NewReader(gz.Bytes()), path)
+		if err != nil {
+			t.Fatalf("Unexpected error for %s: %v", path, err)
+		}
+		out, _ := ioutil.ReadAll(r)
+		if string(out) != "example.com\nexample.org\n" {
+			t.Errorf("Unexpected gzip input read from %s: %q", path, out)
+		}
+	}
+
+	r, err := decompress(strings.NewReader("example.com\n"), "names.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := ioutil.ReadAll(r); string(out) != "example.com\n" {
+		t.Errorf("Unexpected plain input: %q", out)
+	}
+	if _, err := decompress(strings.NewReader("example.com\n"), "names.gz"); err == nil {
+		t.Error("Expected plain text in a .gz file to be rejected")
+	}
+	if _, err := decompress(strings.NewReader(""), "-"); err != nil {
+		t.Errorf("Unexpected error for empty input: %v", err)
+	}
+}
+
+func TestWriteArray(t *testing.T) {
+	for _, results := range [][]string{
+		{},
+		{`{"name":"example.com"}`},
+		{`{"name":"example.com"}`, `{"name":"example.org"}`, `{"name":"example.net"}`},
+	} {
+		ch := make(chan string, len(results))
+		for _, r := range results {
+			ch <- r
+		}
+		close(ch)
+		var buf bytes.Buffer
+		writeArray(&buf, ch)
+		var parsed []map[string]string
+		if err := json.Unmarshal(buf.Bytes(), &parsed); err != nil {
+			t.Fatalf("Invalid JSON array %q: %v", buf.String(), err)
+		}
+		if len(parsed) != len(results) {
+			t.Errorf("Expected %d elements, got %q", len(results), buf.String())
+		}
+	}
+}
+
+func TestFeedPassedNames(t *testing.T) {
+	h := InputHandler{filepath: "-", names: []string{"example.com", "example.org"}}
+	in := make(chan interface{}, 2)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	h.FeedChannel(in, &wg, false)
+	wg.Wait()
+	var names []string
+	for name := range in {
+		names = append(names, name.(string))
+	}
+	if len(names) != 2 || names[0] != "example.com" || names[1] != "example.org" {
+		t.Errorf("Expected the passed names in order, got %v", names)
+	}
+}
+
+func TestFeedColumn(t *testing.T) {
+	input := "1,\"Example, Inc.\",example.com\n" +
+		"2,\"multi\nline\",\" example.org \"\n" +
+		"3,too short\n" +
+		"4,\"bad\"quote,example.net\n" +
+		"5,\"say \"\"hi\"\"\",example.edu\n"
+
+	h := InputHandler{column: 3, delimiter: ','}
+	in := make(chan interface{}, 10)
+	h.feedColumn(strings.NewReader(input), in)
+	close(in)
+	var names []string
+	for name := range in {
+		names = append(names, name.(string))
+	}
+	if strings.Join(names, " ") != "example.com example.org example.edu" {
+		t.Errorf("Unexpected names %q", names)
+	}
+
+	h = InputHandler{column: 2, delimiter: '\t', passthrough: true}
+	in = make(chan interface{}, 10)
+	h.feedColumn(strings.NewReader("a\texample.com\tb\n"), in)
+	close(in)
+	r := (<-in).(zdns.InputRecord)
+	if r.Name != "example.com" || len(r.Columns) != 2 || r.Columns[0] != "a" || r.Columns[1] != "b" {
+		t.Errorf("Unexpected record %+v", r)
+	}
+}
+
+func TestValidateOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zdns-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "out.json")
+	h := OutputHandler{filepath: path, format: "jsonl", validate: true}
+	results := make(chan string, 3)
+	results <- `{"name":"example.com"}`
+	results <- `{"name":"exam`
+	results <- `{"name":"example.org"}`
+	close(results)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	if err := h.WriteResults(results, &wg); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadFile(path)
+	if string(out) != "{\"name\":\"example.com\"}\n{\"name\":\"example.org\"}\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if h.InvalidResults() != 1 {
+		t.Errorf("Expected 1 invalid result, got %d", h.InvalidResults())
+	}
+}
+
+func TestOutputCompression(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zdns-output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cases := []struct {
+		compression string
+		file        string
+		gzipped     bool
+		zstd        bool
+	}{
+		{"auto", "out.json", false, false},
+		{"auto", "out.json.gz", true, false},
+		{"gzip", "out.json", true, false},
+		{"none", "out.json.gz", false, false},
+		{"auto", "out.json.zst", false, true},
+		{"zstd", "out.json", false, true},
+	}
+	for _, c := range cases {
+		path := filepath.Join(dir, c.file)
+		var h OutputHandler
+		h.Initialize(&zdns.GlobalConf{OutputFilePath: path, OutputFormat: "jsonl", OutputCompression: c.compression})
+		results := make(chan string, 1)
+		results <- `{"name":"example.com"}`
+		close(results)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		if err := h.WriteResults(results, &wg); err != nil {
+			t.Fatal(err)
+		}
+		raw, _ := ioutil.ReadFile(path)
+		if gzipped := bytes.HasPrefix(raw, gzipMagic); gzipped != c.gzipped {
+			t.Errorf("%s to %s: expected gzipped %v, got %q", c.compression, c.file, c.gzipped, raw)
+			continue
+		}
+		if zstded := bytes.HasPrefix(raw, zstdMagic); zstded != c.zstd {
+			t.Errorf("%s to %s: expected zstd %v, got %q", c.compression, c.file, c.zstd, raw)
+			continue
+		}
+		var r io.Reader
+		var err error
+		if c.zstd {
+			r, err = zstd.NewReader(bytes.NewReader(raw))
+		} else {
+			r, err = decompress(bytes.NewReader(raw), "")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, _ := ioutil.ReadAll(r); string(out) != "{\"name\":\"example.com\"}\n" {
+			t.Errorf("%s to %s: unexpected output %q", c.compression, c.file, out)
+		}
+	}
+}
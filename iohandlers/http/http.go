@@ -0,0 +1,249 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdhttp "net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zdns"
+)
+
+// how many full batches may wait for delivery before writing results blocks
+const maxPendingBatches = 4
+
+// how often a batch is sent before it is given up on
+const maxAttempts = 5
+
+type OutputHandler struct {
+	url           string
+	batchSize     int
+	flushInterval time.Duration
+	// wait before the first retry, doubled for each one after it
+	retryBackoff time.Duration
+	client       *stdhttp.Client
+}
+
+func (h *OutputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.url = conf.HTTPOutputURL
+	h.batchSize = conf.HTTPBatchSize
+	h.flushInterval = conf.HTTPFlushInterval
+	h.retryBackoff = time.Second
+	h.client = &stdhttp.Client{Timeout: conf.HTTPTimeout}
+}
+
+// retryable is a delivery failure that may succeed when tried again
+type retryable struct {
+	error
+}
+
+// post sends one batch of results as a JSON array.
+func (h *OutputHandler) post(batch []string) error {
+	body := "[" + strings.Join(batch, ",") + "]"
+	resp, err := h.client.Post(h.url, "application/json", strings.NewReader(body))
+	if err != nil {
+		return retryable{err}
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	err = fmt.Errorf("%s returned %s", h.url, resp.Status)
+	if resp.StatusCode >= 500 || resp.StatusCode == stdhttp.StatusTooManyRequests {
+		return retryable{err}
+	}
+	return err
+}
+
+// deliver posts a batch, retrying with exponential backoff while the
+// failures look temporary.
+func (h *OutputHandler) deliver(batch []string) error {
+	backoff := h.retryBackoff
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = h.post(batch); err == nil {
+			return nil
+		}
+		if _, ok := err.(retryable); !ok || attempt == maxAttempts {
+			break
+		}
+		log.Warn("unable to post results (attempt ", attempt, " of ", maxAttempts, "): ", err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	return err
+}
+
+// send delivers batches until the channel is closed and returns the number
+// of batches and results that couldn't be delivered.
+func (h *OutputHandler) send(batches <-chan []string) (failedBatches int, failedResults int) {
+	for batch := range batches {
+		if err := h.deliver(batch); err != nil {
+			log.Error("giving up on posting ", len(batch), " results: ", err)
+			failedBatches++
+			failedResults += len(batch)
+		}
+	}
+	return failedBatches, failedResults
+}
+
+// batch groups results into batches of up to batchSize, handing over a
+// partial batch once it has waited for flushInterval. The pending batches
+// are bounded, so a slow endpoint holds up the scan instead of having results
+// pile up in memory.
+func (h *OutputHandler) batch(results <-chan string, batches chan<- []string) {
+	defer close(batches)
+	var ticker <-chan time.Time
+	if h.flushInterval > 0 {
+		t := time.NewTicker(h.flushInterval)
+		defer t.Stop()
+		ticker = t.C
+	}
+	pending := make([]string, 0, h.batchSize)
+	flush := func() {
+		if len(pending) > 0 {
+			batches <- pending
+			pending = make([]string, 0, h.batchSize)
+		}
+	}
+	for {
+		select {
+		case n, ok := <-results:
+			if !ok {
+				flush()
+				return
+			}
+			pending = append(pending, n)
+			if len(pending) >= h.batchSize {
+				flush()
+			}
+		case <-ticker:
+			flush()
+		}
+	}
+}
+
+func (h *OutputHandler) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	batches := make(chan []string, maxPendingBatches)
+	go h.batch(results, batches)
+	if failedBatches, failedResults := h.send(batches); failedBatches > 0 {
+		return fmt.Errorf("%d batches with %d results could not be posted to %s", failedBatches, failedResults, h.url)
+	}
+	return nil
+}
+
+type InputHandler struct {
+	url string
+	// user:password for basic authentication
+	basicAuth string
+	token     string
+	client    *stdhttp.Client
+}
+
+func (h *InputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.url = conf.HTTPInputURL
+	h.basicAuth = conf.HTTPInputBasicAuth
+	h.token = conf.HTTPInputToken
+	h.client = &stdhttp.Client{}
+}
+
+// open requests the name list and returns its body, decompressed if it was
+// sent with gzip Content-Encoding or is itself a gzip file.
+func (h *InputHandler) open() (io.ReadCloser, error) {
+	req, err := stdhttp.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	// asking for gzip ourselves turns off the client's transparent
+	// decompression, which would otherwise hide the Content-Encoding
+	req.Header.Set("Accept-Encoding", "gzip")
+	if h.basicAuth != "" {
+		user, password := h.basicAuth, ""
+		if i := strings.Index(h.basicAuth, ":"); i >= 0 {
+			user, password = h.basicAuth[:i], h.basicAuth[i+1:]
+		}
+		req.SetBasicAuth(user, password)
+	}
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s returned %s", h.url, resp.Status)
+	}
+	body := resp.Body
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		body = readCloser{gz, body}
+	}
+	br := bufio.NewReader(body)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			body.Close()
+			return nil, err
+		}
+		return readCloser{gz, body}, nil
+	}
+	return readCloser{br, body}, nil
+}
+
+// readCloser reads from a decompressor, or buffer, over a response body
+// and closes the body.
+type readCloser struct {
+	io.Reader
+	body io.Closer
+}
+
+func (r readCloser) Close() error {
+	return r.body.Close()
+}
+
+func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
+	defer close(in)
+	defer (*wg).Done()
+
+	if zonefileInput {
+		log.Fatal("the http input handler does not support zone file input")
+	}
+	body, err := h.open()
+	if err != nil {
+		log.Fatal("unable to read input from ", h.url, ": ", err.Error())
+	}
+	defer body.Close()
+	s := bufio.NewScanner(body)
+	for s.Scan() {
+		in <- s.Text()
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal("unable to read input from ", h.url, ": ", err.Error())
+	}
+	return nil
+}
+
+// register handlers
+func init() {
+	in := new(InputHandler)
+	zdns.RegisterInputHandler("http", in)
+
+	out := new(OutputHandler)
+	zdns.RegisterOutputHandler("http", out)
+}
@@ -0,0 +1,165 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	stdhttp "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zmap/zdns"
+)
+
+func TestWriteResults(t *testing.T) {
+	var mu sync.Mutex
+	var received [][]map[string]string
+	failures := 1
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			w.WriteHeader(stdhttp.StatusServiceUnavailable)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var batch []map[string]string
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Errorf("Invalid JSON array %q: %v", body, err)
+		}
+		received = append(received, batch)
+	}))
+	defer srv.Close()
+
+	h := &OutputHandler{url: srv.URL, batchSize: 2, client: srv.Client(), retryBackoff: time.Millisecond}
+	results := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go h.WriteResults(results, &wg)
+	for _, name := range []string{"a.example", "b.example", "c.example"} {
+		results <- `{"name":"` + name + `"}`
+	}
+	close(results)
+	wg.Wait()
+
+	if len(received) != 2 || len(received[0]) != 2 || len(received[1]) != 1 {
+		t.Fatalf("Expected batches of 2 and 1 results, got %v", received)
+	}
+	if received[1][0]["name"] != "c.example" {
+		t.Errorf("Unexpected last result: %v", received[1][0])
+	}
+}
+
+func TestDeliverClientError(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		requests++
+		w.WriteHeader(stdhttp.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	h := &OutputHandler{url: srv.URL, batchSize: 1, client: srv.Client(), retryBackoff: time.Millisecond}
+	if err := h.deliver([]string{`{}`}); err == nil {
+		t.Error("Expected rejected batch to fail")
+	}
+	if requests != 1 {
+		t.Errorf("Expected client errors not to be retried, got %d requests", requests)
+	}
+}
+
+func TestDeliverTimeout(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		mu.Lock()
+		requests++
+		first := requests == 1
+		mu.Unlock()
+		if first {
+			// outlasts --http-timeout, but not the DNS timeout
+			time.Sleep(500 * time.Millisecond)
+		}
+	}))
+	defer srv.Close()
+
+	h := new(OutputHandler)
+	h.Initialize(&zdns.GlobalConf{HTTPOutputURL: srv.URL, HTTPBatchSize: 1, Timeout: time.Minute, HTTPTimeout: 100 * time.Millisecond})
+	h.retryBackoff = time.Millisecond
+	if err := h.deliver([]string{`{}`}); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 {
+		t.Errorf("Expected the request that timed out to be retried, got %d requests", requests)
+	}
+}
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(s))
+	gz.Close()
+	return b.Bytes()
+}
+
+func TestInputOpen(t *testing.T) {
+	names := "a.example\nb.example\n"
+	srv := httptest.NewServer(stdhttp.HandlerFunc(func(w stdhttp.ResponseWriter, r *stdhttp.Request) {
+		switch r.URL.Path {
+		case "/basic":
+			if user, password, ok := r.BasicAuth(); !ok || user != "zdns" || password != "secret" {
+				w.WriteHeader(stdhttp.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(names))
+		case "/bearer":
+			if r.Header.Get("Authorization") != "Bearer t0ken" {
+				w.WriteHeader(stdhttp.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(names))
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(gzipped(names))
+		case "/names.gz":
+			w.Write(gzipped(names))
+		default:
+			w.WriteHeader(stdhttp.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for _, h := range []*InputHandler{
+		{url: srv.URL + "/basic", basicAuth: "zdns:secret"},
+		{url: srv.URL + "/bearer", token: "t0ken"},
+		{url: srv.URL + "/encoded"},
+		{url: srv.URL + "/names.gz"},
+	} {
+		h.client = srv.Client()
+		body, err := h.open()
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", h.url, err)
+			continue
+		}
+		b, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil || string(b) != names {
+			t.Errorf("Unexpected body for %s: %q, %v", h.url, b, err)
+		}
+	}
+
+	for _, h := range []*InputHandler{
+		{url: srv.URL + "/basic", basicAuth: "zdns:wrong"},
+		{url: srv.URL + "/missing"},
+	} {
+		h.client = srv.Client()
+		if _, err := h.open(); err == nil {
+			t.Errorf("Expected an error for %s", h.url)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zdns"
+)
+
+// how long to wait for the server beyond the BLPOP timeout before giving up
+const replySlack = 10 * time.Second
+
+// conn is a minimal client for the subset of the Redis protocol (RESP) that
+// the input handler needs.
+type conn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+func dial(addr string, timeout time.Duration) (*conn, error) {
+	c, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{c: c, r: bufio.NewReader(c)}, nil
+}
+
+func (c *conn) Close() error {
+	return c.c.Close()
+}
+
+// do sends a command and returns its reply. A reply of nil means the server
+// returned a null bulk string or array.
+func (c *conn) do(deadline time.Duration, args ...string) (interface{}, error) {
+	if deadline > 0 {
+		c.c.SetDeadline(time.Now().Add(deadline))
+	} else {
+		c.c.SetDeadline(time.Time{})
+	}
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(arg))+"\r\n"...)
+		buf = append(buf, arg...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.c.Write(buf); err != nil {
+		return nil, err
+	}
+	return c.readReply()
+}
+
+func (c *conn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return "", errors.New("malformed reply from redis server")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *conn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = c.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply type %q from redis server", line[0])
+	}
+}
+
+type InputHandler struct {
+	addr        string
+	password    string
+	key         string
+	idleTimeout time.Duration
+	dialTimeout time.Duration
+}
+
+func (h *InputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.addr = conf.RedisAddr
+	h.password = conf.RedisPassword
+	h.key = conf.RedisKey
+	h.idleTimeout = conf.RedisIdleTimeout
+	h.dialTimeout = conf.Timeout
+}
+
+// blpopTimeout converts the idle timeout into the whole number of seconds
+// BLPOP expects. Zero blocks forever.
+func blpopTimeout(idle time.Duration) int {
+	if idle <= 0 {
+		return 0
+	}
+	return int((idle + time.Second - 1) / time.Second)
+}
+
+func (h *InputHandler) feed(c *conn, in chan<- interface{}) error {
+	if h.password != "" {
+		if _, err := c.do(h.dialTimeout, "AUTH", h.password); err != nil {
+			return err
+		}
+	}
+	timeout := blpopTimeout(h.idleTimeout)
+	var deadline time.Duration
+	if timeout > 0 {
+		deadline = time.Duration(timeout)*time.Second + replySlack
+	}
+	for {
+		reply, err := c.do(deadline, "BLPOP", h.key, strconv.Itoa(timeout))
+		if err != nil {
+			return err
+		}
+		if reply == nil {
+			// the list stayed empty for the whole idle timeout
+			log.Info("redis input list ", h.key, " idle for ", h.idleTimeout, ", finishing")
+			return nil
+		}
+		elems, ok := reply.([]interface{})
+		if !ok || len(elems) != 2 {
+			return errors.New("unexpected BLPOP reply from redis server")
+		}
+		name, ok := elems[1].(string)
+		if !ok {
+			return errors.New("unexpected BLPOP reply from redis server")
+		}
+		in <- name
+	}
+}
+
+func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
+	defer close(in)
+	defer (*wg).Done()
+
+	if zonefileInput {
+		log.Fatal("the redis input handler does not support zone file input")
+	}
+	c, err := dial(h.addr, h.dialTimeout)
+	if err != nil {
+		log.Fatal("unable to connect to redis server: ", err.Error())
+	}
+	defer c.Close()
+	if err := h.feed(c, in); err != nil {
+		log.Fatal("unable to read input from redis: ", err.Error())
+	}
+	return nil
+}
+
+// register handlers
+func init() {
+	in := new(InputHandler)
+	zdns.RegisterInputHandler("redis", in)
+}
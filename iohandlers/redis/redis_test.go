@@ -0,0 +1,97 @@
+package redis
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer answers each command read from the connection with the next
+// canned reply and records the commands it saw.
+func fakeServer(t *testing.T, replies []string) (string, *[]string, *sync.WaitGroup) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var commands []string
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		r := bufio.NewReader(c)
+		for _, reply := range replies {
+			header, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			var n int
+			for i := 1; header[i] != '\r'; i++ {
+				n = n*10 + int(header[i]-'0')
+			}
+			var args []string
+			for i := 0; i < n; i++ {
+				r.ReadString('\n')
+				arg, _ := r.ReadString('\n')
+				args = append(args, strings.TrimSuffix(arg, "\r\n"))
+			}
+			commands = append(commands, strings.Join(args, " "))
+			c.Write([]byte(reply))
+		}
+	}()
+	return l.Addr().String(), &commands, &wg
+}
+
+func TestFeedUntilIdle(t *testing.T) {
+	addr, commands, wg := fakeServer(t, []string{
+		"+OK\r\n",
+		"*2\r\n$5\r\nnames\r\n$11\r\nexample.com\r\n",
+		"*2\r\n$5\r\nnames\r\n$11\r\nexample.org\r\n",
+		"*-1\r\n",
+	})
+	h := &InputHandler{addr: addr, password: "secret", key: "names", idleTimeout: 1500 * time.Millisecond, dialTimeout: time.Second}
+	c, err := dial(h.addr, h.dialTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	in := make(chan interface{}, 10)
+	if err := h.feed(c, in); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	close(in)
+	var names []string
+	for n := range in {
+		names = append(names, n.(string))
+	}
+	wg.Wait()
+	if strings.Join(names, ",") != "example.com,example.org" {
+		t.Errorf("Unexpected names: %v", names)
+	}
+	expected := []string{"AUTH secret", "BLPOP names 2", "BLPOP names 2", "BLPOP names 2"}
+	if strings.Join(*commands, ",") != strings.Join(expected, ",") {
+		t.Errorf("Unexpected commands. Expected %v, got %v", expected, *commands)
+	}
+}
+
+func TestFeedError(t *testing.T) {
+	addr, _, wg := fakeServer(t, []string{"-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"})
+	h := &InputHandler{addr: addr, key: "names", dialTimeout: time.Second}
+	c, err := dial(h.addr, h.dialTimeout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := h.feed(c, make(chan interface{}, 1)); err == nil || !strings.HasPrefix(err.Error(), "WRONGTYPE") {
+		t.Errorf("Expected WRONGTYPE error, got %v", err)
+	}
+	wg.Wait()
+}
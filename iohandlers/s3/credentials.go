@@ -0,0 +1,198 @@
+package s3
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdhttp "net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// how long to wait for the instance metadata service, which doesn't answer at
+// all outside of EC2
+const metadataTimeout = 2 * time.Second
+
+const imdsHost = "http://169.254.169.254"
+
+type credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// credentialProvider looks up credentials from one source. It returns nil
+// credentials and no error if the source isn't configured.
+type credentialProvider func() (*credentials, error)
+
+// loadCredentials looks for static keys in the environment, then in the
+// AWS_PROFILE profile of the shared credentials file, then for the role of
+// the EC2 instance. This is a subset of the AWS SDKs' chain: profiles that
+// assume a role, SSO, credential_process, and container credentials aren't
+// supported. Nil credentials mean none were found, in which case requests are
+// sent unsigned, as for public objects.
+func loadCredentials() (*credentials, error) {
+	for _, provider := range []credentialProvider{envCredentials, sharedCredentials, instanceCredentials} {
+		creds, err := provider()
+		if err != nil {
+			return nil, err
+		}
+		if creds != nil {
+			return creds, nil
+		}
+	}
+	return nil, nil
+}
+
+func envCredentials() (*credentials, error) {
+	id := os.Getenv("AWS_ACCESS_KEY_ID")
+	if id == "" {
+		id = os.Getenv("AWS_ACCESS_KEY")
+	}
+	secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if secret == "" {
+		secret = os.Getenv("AWS_SECRET_KEY")
+	}
+	if id == "" || secret == "" {
+		return nil, nil
+	}
+	return &credentials{AccessKeyID: id, SecretAccessKey: secret, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+}
+
+func sharedCredentials() (*credentials, error) {
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+	return parseSharedCredentials(f, profile)
+}
+
+// parseSharedCredentials reads the keys of profile from an INI-style
+// credentials file.
+func parseSharedCredentials(r io.Reader, profile string) (*credentials, error) {
+	var creds credentials
+	section := ""
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "aws_access_key_id":
+			creds.AccessKeyID = value
+		case "aws_secret_access_key":
+			creds.SecretAccessKey = value
+		case "aws_session_token":
+			creds.SessionToken = value
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, nil
+	}
+	return &creds, nil
+}
+
+// the credentials document served by the instance metadata service
+type metadataCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+func getMetadata(req *stdhttp.Request) ([]byte, error) {
+	client := stdhttp.Client{Timeout: metadataTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != stdhttp.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return body, nil
+}
+
+func decodeMetadataCredentials(body []byte) (*credentials, error) {
+	var m metadataCredentials
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, err
+	}
+	if m.AccessKeyID == "" || m.SecretAccessKey == "" {
+		return nil, errors.New("metadata endpoint returned no credentials")
+	}
+	return &credentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey, SessionToken: m.Token}, nil
+}
+
+// instanceCredentials asks the EC2 instance metadata service (IMDSv2) for
+// the credentials of the instance's role. Anywhere else the service doesn't
+// answer, which means there are no credentials.
+func instanceCredentials() (*credentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return nil, nil
+	}
+	req, _ := stdhttp.NewRequest("PUT", imdsHost+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	token, err := getMetadata(req)
+	if err != nil {
+		return nil, nil
+	}
+	get := func(path string) ([]byte, error) {
+		req, _ := stdhttp.NewRequest("GET", imdsHost+path, nil)
+		req.Header.Set("X-aws-ec2-metadata-token", string(token))
+		return getMetadata(req)
+	}
+	roles, err := get("/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		// an instance without a role
+		return nil, nil
+	}
+	role := strings.TrimSpace(strings.SplitN(string(roles), "\n", 2)[0])
+	if role == "" {
+		return nil, nil
+	}
+	body, err := get("/latest/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get instance credentials: %v", err)
+	}
+	return decodeMetadataCredentials(body)
+}
@@ -0,0 +1,266 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	stdhttp "net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zmap/zdns"
+)
+
+// how often a broken download is resumed before giving up
+const maxResumes = 5
+
+// the payload hash of requests whose body isn't signed
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// parseS3URL splits an s3://bucket/key URL.
+func parseS3URL(s string) (bucket string, key string, err error) {
+	if !strings.HasPrefix(s, "s3://") {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key URL", s)
+	}
+	parts := strings.SplitN(strings.TrimPrefix(s, "s3://"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q is not an s3://bucket/key URL", s)
+	}
+	return parts[0], parts[1], nil
+}
+
+// escapePath percent-encodes an object key the way SigV4 expects: every
+// byte other than unreserved characters and the / separators.
+func escapePath(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to a request
+// without a body, covering the host and every header already set.
+func sign(req *stdhttp.Request, creds *credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if req.Header.Get("X-Amz-Content-Sha256") == "" {
+		req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapePath(req.URL.Path),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+	scope := day + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// object streams an S3 object, resuming with a range request from where it
+// broke off if the download fails part way.
+type object struct {
+	url    string
+	region string
+	creds  *credentials
+	client *stdhttp.Client
+
+	body    io.ReadCloser
+	offset  int64
+	resumes int
+}
+
+func (o *object) open() error {
+	req, err := stdhttp.NewRequest("GET", o.url, nil)
+	if err != nil {
+		return err
+	}
+	// the offset counts bytes as stored, so the transport mustn't
+	// decompress them on the fly
+	req.Header.Set("Accept-Encoding", "identity")
+	if o.offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", o.offset))
+	}
+	if o.creds != nil {
+		sign(req, o.creds, o.region, time.Now())
+	}
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != stdhttp.StatusOK && resp.StatusCode != stdhttp.StatusPartialContent {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return fmt.Errorf("%s returned %s: %s", o.url, resp.Status, bytes.TrimSpace(msg))
+	}
+	o.body = resp.Body
+	return nil
+}
+
+func (o *object) Read(p []byte) (int, error) {
+	for {
+		n, err := o.body.Read(p)
+		o.offset += int64(n)
+		if err == nil || err == io.EOF || n > 0 {
+			return n, err
+		}
+		if o.resumes >= maxResumes {
+			return 0, err
+		}
+		o.resumes++
+		log.Warn("download of ", o.url, " interrupted at byte ", o.offset, ", resuming: ", err)
+		o.body.Close()
+		if err := o.open(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (o *object) Close() error {
+	return o.body.Close()
+}
+
+type InputHandler struct {
+	input    string
+	region   string
+	endpoint string
+}
+
+func (h *InputHandler) Initialize(conf *zdns.GlobalConf) {
+	h.input = conf.S3Input
+	h.region = conf.S3Region
+	h.endpoint = conf.S3Endpoint
+}
+
+// objectURL builds the HTTPS URL of the object. Buckets are addressed
+// virtual-hosted style, except on custom endpoints and for bucket names with
+// dots, which don't match the wildcard certificate.
+func (h *InputHandler) objectURL(bucket string, key string) string {
+	path := "/" + escapePath(key)
+	if h.endpoint != "" {
+		return strings.TrimSuffix(h.endpoint, "/") + "/" + bucket + path
+	}
+	if strings.Contains(bucket, ".") {
+		return "https://s3." + h.region + ".amazonaws.com/" + bucket + path
+	}
+	return "https://" + bucket + ".s3." + h.region + ".amazonaws.com" + path
+}
+
+func (h *InputHandler) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
+	defer close(in)
+	defer (*wg).Done()
+
+	if zonefileInput {
+		log.Fatal("the s3 input handler does not support zone file input")
+	}
+	bucket, key, err := parseS3URL(h.input)
+	if err != nil {
+		log.Fatal("invalid --s3-input: ", err.Error())
+	}
+	region := h.region
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	h.region = region
+	creds, err := loadCredentials()
+	if err != nil {
+		log.Fatal("unable to load AWS credentials: ", err.Error())
+	}
+	if creds == nil {
+		log.Warn("no AWS credentials found, reading ", h.input, " anonymously")
+	}
+	o := &object{
+		url:    h.objectURL(bucket, key),
+		region: region,
+		creds:  creds,
+		client: &stdhttp.Client{},
+	}
+	if err := o.open(); err != nil {
+		log.Fatal("unable to read input from s3: ", err.Error())
+	}
+	defer o.Close()
+
+	br := bufio.NewReader(o)
+	var r io.Reader = br
+	magic, _ := br.Peek(2)
+	if strings.HasSuffix(key, ".gz") || bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		if r, err = gzip.NewReader(br); err != nil {
+			log.Fatal("unable to decompress input from s3: ", err.Error())
+		}
+	}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		in <- s.Text()
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal("unable to read input from s3: ", err.Error())
+	}
+	return nil
+}
+
+// register handlers
+func init() {
+	in := new(InputHandler)
+	zdns.RegisterInputHandler("s3", in)
+}
@@ -15,11 +15,19 @@
 package zdns
 
 import (
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/hashicorp/go-version"
@@ -31,6 +39,29 @@ import (
 type routineMetadata struct {
 	Names  int
 	Status map[Status]int
+	// results left out by --sample-rate
+	SampledOut int
+}
+
+// an input line (or zone file token) along with its position in the input
+type lookupInput struct {
+	index int
+	// the order in which inputs are handed out, for --preserve-order
+	seq   int
+	input interface{}
+	// the name was already read earlier in the scan (--dedupe-input)
+	duplicate bool
+	// the name is excluded by --name-allowlist or --name-blocklist
+	filtered bool
+}
+
+// the serialized results for an input, one per name looked up for it.
+// Lookups that produce no output still report back so that their input can
+// be marked as processed.
+type lookupOutput struct {
+	index   int
+	seq     int
+	results []string
 }
 
 func GetDNSServers(path string) ([]string, error) {
@@ -49,6 +80,179 @@ func GetDNSServers(path string) ([]string, error) {
 	return servers, nil
 }
 
+// ParseClientSubnet converts a CIDR string into an EDNS0 client subnet option.
+// A zero-length prefix (e.g., 0.0.0.0/0) is valid and asks the resolver not to
+// use the client's address when answering.
+func ParseClientSubnet(cidr string) (*dns.EDNS0_SUBNET, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, err
+	}
+	ones, bits := ipNet.Mask.Size()
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+		SourceScope:   0,
+	}
+	switch bits {
+	case net.IPv4len * 8:
+		subnet.Family = 1
+		subnet.Address = ipNet.IP.To4()
+	case net.IPv6len * 8:
+		subnet.Family = 2
+		subnet.Address = ipNet.IP.To16()
+	default:
+		return nil, errors.New("unsupported address family")
+	}
+	return subnet, nil
+}
+
+// ParseEDNSOption converts a code:hexvalue string into a raw EDNS0 option.
+// Codes 0 and 65535 are reserved (RFC 6891, section 9) and can't be sent.
+func ParseEDNSOption(s string) (*dns.EDNS0_LOCAL, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("expected code:hexvalue")
+	}
+	code, err := strconv.ParseUint(parts[0], 10, 16)
+	if err != nil || code == 0 || code == 65535 {
+		return nil, fmt.Errorf("option code %s is not between 1 and 65534", parts[0])
+	}
+	data, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid hex value: %v", err)
+	}
+	// the option's length, like the whole OPT record's, must fit in 16 bits
+	if len(data) > dns.MaxMsgSize-4 {
+		return nil, fmt.Errorf("value of %d bytes is too long", len(data))
+	}
+	return &dns.EDNS0_LOCAL{Code: uint16(code), Data: data}, nil
+}
+
+// ParseLocalAddrs parses a comma-separated list of local IP addresses to send
+// queries from, and checks that each of the given name servers can be reached
+// from at least one of them, i.e., that an address of its family was given.
+func ParseLocalAddrs(list string, nameServers []string) ([]net.IP, error) {
+	var addrs []net.IP
+	haveV4, haveV6 := false, false
+	for _, a := range strings.Split(list, ",") {
+		ip := net.ParseIP(strings.TrimSpace(a))
+		if ip == nil {
+			return nil, errors.New("invalid local address: " + a)
+		}
+		if ip.To4() != nil {
+			haveV4 = true
+		} else {
+			haveV6 = true
+		}
+		addrs = append(addrs, ip)
+	}
+	for _, ns := range nameServers {
+		_, ns = SplitTransport(ns)
+		host, _, err := net.SplitHostPort(ns)
+		if err != nil {
+			host = ns
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil && !haveV4 {
+			return nil, errors.New("no IPv4 local address given for name server " + ns)
+		} else if ip.To4() == nil && !haveV6 {
+			return nil, errors.New("no IPv6 local address given for name server " + ns)
+		}
+	}
+	return addrs, nil
+}
+
+// ParseStatuses parses a comma-separated list of result statuses, which may
+// be given in any case.
+func ParseStatuses(list string) ([]Status, error) {
+	var statuses []Status
+	for _, s := range strings.Split(list, ",") {
+		status := Status(strings.ToUpper(strings.TrimSpace(s)))
+		if !knownStatus(status) {
+			return nil, errors.New("unknown status: " + s)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func knownStatus(status Status) bool {
+	if _, ok := dns.StringToRcode[string(status)]; ok {
+		return true
+	}
+	for _, s := range otherStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// isNegativeStatus reports whether a lookup with status found that the name,
+// or the records asked for, don't exist.
+func isNegativeStatus(status Status) bool {
+	switch status {
+	case STATUS_NXDOMAIN, STATUS_NODATA, STATUS_NO_ANSWER, STATUS_NO_RECORD:
+		return true
+	}
+	return false
+}
+
+// sampled reports whether --sample-rate keeps the result for name. The
+// choice depends only on the name and seed, so a scan is sampled the same way
+// whatever order its lookups finish in.
+func sampled(name string, rate float64, seed int64) bool {
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(seed))
+	h.Write(b[:])
+	h.Write([]byte(strings.ToLower(name)))
+	// mix the bits (the splitmix64 finalizer), since FNV leaves names that
+	// differ only in their last characters close together
+	x := h.Sum64()
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11)/(1<<53) < rate
+}
+
+// filteredOut reports whether --filter-status drops results with status.
+func filteredOut(status Status, keep []Status) bool {
+	if len(keep) == 0 {
+		return false
+	}
+	for _, s := range keep {
+		if s == status {
+			return false
+		}
+	}
+	return true
+}
+
+// InputRecord is an input name along with the other columns of its line,
+// which input handlers send in place of the bare name with
+// --input-passthrough.
+type InputRecord struct {
+	Name    string
+	Columns []string
+}
+
+// inputLine returns the line to look up of an input, be it a bare line or
+// an InputRecord.
+func inputLine(input interface{}) string {
+	if r, ok := input.(InputRecord); ok {
+		return r.Name
+	}
+	return input.(string)
+}
+
 func parseAlexa(line string) (string, int) {
 	s := strings.SplitN(line, ",", 2)
 	rank, err := strconv.Atoi(s[0])
@@ -58,6 +262,39 @@ func parseAlexa(line string) (string, int) {
 	return s[1], rank
 }
 
+var classNames = map[string]uint16{
+	"INET":   dns.ClassINET,
+	"IN":     dns.ClassINET,
+	"CSNET":  dns.ClassCSNET,
+	"CS":     dns.ClassCSNET,
+	"CHAOS":  dns.ClassCHAOS,
+	"CH":     dns.ClassCHAOS,
+	"HESIOD": dns.ClassHESIOD,
+	"HS":     dns.ClassHESIOD,
+	"NONE":   dns.ClassNONE,
+	"ANY":    dns.ClassANY,
+}
+
+// ParseClass looks up a DNS class by its name or mnemonic, in any case.
+func ParseClass(s string) (uint16, bool) {
+	class, ok := classNames[strings.ToUpper(s)]
+	return class, ok
+}
+
+// splitClass splits the class off an input line of the form name,CLASS (e.g.,
+// version.bind,CH). Lines whose last field isn't a class are left whole.
+func splitClass(line string) (string, uint16, bool) {
+	idx := strings.LastIndex(line, ",")
+	if idx < 0 {
+		return line, 0, false
+	}
+	class, ok := ParseClass(strings.TrimSpace(line[idx+1:]))
+	if !ok {
+		return line, 0, false
+	}
+	return line[:idx], class, true
+}
+
 func makeName(name string, prefix string) (string, bool) {
 	if prefix == "" {
 		return name, false
@@ -66,26 +303,159 @@ func makeName(name string, prefix string) (string, bool) {
 	}
 }
 
-func doLookup(g *GlobalLookupFactory, gc *GlobalConf, input <-chan interface{}, output chan<- string, metaChan chan<- routineMetadata, wg *sync.WaitGroup, threadID int) error {
+// appendSuffix appends the --suffix domain to name, unless name is absolute
+// (ends with a dot), as a resolver does with its search domains.
+func appendSuffix(name string, suffix string) string {
+	if suffix == "" || name == "" || strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "." + suffix
+}
+
+// expandName builds the name to look up from one of the --prefixes entries.
+// An entry containing {} has the name substituted there; any other entry is
+// prepended to it.
+func expandName(name string, template string) string {
+	if strings.Contains(template, "{}") {
+		return strings.Replace(template, "{}", name, -1)
+	}
+	return template + name
+}
+
+func doLookup(g *GlobalLookupFactory, gc *GlobalConf, gate *workerGate, sticky *stickyServers, input <-chan lookupInput, output chan<- lookupOutput, metaChan chan<- routineMetadata, wg *sync.WaitGroup, threadID int) error {
 	f, err := (*g).MakeRoutineFactory(threadID)
 	if err != nil {
 		log.Fatal("Unable to create new routine factory", err.Error())
 	}
 	var metadata routineMetadata
 	metadata.Status = make(map[Status]int)
-	for genericInput := range input {
-		var res Result
-		var innerRes interface{}
-		var trace []interface{}
-		var status Status
-		var err error
+	for in := range input {
+		gate.acquire()
+		genericInput := in.input
+		out := lookupOutput{index: in.index, seq: in.seq}
 		l, err := f.MakeLookup()
 		if err != nil {
 			log.Fatal("Unable to build lookup instance", err)
 		}
+		// record the outcome of one lookup for this input
+		emit := func(res Result, innerRes interface{}, trace []interface{}, status Status, err error) {
+			res.Timestamp = time.Now().Format(gc.TimeFormat)
+			if gc.SOCKS5 != nil {
+				res.Proxy = gc.SOCKS5.Addr
+			}
+			if gc.DryRun {
+				// report the queries the lookup would have sent, if any, in
+				// place of whatever the module made of their absence
+				if queries := gc.QueryPlan.take(); len(queries) > 0 {
+					innerRes, trace, status, err = queries, nil, STATUS_DRY_RUN, nil
+				}
+			}
+			if status != STATUS_NO_OUTPUT && !filteredOut(status, gc.FilterStatuses) {
+				if sampled(res.Name, gc.SampleRate, gc.SampleSeed) {
+					out.results = append(out.results, marshalResult(gc, res, innerRes, trace, status, err))
+				} else {
+					metadata.SampledOut++
+				}
+			}
+			metadata.Names++
+			metadata.Status[status]++
+		}
+		query := func(lookupName string) (interface{}, []interface{}, Status, error) {
+			lookupStart := time.Now()
+			gc.Metrics.StartLookup()
+			innerRes, trace, status, err := l.DoLookup(lookupName)
+			gc.Metrics.FinishLookup(status, time.Since(lookupStart))
+			gate.record(status, time.Since(lookupStart))
+			return innerRes, trace, status, err
+		}
+		var counter QueryCounter
+		if gc.MaxQueriesPerName > 0 {
+			var ok bool
+			if counter, ok = l.(QueryCounter); !ok {
+				log.Fatal("--max-queries-per-name is not supported by the ", gc.Module, " module")
+			}
+		}
+		var answers AnswerRecorder
+		if gc.FullAnswer {
+			var ok bool
+			if answers, ok = l.(AnswerRecorder); !ok {
+				log.Fatal("--full-answer is not supported by the ", gc.Module, " module")
+			}
+		}
+		soas, _ := l.(NegativeSOARecorder)
+		noData, _ := l.(NoDataRecorder)
+		var flags FlagsRecorder
+		for _, group := range gc.OutputGroups {
+			if group == "flags" {
+				flags, _ = l.(FlagsRecorder)
+			}
+		}
+		lookup := func(res Result, lookupName string) {
+			var innerRes interface{}
+			var trace []interface{}
+			var status Status
+			var err error
+			if counter != nil {
+				counter.ResetQueries()
+			}
+			if soas != nil {
+				soas.ResetNegativeSOA()
+			}
+			if noData != nil {
+				noData.ResetNoData()
+			}
+			if answers != nil {
+				answers.ResetAnswers()
+			}
+			if flags != nil {
+				flags.ResetResponseFlags()
+			}
+			if in.filtered {
+				status = STATUS_SKIPPED
+			} else if in.duplicate {
+				status = STATUS_DUPLICATE
+			} else if len(gc.CompareServers) > 0 {
+				setter, ok := l.(NameServerSetter)
+				if !ok {
+					log.Fatal("--compare-servers is not supported by the ", gc.Module, " module")
+				}
+				innerRes, trace, status, err = compareLookup(setter, query, lookupName, gc.CompareServers)
+			} else if gc.Repeat > 1 {
+				innerRes, trace, status, err = repeatLookup(query, lookupName, gc.Repeat, gc.RepeatDelay)
+			} else {
+				innerRes, trace, status, err = query(lookupName)
+			}
+			if counter != nil {
+				var limited bool
+				if res.Queries, limited = counter.Queries(); limited {
+					// whatever the module made of the lookups that were
+					// refused, the name wasn't fully resolved
+					status, err = STATUS_QUERY_LIMIT, nil
+				}
+			}
+			if noData != nil && (status == STATUS_NO_RECORD || status == STATUS_NO_ANSWER) && noData.NoData() {
+				// the name exists, without the records the module looks for
+				status = STATUS_NODATA
+			}
+			if soas != nil && isNegativeStatus(status) {
+				res.SOA = soas.NegativeSOA()
+			}
+			if answers != nil {
+				res.FullAnswer = answers.Answers()
+			}
+			if flags != nil {
+				if f := flags.ResponseFlags(); f != nil {
+					res.Flags = f
+				}
+			}
+			emit(res, innerRes, trace, status, err)
+		}
 		if (*g).ZonefileInput() {
+			var res Result
 			length := len(genericInput.(*dns.Token).RR.Header().Name)
 			if length == 0 {
+				gate.release()
+				output <- out
 				continue
 			}
 			res.Name = genericInput.(*dns.Token).RR.Header().Name[0 : length-1]
@@ -95,10 +465,18 @@ func doLookup(g *GlobalLookupFactory, gc *GlobalConf, input <-chan interface{},
 				ns := strings.ToLower(typ.Ns)
 				res.Nameserver = ns[:len(ns)-1]
 			}
-			innerRes, status, err = l.DoZonefileLookup(genericInput.(*dns.Token))
+			lookupStart := time.Now()
+			gc.Metrics.StartLookup()
+			innerRes, status, err := l.DoZonefileLookup(genericInput.(*dns.Token))
+			gc.Metrics.FinishLookup(status, time.Since(lookupStart))
+			gate.record(status, time.Since(lookupStart))
+			emit(res, innerRes, nil, status, err)
 		} else {
-			line := genericInput.(string)
-			var changed bool
+			var res Result
+			line := inputLine(genericInput)
+			if r, ok := genericInput.(InputRecord); ok {
+				res.Columns = r.Columns
+			}
 			var rawName string
 			var rank int
 			if gc.AlexaFormat == true {
@@ -107,47 +485,107 @@ func doLookup(g *GlobalLookupFactory, gc *GlobalConf, input <-chan interface{},
 			} else {
 				rawName = line
 			}
-			lookupName, changed := makeName(rawName, gc.NamePrefix)
-			if changed {
-				res.AlteredName = lookupName
-			}
-			res.Name = rawName
 			res.Class = dns.Class(gc.Class).String()
-			innerRes, trace, status, err = l.DoLookup(lookupName)
-		}
-		res.Timestamp = time.Now().Format(gc.TimeFormat)
-		if status != STATUS_NO_OUTPUT {
-			res.Status = string(status)
-			res.Data = innerRes
-			res.Trace = trace
-			if err != nil {
-				res.Error = err.Error()
+			if name, class, ok := splitClass(rawName); ok {
+				cs, supported := l.(ClassSetter)
+				if !supported {
+					res.Name = rawName
+					emit(res, nil, nil, STATUS_ILLEGAL_INPUT, errors.New("module does not support a class on input lines"))
+					gate.release()
+					output <- out
+					continue
+				}
+				cs.SetDNSClass(class)
+				rawName = name
+				res.Class = dns.Class(class).String()
 			}
-			v, _ := version.NewVersion("0.0.0")
-			o := &sheriff.Options{
-				Groups:     gc.OutputGroups,
-				ApiVersion: v,
+			if !gc.NoIDNA {
+				ascii, changed, err := toASCIIName(rawName)
+				if err != nil {
+					res.Name = rawName
+					emit(res, nil, nil, STATUS_ILLEGAL_INPUT, err)
+					gate.release()
+					output <- out
+					continue
+				}
+				if changed {
+					res.UnicodeName = rawName
+					rawName = ascii
+				}
 			}
-			data, err := sheriff.Marshal(o, res)
-			jsonRes, err := json.Marshal(data)
-			if err != nil {
-				log.Fatal("Unable to marshal JSON result", err)
+			res.Name = rawName
+			if sticky != nil {
+				setter, ok := l.(NameServerSetter)
+				if !ok {
+					log.Fatal("--sticky-server is not supported by the ", gc.Module, " module")
+				}
+				res.Nameserver = sticky.pick(rawName)
+				setter.SetNameServer(res.Nameserver)
+			}
+			qualified := appendSuffix(rawName, gc.NameSuffix)
+			if len(gc.NamePrefixes) > 0 {
+				for _, template := range gc.NamePrefixes {
+					expanded := res
+					expanded.Expansion = template
+					lookupName := expandName(qualified, template)
+					if lookupName != rawName {
+						expanded.AlteredName = lookupName
+					}
+					lookup(expanded, lookupName)
+				}
+			} else {
+				lookupName, changed := makeName(qualified, gc.NamePrefix)
+				if changed || lookupName != rawName {
+					res.AlteredName = lookupName
+				}
+				lookup(res, lookupName)
 			}
-			output <- string(jsonRes)
 		}
-		metadata.Names++
-		metadata.Status[status]++
+		gate.release()
+		output <- out
 	}
 	metaChan <- metadata
 	(*wg).Done()
 	return nil
 }
 
-func aggregateMetadata(c <-chan routineMetadata) Metadata {
+// marshalResult serializes the result of one lookup for the output handler.
+func marshalResult(gc *GlobalConf, res Result, innerRes interface{}, trace []interface{}, status Status, err error) string {
+	res.Status = string(status)
+	res.Data = innerRes
+	res.Trace = trace
+	if err != nil {
+		res.Error = err.Error()
+	}
+	res.ErrorDetail = ErrorDetail(status, err)
+	if d, ok := innerRes.(ErrorDetailer); ok && res.ErrorDetail == "" {
+		res.ErrorDetail = d.ErrorDetail()
+	}
+	v, _ := version.NewVersion("0.0.0")
+	o := &sheriff.Options{
+		Groups:     gc.OutputGroups,
+		ApiVersion: v,
+	}
+	data, err := sheriff.Marshal(o, res)
+	if gc.MinTTL > 0 || gc.MaxTTL > 0 {
+		clampTTLs(data, gc.MinTTL, gc.MaxTTL, gc.ResultVerbosity == "trace")
+	}
+	if len(gc.FieldMap) > 0 {
+		renameFields(data, gc.FieldMap)
+	}
+	jsonRes, err := json.Marshal(data)
+	if err != nil {
+		log.Fatal("Unable to marshal JSON result", err)
+	}
+	return string(jsonRes)
+}
+
+func aggregateMetadata(routines []routineMetadata) Metadata {
 	var meta Metadata
 	meta.Status = make(map[string]int)
-	for m := range c {
+	for _, m := range routines {
 		meta.Names += m.Names
+		meta.SampledOut += m.SampledOut
 		for k, v := range m.Status {
 			meta.Status[string(k)] += v
 		}
@@ -155,41 +593,385 @@ func aggregateMetadata(c <-chan routineMetadata) Metadata {
 	return meta
 }
 
+// how often scan progress is written to the checkpoint file
+const checkpointInterval = 10 * time.Second
+
+// how long lookups in flight may take to finish after an interrupt. Tests
+// shorten it.
+var interruptGracePeriod = 5 * time.Second
+
 func DoLookups(g *GlobalLookupFactory, c *GlobalConf) error {
 	// DoLookup:
 	//	- n threads that do processing from in and place results in out
 	//	- process until inChan closes, then wg.done()
 	// Once we processing threads have all finished, wait until the
 	// output and metadata threads have completed
-	inChan := make(chan interface{})
+	rawInChan := make(chan interface{})
+	inChan := make(chan lookupInput)
+	resultChan := make(chan lookupOutput)
 	outChan := make(chan string)
 	metaChan := make(chan routineMetadata, c.Threads)
-	var routineWG sync.WaitGroup
+
+	var cp *checkpointer
+	resumedFrom := 0
+	if c.CheckpointFilePath != "" {
+		if c.Resume {
+			var err error
+			cp, err = loadCheckpointer(c.CheckpointFilePath)
+			if err != nil {
+				return err
+			}
+			resumedFrom = cp.lines
+			log.Info("resuming scan after ", cp.lines, " processed input lines")
+		} else {
+			cp = newCheckpointer(c.CheckpointFilePath)
+		}
+	}
 
 	inHandler := GetInputHandler(c.InputHandler)
-	outHandler := GetOutputHandler(c.OutputHandler)
+	outHandler := newFanOut(c.OutputHandlers)
 	inHandler.Initialize(c)
 	outHandler.Initialize(c)
 
-	// Use handlers to populate the input and output/results channel
-	go inHandler.FeedChannel(inChan, &routineWG, (*g).ZonefileInput())
-	go outHandler.WriteResults(outChan, &routineWG)
-	routineWG.Add(2)
+	// Use handlers to populate the input and output/results channel. An
+	// interrupted scan doesn't wait for the input handler to finish reading.
+	var inputWG, outputWG sync.WaitGroup
+	inputWG.Add(1)
+	outputWG.Add(1)
+	go inHandler.FeedChannel(rawInChan, &inputWG, (*g).ZonefileInput())
+	go outHandler.WriteResults(outChan, &outputWG)
+
+	// once the deadline passes, stop handing out inputs. The channel is
+	// closed rather than sent on, so that warming the cache sees it too.
+	var deadline chan struct{}
+	if c.MaxRuntime > 0 {
+		deadline = make(chan struct{})
+		timer := time.AfterFunc(c.MaxRuntime, func() { close(deadline) })
+		defer timer.Stop()
+	}
+
+	// on SIGINT or SIGTERM, stop handing out inputs too, and give the lookups
+	// in flight a grace period to finish so that the output is still complete
+	// and valid. A second signal exits immediately.
+	interrupt := make(chan struct{})
+	finished := make(chan struct{})
+	defer close(finished)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case sig := <-sigs:
+			log.Warn("received ", sig, ", finishing the lookups in flight. Repeat to exit immediately")
+			close(interrupt)
+		case <-finished:
+			return
+		}
+		select {
+		case sig := <-sigs:
+			log.Fatal("received ", sig, " again, exiting without writing the remaining output")
+		case <-finished:
+		}
+	}()
+
+	// with --warm-cache, the inputs read ahead are handed out once the cache
+	// is warm. The deadline and interrupts cut warming short.
+	var inputs <-chan interface{} = rawInChan
+	if c.WarmCache > 0 && !(*g).ZonefileInput() {
+		if w, ok := (*g).(CacheWarmer); !ok {
+			log.Warn("--warm-cache is not supported by the ", c.Module, " module")
+		} else if c.DryRun {
+			log.Info("--dry-run: not warming the cache")
+		} else {
+			stopWarming := make(chan struct{})
+			go func() {
+				select {
+				case <-deadline:
+				case <-interrupt:
+				case <-finished:
+				}
+				close(stopWarming)
+			}()
+			inputs = warmCache(w, rawInChan, c, stopWarming)
+		}
+	}
+
+	// number each input and drop those a previous run already completed. At
+	// the deadline, the remaining input is left unread, since stdin or a redis
+	// list may never end; inputs already read but not handed out are skipped.
+	// With --shuffle-input, inputs keep their number, which checkpoints rely
+	// on, but are handed out in random order.
+	skipped := 0
+	var dedupe *deduper
+	if c.DedupeInput && !(*g).ZonefileInput() {
+		dedupe = newDeduper(c.DedupeMaxNames, c.AlexaFormat)
+	}
+	var filter *nameFilter
+	if (c.NameAllowlist != "" || c.NameBlocklist != "") && !(*g).ZonefileInput() {
+		var err error
+		if filter, err = newNameFilter(c.NameAllowlist, c.NameBlocklist, c.AlexaFormat); err != nil {
+			return err
+		}
+	}
+	var shuffle *shuffleBuffer
+	if c.ShuffleInput {
+		shuffle = newShuffleBuffer(c.ShuffleWindow)
+	}
+	numberingDone := make(chan struct{})
+	go func() {
+		defer close(numberingDone)
+		index := 0
+		seq := 0
+		expired := false
+		expire := func() {
+			expired = true
+			close(inChan)
+			if shuffle != nil {
+				skipped += len(shuffle.drain())
+			}
+			log.Warn("maximum runtime of ", c.MaxRuntime, " reached, skipping the remaining input")
+		}
+	read:
+		for {
+			var genericInput interface{}
+			select {
+			case input, ok := <-inputs:
+				if !ok {
+					break read
+				}
+				genericInput = input
+			case <-deadline:
+				// waiting for input, e.g. from an idle stdin
+				expire()
+				break read
+			case <-interrupt:
+				close(inChan)
+				return
+			}
+			if c.DryRun && index == dryRunNames {
+				// the rest of the input is left unread
+				close(inChan)
+				return
+			}
+			// inputs skipped on resume are still remembered, so that their
+			// repeats are reported the same way as in the interrupted run
+			duplicate := dedupe != nil && dedupe.duplicate(inputLine(genericInput))
+			filtered := filter != nil && filter.skip(inputLine(genericInput))
+			select {
+			case <-deadline:
+				expire()
+				if cp == nil || !cp.skip(index) {
+					skipped++
+				}
+				break read
+			case <-interrupt:
+				close(inChan)
+				return
+			default:
+			}
+			if cp == nil || !cp.skip(index) {
+				in := lookupInput{index: index, input: genericInput, duplicate: duplicate, filtered: filtered}
+				ready := true
+				if shuffle != nil {
+					in, ready = shuffle.push(in)
+				}
+				if ready {
+					in.seq = seq
+					select {
+					case inChan <- in:
+						seq++
+					case <-deadline:
+						expire()
+						skipped++
+						break read
+					case <-interrupt:
+						close(inChan)
+						return
+					}
+				}
+			}
+			index++
+		}
+		if shuffle != nil && !expired {
+			buffered := shuffle.drain()
+			for i := 0; i < len(buffered) && !expired; i++ {
+				buffered[i].seq = seq
+				select {
+				case inChan <- buffered[i]:
+					seq++
+				case <-deadline:
+					expire()
+					skipped += len(buffered) - i
+				case <-interrupt:
+					close(inChan)
+					return
+				}
+			}
+		}
+		if !expired {
+			close(inChan)
+		}
+	}()
+
+	var prog *progress
+	stopProgress := make(chan struct{})
+	if c.Progress {
+		prog = newProgress(resumedFrom)
+		if c.InputHandler == "file" && c.InputFilePath != "" && c.InputFilePath != "-" && !(*g).ZonefileInput() {
+			prog.countInput(c.InputFilePath)
+		}
+		go prog.run(os.Stderr, stopProgress)
+	}
+
+	// hand results to the output handler. The output channel is unbuffered,
+	// so once the handler accepts a result it has finished writing the one
+	// before it, and only then is that input marked as processed. Results of
+	// abandoned lookups are never forwarded.
+	// With --preserve-order, results wait in a reorder buffer until those of
+	// the inputs handed out before them have been written.
+	var reorder *reorderBuffer
+	if c.PreserveOrder {
+		reorder = newReorderBuffer(c.ReorderWindow)
+	}
+	abandon := make(chan struct{})
+	forwardDone := make(chan struct{})
+	go func() {
+		pending := -1
+		write := func(out lookupOutput) {
+			if len(out.results) == 0 {
+				if cp != nil {
+					cp.done(out.index)
+				}
+				return
+			}
+			for _, result := range out.results {
+				outChan <- result
+				if cp != nil && pending >= 0 {
+					cp.done(pending)
+				}
+				pending = -1
+			}
+			pending = out.index
+		}
+	forward:
+		for {
+			select {
+			case out, ok := <-resultChan:
+				if !ok {
+					break forward
+				}
+				if prog != nil {
+					prog.add()
+				}
+				if reorder == nil {
+					write(out)
+					continue
+				}
+				for _, ready := range reorder.push(out) {
+					write(ready)
+				}
+			case <-abandon:
+				break forward
+			}
+		}
+		if reorder != nil {
+			for _, ready := range reorder.drain() {
+				write(ready)
+			}
+		}
+		close(outChan)
+		outputWG.Wait()
+		if cp != nil && pending >= 0 {
+			cp.done(pending)
+		}
+		close(forwardDone)
+	}()
+
+	stopCheckpoints := make(chan struct{})
+	if cp != nil {
+		go func() {
+			ticker := time.NewTicker(checkpointInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := cp.save(); err != nil {
+						log.Warn("unable to write checkpoint: ", err)
+					}
+				case <-stopCheckpoints:
+					return
+				}
+			}
+		}()
+	}
 
 	// create pool of worker goroutines
 	var lookupWG sync.WaitGroup
 	lookupWG.Add(c.Threads)
 	startTime := time.Now().Format(c.TimeFormat)
+	var gate *workerGate
+	stopAutoscale := make(chan struct{})
+	if c.AutoThreads {
+		gate = newWorkerGate(c.MinThreads, c.Threads)
+		go gate.run(stopAutoscale)
+	}
+	var sticky *stickyServers
+	if c.StickyServer != "" {
+		sticky = newStickyServers(c.StickyServer, c.NameServers, c.NameServerWeights)
+	}
 	for i := 0; i < c.Threads; i++ {
-		go doLookup(g, c, inChan, outChan, metaChan, &lookupWG, i)
+		go doLookup(g, c, gate, sticky, inChan, resultChan, metaChan, &lookupWG, i)
+	}
+	lookupsDone := make(chan struct{})
+	go func() {
+		lookupWG.Wait()
+		close(lookupsDone)
+	}()
+	abandoned := false
+	select {
+	case <-lookupsDone:
+	case <-interrupt:
+		select {
+		case <-lookupsDone:
+		case <-time.After(interruptGracePeriod):
+			log.Warn("abandoning the lookups still in flight after ", interruptGracePeriod)
+			abandoned = true
+		}
+	}
+	var routineMeta []routineMetadata
+	if abandoned {
+		// the workers that are still running will never finish, so neither
+		// channel can be closed. Collect what the others reported.
+		close(abandon)
+		for i := len(metaChan); i > 0; i-- {
+			routineMeta = append(routineMeta, <-metaChan)
+		}
+	} else {
+		close(resultChan)
+		close(metaChan)
+		for m := range metaChan {
+			routineMeta = append(routineMeta, m)
+		}
+	}
+	<-forwardDone
+	interrupted := false
+	select {
+	case <-interrupt:
+		interrupted = true
+	default:
+		<-numberingDone
+	}
+	close(stopCheckpoints)
+	close(stopProgress)
+	close(stopAutoscale)
+	if cp != nil {
+		if err := cp.save(); err != nil {
+			log.Error("unable to write checkpoint: ", err)
+		}
 	}
-	lookupWG.Wait()
-	close(outChan)
-	close(metaChan)
-	routineWG.Wait()
 	if c.MetadataFilePath != "" {
 		// we're done processing data. aggregate all the data from individual routines
-		metaData := aggregateMetadata(metaChan)
+		metaData := aggregateMetadata(routineMeta)
 		metaData.StartTime = startTime
 		metaData.EndTime = time.Now().Format(c.TimeFormat)
 		metaData.NameServers = c.NameServers
@@ -199,7 +981,21 @@ func DoLookups(g *GlobalLookupFactory, c *GlobalConf) error {
 		// back to an integer here.
 		metaData.Timeout = int(c.Timeout.Seconds())
 		metaData.Conf = c
+		metaData.ResumedFrom = resumedFrom
+		if !interrupted {
+			metaData.Skipped = skipped
+		}
+		metaData.Interrupted = interrupted
+		metaData.DedupeTruncated = dedupe != nil && dedupe.wasTruncated()
+		metaData.OutputErrors = outHandler.outputErrors()
+		metaData.InvalidResults = outHandler.invalidResults()
+		if reorder != nil {
+			metaData.LateResults = reorder.late
+		}
 		// add global lookup-related metadata
+		if cr, ok := (*g).(CacheReporter); ok {
+			metaData.Cache = cr.CacheStats()
+		}
 		// write out metadata
 		var f *os.File
 		if c.MetadataFilePath == "-" {
@@ -218,5 +1014,5 @@ func DoLookups(g *GlobalLookupFactory, c *GlobalConf) error {
 		}
 		f.WriteString(string(j))
 	}
-	return nil
+	return outHandler.err()
 }
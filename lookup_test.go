@@ -0,0 +1,355 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestExpandName(t *testing.T) {
+	cases := map[string]string{
+		"":           "example.com",
+		"www.":       "www.example.com",
+		"_dmarc.{}":  "_dmarc.example.com",
+		"{}.cdn.net": "example.com.cdn.net",
+		"{}":         "example.com",
+		"a.{}.b.{}":  "a.example.com.b.example.com",
+	}
+	for template, expected := range cases {
+		if name := expandName("example.com", template); name != expected {
+			t.Errorf("%q: got %s, expected %s", template, name, expected)
+		}
+	}
+}
+
+func TestAppendSuffix(t *testing.T) {
+	cases := []struct {
+		name     string
+		suffix   string
+		expected string
+	}{
+		{"host", "example.com", "host.example.com"},
+		{"host", "example.com.", "host.example.com."},
+		{"host.", "example.com", "host."},
+		{"www.host", "corp.example", "www.host.corp.example"},
+		{"host", "", "host"},
+		{"", "example.com", ""},
+	}
+	for _, c := range cases {
+		if name := appendSuffix(c.name, c.suffix); name != c.expected {
+			t.Errorf("%q with suffix %q: got %q, expected %q", c.name, c.suffix, name, c.expected)
+		}
+	}
+	// prefixes apply to the qualified name
+	if name := expandName(appendSuffix("host", "example.com"), "_dmarc.{}"); name != "_dmarc.host.example.com" {
+		t.Errorf("Unexpected expansion %s", name)
+	}
+}
+
+func TestSplitClass(t *testing.T) {
+	cases := []struct {
+		line  string
+		name  string
+		class uint16
+		ok    bool
+	}{
+		{"version.bind,CH", "version.bind", dns.ClassCHAOS, true},
+		{"version.bind,chaos", "version.bind", dns.ClassCHAOS, true},
+		{"example.com, IN", "example.com", dns.ClassINET, true},
+		{"example.com", "example.com", 0, false},
+		{"example.com,2020010101", "example.com,2020010101", 0, false},
+		{"example.com,", "example.com,", 0, false},
+	}
+	for _, c := range cases {
+		name, class, ok := splitClass(c.line)
+		if name != c.name || class != c.class || ok != c.ok {
+			t.Errorf("%q: got %s %d %v", c.line, name, class, ok)
+		}
+	}
+}
+
+func TestParseClientSubnet(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		family  uint16
+		netmask uint8
+		address string
+	}{
+		{"192.0.2.0/24", 1, 24, "192.0.2.0"},
+		// host bits aren't sent
+		{"192.0.2.77/24", 1, 24, "192.0.2.0"},
+		{"198.51.100.1/32", 1, 32, "198.51.100.1"},
+		{"0.0.0.0/0", 1, 0, "0.0.0.0"},
+		{"2001:db8::/56", 2, 56, "2001:db8::"},
+		{"2001:db8:0:1::1/48", 2, 48, "2001:db8::"},
+		{"2001:db8::1/128", 2, 128, "2001:db8::1"},
+	}
+	for _, test := range tests {
+		subnet, err := ParseClientSubnet(test.cidr)
+		if err != nil {
+			t.Errorf("%s: %v", test.cidr, err)
+			continue
+		}
+		if subnet.Code != dns.EDNS0SUBNET || subnet.Family != test.family || subnet.SourceNetmask != test.netmask || subnet.SourceScope != 0 {
+			t.Errorf("%s: parsed as family %d, netmask %d, scope %d", test.cidr, subnet.Family, subnet.SourceNetmask, subnet.SourceScope)
+		}
+		if !subnet.Address.Equal(net.ParseIP(test.address)) {
+			t.Errorf("%s: parsed as address %s, expected %s", test.cidr, subnet.Address, test.address)
+		}
+		if test.family == 1 && len(subnet.Address) != net.IPv4len {
+			t.Errorf("%s: IPv4 address of %d bytes", test.cidr, len(subnet.Address))
+		}
+	}
+	for _, bad := range []string{"", "192.0.2.0", "192.0.2.0/33", "2001:db8::/129", "192.0.2.0/-1", "example.com/24", "192.0.2/24"} {
+		if _, err := ParseClientSubnet(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestParseEDNSOption(t *testing.T) {
+	o, err := ParseEDNSOption("65001:C0ffee")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Code != 65001 || string(o.Data) != "\xc0\xff\xee" {
+		t.Errorf("parsed as code %d, data %x", o.Code, o.Data)
+	}
+	if o, err := ParseEDNSOption("10:"); err != nil || len(o.Data) != 0 {
+		t.Errorf("empty value not accepted: %v", err)
+	}
+	for _, bad := range []string{"65001", "0:00", "65535:00", "65536:00", "-1:00", "x:00", "10:abc", "10:zz"} {
+		if _, err := ParseEDNSOption(bad); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestSampled(t *testing.T) {
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		name := "name" + strconv.Itoa(i) + ".example.com"
+		if sampled(name, 0.1, 42) {
+			kept++
+		}
+		if sampled(name, 0.1, 42) != sampled(strings.ToUpper(name), 0.1, 42) {
+			t.Fatalf("%s was sampled differently in upper case", name)
+		}
+		if !sampled(name, 1, 42) || sampled(name, 0, 42) {
+			t.Fatalf("%s wasn't sampled by a rate of 1 and left out by a rate of 0", name)
+		}
+	}
+	if kept < 900 || kept > 1100 {
+		t.Errorf("a rate of 0.1 kept %d of 10000 names", kept)
+	}
+	differ := false
+	for i := 0; i < 100 && !differ; i++ {
+		name := "name" + strconv.Itoa(i) + ".example.com"
+		differ = sampled(name, 0.5, 1) != sampled(name, 0.5, 2)
+	}
+	if !differ {
+		t.Error("seeds 1 and 2 picked the same names")
+	}
+}
+
+// idleInput feeds its names and then blocks, like stdin that nobody closes.
+type idleInput struct {
+	names []string
+}
+
+func (h *idleInput) Initialize(conf *GlobalConf) {}
+
+func (h *idleInput) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
+	for _, name := range h.names {
+		in <- name
+	}
+	select {}
+}
+
+// slowFactory makes lookups that take delay and always succeed.
+type slowFactory struct {
+	BaseGlobalLookupFactory
+	delay time.Duration
+}
+
+func (f *slowFactory) MakeRoutineFactory(int) (RoutineLookupFactory, error) {
+	return f, nil
+}
+
+func (f *slowFactory) MakeLookup() (Lookup, error) {
+	return f, nil
+}
+
+func (f *slowFactory) DoLookup(name string) (interface{}, []interface{}, Status, error) {
+	time.Sleep(f.delay)
+	return nil, nil, STATUS_NOERROR, nil
+}
+
+func (f *slowFactory) DoZonefileLookup(record *dns.Token) (interface{}, Status, error) {
+	return nil, STATUS_NOERROR, nil
+}
+
+// runLookups runs DoLookups over names from an idle input with lookups that
+// take delay and returns the metadata, failing the test if the scan doesn't
+// finish within timeout.
+func runLookups(t *testing.T, c *GlobalConf, names []string, delay, timeout time.Duration) Metadata {
+	return runFactoryLookups(t, c, names, &slowFactory{delay: delay}, timeout)
+}
+
+func runFactoryLookups(t *testing.T, c *GlobalConf, names []string, g GlobalLookupFactory, timeout time.Duration) Metadata {
+	RegisterInputHandler("test-idle", &idleInput{names: names})
+	RegisterOutputHandler("test-recording", new(recordingOutput))
+	c.InputHandler = "test-idle"
+	c.OutputHandlers = []string{"test-recording"}
+	c.Threads = 2
+	c.TimeFormat = time.RFC3339
+	c.MetadataFilePath = filepath.Join(t.TempDir(), "metadata.json")
+	g.Initialize(c)
+
+	done := make(chan error, 1)
+	go func() { done <- DoLookups(&g, c) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(timeout):
+		t.Fatal("DoLookups didn't return")
+	}
+	j, err := ioutil.ReadFile(c.MetadataFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var meta Metadata
+	if err := json.Unmarshal(j, &meta); err != nil {
+		t.Fatal(err)
+	}
+	return meta
+}
+
+func TestMaxRuntimeIdleInput(t *testing.T) {
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = strconv.Itoa(i) + ".example.com"
+	}
+	for _, shuffle := range []bool{false, true} {
+		c := &GlobalConf{MaxRuntime: 200 * time.Millisecond, ShuffleInput: shuffle, ShuffleWindow: 10}
+		meta := runLookups(t, c, names, 50*time.Millisecond, 2*time.Second)
+		if meta.Names == 0 || meta.Names >= len(names) {
+			t.Errorf("shuffle %v: looked up %d of %d names before the deadline", shuffle, meta.Names, len(names))
+		}
+		if shuffle && meta.Skipped == 0 {
+			t.Errorf("shuffle %v: expected the buffered names to be skipped", shuffle)
+		}
+		if meta.Interrupted {
+			t.Errorf("shuffle %v: reached the deadline, not interrupted", shuffle)
+		}
+	}
+}
+
+// interruptAfter sends the test process SIGINT after d, which DoLookups
+// catches once it has started.
+func interruptAfter(d time.Duration) {
+	time.AfterFunc(d, func() { syscall.Kill(syscall.Getpid(), syscall.SIGINT) })
+}
+
+func TestInterrupt(t *testing.T) {
+	names := make([]string, 100)
+	for i := range names {
+		names[i] = strconv.Itoa(i) + ".example.com"
+	}
+	interruptAfter(200 * time.Millisecond)
+	c := &GlobalConf{DedupeInput: true}
+	meta := runLookups(t, c, names, 50*time.Millisecond, 2*time.Second)
+	if !meta.Interrupted {
+		t.Error("Expected the scan to be marked as interrupted")
+	}
+	// the lookups in flight finished within the grace period
+	if meta.Names == 0 || meta.Names >= len(names) {
+		t.Errorf("looked up %d of %d names before the interrupt", meta.Names, len(names))
+	}
+}
+
+func TestInterruptGracePeriod(t *testing.T) {
+	defer func(d time.Duration) { interruptGracePeriod = d }(interruptGracePeriod)
+	interruptGracePeriod = 100 * time.Millisecond
+	interruptAfter(200 * time.Millisecond)
+	meta := runLookups(t, &GlobalConf{}, []string{"a.example.com", "b.example.com"}, time.Minute, 2*time.Second)
+	if !meta.Interrupted {
+		t.Error("Expected the scan to be marked as interrupted")
+	}
+	// both workers were abandoned mid-lookup
+	if meta.Names != 0 {
+		t.Errorf("Expected no finished lookups, got %d", meta.Names)
+	}
+}
+
+// noDataFactory makes lookups that find no records, of names that exist if
+// they start with "nodata.".
+type noDataFactory struct {
+	slowFactory
+}
+
+func (f *noDataFactory) MakeRoutineFactory(int) (RoutineLookupFactory, error) {
+	return f, nil
+}
+
+func (f *noDataFactory) MakeLookup() (Lookup, error) {
+	return new(noDataLookup), nil
+}
+
+type noDataLookup struct {
+	noData bool
+}
+
+func (l *noDataLookup) DoLookup(name string) (interface{}, []interface{}, Status, error) {
+	l.noData = strings.HasPrefix(name, "nodata.")
+	return nil, nil, STATUS_NO_RECORD, nil
+}
+
+func (l *noDataLookup) DoZonefileLookup(record *dns.Token) (interface{}, Status, error) {
+	return nil, STATUS_NO_RECORD, nil
+}
+
+func (l *noDataLookup) ResetNoData() {
+	l.noData = false
+}
+
+func (l *noDataLookup) NoData() bool {
+	return l.noData
+}
+
+func TestNoDataStatus(t *testing.T) {
+	names := []string{"nodata.example.com", "nodata.example.net", "missing.example.com"}
+	c := &GlobalConf{MaxRuntime: 200 * time.Millisecond}
+	meta := runFactoryLookups(t, c, names, new(noDataFactory), 2*time.Second)
+	if meta.Status[string(STATUS_NODATA)] != 2 {
+		t.Errorf("Expected 2 NODATA results, got %v", meta.Status)
+	}
+	if meta.Status[string(STATUS_NO_RECORD)] != 1 {
+		t.Errorf("Expected 1 NO_RECORD result, got %v", meta.Status)
+	}
+}
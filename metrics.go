@@ -0,0 +1,212 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// upper bounds (in seconds) of the latency histogram buckets. These
+// match the Prometheus client library defaults.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram counts observations in latencyBuckets.
+type histogram struct {
+	counts  []uint64
+	sum     float64
+	observe uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+func (h *histogram) add(seconds float64) {
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += seconds
+	h.observe++
+}
+
+// write writes the series of the histogram, whose labels, if any, are given
+// in the Prometheus format without braces (e.g., name_server="192.0.2.1:53").
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	var bucket, series string
+	if labels != "" {
+		bucket = labels + ","
+		series = "{" + labels + "}"
+	}
+	for i, bound := range latencyBuckets {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		fmt.Fprintf(w, "%s_bucket{%sle=%q} %d\n", name, bucket, le, h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", name, bucket, h.observe)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, series, strconv.FormatFloat(h.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, series, h.observe)
+}
+
+// Metrics holds live counters for a scan and serves them in the Prometheus
+// text exposition format.
+type Metrics struct {
+	mu        sync.Mutex
+	completed uint64
+	failed    map[Status]uint64
+	retries   uint64
+	inFlight  int64
+	latency   *histogram
+	// the latency of the queries sent to each name server
+	queryLatency map[string]*histogram
+
+	server *http.Server
+}
+
+func NewMetrics() *Metrics {
+	m := new(Metrics)
+	m.failed = make(map[Status]uint64)
+	m.latency = newHistogram()
+	m.queryLatency = make(map[string]*histogram)
+	return m
+}
+
+// Listen binds the metrics endpoint and serves /metrics in the background.
+func (m *Metrics) Listen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m.write(w)
+	})
+	m.server = &http.Server{Handler: mux}
+	go m.server.Serve(l)
+	return nil
+}
+
+// Close gracefully shuts the metrics endpoint down, if it was started.
+func (m *Metrics) Close() error {
+	if m.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.server.Shutdown(ctx)
+}
+
+// The recording methods below are no-ops on a nil *Metrics so that callers
+// don't need to check whether metrics were enabled.
+
+func (m *Metrics) StartLookup() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.inFlight++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) FinishLookup(status Status, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	seconds := duration.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inFlight--
+	m.completed++
+	if status != STATUS_NOERROR {
+		m.failed[status]++
+	}
+	m.latency.add(seconds)
+}
+
+// ObserveQuery records how long a query to nameServer took to be answered or
+// to fail, so that a slow name server stands out.
+func (m *Metrics) ObserveQuery(nameServer string, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.queryLatency[nameServer]
+	if !ok {
+		h = newHistogram()
+		m.queryLatency[nameServer] = h
+	}
+	h.add(duration.Seconds())
+}
+
+func (m *Metrics) AddRetry() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.retries++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintln(w, "# HELP zdns_lookups_completed_total Number of names for which a lookup completed.")
+	fmt.Fprintln(w, "# TYPE zdns_lookups_completed_total counter")
+	fmt.Fprintf(w, "zdns_lookups_completed_total %d\n", m.completed)
+
+	fmt.Fprintln(w, "# HELP zdns_lookups_failed_total Number of lookups that did not complete with NOERROR, by status.")
+	fmt.Fprintln(w, "# TYPE zdns_lookups_failed_total counter")
+	statuses := make([]string, 0, len(m.failed))
+	for status := range m.failed {
+		statuses = append(statuses, string(status))
+	}
+	sort.Strings(statuses)
+	for _, status := range statuses {
+		fmt.Fprintf(w, "zdns_lookups_failed_total{status=%q} %d\n", status, m.failed[Status(status)])
+	}
+
+	fmt.Fprintln(w, "# HELP zdns_retries_total Number of queries that were retried after a timeout or temporary failure.")
+	fmt.Fprintln(w, "# TYPE zdns_retries_total counter")
+	fmt.Fprintf(w, "zdns_retries_total %d\n", m.retries)
+
+	fmt.Fprintln(w, "# HELP zdns_inflight_lookups Number of lookups currently in progress.")
+	fmt.Fprintln(w, "# TYPE zdns_inflight_lookups gauge")
+	fmt.Fprintf(w, "zdns_inflight_lookups %d\n", m.inFlight)
+
+	fmt.Fprintln(w, "# HELP zdns_lookup_duration_seconds Time taken to complete the lookup of a single name.")
+	fmt.Fprintln(w, "# TYPE zdns_lookup_duration_seconds histogram")
+	m.latency.write(w, "zdns_lookup_duration_seconds", "")
+
+	fmt.Fprintln(w, "# HELP zdns_query_duration_seconds Time taken by the queries sent to each name server.")
+	fmt.Fprintln(w, "# TYPE zdns_query_duration_seconds histogram")
+	servers := make([]string, 0, len(m.queryLatency))
+	for ns := range m.queryLatency {
+		servers = append(servers, ns)
+	}
+	sort.Strings(servers)
+	for _, ns := range servers {
+		m.queryLatency[ns].write(w, "zdns_query_duration_seconds", fmt.Sprintf("name_server=%q", ns))
+	}
+}
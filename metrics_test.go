@@ -0,0 +1,129 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewMetrics()
+	m.StartLookup()
+	m.StartLookup()
+	m.StartLookup()
+	m.FinishLookup(STATUS_NOERROR, 20*time.Millisecond)
+	m.FinishLookup(STATUS_TIMEOUT, 3*time.Second)
+	m.AddRetry()
+	m.AddRetry()
+	m.ObserveQuery("192.0.2.1:53", 30*time.Millisecond)
+	m.ObserveQuery("192.0.2.1:53", 40*time.Millisecond)
+	m.ObserveQuery("tls://192.0.2.2:853", 2*time.Second)
+
+	var b bytes.Buffer
+	m.write(&b)
+	out := b.String()
+	for _, line := range []string{
+		"zdns_lookups_completed_total 2",
+		`zdns_lookups_failed_total{status="TIMEOUT"} 1`,
+		"zdns_retries_total 2",
+		"zdns_inflight_lookups 1",
+		`zdns_lookup_duration_seconds_bucket{le="0.01"} 0`,
+		`zdns_lookup_duration_seconds_bucket{le="0.025"} 1`,
+		`zdns_lookup_duration_seconds_bucket{le="2.5"} 1`,
+		`zdns_lookup_duration_seconds_bucket{le="5"} 2`,
+		`zdns_lookup_duration_seconds_bucket{le="+Inf"} 2`,
+		"zdns_lookup_duration_seconds_sum 3.02",
+		"zdns_lookup_duration_seconds_count 2",
+		`zdns_query_duration_seconds_bucket{name_server="192.0.2.1:53",le="0.025"} 0`,
+		`zdns_query_duration_seconds_bucket{name_server="192.0.2.1:53",le="0.05"} 2`,
+		`zdns_query_duration_seconds_bucket{name_server="192.0.2.1:53",le="+Inf"} 2`,
+		`zdns_query_duration_seconds_count{name_server="192.0.2.1:53"} 2`,
+		`zdns_query_duration_seconds_bucket{name_server="tls://192.0.2.2:853",le="1"} 0`,
+		`zdns_query_duration_seconds_bucket{name_server="tls://192.0.2.2:853",le="2.5"} 1`,
+		`zdns_query_duration_seconds_sum{name_server="tls://192.0.2.2:853"} 2`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("%q missing from\n%s", line, out)
+		}
+	}
+	// lookups that succeed aren't failures
+	if strings.Contains(out, `status="NOERROR"`) {
+		t.Errorf("NOERROR counted as a failure:\n%s", out)
+	}
+
+	// the recording methods are no-ops without metrics
+	var none *Metrics
+	none.StartLookup()
+	none.FinishLookup(STATUS_TIMEOUT, time.Second)
+	none.AddRetry()
+	none.ObserveQuery("192.0.2.1:53", time.Second)
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	m := NewMetrics()
+	if err := m.Listen(addr); err != nil {
+		t.Skip(err)
+	}
+	defer m.Close()
+	m.StartLookup()
+	m.FinishLookup(STATUS_NXDOMAIN, time.Millisecond)
+
+	resp, err := http.Get("http://" + addr + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %s", resp.Status)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"zdns_lookups_completed_total 1", `zdns_lookups_failed_total{status="NXDOMAIN"} 1`} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("%q missing from\n%s", line, body)
+		}
+	}
+
+	if resp, err := http.Get("http://" + addr + "/other"); err == nil {
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("unexpected status %s for another path", resp.Status)
+		}
+		resp.Body.Close()
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		resp.Body.Close()
+		t.Error("metrics endpoint still served after Close")
+	}
+}
@@ -17,6 +17,7 @@ package alookup
 import (
 	"errors"
 	"flag"
+	"reflect"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -27,6 +28,14 @@ import (
 type Result struct {
 	IPv4Addresses []string `json:"ipv4_addresses,omitempty" groups:"short,normal,long,trace"`
 	IPv6Addresses []string `json:"ipv6_addresses,omitempty" groups:"short,normal,long,trace"`
+	// the aliases followed from the name to the addresses, in order
+	CNAMEChain []miekg.CNAMELink `json:"cname_chain,omitempty" groups:"short,normal,long,trace"`
+}
+
+// AnswerSet returns the addresses found, for comparison across --repeat
+// queries.
+func (r Result) AnswerSet() []string {
+	return append(append([]string{}, r.IPv4Addresses...), r.IPv6Addresses...)
 }
 
 // Per Connection Lookup ======================================================
@@ -37,15 +46,10 @@ type Lookup struct {
 }
 
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
-	nameServer := s.Factory.Factory.RandomNameServer()
-	return s.DoTargetedLookup(name, nameServer)
+	return s.DoTargetedLookup(name, s.NameServer)
 }
 
-func (s *Lookup) doLookupProtocol(name string, nameServer string, dnsType uint16, candidateSet map[string][]miekg.Answer, cnameSet map[string][]miekg.Answer, origName string, depth int) ([]string, []interface{}, zdns.Status, error) {
-	// avoid infinite loops
-	if name == origName && depth != 0 {
-		return nil, make([]interface{}, 0), zdns.STATUS_ERROR, errors.New("Infinite redirection loop")
-	}
+func (s *Lookup) doLookupProtocol(name string, nameServer string, dnsType uint16, candidateSet map[string][]miekg.Answer, cnameSet map[string][]miekg.Answer, chain *[]miekg.CNAMELink, depth int) ([]string, []interface{}, zdns.Status, error) {
 	if depth > 10 {
 		return nil, make([]interface{}, 0), zdns.STATUS_ERROR, errors.New("Max recursion depth reached")
 	}
@@ -57,7 +61,7 @@ func (s *Lookup) doLookupProtocol(name string, nameServer string, dnsType uint16
 		var miekgResult interface{}
 		var status zdns.Status
 		var err error
-		miekgResult, trace, status, err = s.DoTypedMiekgLookup(name, dnsType)
+		miekgResult, trace, status, err = s.DoTargetedMiekgLookup(name, dnsType, nameServer)
 		if status != zdns.STATUS_NOERROR || err != nil {
 			return nil, trace, status, err
 		}
@@ -102,8 +106,15 @@ func (s *Lookup) doLookupProtocol(name string, nameServer string, dnsType uint16
 		return ips, trace, zdns.STATUS_NOERROR, nil
 	} else if res, ok = cnameSet[name]; ok && len(res) > 0 {
 		// we have a CNAME and need to further recurse to find IPs
+		var loops bool
+		if *chain, loops = miekg.ExtendCNAMEChain(*chain, res[0]); loops {
+			return nil, trace, zdns.STATUS_CNAME_LOOP, miekg.CNAMELoopError(*chain)
+		}
+		if s.Factory.Factory.NoFollowCNAME {
+			return nil, trace, zdns.STATUS_NOERROR, nil
+		}
 		shortName := strings.ToLower(res[0].Answer[0 : len(res[0].Answer)-1])
-		res, secondTrace, status, err := s.doLookupProtocol(shortName, nameServer, dnsType, candidateSet, cnameSet, origName, depth+1)
+		res, secondTrace, status, err := s.doLookupProtocol(shortName, nameServer, dnsType, candidateSet, cnameSet, chain, depth+1)
 		trace = append(trace, secondTrace...)
 		return res, trace, status, err
 	} else if res, ok = garbage[name]; ok && len(res) > 0 {
@@ -123,22 +134,40 @@ func (s *Lookup) DoTargetedLookup(name string, nameServer string) (interface{},
 	var ipv6 []string
 	var ipv4Trace []interface{}
 	var ipv6Trace []interface{}
+	var ipv4Chain []miekg.CNAMELink
+	var ipv6Chain []miekg.CNAMELink
+	var ipv4Status, ipv6Status zdns.Status
+	var ipv4Err, ipv6Err error
 	if s.Factory.Factory.IPv4Lookup || !s.Factory.Factory.IPv6Lookup {
-		ipv4, ipv4Trace, _, _ = s.doLookupProtocol(name, nameServer, dns.TypeA, candidateSet, cnameSet, name, 0)
+		ipv4, ipv4Trace, ipv4Status, ipv4Err = s.doLookupProtocol(name, nameServer, dns.TypeA, candidateSet, cnameSet, &ipv4Chain, 0)
 		res.IPv4Addresses = make([]string, len(ipv4))
 		copy(res.IPv4Addresses, ipv4)
 	}
 	candidateSet = map[string][]miekg.Answer{}
 	cnameSet = map[string][]miekg.Answer{}
 	if s.Factory.Factory.IPv6Lookup {
-		ipv6, ipv6Trace, _, _ = s.doLookupProtocol(name, nameServer, dns.TypeAAAA, candidateSet, cnameSet, name, 0)
+		ipv6, ipv6Trace, ipv6Status, ipv6Err = s.doLookupProtocol(name, nameServer, dns.TypeAAAA, candidateSet, cnameSet, &ipv6Chain, 0)
 		res.IPv6Addresses = make([]string, len(ipv6))
 		copy(res.IPv6Addresses, ipv6)
 	}
+	// both families normally follow the same aliases; report the longer
+	// chain in case one of them was cut short
+	res.CNAMEChain = ipv4Chain
+	if len(ipv6Chain) > len(ipv4Chain) {
+		res.CNAMEChain = ipv6Chain
+	}
 
 	ipv4Trace = append(ipv4Trace, ipv6Trace...)
 
+	if ipv4Status == zdns.STATUS_CNAME_LOOP {
+		return res, ipv4Trace, ipv4Status, ipv4Err
+	} else if ipv6Status == zdns.STATUS_CNAME_LOOP {
+		return res, ipv4Trace, ipv6Status, ipv6Err
+	}
 	if len(res.IPv4Addresses) == 0 && len(res.IPv6Addresses) == 0 {
+		if s.Factory.Factory.NoFollowCNAME && len(res.CNAMEChain) > 0 {
+			return res, ipv4Trace, zdns.STATUS_NOERROR, nil
+		}
 		return nil, ipv4Trace, zdns.STATUS_NO_ANSWER, nil
 	}
 	return res, ipv4Trace, zdns.STATUS_NOERROR, nil
@@ -162,13 +191,15 @@ func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
 //
 type GlobalLookupFactory struct {
 	miekg.GlobalLookupFactory
-	IPv4Lookup bool
-	IPv6Lookup bool
+	IPv4Lookup    bool
+	IPv6Lookup    bool
+	NoFollowCNAME bool
 }
 
 func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
-	f.BoolVar(&s.IPv4Lookup, "ipv4-lookup", false, "perform A lookups for each server")
-	f.BoolVar(&s.IPv6Lookup, "ipv6-lookup", false, "perform AAAA record lookups for each server")
+	f.BoolVar(&s.IPv4Lookup, "ipv4-lookup", false, "resolve IPv4 addresses (A records); done by default unless only --ipv6-lookup is given")
+	f.BoolVar(&s.IPv6Lookup, "ipv6-lookup", false, "resolve IPv6 addresses (AAAA records); combine with --ipv4-lookup to resolve both")
+	f.BoolVar(&s.NoFollowCNAME, "no-follow-cname", false, "report the CNAME record of an alias instead of following it to the addresses of its target")
 }
 
 // Command-line Help Documentation. This is the descriptive text what is
@@ -186,6 +217,10 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // Global Registration ========================================================
 //
 func init() {
@@ -15,14 +15,23 @@
 package alookup
 
 import (
+	"encoding/json"
 	"github.com/zmap/zdns"
 	"github.com/zmap/zdns/modules/miekg"
 	"reflect"
+	"sort"
+	"sync"
 	"testing"
 )
 
 // Mock the actual Miekg lookup.
-func (s *Lookup) DoTypedMiekgLookup(name string, dnsType uint16) (interface{}, []interface{}, zdns.Status, error) {
+func (s *Lookup) DoTargetedMiekgLookup(name string, dnsType uint16, nameServer string) (interface{}, []interface{}, zdns.Status, error) {
+	mockServers.Lock()
+	mockServers.queried = append(mockServers.queried, nameServer)
+	mockServers.Unlock()
+	if res, ok := mockResults[nameServer+" "+name]; ok {
+		return res, nil, zdns.STATUS_NOERROR, nil
+	}
 	if res, ok := mockResults[name]; ok {
 		return res, nil, zdns.STATUS_NOERROR, nil
 	} else {
@@ -30,8 +39,16 @@ func (s *Lookup) DoTypedMiekgLookup(name string, dnsType uint16) (interface{}, [
 	}
 }
 
+// keyed by name, or by the name server and name for answers that only one
+// server gives
 var mockResults = make(map[string]miekg.Result)
 
+// the name servers that the mock was asked to query
+var mockServers struct {
+	sync.Mutex
+	queried []string
+}
+
 func TestDoLookup(t *testing.T) {
 	gc := new(zdns.GlobalConf)
 	gc.NameServers = []string{"127.0.0.1"}
@@ -243,6 +260,127 @@ func TestDoLookup(t *testing.T) {
 
 	res, _, _, _ = l.DoLookup("example.com")
 	verifyResult(t, res.(Result), []string{"192.0.2.3"}, []string{"2001:db8::4"})
+
+	// Case 10: CNAME to a target with both A and AAAA records
+	mockResults["example.com"] = miekg.Result{
+		Answers: []interface{}{miekg.Answer{
+			Ttl:    3600,
+			Type:   "A",
+			Class:  "IN",
+			Name:   "example.com",
+			Answer: "192.0.2.5",
+		},
+			miekg.Answer{
+				Ttl:    3600,
+				Type:   "AAAA",
+				Class:  "IN",
+				Name:   "example.com",
+				Answer: "2001:db8::5",
+			}},
+		Additional:  nil,
+		Authorities: nil,
+		Protocol:    "",
+		Flags:       miekg.DNSFlags{},
+	}
+	mockResults["cname.example.com"] = miekg.Result{
+		Answers: []interface{}{miekg.Answer{
+			Ttl:    3600,
+			Type:   "CNAME",
+			Class:  "IN",
+			Name:   "cname.example.com",
+			Answer: "example.com.",
+		}},
+		Additional:  nil,
+		Authorities: nil,
+		Protocol:    "",
+		Flags:       miekg.DNSFlags{},
+	}
+
+	res, _, _, _ = l.DoLookup("cname.example.com")
+	verifyResult(t, res.(Result), []string{"192.0.2.5"}, []string{"2001:db8::5"})
+
+	// Case 11: IPv4 disabled, only the AAAA record of the target is returned
+	glf.IPv4Lookup = false
+
+	res, _, _, _ = l.DoLookup("cname.example.com")
+	verifyResult(t, res.(Result), nil, []string{"2001:db8::5"})
+}
+
+func TestCNAMEChain(t *testing.T) {
+	gc := new(zdns.GlobalConf)
+	gc.NameServers = []string{"127.0.0.1"}
+
+	glf := new(GlobalLookupFactory)
+	glf.GlobalConf = gc
+	glf.IPv4Lookup = true
+
+	rlf := new(RoutineLookupFactory)
+	rlf.Factory = glf
+
+	l, err := rlf.MakeLookup()
+	if l == nil || err != nil {
+		t.Error("Failed to initialize lookup")
+	}
+
+	cname := func(name string, target string) miekg.Result {
+		return miekg.Result{Answers: []interface{}{miekg.Answer{
+			Ttl:    300,
+			Type:   "CNAME",
+			Class:  "IN",
+			Name:   name,
+			Answer: target + ".",
+		}}}
+	}
+	mockResults["www.example.org"] = cname("www.example.org", "cdn.example.net")
+	mockResults["cdn.example.net"] = cname("cdn.example.net", "edge.example.com")
+	mockResults["edge.example.com"] = miekg.Result{Answers: []interface{}{miekg.Answer{
+		Ttl:    60,
+		Type:   "A",
+		Class:  "IN",
+		Name:   "edge.example.com",
+		Answer: "192.0.2.10",
+	}}}
+
+	// the chain is reported in order along with the final address
+	res, _, status, _ := l.DoLookup("www.example.org")
+	if status != zdns.STATUS_NOERROR {
+		t.Fatalf("Expected NOERROR status, got %v", status)
+	}
+	verifyResult(t, res.(Result), []string{"192.0.2.10"}, nil)
+	expected := []miekg.CNAMELink{
+		{Name: "www.example.org", Target: "cdn.example.net", Ttl: 300},
+		{Name: "cdn.example.net", Target: "edge.example.com", Ttl: 300},
+	}
+	if !reflect.DeepEqual(res.(Result).CNAMEChain, expected) {
+		t.Errorf("Unexpected CNAME chain: %v", res.(Result).CNAMEChain)
+	}
+
+	// without following, only the name's own CNAME is reported
+	glf.NoFollowCNAME = true
+	res, _, status, _ = l.DoLookup("www.example.org")
+	if status != zdns.STATUS_NOERROR {
+		t.Fatalf("Expected NOERROR status, got %v", status)
+	}
+	verifyResult(t, res.(Result), nil, nil)
+	if !reflect.DeepEqual(res.(Result).CNAMEChain, expected[:1]) {
+		t.Errorf("Unexpected CNAME chain: %v", res.(Result).CNAMEChain)
+	}
+	glf.NoFollowCNAME = false
+
+	// a chain leading back to one of its aliases is a loop
+	mockResults["a.example.org"] = cname("a.example.org", "b.example.org")
+	mockResults["b.example.org"] = cname("b.example.org", "c.example.org")
+	mockResults["c.example.org"] = cname("c.example.org", "b.example.org")
+	res, _, status, err = l.DoLookup("a.example.org")
+	if status != zdns.STATUS_CNAME_LOOP {
+		t.Fatalf("Expected CNAME_LOOP status, got %v", status)
+	}
+	if err == nil || err.Error() != "CNAME loop: a.example.org -> b.example.org -> c.example.org -> b.example.org" {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if len(res.(Result).CNAMEChain) != 3 {
+		t.Errorf("Unexpected CNAME chain: %v", res.(Result).CNAMEChain)
+	}
 }
 
 func verifyResult(t *testing.T, res Result, ipv4 []string, ipv6 []string) {
@@ -270,3 +408,90 @@ func verifyResult(t *testing.T, res Result, ipv4 []string, ipv6 []string) {
 		}
 	}
 }
+
+// namesInput feeds a fixed list of names.
+type namesInput struct {
+	names []string
+}
+
+func (h *namesInput) Initialize(conf *zdns.GlobalConf) {}
+
+func (h *namesInput) FeedChannel(in chan<- interface{}, wg *sync.WaitGroup, zonefileInput bool) error {
+	defer close(in)
+	defer (*wg).Done()
+	for _, name := range h.names {
+		in <- name
+	}
+	return nil
+}
+
+// resultsOutput keeps the results written.
+type resultsOutput struct {
+	results []string
+}
+
+func (h *resultsOutput) Initialize(conf *zdns.GlobalConf) {}
+
+func (h *resultsOutput) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+	for r := range results {
+		h.results = append(h.results, r)
+	}
+	return nil
+}
+
+func TestCompareServers(t *testing.T) {
+	mockResults = make(map[string]miekg.Result)
+	mockServers.queried = nil
+	mockResults["192.0.2.1:53 example.com"] = miekg.Result{
+		Answers: []interface{}{miekg.Answer{Name: "example.com", Answer: "192.0.2.10", Type: "A"}},
+	}
+	mockResults["192.0.2.2:53 example.com"] = miekg.Result{
+		Answers: []interface{}{miekg.Answer{Name: "example.com", Answer: "192.0.2.20", Type: "A"}},
+	}
+	out := new(resultsOutput)
+	zdns.RegisterInputHandler("test-names", &namesInput{names: []string{"example.com"}})
+	zdns.RegisterOutputHandler("test-results", out)
+
+	gc := &zdns.GlobalConf{
+		Threads:        1,
+		TimeFormat:     "2006-01-02T15:04:05Z07:00",
+		InputHandler:   "test-names",
+		OutputHandlers: []string{"test-results"},
+		OutputGroups:   []string{"short"},
+		SampleRate:     1,
+		// never queried, as the compared servers take its place
+		NameServers:    []string{"192.0.2.99:53"},
+		CompareServers: []string{"192.0.2.1:53", "192.0.2.2:53"},
+	}
+	var factory zdns.GlobalLookupFactory = new(GlobalLookupFactory)
+	if err := factory.Initialize(gc); err != nil {
+		t.Fatal(err)
+	}
+	if err := zdns.DoLookups(&factory, gc); err != nil {
+		t.Fatal(err)
+	}
+
+	queried := append([]string{}, mockServers.queried...)
+	sort.Strings(queried)
+	if !reflect.DeepEqual(queried, gc.CompareServers) {
+		t.Errorf("expected queries to %v, got %v", gc.CompareServers, queried)
+	}
+	if len(out.results) != 1 {
+		t.Fatalf("expected one result, got %v", out.results)
+	}
+	var res struct {
+		Data zdns.CompareResult `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(out.results[0]), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Data.Agree || len(res.Data.Servers) != 2 {
+		t.Fatalf("expected the servers to disagree: %s", out.results[0])
+	}
+	for i, expected := range []string{"192.0.2.10", "192.0.2.20"} {
+		if s := res.Data.Servers[i]; s.Server != gc.CompareServers[i] || len(s.Unique) != 1 || s.Unique[0] != expected {
+			t.Errorf("unexpected answers of %s: %+v", gc.CompareServers[i], s)
+		}
+	}
+}
@@ -15,10 +15,15 @@
 package axfr
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"net"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -41,6 +46,21 @@ type AXFRServerResult struct {
 	Status  string        `json:"status" groups:"short,normal,long,trace"`
 	Error   string        `json:"error,omitempty" groups:"short,normal,long,trace"`
 	Records []interface{} `json:"records,omitempty" groups:"short,normal,long,trace"`
+	// only set with --ixfr: whether the server sent the changes (ixfr), the
+	// entire zone in Records instead (axfr), or nothing because the zone
+	// hasn't changed (up_to_date)
+	Transfer string `json:"transfer,omitempty" groups:"short,normal,long,trace"`
+	// the zone's current serial according to the server, with --ixfr
+	Serial  uint32       `json:"serial,omitempty" groups:"short,normal,long,trace"`
+	Changes []IXFRChange `json:"changes,omitempty" groups:"short,normal,long,trace"`
+}
+
+// the records deleted and added to get from one version of a zone to the next
+type IXFRChange struct {
+	FromSerial uint32        `json:"from_serial" groups:"short,normal,long,trace"`
+	ToSerial   uint32        `json:"to_serial" groups:"short,normal,long,trace"`
+	Deleted    []interface{} `json:"deleted" groups:"short,normal,long,trace"`
+	Added      []interface{} `json:"added" groups:"short,normal,long,trace"`
 }
 
 type AXFRResult struct {
@@ -51,51 +71,208 @@ func dotName(name string) string {
 	return strings.Join([]string{name, "."}, "")
 }
 
+// checkBlacklist returns why server must not be contacted, if it mustn't
+func (s *Lookup) checkBlacklist(server string) string {
+	if s.Factory.Factory.Blacklist == nil {
+		return ""
+	}
+	s.Factory.Factory.BlMu.Lock()
+	defer s.Factory.Factory.BlMu.Unlock()
+	if blacklisted, err := s.Factory.Factory.Blacklist.IsBlacklisted(server); err != nil {
+		return "blacklist-error"
+	} else if blacklisted {
+		return "blacklisted"
+	}
+	return ""
+}
+
+// transfer sends a zone transfer request to server and collects the records
+// of every message of the response. With a TSIG key, the request is signed
+// and the signed messages of the response are verified. With a proxy, the
+// connection is tunneled through it.
+func transfer(m *dns.Msg, server string, key *zdns.TSIGKey, proxy *zdns.SOCKS5Proxy, timeout time.Duration) ([]dns.RR, error) {
+	tr := new(dns.Transfer)
+	if key != nil {
+		tr.TsigSecret = key.Secrets()
+		key.Sign(m)
+	}
+	addr := net.JoinHostPort(server, "53")
+	if proxy != nil {
+		// the dns package would otherwise dial the server directly
+		nc, err := proxy.DialContext(context.Background(), &net.Dialer{Timeout: timeout}, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		tr.Conn = &dns.Conn{TCP: nc}
+	}
+	a, err := tr.In(m, addr)
+	if err != nil {
+		if tr.Conn != nil {
+			tr.Conn.Close()
+		}
+		return nil, err
+	}
+	var rrs []dns.RR
+	for ex := range a {
+		if ex.Error != nil {
+			return nil, ex.Error
+		}
+		rrs = append(rrs, ex.RR...)
+	}
+	return rrs, nil
+}
+
+// transfer runs a zone transfer with the TSIG key and proxy of the scan.
+func (s *Lookup) transfer(m *dns.Msg, server string) ([]dns.RR, error) {
+	c := s.Factory.Factory.GlobalConf
+	return transfer(m, server, c.TSIG, c.SOCKS5, c.Timeout)
+}
+
+// transferStatus tells TSIG verification failures apart from other failed
+// transfers.
+func transferStatus(err error) string {
+	if zdns.IsTSIGError(err) {
+		return string(zdns.STATUS_TSIG_ERROR)
+	}
+	return "ERROR"
+}
+
+func parseRecords(rrs []dns.RR) []interface{} {
+	var records []interface{}
+	for _, rr := range rrs {
+		records = append(records, miekg.ParseAnswer(rr))
+	}
+	return records
+}
+
 func (s *Lookup) DoAXFR(name string, server string) AXFRServerResult {
 	var retv AXFRServerResult
 	retv.Server = server
 	// check if the server address is blacklisted and if so, exclude
-	if s.Factory.Factory.Blacklist != nil {
-		s.Factory.Factory.BlMu.Lock()
-		if blacklisted, err := s.Factory.Factory.Blacklist.IsBlacklisted(server); err != nil {
-			s.Factory.Factory.BlMu.Unlock()
-			retv.Status = "ERROR"
-			retv.Error = "blacklist-error"
-			return retv
-		} else if blacklisted {
-			s.Factory.Factory.BlMu.Unlock()
-			retv.Status = "ERROR"
-			retv.Error = "blacklisted"
-			return retv
-		}
-		s.Factory.Factory.BlMu.Unlock()
+	if reason := s.checkBlacklist(server); reason != "" {
+		retv.Status = "ERROR"
+		retv.Error = reason
+		return retv
 	}
 	m := new(dns.Msg)
 	m.SetAxfr(dotName(name))
-	tr := new(dns.Transfer)
-	if a, err := tr.In(m, net.JoinHostPort(server, "53")); err != nil {
-		retv.Status = "ERROR"
+	rrs, err := s.transfer(m, server)
+	if err != nil {
+		retv.Status = transferStatus(err)
 		retv.Error = err.Error()
 		return retv
-	} else {
-		for ex := range a {
-			if ex.Error != nil {
-				retv.Status = "ERROR"
-				retv.Error = ex.Error.Error()
-				return retv
-			} else {
-				retv.Status = "NOERROR"
-				for _, rr := range ex.RR {
-					ans := miekg.ParseAnswer(rr)
-					retv.Records = append(retv.Records, ans)
-				}
+	}
+	retv.Status = "NOERROR"
+	retv.Records = parseRecords(rrs)
+	return retv
+}
+
+// parseIXFR interprets the records of an IXFR response (RFC 1995, section 4)
+// to a query with the given serial. The response starts and ends with the
+// zone's current SOA. In between, each change is the old SOA followed by the
+// deleted records, then the new SOA followed by the added ones. A server that
+// can't send changes sends the entire zone instead, as in AXFR, and a lone
+// SOA means the zone hasn't changed.
+func parseIXFR(rrs []dns.RR, serial uint32, retv *AXFRServerResult) error {
+	if len(rrs) == 0 {
+		return errors.New("empty IXFR response")
+	}
+	current, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return errors.New("IXFR response doesn't start with an SOA record")
+	}
+	retv.Serial = current.Serial
+	if len(rrs) == 1 || current.Serial == serial {
+		retv.Transfer = "up_to_date"
+		return nil
+	}
+	if _, ok := rrs[1].(*dns.SOA); !ok || len(rrs) == 2 {
+		retv.Transfer = "axfr"
+		retv.Records = parseRecords(rrs)
+		return nil
+	}
+	retv.Transfer = "ixfr"
+	i := 1
+	// next returns the records up to the next SOA, which it also returns
+	next := func() ([]interface{}, *dns.SOA) {
+		records := []interface{}{}
+		for ; i < len(rrs); i++ {
+			if soa, ok := rrs[i].(*dns.SOA); ok {
+				i++
+				return records, soa
 			}
+			records = append(records, miekg.ParseAnswer(rrs[i]))
 		}
+		return records, nil
 	}
+	_, from := next()
+	for from != nil && i < len(rrs) {
+		deleted, to := next()
+		if to == nil {
+			return errors.New("IXFR change from serial " + strconv.FormatUint(uint64(from.Serial), 10) + " has no new SOA record")
+		}
+		added, soa := next()
+		if soa == nil {
+			return errors.New("IXFR response doesn't end with an SOA record")
+		}
+		retv.Changes = append(retv.Changes, IXFRChange{
+			FromSerial: from.Serial,
+			ToSerial:   to.Serial,
+			Deleted:    deleted,
+			Added:      added,
+		})
+		from = soa
+	}
+	return nil
+}
+
+func (s *Lookup) DoIXFR(name string, server string, serial uint32) AXFRServerResult {
+	var retv AXFRServerResult
+	retv.Server = server
+	if reason := s.checkBlacklist(server); reason != "" {
+		retv.Status = "ERROR"
+		retv.Error = reason
+		return retv
+	}
+	m := new(dns.Msg)
+	m.SetIxfr(dotName(name), serial, ".", ".")
+	rrs, err := s.transfer(m, server)
+	if err == nil {
+		err = parseIXFR(rrs, serial, &retv)
+	}
+	if err != nil {
+		retv.Status = transferStatus(err)
+		retv.Error = err.Error()
+		return retv
+	}
+	retv.Status = "NOERROR"
 	return retv
 }
 
+// parseIXFRInput splits an input line of the form name[,serial] into the
+// zone name and the serial from which to request changes, which defaults to
+// --ixfr-serial.
+func (s *Lookup) parseIXFRInput(line string) (string, uint32, error) {
+	serial := s.Factory.Factory.IXFRSerial
+	parts := strings.SplitN(line, ",", 2)
+	if len(parts) == 2 {
+		parsed, err := strconv.ParseUint(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil {
+			return "", 0, errors.New("invalid serial: " + parts[1])
+		}
+		serial = uint(parsed)
+	}
+	return strings.TrimSpace(parts[0]), uint32(serial), nil
+}
+
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	var serial uint32
+	if s.Factory.Factory.IXFR {
+		var err error
+		if name, serial, err = s.parseIXFRInput(name); err != nil {
+			return nil, nil, zdns.STATUS_ILLEGAL_INPUT, err
+		}
+	}
 	parsedNS, trace, status, err := s.DoNSLookup(name, true, false)
 	if status != zdns.STATUS_NOERROR {
 		return nil, trace, status, err
@@ -103,7 +280,11 @@ func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status,
 	var retv AXFRResult
 	for _, server := range parsedNS.Servers {
 		if len(server.IPv4Addresses) > 0 {
-			retv.Servers = append(retv.Servers, s.DoAXFR(name, server.IPv4Addresses[0]))
+			if s.Factory.Factory.IXFR {
+				retv.Servers = append(retv.Servers, s.DoIXFR(name, server.IPv4Addresses[0], serial))
+			} else {
+				retv.Servers = append(retv.Servers, s.DoAXFR(name, server.IPv4Addresses[0]))
+			}
 		}
 	}
 	return retv, trace, zdns.STATUS_NOERROR, nil
@@ -130,6 +311,8 @@ type GlobalLookupFactory struct {
 	BlacklistPath string
 	Blacklist     *blacklist.Blacklist
 	BlMu          sync.Mutex
+	IXFR          bool
+	IXFRSerial    uint
 }
 
 // Command-line Help Documentation. This is the descriptive text what is
@@ -140,6 +323,8 @@ func (s *GlobalLookupFactory) Help() string {
 
 func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
 	f.StringVar(&s.BlacklistPath, "blacklist-file", "", "blacklist file for servers to exclude from AXFR lookups")
+	f.BoolVar(&s.IXFR, "ixfr", false, "request only the changes since a known serial (IXFR) instead of the entire zone. Input lines are name[,serial]")
+	f.UintVar(&s.IXFRSerial, "ixfr-serial", 0, "serial to request IXFR changes from, for input lines that don't give one")
 }
 
 func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
@@ -147,6 +332,8 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	r.Factory = s
 	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
 	r.Initialize(s.GlobalConf)
+	// only the transfers are signed, not the lookups of the name servers
+	r.QueryOptions.TSIG = nil
 	r.ThreadID = threadID
 	return r, nil
 }
@@ -162,7 +349,15 @@ func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
 	if c.IterativeResolution == true {
 		log.Fatal("AXFR module does not support iterative resolution")
 	}
-	return nil
+	if s.IXFRSerial > 0xffffffff {
+		log.Fatal("--ixfr-serial must fit in 32 bits")
+	}
+	// sets up the transports of the name server lookups
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(AXFRResult{})
 }
 
 // Global Registration ========================================================
@@ -0,0 +1,242 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package axfr
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func makeRRs(t *testing.T, records ...string) []dns.RR {
+	var rrs []dns.RR
+	for _, r := range records {
+		rr, err := dns.NewRR(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return rrs
+}
+
+func soa(serial string) string {
+	return "example.com. 3600 IN SOA ns.example.com. hostmaster.example.com. " + serial + " 7200 3600 1209600 300"
+}
+
+func TestParseIXFRIncremental(t *testing.T) {
+	// the example of RFC 1995, section 7: two changes, from 1 to 2 and 2 to 3
+	rrs := makeRRs(t,
+		soa("3"),
+		soa("1"),
+		"nezu.example.com. 3600 IN A 133.69.136.5",
+		soa("2"),
+		soa("2"),
+		"jain-bb.example.com. 3600 IN A 133.69.136.4",
+		"jain-bb.example.com. 3600 IN A 192.41.197.2",
+		soa("3"),
+		"jain-bb.example.com. 3600 IN A 133.69.136.3",
+		soa("3"),
+	)
+	var retv AXFRServerResult
+	if err := parseIXFR(rrs, 1, &retv); err != nil {
+		t.Fatal(err)
+	}
+	if retv.Transfer != "ixfr" || retv.Serial != 3 {
+		t.Fatalf("expected an incremental transfer to serial 3, got %s to %d", retv.Transfer, retv.Serial)
+	}
+	if len(retv.Changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(retv.Changes))
+	}
+	first, second := retv.Changes[0], retv.Changes[1]
+	if first.FromSerial != 1 || first.ToSerial != 2 || len(first.Deleted) != 1 || len(first.Added) != 0 {
+		t.Errorf("unexpected first change: %+v", first)
+	}
+	if second.FromSerial != 2 || second.ToSerial != 3 || len(second.Deleted) != 2 || len(second.Added) != 1 {
+		t.Errorf("unexpected second change: %+v", second)
+	}
+	if a := second.Added[0].(miekg.Answer); a.Answer != "133.69.136.3" {
+		t.Errorf("unexpected added record: %v", a)
+	}
+	if retv.Records != nil {
+		t.Error("an incremental transfer shouldn't report the entire zone")
+	}
+}
+
+func TestParseIXFRFallback(t *testing.T) {
+	rrs := makeRRs(t,
+		soa("3"),
+		"example.com. 3600 IN NS ns.example.com.",
+		"ns.example.com. 3600 IN A 192.0.2.1",
+		soa("3"),
+	)
+	var retv AXFRServerResult
+	if err := parseIXFR(rrs, 1, &retv); err != nil {
+		t.Fatal(err)
+	}
+	if retv.Transfer != "axfr" || len(retv.Records) != 4 || len(retv.Changes) != 0 {
+		t.Errorf("expected a full zone transfer, got %s with %d records", retv.Transfer, len(retv.Records))
+	}
+}
+
+func TestParseIXFRUpToDate(t *testing.T) {
+	var retv AXFRServerResult
+	if err := parseIXFR(makeRRs(t, soa("3")), 3, &retv); err != nil {
+		t.Fatal(err)
+	}
+	if retv.Transfer != "up_to_date" || retv.Serial != 3 {
+		t.Errorf("expected an up to date zone, got %s", retv.Transfer)
+	}
+}
+
+func TestParseIXFRMalformed(t *testing.T) {
+	var retv AXFRServerResult
+	if err := parseIXFR(makeRRs(t, "ns.example.com. 3600 IN A 192.0.2.1"), 1, &retv); err == nil {
+		t.Error("expected an error for a response without a leading SOA")
+	}
+	retv = AXFRServerResult{}
+	rrs := makeRRs(t, soa("3"), soa("1"), "nezu.example.com. 3600 IN A 133.69.136.5")
+	if err := parseIXFR(rrs, 1, &retv); err == nil {
+		t.Error("expected an error for a truncated change")
+	}
+}
+
+// serveZoneBySOCKS5 accepts a single SOCKS5 CONNECT request and, rather than
+// connecting anywhere, answers the zone transfer sent through it with zone.
+// The requested address is sent to target.
+func serveZoneBySOCKS5(t *testing.T, zone []dns.RR, target chan<- string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go func() {
+		defer l.Close()
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		greeting := make([]byte, 2)
+		if _, err := io.ReadFull(c, greeting); err != nil {
+			return
+		}
+		if _, err := io.ReadFull(c, make([]byte, greeting[1])); err != nil {
+			return
+		}
+		c.Write([]byte{5, 0})
+		req := make([]byte, 10)
+		if _, err := io.ReadFull(c, req); err != nil || req[3] != 1 {
+			return
+		}
+		target <- (&net.TCPAddr{IP: net.IP(req[4:8]), Port: int(req[8])<<8 | int(req[9])}).String()
+		c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn := &dns.Conn{TCP: c}
+		m, err := conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = zone
+		conn.WriteMsg(r)
+	}()
+	return l.Addr().String()
+}
+
+func TestTransferSOCKS5(t *testing.T) {
+	zone := makeRRs(t, soa("5"), "www.example.com. 3600 IN A 192.0.2.1", soa("5"))
+	target := make(chan string, 1)
+	proxy := &zdns.SOCKS5Proxy{Addr: serveZoneBySOCKS5(t, zone, target)}
+	m := new(dns.Msg)
+	m.SetAxfr("example.com.")
+	rrs, err := transfer(m, "192.0.2.53", nil, proxy, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr := <-target; addr != "192.0.2.53:53" {
+		t.Errorf("proxy was asked for %s", addr)
+	}
+	if len(rrs) != len(zone) {
+		t.Errorf("expected %d records, got %v", len(zone), rrs)
+	}
+}
+
+// serveNS answers queries for the name servers of example.com, which is
+// served by ns1.example.com at 127.0.0.1, on the returned address.
+func serveNS(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if m.Unpack(buf[:n]) != nil || len(m.Question) != 1 {
+				continue
+			}
+			r := new(dns.Msg)
+			r.SetReply(m)
+			q := m.Question[0]
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 300}
+			switch {
+			case q.Qtype == dns.TypeNS && q.Name == "example.com.":
+				r.Answer = append(r.Answer, &dns.NS{Hdr: hdr, Ns: "ns1.example.com."})
+			case q.Qtype == dns.TypeSOA && q.Name == "example.com.":
+				r.Answer = append(r.Answer, &dns.SOA{Hdr: hdr, Ns: "ns1.example.com.", Mbox: "hostmaster.example.com.", Serial: 1})
+			case q.Qtype == dns.TypeA && q.Name == "ns1.example.com.":
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("127.0.0.1")})
+			}
+			wire, _ := r.Pack()
+			conn.WriteTo(wire, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestDoLookupFromMakeLookup(t *testing.T) {
+	gc := &zdns.GlobalConf{NameServers: []string{serveNS(t)}, Timeout: 2 * time.Second, Class: dns.ClassINET}
+	glf := new(GlobalLookupFactory)
+	if err := glf.Initialize(gc); err != nil {
+		t.Fatal(err)
+	}
+	rlf, err := glf.MakeRoutineFactory(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := rlf.MakeLookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _, status, err := l.DoLookup("example.com")
+	if status != zdns.STATUS_NOERROR || err != nil {
+		t.Fatalf("expected status %s, got %s: %v", zdns.STATUS_NOERROR, status, err)
+	}
+	// nothing answers transfers at 127.0.0.1, but the server is tried
+	servers := res.(AXFRResult).Servers
+	if len(servers) != 1 || servers[0].Server != "127.0.0.1" {
+		t.Errorf("expected a transfer from 127.0.0.1, got %+v", servers)
+	}
+}
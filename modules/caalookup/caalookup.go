@@ -0,0 +1,183 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package caalookup
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// bit 0 of the flags octet (RFC 8659, Section 4.1)
+const criticalFlag = 128
+
+// property tags defined by RFC 8659 and the CA/Browser Forum
+var knownTags = map[string]bool{
+	"issue":        true,
+	"issuewild":    true,
+	"iodef":        true,
+	"issuemail":    true,
+	"contactemail": true,
+	"contactphone": true,
+}
+
+// result to be returned by scan of host
+
+type CAARecord struct {
+	Flag     uint8  `json:"flag" groups:"short,normal,long,trace"`
+	Critical bool   `json:"critical" groups:"short,normal,long,trace"`
+	Tag      string `json:"tag" groups:"short,normal,long,trace"`
+	KnownTag bool   `json:"known_tag" groups:"short,normal,long,trace"`
+	Value    string `json:"value" groups:"short,normal,long,trace"`
+	TTL      uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	// the name in the tree walk at which the relevant record set was found
+	FoundAt string      `json:"found_at,omitempty" groups:"short,normal,long,trace"`
+	Records []CAARecord `json:"records" groups:"short,normal,long,trace"`
+	// a critical property with a tag we don't understand forbids issuance
+	UnknownCritical bool `json:"unknown_critical" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// candidateNames returns the names a CA inspects for a domain: the name itself
+// followed by each of its parents, excluding the root.
+func candidateNames(name string) []string {
+	name = strings.TrimSuffix(name, ".")
+	labels := strings.Split(name, ".")
+	names := make([]string, 0, len(labels))
+	for i := range labels {
+		names = append(names, strings.Join(labels[i:], "."))
+	}
+	return names
+}
+
+// parseRecords returns the CAA records among answers, and whether any of them
+// is a critical property with a tag we don't understand.
+func parseRecords(answers []interface{}) ([]CAARecord, bool) {
+	var records []CAARecord
+	unknownCritical := false
+	for _, a := range answers {
+		caa, ok := a.(miekg.CAAAnswer)
+		if !ok {
+			continue
+		}
+		tag := strings.ToLower(caa.Tag)
+		rec := CAARecord{
+			Flag:     caa.Flag,
+			Critical: caa.Flag&criticalFlag != 0,
+			Tag:      caa.Tag,
+			KnownTag: knownTags[tag],
+			Value:    caa.Value,
+			TTL:      caa.Ttl,
+		}
+		if rec.Critical && !rec.KnownTag {
+			unknownCritical = true
+		}
+		records = append(records, rec)
+	}
+	return records, unknownCritical
+}
+
+// climb looks up the CAA records of name and then of each of its parents
+// with lookup, until it finds a record set.
+func climb(name string, lookup func(string, uint16) (interface{}, []interface{}, zdns.Status, error)) (Result, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []CAARecord{}}
+	trace := make([]interface{}, 0)
+	for _, candidate := range candidateNames(name) {
+		res, secondTrace, status, err := lookup(candidate, dns.TypeCAA)
+		trace = append(trace, secondTrace...)
+		// a missing name is not an error here; CAs keep climbing the tree. Any
+		// other failure means we can't know the relevant record set.
+		if status == zdns.STATUS_NXDOMAIN {
+			continue
+		}
+		if status != zdns.STATUS_NOERROR {
+			return retv, trace, status, err
+		}
+		r, ok := res.(miekg.Result)
+		if !ok {
+			panic("could not cast correctly")
+		}
+		records, unknownCritical := parseRecords(r.Answers)
+		if len(records) > 0 {
+			retv.Records = records
+			retv.UnknownCritical = unknownCritical
+			retv.FoundAt = candidate
+			return retv, trace, zdns.STATUS_NOERROR, nil
+		}
+	}
+	return retv, trace, zdns.STATUS_NO_RECORD, nil
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	return climb(name, s.DoTypedMiekgLookup)
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeCAA, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("CAALOOKUP", s)
+}
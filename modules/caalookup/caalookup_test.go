@@ -0,0 +1,113 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package caalookup
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func TestCandidateNames(t *testing.T) {
+	expected := []string{"www.sub.example.com", "sub.example.com", "example.com", "com"}
+	if got := candidateNames("www.sub.example.com."); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func caa(flag uint8, tag string, value string) miekg.CAAAnswer {
+	return miekg.CAAAnswer{Answer: miekg.Answer{Type: "CAA", Ttl: 300}, Flag: flag, Tag: tag, Value: value}
+}
+
+func TestParseRecords(t *testing.T) {
+	records, unknownCritical := parseRecords([]interface{}{
+		caa(0, "issue", "ca.example.net"),
+		caa(128, "IssueWild", ";"),
+		miekg.Answer{Type: "CNAME", Answer: "alias.example.com."},
+		caa(0, "tbs", "unknown, but not critical"),
+	})
+	expected := []CAARecord{
+		{Flag: 0, Tag: "issue", KnownTag: true, Value: "ca.example.net", TTL: 300},
+		{Flag: 128, Critical: true, Tag: "IssueWild", KnownTag: true, Value: ";", TTL: 300},
+		{Flag: 0, Tag: "tbs", Value: "unknown, but not critical", TTL: 300},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, records)
+	}
+	if unknownCritical {
+		t.Error("Unexpected unknown critical property")
+	}
+	if _, unknownCritical := parseRecords([]interface{}{caa(128, "tbs", "")}); !unknownCritical {
+		t.Error("Expected a critical property with an unknown tag to be flagged")
+	}
+}
+
+// fakeCAA answers CAA lookups from a map of names to records. Names missing
+// from the map don't exist, and those mapped to nil have no CAA records.
+func fakeCAA(records map[string][]interface{}, queried *[]string) func(string, uint16) (interface{}, []interface{}, zdns.Status, error) {
+	return func(name string, dnsType uint16) (interface{}, []interface{}, zdns.Status, error) {
+		*queried = append(*queried, name)
+		if name == "fail.example.com" {
+			return nil, nil, zdns.STATUS_SERVFAIL, errors.New("server failure")
+		}
+		answers, ok := records[name]
+		if !ok {
+			return miekg.Result{}, nil, zdns.STATUS_NXDOMAIN, nil
+		}
+		return miekg.Result{Answers: answers}, []interface{}{name}, zdns.STATUS_NOERROR, nil
+	}
+}
+
+func TestClimb(t *testing.T) {
+	records := map[string][]interface{}{
+		"www.example.com": nil,
+		"example.com":     {caa(0, "issue", "ca.example.net")},
+		"com":             {caa(0, "issue", "ca.example.org")},
+	}
+	var queried []string
+	res, trace, status, _ := climb("missing.www.example.com", fakeCAA(records, &queried))
+	if status != zdns.STATUS_NOERROR || res.FoundAt != "example.com" {
+		t.Fatalf("Expected the records of example.com, got %s %+v", status, res)
+	}
+	if len(res.Records) != 1 || res.Records[0].Value != "ca.example.net" {
+		t.Errorf("Unexpected records %+v", res.Records)
+	}
+	// the climb stops at the first record set
+	if expected := []string{"missing.www.example.com", "www.example.com", "example.com"}; !reflect.DeepEqual(queried, expected) {
+		t.Errorf("Expected lookups of %v, got %v", expected, queried)
+	}
+	if len(trace) != 2 {
+		t.Errorf("Expected the traces of 2 lookups, got %d", len(trace))
+	}
+
+	queried = nil
+	res, _, status, _ = climb("example.net", fakeCAA(records, &queried))
+	if status != zdns.STATUS_NO_RECORD || len(res.Records) != 0 || res.FoundAt != "" {
+		t.Errorf("Expected no records, got %s %+v", status, res)
+	}
+
+	// a failure means the relevant record set can't be known
+	queried = nil
+	_, _, status, err := climb("www.fail.example.com", fakeCAA(records, &queried))
+	if status != zdns.STATUS_SERVFAIL || err == nil {
+		t.Errorf("Expected the failure to be reported, got %s", status)
+	}
+	if len(queried) != 2 {
+		t.Errorf("Expected the climb to stop at the failure, got lookups of %v", queried)
+	}
+}
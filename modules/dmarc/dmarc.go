@@ -15,6 +15,11 @@
 package dmarc
 
 import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
 	"github.com/miekg/dns"
 	"github.com/zmap/zdns"
 	"github.com/zmap/zdns/modules/miekg"
@@ -22,7 +27,153 @@ import (
 
 // result to be returned by scan of host
 type Result struct {
-	Dmarc string `json:"dmarc,omitempty" groups:"short,normal,long,trace"`
+	Dmarc  string  `json:"dmarc,omitempty" groups:"short,normal,long,trace"`
+	Policy *Policy `json:"policy,omitempty" groups:"short,normal,long,trace"`
+	// every DMARC record found, when there is more than one
+	Records []string `json:"records,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Policy is a parsed DMARC record (RFC 7489). Tags that the record leaves
+// out are reported with their default values.
+type Policy struct {
+	Valid bool `json:"valid" groups:"short,normal,long,trace"`
+	// why the record is invalid
+	Errors []string `json:"errors,omitempty" groups:"short,normal,long,trace"`
+	// none, quarantine, or reject
+	P     string   `json:"p,omitempty" groups:"short,normal,long,trace"`
+	SP    string   `json:"sp,omitempty" groups:"short,normal,long,trace"`
+	PCT   int      `json:"pct" groups:"short,normal,long,trace"`
+	RUA   []string `json:"rua,omitempty" groups:"short,normal,long,trace"`
+	RUF   []string `json:"ruf,omitempty" groups:"short,normal,long,trace"`
+	ADKIM string   `json:"adkim" groups:"short,normal,long,trace"`
+	ASPF  string   `json:"aspf" groups:"short,normal,long,trace"`
+	FO    []string `json:"fo" groups:"short,normal,long,trace"`
+}
+
+func validPolicy(p string) bool {
+	return p == "none" || p == "quarantine" || p == "reject"
+}
+
+// parseURIs splits a rua or ruf list. Each entry is a URI, usually mailto:,
+// optionally followed by !size.
+func parseURIs(value string) ([]string, bool) {
+	var uris []string
+	for _, uri := range strings.Split(value, ",") {
+		uri = strings.TrimSpace(uri)
+		if !strings.Contains(uri, ":") {
+			return nil, false
+		}
+		uris = append(uris, uri)
+	}
+	return uris, true
+}
+
+// ParsePolicy parses a DMARC record. An invalid record is still parsed as far
+// as possible, with the problems listed in Errors.
+func ParsePolicy(record string) *Policy {
+	policy := &Policy{PCT: 100, ADKIM: "r", ASPF: "r", FO: []string{"0"}}
+	invalid := func(format string, args ...interface{}) {
+		policy.Errors = append(policy.Errors, fmt.Sprintf(format, args...))
+	}
+	// a record split into several strings is read as their concatenation
+	record = strings.Replace(record, "\n", "", -1)
+	seen := make(map[string]bool)
+	first := true
+	for _, tag := range strings.Split(record, ";") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			invalid("malformed tag %q", tag)
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		value := strings.TrimSpace(kv[1])
+		if first {
+			first = false
+			if name == "v" {
+				if value != "DMARC1" {
+					invalid("invalid v=%s", value)
+				}
+				seen[name] = true
+				continue
+			}
+			invalid("record does not start with v=DMARC1")
+		}
+		if seen[name] {
+			invalid("duplicate tag %s", name)
+			continue
+		}
+		seen[name] = true
+		switch name {
+		case "v":
+			invalid("v is not the first tag")
+		case "p", "sp":
+			value = strings.ToLower(value)
+			if !validPolicy(value) {
+				invalid("invalid %s=%s", name, value)
+			}
+			if name == "p" {
+				policy.P = value
+			} else {
+				policy.SP = value
+			}
+		case "pct":
+			pct, err := strconv.Atoi(value)
+			if err != nil || pct < 0 || pct > 100 {
+				invalid("invalid pct=%s", value)
+				continue
+			}
+			policy.PCT = pct
+		case "rua", "ruf":
+			uris, ok := parseURIs(value)
+			if !ok {
+				invalid("invalid %s=%s", name, value)
+				continue
+			}
+			if name == "rua" {
+				policy.RUA = uris
+			} else {
+				policy.RUF = uris
+			}
+		case "adkim", "aspf":
+			value = strings.ToLower(value)
+			if value != "r" && value != "s" {
+				invalid("invalid %s=%s", name, value)
+				continue
+			}
+			if name == "adkim" {
+				policy.ADKIM = value
+			} else {
+				policy.ASPF = value
+			}
+		case "fo":
+			options := strings.Split(value, ":")
+			for i, o := range options {
+				options[i] = strings.ToLower(strings.TrimSpace(o))
+				if options[i] != "0" && options[i] != "1" && options[i] != "d" && options[i] != "s" {
+					invalid("invalid fo=%s", value)
+					options = nil
+					break
+				}
+			}
+			if options != nil {
+				policy.FO = options
+			}
+		}
+		// other tags (e.g., rf and ri) are left out and unknown ones are
+		// ignored, as RFC 7489 requires
+	}
+	if !seen["p"] {
+		invalid("missing p tag")
+	}
+	if policy.SP == "" && validPolicy(policy.P) {
+		policy.SP = policy.P
+	}
+	policy.Valid = len(policy.Errors) == 0
+	return policy
 }
 
 // Per Connection Lookup ======================================================
@@ -34,11 +185,16 @@ type Lookup struct {
 
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
 	var res Result
-	innerRes, trace, status, err := s.DoTxtLookup(name)
+	records, trace, status, err := s.DoTxtLookupAll(name)
 	if status != zdns.STATUS_NOERROR {
-		return res, nil, status, err
+		return res, trace, status, err
+	}
+	if len(records) > 1 {
+		res.Records = records
+		return res, trace, zdns.STATUS_MULTI_RECORD, nil
 	}
-	res.Dmarc = innerRes
+	res.Dmarc = records[0]
+	res.Policy = ParsePolicy(records[0])
 	return res, trace, zdns.STATUS_NOERROR, nil
 }
 
@@ -72,6 +228,10 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // Global Registration ========================================================
 //
 func init() {
@@ -0,0 +1,71 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dmarc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePolicy(t *testing.T) {
+	policy := ParsePolicy("v=DMARC1; p=quarantine; sp=reject; pct=50; rua=mailto:agg@example.com,mailto:dmarc@example.net!10m; ruf=mailto:forensic@example.com; adkim=s; aspf=r; fo=1:d")
+	expected := &Policy{
+		Valid: true,
+		P:     "quarantine",
+		SP:    "reject",
+		PCT:   50,
+		RUA:   []string{"mailto:agg@example.com", "mailto:dmarc@example.net!10m"},
+		RUF:   []string{"mailto:forensic@example.com"},
+		ADKIM: "s",
+		ASPF:  "r",
+		FO:    []string{"1", "d"},
+	}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, policy)
+	}
+}
+
+func TestParsePolicyDefaults(t *testing.T) {
+	// split into two strings, with a trailing separator
+	policy := ParsePolicy("v=DMARC1; p=no\nne;")
+	expected := &Policy{Valid: true, P: "none", SP: "none", PCT: 100, ADKIM: "r", ASPF: "r", FO: []string{"0"}}
+	if !reflect.DeepEqual(policy, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, policy)
+	}
+}
+
+func TestParsePolicyInvalid(t *testing.T) {
+	tests := map[string]string{
+		"v=DMARC1; p=reject; pct=150":     "invalid pct=150",
+		"v=DMARC1; p=block":               "invalid p=block",
+		"v=DMARC1; sp=reject":             "missing p tag",
+		"p=reject; v=DMARC1":              "record does not start with v=DMARC1",
+		"v=DMARC1; p=reject; p=none":      "duplicate tag p",
+		"v=DMARC1; p=reject; rua=agg":     "invalid rua=agg",
+		"v=DMARC1; p=reject; adkim=x":     "invalid adkim=x",
+		"v=DMARC1; p=reject; fo=2":        "invalid fo=2",
+		"v=DMARC1; p=reject; reject":      `malformed tag "reject"`,
+		"v=DMARC2; p=reject; foo=ignored": "invalid v=DMARC2",
+	}
+	for record, reason := range tests {
+		policy := ParsePolicy(record)
+		if policy.Valid || len(policy.Errors) == 0 || policy.Errors[0] != reason {
+			t.Errorf("Expected %q to be invalid because of %q, got %+v", record, reason, policy)
+		}
+	}
+	if policy := ParsePolicy("v=DMARC1; p=reject; ri=3600; rf=afrf; future=1"); !policy.Valid {
+		t.Errorf("Expected other and unknown tags to be ignored, got %+v", policy.Errors)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dnskeylookup
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+type DNSKEYRecord struct {
+	Flags         uint16 `json:"flags" groups:"short,normal,long,trace"`
+	ZoneKey       bool   `json:"zone_key" groups:"short,normal,long,trace"`
+	SecureEntry   bool   `json:"secure_entry_point" groups:"short,normal,long,trace"`
+	Protocol      uint8  `json:"protocol" groups:"short,normal,long,trace"`
+	Algorithm     uint8  `json:"algorithm" groups:"short,normal,long,trace"`
+	AlgorithmName string `json:"algorithm_name,omitempty" groups:"short,normal,long,trace"`
+	// computed from the key (RFC 4034, appendix B) to match DS records and
+	// signatures against it
+	KeyTag uint16 `json:"key_tag" groups:"short,normal,long,trace"`
+	// base64-encoded public key
+	PublicKey string `json:"public_key" groups:"short,normal,long,trace"`
+	// digest of the DS record the parent zone would publish for this key
+	DSDigestSHA256 string `json:"ds_digest_sha256,omitempty" groups:"normal,long,trace"`
+	TTL            uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type DSRecord struct {
+	KeyTag         uint16 `json:"key_tag" groups:"short,normal,long,trace"`
+	Algorithm      uint8  `json:"algorithm" groups:"short,normal,long,trace"`
+	AlgorithmName  string `json:"algorithm_name,omitempty" groups:"short,normal,long,trace"`
+	DigestType     uint8  `json:"digest_type" groups:"short,normal,long,trace"`
+	DigestTypeName string `json:"digest_type_name,omitempty" groups:"short,normal,long,trace"`
+	Digest         string `json:"digest" groups:"short,normal,long,trace"`
+	TTL            uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type RRSIGRecord struct {
+	TypeCovered   string `json:"type_covered" groups:"short,normal,long,trace"`
+	Algorithm     uint8  `json:"algorithm" groups:"short,normal,long,trace"`
+	AlgorithmName string `json:"algorithm_name,omitempty" groups:"short,normal,long,trace"`
+	Labels        uint8  `json:"labels" groups:"short,normal,long,trace"`
+	OriginalTTL   uint32 `json:"original_ttl" groups:"short,normal,long,trace"`
+	Inception     string `json:"inception" groups:"short,normal,long,trace"`
+	Expiration    string `json:"expiration" groups:"short,normal,long,trace"`
+	KeyTag        uint16 `json:"key_tag" groups:"short,normal,long,trace"`
+	SignerName    string `json:"signer_name" groups:"short,normal,long,trace"`
+	// base64-encoded signature
+	Signature string `json:"signature" groups:"short,normal,long,trace"`
+	TTL       uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	Keys []DNSKEYRecord `json:"keys,omitempty" groups:"short,normal,long,trace"`
+	DS   []DSRecord     `json:"ds,omitempty" groups:"short,normal,long,trace"`
+	// signatures over the fetched records, or every signature at the name
+	// with --dnssec-type RRSIG
+	Signatures []RRSIGRecord `json:"signatures,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+func makeKey(ans miekg.DNSKEYAnswer) DNSKEYRecord {
+	key := &dns.DNSKEY{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(ans.Name),
+			Rrtype: dns.TypeDNSKEY,
+			Class:  dns.ClassINET,
+			Ttl:    ans.Ttl,
+		},
+		Flags:     ans.Flags,
+		Protocol:  ans.Protocol,
+		Algorithm: ans.Algorithm,
+		PublicKey: ans.PublicKey,
+	}
+	record := DNSKEYRecord{
+		Flags:         ans.Flags,
+		ZoneKey:       ans.Flags&dns.ZONE != 0,
+		SecureEntry:   ans.Flags&dns.SEP != 0,
+		Protocol:      ans.Protocol,
+		Algorithm:     ans.Algorithm,
+		AlgorithmName: dns.AlgorithmToString[ans.Algorithm],
+		KeyTag:        key.KeyTag(),
+		PublicKey:     ans.PublicKey,
+		TTL:           ans.Ttl,
+	}
+	if ds := key.ToDS(dns.SHA256); ds != nil {
+		record.DSDigestSHA256 = strings.ToLower(ds.Digest)
+	}
+	return record
+}
+
+func makeDS(ans miekg.DSAnswer) DSRecord {
+	return DSRecord{
+		KeyTag:         ans.KeyTag,
+		Algorithm:      ans.Algorithm,
+		AlgorithmName:  dns.AlgorithmToString[ans.Algorithm],
+		DigestType:     ans.DigestType,
+		DigestTypeName: dns.HashToString[ans.DigestType],
+		Digest:         strings.ToLower(ans.Digest),
+		TTL:            ans.Ttl,
+	}
+}
+
+func signatureTime(t uint32) string {
+	return time.Unix(int64(t), 0).UTC().Format(time.RFC3339)
+}
+
+func makeSignature(ans miekg.RRSIGAnswer) RRSIGRecord {
+	return RRSIGRecord{
+		TypeCovered:   dns.Type(ans.TypeCovered).String(),
+		Algorithm:     ans.Algorithm,
+		AlgorithmName: dns.AlgorithmToString[ans.Algorithm],
+		Labels:        ans.Labels,
+		OriginalTTL:   ans.OriginalTtl,
+		Inception:     signatureTime(ans.Inception),
+		Expiration:    signatureTime(ans.Expiration),
+		KeyTag:        ans.KeyTag,
+		SignerName:    strings.TrimSuffix(ans.SignerName, "."),
+		Signature:     ans.Signature,
+		TTL:           ans.Ttl,
+	}
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	var retv Result
+	dnsType := s.Factory.Factory.DNSSECType
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dnsType)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		switch ans := a.(type) {
+		case miekg.DNSKEYAnswer:
+			retv.Keys = append(retv.Keys, makeKey(ans))
+		case miekg.DSAnswer:
+			// CDS records are parsed into DSAnswers as well
+			if ans.Type == "DS" {
+				retv.DS = append(retv.DS, makeDS(ans))
+			}
+		case miekg.RRSIGAnswer:
+			retv.Signatures = append(retv.Signatures, makeSignature(ans))
+		}
+	}
+	if len(retv.Keys) == 0 && len(retv.DS) == 0 && (dnsType != dns.TypeRRSIG || len(retv.Signatures) == 0) {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, s.Factory.DNSSECType, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	TypeName   string
+	DNSSECType uint16
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.StringVar(&s.TypeName, "dnssec-type", "DNSKEY", "DNSSEC record type to fetch. Options: DNSKEY, DS, RRSIG")
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	switch strings.ToUpper(s.TypeName) {
+	case "DNSKEY":
+		s.DNSSECType = dns.TypeDNSKEY
+	case "DS":
+		s.DNSSECType = dns.TypeDS
+	case "RRSIG":
+		s.DNSSECType = dns.TypeRRSIG
+	default:
+		return errors.New("--dnssec-type must be one of DNSKEY, DS, or RRSIG")
+	}
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	// ask for the signatures along with the records
+	r.QueryOptions.DNSSEC = true
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("DNSKEYLOOKUP", s)
+}
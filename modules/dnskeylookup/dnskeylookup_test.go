@@ -0,0 +1,65 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package dnskeylookup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func TestMakeKey(t *testing.T) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	if _, err := key.Generate(256); err != nil {
+		t.Fatal(err)
+	}
+	ans, ok := miekg.ParseAnswer(key).(miekg.DNSKEYAnswer)
+	if !ok {
+		t.Fatal("Expected DNSKEY to be parsed into a DNSKEYAnswer")
+	}
+	r := makeKey(ans)
+	if r.KeyTag != key.KeyTag() {
+		t.Errorf("Expected key tag %d, got %d", key.KeyTag(), r.KeyTag)
+	}
+	if !r.ZoneKey || !r.SecureEntry || r.AlgorithmName != "ECDSAP256SHA256" {
+		t.Errorf("Unexpected key properties: %+v", r)
+	}
+	if ds := key.ToDS(dns.SHA256); r.DSDigestSHA256 != strings.ToLower(ds.Digest) {
+		t.Errorf("Expected DS digest %s, got %s", ds.Digest, r.DSDigestSHA256)
+	}
+}
+
+func TestMakeSignature(t *testing.T) {
+	r := makeSignature(miekg.RRSIGAnswer{
+		TypeCovered: dns.TypeDNSKEY,
+		Algorithm:   dns.RSASHA256,
+		Inception:   1577836800,
+		Expiration:  1580515200,
+		SignerName:  "example.",
+	})
+	if r.TypeCovered != "DNSKEY" || r.AlgorithmName != "RSASHA256" || r.SignerName != "example" {
+		t.Errorf("Unexpected signature fields: %+v", r)
+	}
+	if r.Inception != "2020-01-01T00:00:00Z" || r.Expiration != "2020-02-01T00:00:00Z" {
+		t.Errorf("Unexpected validity period: %s - %s", r.Inception, r.Expiration)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package hinfolookup
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"reflect"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// the CPU of the HINFO record that a server synthesizes in place of the
+// records of an ANY query (RFC 8482, section 4.2)
+const rfc8482CPU = "RFC8482"
+
+// result to be returned by scan of host
+
+type HINFORecord struct {
+	// the two character-strings of the RDATA as text
+	CPU string `json:"cpu" groups:"short,normal,long,trace"`
+	OS  string `json:"os" groups:"short,normal,long,trace"`
+	// the same character-strings as sent, hex-encoded without their length
+	// octets
+	CPURaw string `json:"cpu_raw" groups:"normal,long,trace"`
+	OSRaw  string `json:"os_raw" groups:"normal,long,trace"`
+	// the record was synthesized by a server that minimizes ANY responses
+	RFC8482 bool   `json:"rfc8482" groups:"short,normal,long,trace"`
+	TTL     uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	Records []HINFORecord `json:"records" groups:"short,normal,long,trace"`
+	// the answer is a lone synthesized HINFO record, which says nothing
+	// about the host
+	RFC8482Response bool `json:"rfc8482_response,omitempty" groups:"short,normal,long,trace"`
+}
+
+// characterString splits the <character-string> (RFC 1035, section 3.3) at
+// the start of rdata, a length octet followed by that many octets, from the
+// rest.
+func characterString(rdata []byte) ([]byte, []byte, error) {
+	if len(rdata) == 0 {
+		return nil, nil, errors.New("missing character-string")
+	}
+	n := int(rdata[0])
+	if 1+n > len(rdata) {
+		return nil, nil, errors.New("character-string longer than the RDATA")
+	}
+	return rdata[1 : 1+n], rdata[1+n:], nil
+}
+
+// parseHINFO reads the CPU and OS character-strings of HINFO RDATA, which
+// must hold nothing else.
+func parseHINFO(rdata []byte) (HINFORecord, error) {
+	cpu, rest, err := characterString(rdata)
+	if err != nil {
+		return HINFORecord{}, err
+	}
+	opsys, rest, err := characterString(rest)
+	if err != nil {
+		return HINFORecord{}, err
+	}
+	if len(rest) > 0 {
+		return HINFORecord{}, errors.New("trailing data after the OS character-string")
+	}
+	return HINFORecord{
+		CPU:     string(cpu),
+		OS:      string(opsys),
+		CPURaw:  hex.EncodeToString(cpu),
+		OSRaw:   hex.EncodeToString(opsys),
+		RFC8482: string(cpu) == rfc8482CPU,
+	}, nil
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []HINFORecord{}}
+	qtype := dns.TypeHINFO
+	if s.Factory.Factory.AnyQuery {
+		qtype = dns.TypeANY
+	}
+	res, trace, status, err := s.DoTypedMiekgLookup(name, qtype)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	var parseErr error
+	for _, a := range r.Answers {
+		// miekg.ParseAnswer has no case for HINFO, so the records come as
+		// an UnknownAnswer with their RDATA in wire format
+		ans, ok := a.(miekg.UnknownAnswer)
+		if !ok || ans.TypeNumber != dns.TypeHINFO {
+			continue
+		}
+		rdata, err := hex.DecodeString(ans.RData)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		record, err := parseHINFO(rdata)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		record.TTL = ans.Ttl
+		retv.Records = append(retv.Records, record)
+	}
+	if len(retv.Records) == 0 {
+		if parseErr != nil {
+			return retv, trace, zdns.STATUS_ERROR, parseErr
+		}
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	retv.RFC8482Response = len(r.Answers) == 1 && retv.Records[0].RFC8482
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeHINFO, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	AnyQuery bool
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.BoolVar(&s.AnyQuery, "any-query", false, "send ANY queries and report the HINFO records of the answers, flagging those synthesized by servers that minimize ANY responses (RFC 8482)")
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("HINFOLOOKUP", s)
+}
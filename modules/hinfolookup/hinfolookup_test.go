@@ -0,0 +1,49 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package hinfolookup
+
+import (
+	"testing"
+)
+
+func TestParseHINFO(t *testing.T) {
+	r, err := parseHINFO([]byte("\x09INTEL-386\x04UNIX"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.CPU != "INTEL-386" || r.OS != "UNIX" || r.RFC8482 {
+		t.Errorf("Unexpected record: %+v", r)
+	}
+	if r.CPURaw != "494e54454c2d333836" || r.OSRaw != "554e4958" {
+		t.Errorf("Unexpected raw character-strings: %s %s", r.CPURaw, r.OSRaw)
+	}
+
+	// quotes and spaces are part of the string, not the presentation format
+	r, err = parseHINFO([]byte("\x05a \"b\"\x00"))
+	if err != nil || r.CPU != "a \"b\"" || r.OS != "" {
+		t.Errorf("Unexpected record %+v: %v", r, err)
+	}
+
+	r, err = parseHINFO([]byte("\x07RFC8482\x00"))
+	if err != nil || !r.RFC8482 {
+		t.Errorf("Expected a synthesized record, got %+v: %v", r, err)
+	}
+
+	for _, bad := range []string{"", "\x04UNIX", "\x09INTEL", "\x03x86\x06LINUX", "\x03x86\x04UNIXextra"} {
+		if _, err := parseHINFO([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+)
+
+// The iterative cache as saved to --cache-file between runs. Entries are
+// listed from least to most recently used, so that loading them in order
+// restores the eviction order, and expire at absolute times, so that time
+// spent between runs counts against their TTLs.
+type cacheFile struct {
+	Entries []cacheFileEntry `json:"entries"`
+}
+
+type cacheFileEntry struct {
+	Name string `json:"name"`
+	Type uint16 `json:"type"`
+	// set for positive entries
+	Answers []cacheFileAnswer `json:"answers,omitempty"`
+	// set for negative entries
+	Status    zdns.Status `json:"status,omitempty"`
+	ExpiresAt *time.Time  `json:"expires_at,omitempty"`
+}
+
+type cacheFileAnswer struct {
+	Answer    Answer    `json:"answer"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// cacheEntries converts the cache into its on-disk form, leaving out what has
+// already expired.
+func (s *GlobalLookupFactory) cacheEntries(now time.Time) []cacheFileEntry {
+	entries := []cacheFileEntry{}
+	s.CacheMutex.Lock()
+	defer s.CacheMutex.Unlock()
+	s.IterativeCache.Range(func(k interface{}, v interface{}) {
+		switch key := k.(type) {
+		case cacheKey:
+			entry := cacheFileEntry{Name: key.Name, Type: key.DnsType}
+			for _, ta := range v.(CachedResult).Answers {
+				a, ok := ta.Answer.(Answer)
+				if ok && ta.ExpiresAt.After(now) {
+					entry.Answers = append(entry.Answers, cacheFileAnswer{Answer: a, ExpiresAt: ta.ExpiresAt})
+				}
+			}
+			if len(entry.Answers) > 0 {
+				entries = append(entries, entry)
+			}
+		case negativeCacheKey:
+			nr := v.(NegativeCachedResult)
+			if nr.ExpiresAt.After(now) {
+				expiresAt := nr.ExpiresAt
+				entries = append(entries, cacheFileEntry{Name: key.Name, Type: key.DnsType, Status: nr.Status, ExpiresAt: &expiresAt})
+			}
+		}
+	})
+	return entries
+}
+
+// loadCacheEntries adds the unexpired entries of a cache file to the cache.
+func (s *GlobalLookupFactory) loadCacheEntries(entries []cacheFileEntry, now time.Time) {
+	s.CacheMutex.Lock()
+	defer s.CacheMutex.Unlock()
+	for _, entry := range entries {
+		if entry.ExpiresAt != nil {
+			if entry.ExpiresAt.After(now) {
+				s.IterativeCache.Add(makeNegativeCacheKey(entry.Name, entry.Type), NegativeCachedResult{
+					Status:    entry.Status,
+					ExpiresAt: *entry.ExpiresAt,
+				})
+			}
+			continue
+		}
+		ca := CachedResult{Answers: make(map[interface{}]TimedAnswer)}
+		for _, fa := range entry.Answers {
+			if !fa.ExpiresAt.After(now) {
+				continue
+			}
+			// the record's type and class aren't part of its JSON form, but
+			// are needed for it to match the same record seen on the wire
+			a := fa.Answer
+			a.rrType = dns.StringToType[a.Type]
+			a.rrClass = dns.StringToClass[a.Class]
+			ca.Answers[a] = TimedAnswer{Answer: a, ExpiresAt: fa.ExpiresAt}
+		}
+		if len(ca.Answers) > 0 {
+			s.IterativeCache.Add(makeCacheKey(entry.Name, entry.Type), ca)
+		}
+	}
+}
+
+// loadCache restores the cache saved by a previous run. A missing file is
+// fine, e.g., on the first run.
+func (s *GlobalLookupFactory) loadCache(path string) error {
+	raw, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var cf cacheFile
+	if err := json.Unmarshal(raw, &cf); err != nil {
+		return err
+	}
+	s.loadCacheEntries(cf.Entries, time.Now())
+	return nil
+}
+
+// saveCache atomically replaces the cache file with the current cache.
+func (s *GlobalLookupFactory) saveCache(path string) error {
+	raw, err := json.Marshal(cacheFile{Entries: s.cacheEntries(time.Now())})
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,815 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+)
+
+// DNSSEC validation states (RFC 4033, section 5)
+const (
+	DNSSECSecure        = "secure"
+	DNSSECInsecure      = "insecure"
+	DNSSECBogus         = "bogus"
+	DNSSECIndeterminate = "indeterminate"
+)
+
+var dnssecStateRank = map[string]int{
+	DNSSECSecure:        0,
+	DNSSECInsecure:      1,
+	DNSSECIndeterminate: 2,
+	DNSSECBogus:         3,
+}
+
+// The DS records of the root zone KSKs (KSK-2017 and KSK-2024), as published
+// by IANA at https://data.iana.org/root-anchors/root-anchors.xml
+const rootTrustAnchors = `
+. IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D
+. IN DS 38696 8 2 683D2D0ACB8C9B712A1948B27F741219298D0A450D612C483AF444A4C0FB2B16
+`
+
+// maximum number of CNAMEs followed while validating an answer
+const maxValidationCNAMEs = 8
+
+// upper bound on how long an authenticated zone is trusted without refetching
+// its keys, regardless of the TTLs involved
+const maxTrustTTL = 3600
+
+var supportedAlgorithms = map[uint8]bool{
+	dns.RSASHA1:          true,
+	dns.RSASHA1NSEC3SHA1: true,
+	dns.RSASHA256:        true,
+	dns.RSASHA512:        true,
+	dns.ECDSAP256SHA256:  true,
+	dns.ECDSAP384SHA384:  true,
+	dns.ED25519:          true,
+}
+
+var supportedDigests = map[uint8]bool{
+	dns.SHA1:   true,
+	dns.SHA256: true,
+	dns.SHA384: true,
+}
+
+type DNSSECRRset struct {
+	Name   string `json:"name" groups:"short,normal,long,trace"`
+	Type   string `json:"type" groups:"short,normal,long,trace"`
+	Status string `json:"status" groups:"short,normal,long,trace"`
+	Reason string `json:"reason,omitempty" groups:"short,normal,long,trace"`
+}
+
+type DNSSECResult struct {
+	// the least trustworthy state of any of the RRsets below
+	Status string        `json:"status" groups:"short,normal,long,trace"`
+	Reason string        `json:"reason,omitempty" groups:"short,normal,long,trace"`
+	RRsets []DNSSECRRset `json:"rrsets" groups:"short,normal,long,trace"`
+}
+
+// ErrorDetail reports answers that failed DNSSEC validation, which otherwise
+// keep the status of the lookup itself.
+func (r Result) ErrorDetail() string {
+	if r.DNSSEC == nil {
+		return ""
+	}
+	switch r.DNSSEC.Status {
+	case DNSSECBogus:
+		return zdns.ERROR_DETAIL_DNSSEC_BOGUS
+	case DNSSECIndeterminate:
+		return zdns.ERROR_DETAIL_DNSSEC_INDETERMINATE
+	}
+	return ""
+}
+
+func (r *DNSSECResult) record(name string, rrType uint16, state string, reason string) {
+	r.RRsets = append(r.RRsets, DNSSECRRset{
+		Name:   strings.TrimSuffix(name, "."),
+		Type:   dns.TypeToString[rrType],
+		Status: state,
+		Reason: reason,
+	})
+	if dnssecStateRank[state] > dnssecStateRank[r.Status] {
+		r.Status = state
+		r.Reason = reason
+	}
+}
+
+// zoneTrust is what we have learned about a zone from walking the chain of
+// trust down to it. Only secure and insecure zones are remembered.
+type zoneTrust struct {
+	Secure    bool
+	Keys      []*dns.DNSKEY
+	Server    string
+	ExpiresAt time.Time
+}
+
+// LoadTrustAnchors reads DS (or DNSKEY) records for the root zone in zone
+// file format. An empty path returns the built-in IANA root anchors.
+func LoadTrustAnchors(path string) ([]*dns.DS, error) {
+	var r io.Reader = strings.NewReader(rootTrustAnchors)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var anchors []*dns.DS
+	for t := range dns.ParseZone(r, ".", path) {
+		if t.Error != nil {
+			return nil, t.Error
+		}
+		if t.RR.Header().Name != "." {
+			continue
+		}
+		switch rr := t.RR.(type) {
+		case *dns.DS:
+			anchors = append(anchors, rr)
+		case *dns.DNSKEY:
+			if rr.Flags&dns.SEP != 0 {
+				anchors = append(anchors, rr.ToDS(dns.SHA256))
+			}
+		}
+	}
+	if len(anchors) == 0 {
+		return nil, errors.New("no root zone DS or DNSKEY records found in trust anchor file")
+	}
+	return anchors, nil
+}
+
+func (s *GlobalLookupFactory) trustedZone(name string) (string, zoneTrust, bool) {
+	s.TrustMutex.Lock()
+	defer s.TrustMutex.Unlock()
+	now := time.Now()
+	labels := dns.SplitDomainName(name)
+	for i := 0; i <= len(labels); i++ {
+		zone := "."
+		if i < len(labels) {
+			zone = dns.Fqdn(strings.Join(labels[i:], "."))
+		}
+		v, ok := s.TrustCache.Get(zone)
+		if !ok {
+			continue
+		}
+		zt := v.(zoneTrust)
+		if zt.ExpiresAt.Before(now) {
+			s.TrustCache.Delete(zone)
+			continue
+		}
+		return zone, zt, true
+	}
+	return "", zoneTrust{}, false
+}
+
+func (s *GlobalLookupFactory) addTrustedZone(zone string, zt zoneTrust, ttl uint32) {
+	if ttl > maxTrustTTL {
+		ttl = maxTrustTTL
+	}
+	zt.ExpiresAt = time.Now().Add(time.Duration(ttl) * time.Second)
+	s.TrustMutex.Lock()
+	s.TrustCache.Add(zone, zt)
+	s.TrustMutex.Unlock()
+}
+
+// canonicalCompare orders two names as described in RFC 4034, section 6.1.
+func canonicalCompare(a string, b string) int {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(la)-1, len(lb)-1; i >= 0 && j >= 0; i, j = i-1, j-1 {
+		if c := strings.Compare(la[i], lb[j]); c != 0 {
+			return c
+		}
+	}
+	return len(la) - len(lb)
+}
+
+// nsecCovers reports whether name falls strictly between the owner and next
+// name of an NSEC record, i.e., whether the record proves name doesn't exist.
+func nsecCovers(nsec *dns.NSEC, name string) bool {
+	owner := nsec.Hdr.Name
+	if canonicalCompare(owner, name) >= 0 {
+		return false
+	}
+	// the last NSEC in a zone points back to the apex
+	if canonicalCompare(owner, nsec.NextDomain) >= 0 {
+		return true
+	}
+	return canonicalCompare(name, nsec.NextDomain) < 0
+}
+
+func hasType(bitmap []uint16, t uint16) bool {
+	for _, b := range bitmap {
+		if b == t {
+			return true
+		}
+	}
+	return false
+}
+
+// ancestor returns the last n labels of name.
+func ancestor(name string, n int) string {
+	labels := dns.SplitDomainName(name)
+	if n <= 0 {
+		return "."
+	}
+	return dns.Fqdn(strings.Join(labels[len(labels)-n:], "."))
+}
+
+type rrset struct {
+	name   string
+	rrType uint16
+	rrs    []dns.RR
+	sigs   []*dns.RRSIG
+}
+
+// groupRRsets splits a message section into RRsets, in order of appearance,
+// and attaches to each the RRSIGs that cover it.
+func groupRRsets(section []dns.RR) []*rrset {
+	var sets []*rrset
+	find := func(name string, t uint16) *rrset {
+		for _, set := range sets {
+			if set.rrType == t && set.name == name {
+				return set
+			}
+		}
+		set := &rrset{name: name, rrType: t}
+		sets = append(sets, set)
+		return set
+	}
+	for _, rr := range section {
+		name := strings.ToLower(dns.Fqdn(rr.Header().Name))
+		switch r := rr.(type) {
+		case *dns.RRSIG:
+			set := find(name, r.TypeCovered)
+			set.sigs = append(set.sigs, r)
+		case *dns.OPT:
+		default:
+			set := find(name, rr.Header().Rrtype)
+			set.rrs = append(set.rrs, rr)
+		}
+	}
+	// drop RRSIGs for which the section carried no records
+	var retv []*rrset
+	for _, set := range sets {
+		if len(set.rrs) > 0 {
+			retv = append(retv, set)
+		}
+	}
+	return retv
+}
+
+// verifyRRset checks that set carries a currently valid signature made by one
+// of the keys of zone.
+func verifyRRset(set *rrset, zone string, keys []*dns.DNSKEY) error {
+	if len(set.sigs) == 0 {
+		return errors.New("no RRSIG for " + set.name + " " + dns.TypeToString[set.rrType])
+	}
+	err := errors.New("no RRSIG by a key of " + zone)
+	now := time.Now()
+	for _, sig := range set.sigs {
+		if !strings.EqualFold(dns.Fqdn(sig.SignerName), zone) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if !sig.ValidityPeriod(now) {
+				err = errors.New("RRSIG outside of its validity period")
+				continue
+			}
+			if e := sig.Verify(key, set.rrs); e != nil {
+				err = e
+				continue
+			}
+			return nil
+		}
+	}
+	return err
+}
+
+// matchDS returns the keys in the DNSKEY RRset that are referenced by a DS.
+func matchDS(keys []dns.RR, ds []*dns.DS) []*dns.DNSKEY {
+	var retv []*dns.DNSKEY
+	for _, rr := range keys {
+		key, ok := rr.(*dns.DNSKEY)
+		if !ok || key.Flags&dns.ZONE == 0 {
+			continue
+		}
+		for _, d := range ds {
+			if key.KeyTag() != d.KeyTag || key.Algorithm != d.Algorithm {
+				continue
+			}
+			if digest := key.ToDS(d.DigestType); digest != nil && strings.EqualFold(digest.Digest, d.Digest) {
+				retv = append(retv, key)
+				break
+			}
+		}
+	}
+	return retv
+}
+
+// denialRecords authenticates the NSEC and NSEC3 RRsets of a section.
+func denialRecords(section []dns.RR, zone string, keys []*dns.DNSKEY) ([]*dns.NSEC, []*dns.NSEC3, error) {
+	var nsecs []*dns.NSEC
+	var nsec3s []*dns.NSEC3
+	for _, set := range groupRRsets(section) {
+		if set.rrType != dns.TypeNSEC && set.rrType != dns.TypeNSEC3 {
+			continue
+		}
+		if err := verifyRRset(set, zone, keys); err != nil {
+			return nil, nil, err
+		}
+		for _, rr := range set.rrs {
+			switch r := rr.(type) {
+			case *dns.NSEC:
+				nsecs = append(nsecs, r)
+			case *dns.NSEC3:
+				nsec3s = append(nsec3s, r)
+			}
+		}
+	}
+	return nsecs, nsec3s, nil
+}
+
+func nsec3Matching(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, n := range nsec3s {
+		if n.Match(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+func nsec3Covering(nsec3s []*dns.NSEC3, name string) *dns.NSEC3 {
+	for _, n := range nsec3s {
+		if n.Cover(name) {
+			return n
+		}
+	}
+	return nil
+}
+
+// nsec3ClosestEncloser performs the closest encloser proof of RFC 5155,
+// section 8.3, returning the closest encloser and the NSEC3 that covers the
+// next closer name.
+func nsec3ClosestEncloser(nsec3s []*dns.NSEC3, name string) (string, *dns.NSEC3, bool) {
+	n := dns.CountLabel(name)
+	for i := n - 1; i >= 0; i-- {
+		ce := ancestor(name, i)
+		if nsec3Matching(nsec3s, ce) == nil {
+			continue
+		}
+		cover := nsec3Covering(nsec3s, ancestor(name, i+1))
+		return ce, cover, cover != nil
+	}
+	return "", nil, false
+}
+
+func nsecClosestEncloser(nsec *dns.NSEC, name string) string {
+	n := dns.CompareDomainName(nsec.Hdr.Name, name)
+	if m := dns.CompareDomainName(nsec.NextDomain, name); m > n {
+		n = m
+	}
+	return ancestor(name, n)
+}
+
+// proveNXDOMAIN checks that the authority section proves name doesn't exist
+// and wasn't synthesized from a wildcard either.
+func proveNXDOMAIN(section []dns.RR, name string, zone string, keys []*dns.DNSKEY) error {
+	nsecs, nsec3s, err := denialRecords(section, zone, keys)
+	if err != nil {
+		return err
+	}
+	if len(nsecs) > 0 {
+		for _, nsec := range nsecs {
+			if !nsecCovers(nsec, name) {
+				continue
+			}
+			wildcard := "*." + nsecClosestEncloser(nsec, name)
+			for _, w := range nsecs {
+				if nsecCovers(w, wildcard) {
+					return nil
+				}
+			}
+			return errors.New("no NSEC proves the absence of " + wildcard)
+		}
+		return errors.New("no NSEC covers " + name)
+	}
+	if len(nsec3s) > 0 {
+		ce, _, ok := nsec3ClosestEncloser(nsec3s, name)
+		if !ok {
+			return errors.New("no NSEC3 closest encloser proof for " + name)
+		}
+		if nsec3Covering(nsec3s, "*."+ce) == nil {
+			return errors.New("no NSEC3 proves the absence of *." + ce)
+		}
+		return nil
+	}
+	return errors.New("NXDOMAIN without NSEC or NSEC3 records")
+}
+
+// proveNODATA checks that the authority section proves name exists but has no
+// records of type t. An opt-out NSEC3 span for a DS query proves an insecure
+// delegation, which is reported by returning insecure.
+func proveNODATA(section []dns.RR, name string, t uint16, zone string, keys []*dns.DNSKEY) (bool, error) {
+	nsecs, nsec3s, err := denialRecords(section, zone, keys)
+	if err != nil {
+		return false, err
+	}
+	if len(nsecs) > 0 {
+		for _, nsec := range nsecs {
+			if strings.EqualFold(nsec.Hdr.Name, name) {
+				if hasType(nsec.TypeBitMap, t) || hasType(nsec.TypeBitMap, dns.TypeCNAME) {
+					return false, errors.New("NSEC for " + name + " lists the queried type")
+				}
+				return false, nil
+			}
+		}
+		// the name may instead be covered and a matching wildcard lack the type
+		for _, nsec := range nsecs {
+			if !nsecCovers(nsec, name) {
+				continue
+			}
+			wildcard := "*." + nsecClosestEncloser(nsec, name)
+			for _, w := range nsecs {
+				if strings.EqualFold(w.Hdr.Name, wildcard) && !hasType(w.TypeBitMap, t) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+					return false, nil
+				}
+			}
+		}
+		return false, errors.New("no NSEC proves " + name + " has no " + dns.TypeToString[t] + " records")
+	}
+	if len(nsec3s) > 0 {
+		if m := nsec3Matching(nsec3s, name); m != nil {
+			if hasType(m.TypeBitMap, t) || hasType(m.TypeBitMap, dns.TypeCNAME) {
+				return false, errors.New("NSEC3 for " + name + " lists the queried type")
+			}
+			return false, nil
+		}
+		ce, cover, ok := nsec3ClosestEncloser(nsec3s, name)
+		if !ok {
+			return false, errors.New("no NSEC3 closest encloser proof for " + name)
+		}
+		if t == dns.TypeDS && cover.Flags&1 == 1 {
+			return true, nil
+		}
+		if w := nsec3Matching(nsec3s, "*."+ce); w != nil && !hasType(w.TypeBitMap, t) && !hasType(w.TypeBitMap, dns.TypeCNAME) {
+			return false, nil
+		}
+		return false, errors.New("no NSEC3 proves " + name + " has no " + dns.TypeToString[t] + " records")
+	}
+	return false, errors.New("NODATA response without NSEC or NSEC3 records")
+}
+
+// proveNoDS checks that a referral without DS records proves the delegation
+// to child is insecure.
+func proveNoDS(section []dns.RR, child string, zone string, keys []*dns.DNSKEY) error {
+	nsecs, nsec3s, err := denialRecords(section, zone, keys)
+	if err != nil {
+		return err
+	}
+	for _, nsec := range nsecs {
+		if strings.EqualFold(nsec.Hdr.Name, child) {
+			if !hasType(nsec.TypeBitMap, dns.TypeNS) || hasType(nsec.TypeBitMap, dns.TypeDS) || hasType(nsec.TypeBitMap, dns.TypeSOA) {
+				return errors.New("NSEC for " + child + " does not prove an unsigned delegation")
+			}
+			return nil
+		}
+	}
+	if len(nsec3s) > 0 {
+		if m := nsec3Matching(nsec3s, child); m != nil {
+			if !hasType(m.TypeBitMap, dns.TypeNS) || hasType(m.TypeBitMap, dns.TypeDS) || hasType(m.TypeBitMap, dns.TypeSOA) {
+				return errors.New("NSEC3 for " + child + " does not prove an unsigned delegation")
+			}
+			return nil
+		}
+		if _, cover, ok := nsec3ClosestEncloser(nsec3s, child); ok && cover.Flags&1 == 1 {
+			return nil
+		}
+	}
+	return errors.New("referral to " + child + " without DS records or proof of their absence")
+}
+
+// proveWildcardAnswer checks that an answer synthesized from a wildcard
+// (signed with fewer labels than its owner has) was accompanied by proof that
+// no closer match exists.
+func proveWildcardAnswer(section []dns.RR, name string, labels int, zone string, keys []*dns.DNSKEY) error {
+	nsecs, nsec3s, err := denialRecords(section, zone, keys)
+	if err != nil {
+		return err
+	}
+	for _, nsec := range nsecs {
+		if nsecCovers(nsec, name) {
+			return nil
+		}
+	}
+	if nsec3Covering(nsec3s, ancestor(name, labels+1)) != nil {
+		return nil
+	}
+	return errors.New("wildcard answer for " + name + " without proof that no closer match exists")
+}
+
+// referral returns the child zone to which a non-authoritative response
+// delegates, if it is a referral toward name.
+func referral(msg *dns.Msg, zone string, name string) (string, bool) {
+	if msg.Rcode != dns.RcodeSuccess || len(msg.Answer) != 0 {
+		return "", false
+	}
+	for _, rr := range msg.Ns {
+		if rr.Header().Rrtype != dns.TypeNS {
+			continue
+		}
+		child := strings.ToLower(dns.Fqdn(rr.Header().Name))
+		if child != zone && dns.IsSubDomain(zone, child) && dns.IsSubDomain(child, name) {
+			return child, true
+		}
+	}
+	return "", false
+}
+
+func (s *Lookup) exchangeDNSSEC(name string, dnsType uint16, dnsClass uint16, nameServer string) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dnsType)
+	m.Id = queryID()
+	m.Question[0].Qclass = dnsClass
+	m.RecursionDesired = false
+	size := s.Factory.QueryOptions.UDPSize
+	if size == 0 {
+		size = dns.DefaultMsgSize
+	}
+	m.SetEdns0(size, true)
+
+	localAddr, err := pickLocalAddr(s.Factory.QueryOptions.LocalAddrs, nameServer)
+	if err != nil {
+		return nil, err
+	}
+	var r *dns.Msg
+	err = errors.New("no transport available")
+	ctx := context.Background()
+	for i := 0; i < s.Factory.maxAttempts(zdns.STATUS_TIMEOUT) || i == 0; i++ {
+		s.Factory.RateLimiter.Wait(nameServer)
+		if s.Factory.Client != nil {
+			r, _, err = exchange(ctx, s.Factory.Client, m, nameServer, localAddr, s.Factory.QueryOptions)
+			if err == nil && r.Truncated && s.Factory.TCPClient != nil {
+				r, _, err = exchange(ctx, s.Factory.TCPClient, m, nameServer, localAddr, s.Factory.QueryOptions)
+			}
+		} else {
+			r, _, err = exchange(ctx, s.Factory.TCPClient, m, nameServer, localAddr, s.Factory.QueryOptions)
+		}
+		if err == nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+// delegationServer picks an address for one of the name servers of child,
+// from the referral's glue if possible and by iterative lookup otherwise.
+func (s *Lookup) delegationServer(msg *dns.Msg, child string) (string, error) {
+	var names []string
+	for _, rr := range msg.Ns {
+		if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(dns.Fqdn(ns.Hdr.Name), child) {
+			names = append(names, strings.ToLower(dns.Fqdn(ns.Ns)))
+		}
+	}
+	for _, rr := range msg.Extra {
+		if a, ok := rr.(*dns.A); ok {
+			for _, name := range names {
+				if strings.EqualFold(dns.Fqdn(a.Hdr.Name), name) {
+					return a.A.String() + ":53", nil
+				}
+			}
+		}
+	}
+	for _, name := range names {
+		res, _, status, _ := s.iterativeLookup(dns.TypeA, dns.ClassINET, strings.TrimSuffix(name, "."), s.NameServer, 1, ".", make([]interface{}, 0))
+		if status != zdns.STATUS_NOERROR {
+			continue
+		}
+		for _, a := range res.Answers {
+			if ans, ok := a.(Answer); ok && ans.Type == "A" {
+				return ans.Answer + ":53", nil
+			}
+		}
+	}
+	return "", errors.New("unable to find an address for any name server of " + child)
+}
+
+// zoneKeys fetches the DNSKEY RRset of zone and authenticates it with the DS
+// records from its parent, returning the zone's keys if it is secure.
+func (s *Lookup) zoneKeys(zone string, dnsClass uint16, nameServer string, ds []*dns.DS) ([]*dns.DNSKEY, uint32, string, string) {
+	var usable []*dns.DS
+	for _, d := range ds {
+		if supportedAlgorithms[d.Algorithm] && supportedDigests[d.DigestType] {
+			usable = append(usable, d)
+		}
+	}
+	if len(usable) == 0 {
+		return nil, 0, DNSSECInsecure, "no DS record for " + zone + " uses a supported algorithm"
+	}
+	msg, err := s.exchangeDNSSEC(zone, dns.TypeDNSKEY, dnsClass, nameServer)
+	if err != nil {
+		return nil, 0, DNSSECIndeterminate, "unable to fetch DNSKEY for " + zone + ": " + err.Error()
+	}
+	for _, set := range groupRRsets(msg.Answer) {
+		if set.rrType != dns.TypeDNSKEY || set.name != zone {
+			continue
+		}
+		sep := matchDS(set.rrs, usable)
+		if len(sep) == 0 {
+			return nil, 0, DNSSECBogus, "no DNSKEY for " + zone + " matches its DS records"
+		}
+		if err := verifyRRset(set, zone, sep); err != nil {
+			return nil, 0, DNSSECBogus, "DNSKEY for " + zone + ": " + err.Error()
+		}
+		var keys []*dns.DNSKEY
+		for _, rr := range set.rrs {
+			if key, ok := rr.(*dns.DNSKEY); ok && key.Flags&dns.ZONE != 0 {
+				keys = append(keys, key)
+			}
+		}
+		return keys, set.rrs[0].Header().Ttl, DNSSECSecure, ""
+	}
+	return nil, 0, DNSSECBogus, "no DNSKEY records for signed zone " + zone
+}
+
+// validateName walks the chain of trust from the deepest zone we already
+// trust down to the zone that answers name and authenticates the answer. If
+// the answer is a CNAME to a name outside that zone, the target is returned so
+// that it can be validated in turn.
+func (s *Lookup) validateName(name string, dnsType uint16, dnsClass uint16, retv *DNSSECResult) string {
+	global := s.Factory.Factory
+	zone, trust, ok := global.trustedZone(name)
+	if ok && !trust.Secure {
+		retv.record(name, dnsType, DNSSECInsecure, "delegation to "+zone+" is unsigned")
+		return ""
+	}
+	server := trust.Server
+	keys := trust.Keys
+	if !ok {
+		zone = "."
+		server = s.NameServer
+		var ttl uint32
+		var state, reason string
+		keys, ttl, state, reason = s.zoneKeys(zone, dnsClass, server, global.TrustAnchors)
+		if state != DNSSECSecure {
+			retv.record(name, dnsType, state, reason)
+			return ""
+		}
+		global.addTrustedZone(zone, zoneTrust{Secure: true, Keys: keys, Server: server}, ttl)
+	}
+	for depth := 0; depth < s.Factory.MaxDepth; depth++ {
+		msg, err := s.exchangeDNSSEC(name, dnsType, dnsClass, server)
+		if err != nil {
+			retv.record(name, dnsType, DNSSECIndeterminate, err.Error())
+			return ""
+		}
+		child, isReferral := referral(msg, zone, name)
+		if !isReferral {
+			return s.validateResponse(msg, name, dnsType, zone, keys, retv)
+		}
+		var ds []*dns.DS
+		var nsTTL uint32 = maxTrustTTL
+		for _, set := range groupRRsets(msg.Ns) {
+			if set.name == child && set.rrType == dns.TypeNS {
+				nsTTL = set.rrs[0].Header().Ttl
+			}
+			if set.name != child || set.rrType != dns.TypeDS {
+				continue
+			}
+			if err := verifyRRset(set, zone, keys); err != nil {
+				retv.record(name, dnsType, DNSSECBogus, "DS for "+child+": "+err.Error())
+				return ""
+			}
+			for _, rr := range set.rrs {
+				ds = append(ds, rr.(*dns.DS))
+			}
+		}
+		if ds == nil {
+			if err := proveNoDS(msg.Ns, child, zone, keys); err != nil {
+				retv.record(name, dnsType, DNSSECBogus, err.Error())
+				return ""
+			}
+			global.addTrustedZone(child, zoneTrust{Secure: false}, nsTTL)
+			retv.record(name, dnsType, DNSSECInsecure, "delegation to "+child+" is unsigned")
+			return ""
+		}
+		next, err := s.delegationServer(msg, child)
+		if err != nil {
+			retv.record(name, dnsType, DNSSECIndeterminate, err.Error())
+			return ""
+		}
+		childKeys, ttl, state, reason := s.zoneKeys(child, dnsClass, next, ds)
+		if state != DNSSECSecure {
+			if state == DNSSECInsecure {
+				global.addTrustedZone(child, zoneTrust{Secure: false}, nsTTL)
+			}
+			retv.record(name, dnsType, state, reason)
+			return ""
+		}
+		global.addTrustedZone(child, zoneTrust{Secure: true, Keys: childKeys, Server: next}, ttl)
+		zone, server, keys = child, next, childKeys
+	}
+	retv.record(name, dnsType, DNSSECIndeterminate, "max recursion depth reached")
+	return ""
+}
+
+// validateResponse authenticates the final response from the servers of zone.
+func (s *Lookup) validateResponse(msg *dns.Msg, name string, dnsType uint16, zone string, keys []*dns.DNSKEY, retv *DNSSECResult) string {
+	switch msg.Rcode {
+	case dns.RcodeNameError:
+		if err := proveNXDOMAIN(msg.Ns, name, zone, keys); err != nil {
+			retv.record(name, dnsType, DNSSECBogus, err.Error())
+		} else {
+			retv.record(name, dnsType, DNSSECSecure, "nonexistence proven")
+		}
+		return ""
+	case dns.RcodeSuccess:
+	default:
+		retv.record(name, dnsType, DNSSECIndeterminate, "server returned "+dns.RcodeToString[msg.Rcode])
+		return ""
+	}
+	sets := groupRRsets(msg.Answer)
+	if len(sets) == 0 && !msg.Authoritative {
+		retv.record(name, dnsType, DNSSECIndeterminate, "non-authoritative response without answers")
+		return ""
+	}
+	if len(sets) == 0 {
+		optOut, err := proveNODATA(msg.Ns, name, dnsType, zone, keys)
+		if err != nil {
+			retv.record(name, dnsType, DNSSECBogus, err.Error())
+		} else if optOut {
+			retv.record(name, dnsType, DNSSECInsecure, "covered by an opt-out NSEC3")
+		} else {
+			retv.record(name, dnsType, DNSSECSecure, "absence of records proven")
+		}
+		return ""
+	}
+	// follow the CNAME chain as far as this zone takes it
+	current := name
+	found := false
+	for _, set := range sets {
+		// records from other zones are validated when their names are
+		if !dns.IsSubDomain(zone, set.name) {
+			continue
+		}
+		if err := verifyRRset(set, zone, keys); err != nil {
+			retv.record(set.name, set.rrType, DNSSECBogus, err.Error())
+			continue
+		}
+		labels := dns.CountLabel(set.name)
+		if len(set.sigs) > 0 && int(set.sigs[0].Labels) < labels {
+			if err := proveWildcardAnswer(msg.Ns, set.name, int(set.sigs[0].Labels), zone, keys); err != nil {
+				retv.record(set.name, set.rrType, DNSSECBogus, err.Error())
+				continue
+			}
+		}
+		retv.record(set.name, set.rrType, DNSSECSecure, "")
+		if set.name == current && set.rrType == dns.TypeCNAME && dnsType != dns.TypeCNAME {
+			current = strings.ToLower(dns.Fqdn(set.rrs[0].(*dns.CNAME).Target))
+		}
+		if set.rrType == dnsType || dnsType == dns.TypeANY {
+			found = true
+		}
+	}
+	if !found && current != name && !dns.IsSubDomain(zone, current) {
+		return current
+	}
+	return ""
+}
+
+// validateDNSSEC determines the DNSSEC validation state of the answer to a
+// query by walking the chain of trust down from the root trust anchors.
+func (s *Lookup) validateDNSSEC(name string, dnsType uint16, dnsClass uint16) *DNSSECResult {
+	retv := &DNSSECResult{Status: DNSSECSecure, RRsets: []DNSSECRRset{}}
+	s.IterativeStop = time.Now().Add(s.Factory.IterativeTimeout)
+	name = strings.ToLower(dns.Fqdn(name))
+	for i := 0; i <= maxValidationCNAMEs && name != ""; i++ {
+		name = s.validateName(name, dnsType, dnsClass, retv)
+	}
+	if name != "" {
+		retv.record(name, dnsType, DNSSECIndeterminate, "too many CNAMEs")
+	}
+	return retv
+}
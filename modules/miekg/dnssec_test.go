@@ -0,0 +1,157 @@
+package miekg
+
+import (
+	"crypto"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func mustRR(t *testing.T, s string) dns.RR {
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("Unable to parse %q: %v", s, err)
+	}
+	return rr
+}
+
+func signedZoneKey(t *testing.T, zone string) (*dns.DNSKEY, crypto.Signer) {
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     dns.ZONE | dns.SEP,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, priv.(crypto.Signer)
+}
+
+func sign(t *testing.T, key *dns.DNSKEY, priv crypto.Signer, rrs ...dns.RR) *dns.RRSIG {
+	sig := &dns.RRSIG{
+		Algorithm:  key.Algorithm,
+		KeyTag:     key.KeyTag(),
+		SignerName: key.Hdr.Name,
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+	if err := sig.Sign(priv, rrs); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestCanonicalCompare(t *testing.T) {
+	// RFC 4034, section 6.1
+	ordered := []string{"example.", "a.example.", "yljkjljk.a.example.", "Z.a.example.", "zABC.a.EXAMPLE.", "z.example.", "*.z.example."}
+	for i := 0; i+1 < len(ordered); i++ {
+		if canonicalCompare(ordered[i], ordered[i+1]) >= 0 {
+			t.Errorf("Expected %s to sort before %s", ordered[i], ordered[i+1])
+		}
+		if canonicalCompare(ordered[i+1], ordered[i]) <= 0 {
+			t.Errorf("Expected %s to sort after %s", ordered[i+1], ordered[i])
+		}
+	}
+	if canonicalCompare("Example.", "example.") != 0 {
+		t.Error("Expected canonical comparison to ignore case")
+	}
+}
+
+func TestNSECCovers(t *testing.T) {
+	nsec := mustRR(t, "b.example. 3600 IN NSEC d.example. A RRSIG NSEC").(*dns.NSEC)
+	for name, expected := range map[string]bool{
+		"c.example.":   true,
+		"x.b.example.": true,
+		"b.example.":   false,
+		"d.example.":   false,
+		"a.example.":   false,
+	} {
+		if nsecCovers(nsec, name) != expected {
+			t.Errorf("Unexpected coverage of %s: expected %v", name, expected)
+		}
+	}
+	last := mustRR(t, "y.example. 3600 IN NSEC example. A RRSIG NSEC").(*dns.NSEC)
+	if !nsecCovers(last, "z.example.") || nsecCovers(last, "x.example.") {
+		t.Error("Unexpected coverage by the last NSEC in the zone")
+	}
+}
+
+func TestVerifyRRset(t *testing.T) {
+	key, priv := signedZoneKey(t, "example.")
+	a := mustRR(t, "www.example. 300 IN A 192.0.2.1")
+	sig := sign(t, key, priv, a)
+	sets := groupRRsets([]dns.RR{a, sig})
+	if len(sets) != 1 || len(sets[0].sigs) != 1 {
+		t.Fatalf("Unexpected RRsets: %v", sets)
+	}
+	if err := verifyRRset(sets[0], "example.", []*dns.DNSKEY{key}); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+	if err := verifyRRset(sets[0], "com.", []*dns.DNSKEY{key}); err == nil {
+		t.Error("Expected signature by another zone to be rejected")
+	}
+	forged := mustRR(t, "www.example. 300 IN A 192.0.2.2")
+	sets = groupRRsets([]dns.RR{forged, sig})
+	if err := verifyRRset(sets[0], "example.", []*dns.DNSKEY{key}); err == nil {
+		t.Error("Expected signature over different data to be rejected")
+	}
+}
+
+func TestMatchDS(t *testing.T) {
+	key, _ := signedZoneKey(t, "example.")
+	ds := key.ToDS(dns.SHA256)
+	if keys := matchDS([]dns.RR{key}, []*dns.DS{ds}); len(keys) != 1 {
+		t.Errorf("Expected DNSKEY to match its DS, got %v", keys)
+	}
+	other, _ := signedZoneKey(t, "example.")
+	if keys := matchDS([]dns.RR{other}, []*dns.DS{ds}); len(keys) != 0 {
+		t.Errorf("Expected unrelated DNSKEY not to match, got %v", keys)
+	}
+}
+
+func TestProveNXDOMAIN(t *testing.T) {
+	key, priv := signedZoneKey(t, "example.")
+	keys := []*dns.DNSKEY{key}
+	cover := mustRR(t, "b.example. 3600 IN NSEC d.example. A RRSIG NSEC")
+	apex := mustRR(t, "example. 3600 IN NSEC b.example. NS SOA RRSIG NSEC DNSKEY")
+	section := []dns.RR{cover, sign(t, key, priv, cover), apex, sign(t, key, priv, apex)}
+	if err := proveNXDOMAIN(section, "c.example.", "example.", keys); err != nil {
+		t.Errorf("Expected nonexistence to be proven, got %v", err)
+	}
+	// without the apex NSEC nothing proves that *.example. doesn't exist
+	if err := proveNXDOMAIN(section[:2], "c.example.", "example.", keys); err == nil {
+		t.Error("Expected missing wildcard proof to be rejected")
+	}
+	if err := proveNXDOMAIN(section, "e.example.", "example.", keys); err == nil {
+		t.Error("Expected uncovered name to be rejected")
+	}
+}
+
+func TestProveNoDS(t *testing.T) {
+	key, priv := signedZoneKey(t, "example.")
+	keys := []*dns.DNSKEY{key}
+	unsigned := mustRR(t, "sub.example. 3600 IN NSEC www.example. NS RRSIG NSEC")
+	if err := proveNoDS([]dns.RR{unsigned, sign(t, key, priv, unsigned)}, "sub.example.", "example.", keys); err != nil {
+		t.Errorf("Expected unsigned delegation to be proven, got %v", err)
+	}
+	signed := mustRR(t, "sub.example. 3600 IN NSEC www.example. NS DS RRSIG NSEC")
+	if err := proveNoDS([]dns.RR{signed, sign(t, key, priv, signed)}, "sub.example.", "example.", keys); err == nil {
+		t.Error("Expected NSEC listing DS to be rejected")
+	}
+	if err := proveNoDS(nil, "sub.example.", "example.", keys); err == nil {
+		t.Error("Expected referral without proof to be rejected")
+	}
+}
+
+func TestLoadTrustAnchors(t *testing.T) {
+	anchors, err := LoadTrustAnchors("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anchors) != 2 || anchors[0].KeyTag != 20326 || anchors[1].KeyTag != 38696 {
+		t.Errorf("Unexpected root trust anchors: %v", anchors)
+	}
+}
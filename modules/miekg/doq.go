@@ -0,0 +1,229 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// the ALPN token of DNS over QUIC (RFC 9250, section 4.1.1)
+const doqALPN = "doq"
+
+// the DOQ_NO_ERROR code, with which idle connections are closed
+const doqNoError = 0
+
+// QUICConns keeps one DNS over QUIC (RFC 9250) connection open to each name
+// server, on which every query is sent on a stream of its own.
+type QUICConns struct {
+	// the TLS settings of new connections, whose server name is set from
+	// the name server. Nil checks certificates against the system roots
+	TLSConfig *tls.Config
+
+	mu    sync.Mutex
+	conns map[string]quic.Connection
+}
+
+// get returns the open connection to nameServer, dialing one if there is
+// none or the last one was closed.
+func (q *QUICConns) get(ctx context.Context, nameServer string) (quic.Connection, error) {
+	q.mu.Lock()
+	conn, ok := q.conns[nameServer]
+	q.mu.Unlock()
+	if ok && conn.Context().Err() == nil {
+		return conn, nil
+	}
+	// other queries to the server aren't held up while we dial
+	conn, err := quic.DialAddr(ctx, nameServer, q.tlsConfig(nameServer), nil)
+	if err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if other, ok := q.conns[nameServer]; ok && other.Context().Err() == nil {
+		// another query dialed the server at the same time
+		conn.CloseWithError(doqNoError, "")
+		return other, nil
+	}
+	if q.conns == nil {
+		q.conns = make(map[string]quic.Connection)
+	}
+	q.conns[nameServer] = conn
+	return conn, nil
+}
+
+// drop forgets conn, which failed, so that the next query to nameServer
+// dials a new connection.
+func (q *QUICConns) drop(nameServer string, conn quic.Connection) {
+	q.mu.Lock()
+	if q.conns[nameServer] == conn {
+		delete(q.conns, nameServer)
+	}
+	q.mu.Unlock()
+	conn.CloseWithError(doqNoError, "")
+}
+
+// Close closes every connection.
+func (q *QUICConns) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for ns, conn := range q.conns {
+		conn.CloseWithError(doqNoError, "")
+		delete(q.conns, ns)
+	}
+}
+
+// tlsConfig returns the TLS settings to connect to nameServer with, checking
+// its certificate against the host of nameServer, be it a name or an
+// address.
+func (q *QUICConns) tlsConfig(nameServer string) *tls.Config {
+	host, _, err := net.SplitHostPort(nameServer)
+	if err != nil {
+		host = nameServer
+	}
+	var conf *tls.Config
+	if q.TLSConfig != nil {
+		conf = q.TLSConfig.Clone()
+	} else {
+		conf = new(tls.Config)
+	}
+	conf.ServerName = host
+	conf.NextProtos = []string{doqALPN}
+	return conf
+}
+
+// exchangeQUIC sends m to the DNS over QUIC server nameServer on a new
+// stream of the server's connection in conns, which is dialed if need be.
+// As RFC 9250 requires, m must have ID 0, and the query and response are
+// each preceded by their length in two bytes. Without conns, the query gets
+// a connection of its own.
+func exchangeQUIC(ctx context.Context, conns *QUICConns, c *dns.Client, m *dns.Msg, nameServer string) (*dns.Msg, wireExchange, error) {
+	var wire wireExchange
+	if conns == nil {
+		conns = new(QUICConns)
+		defer conns.Close()
+	}
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	out, err := m.Pack()
+	if err != nil {
+		return nil, wire, err
+	}
+	conn, err := conns.get(ctx, nameServer)
+	if err != nil {
+		return nil, wire, err
+	}
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		wire.port = a.Port
+	}
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		if isQUICError(err) {
+			conns.drop(nameServer, conn)
+		}
+		return nil, wire, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+	// the stream is reset if ctx is done before the response arrives
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.CancelRead(doqNoError)
+			stream.CancelWrite(doqNoError)
+		case <-done:
+		}
+	}()
+	p, err := streamExchange(stream, out)
+	if err != nil && ctx.Err() != nil {
+		// we reset the stream ourselves, which the connection survives
+		return nil, wire, ctx.Err()
+	}
+	if isQUICError(err) {
+		// the connection is gone, or the server is misbehaving
+		conns.drop(nameServer, conn)
+	}
+	if err != nil {
+		return nil, wire, err
+	}
+	wire.querySize = len(out)
+	wire.responseSize = len(p)
+	r := new(dns.Msg)
+	if err := r.Unpack(p); err != nil {
+		return nil, wire, err
+	}
+	if r.Id != m.Id {
+		return r, wire, errIDMismatch(m.Id, r.Id)
+	}
+	return r, wire, nil
+}
+
+// streamExchange writes the query out to stream, closes the stream's
+// sending side to tell the server that nothing else follows, and reads the
+// response.
+func streamExchange(stream quic.Stream, out []byte) ([]byte, error) {
+	msg := make([]byte, 2+len(out))
+	binary.BigEndian.PutUint16(msg, uint16(len(out)))
+	copy(msg[2:], out)
+	if _, err := stream.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := stream.Close(); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, err
+	}
+	p := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// isQUICError reports whether err was raised by the QUIC layer, e.g., a
+// failed handshake, a closed or idle connection, or a reset stream, rather
+// than by the network or the deadline of the query.
+func isQUICError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var transportErr *quic.TransportError
+	var applicationErr *quic.ApplicationError
+	var streamErr *quic.StreamError
+	var idleErr *quic.IdleTimeoutError
+	var handshakeErr *quic.HandshakeTimeoutError
+	var resetErr *quic.StatelessResetError
+	var versionErr *quic.VersionNegotiationError
+	return errors.As(err, &transportErr) || errors.As(err, &applicationErr) ||
+		errors.As(err, &streamErr) || errors.As(err, &idleErr) ||
+		errors.As(err, &handshakeErr) || errors.As(err, &resetErr) ||
+		errors.As(err, &versionErr)
+}
@@ -0,0 +1,59 @@
+//go:build (linux && amd64) || (linux && arm64)
+// +build linux,amd64 linux,arm64
+
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package miekg
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+const fastOpenSupported = true
+
+// from linux/tcp.h, which the syscall package predates
+const (
+	tcpFastOpenConnect = 30
+	tcpiOptSynData     = 0x20
+)
+
+// enableFastOpen is a net.Dialer Control function that has the kernel (4.11
+// or later) send the first data written on a connection in its SYN. Where
+// the option is unknown, the connection is opened as usual.
+func enableFastOpen(network, address string, c syscall.RawConn) error {
+	return c.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpFastOpenConnect, 1)
+	})
+}
+
+// fastOpened reports whether the server accepted the data sent in the SYN
+// of conn. The first connection to a server only asks for a Fast Open
+// cookie, so it never does.
+func fastOpened(conn *net.TCPConn) bool {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var info syscall.TCPInfo
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		size := uint32(syscall.SizeofTCPInfo)
+		_, _, errno = syscall.Syscall6(syscall.SYS_GETSOCKOPT, fd, syscall.IPPROTO_TCP, syscall.TCP_INFO,
+			uintptr(unsafe.Pointer(&info)), uintptr(unsafe.Pointer(&size)), 0)
+	})
+	return err == nil && errno == 0 && info.Options&tcpiOptSynData != 0
+}
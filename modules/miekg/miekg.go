@@ -1,12 +1,27 @@
 package miekg
 
 import (
+	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
 	"net"
+	"net/http"
+	"reflect"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -100,6 +115,63 @@ type Answer struct {
 	Answer  string `json:"answer,omitempty" groups:"short,normal,long,trace"`
 }
 
+// CNAMELink is one step of a CNAME chain: Name is an alias for Target.
+type CNAMELink struct {
+	Name   string `json:"name" groups:"short,normal,long,trace"`
+	Target string `json:"target" groups:"short,normal,long,trace"`
+	Ttl    uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+// CNAMELoopError reports a CNAME chain that leads back to one of its own
+// aliases.
+func CNAMELoopError(chain []CNAMELink) error {
+	names := make([]string, 0, len(chain)+1)
+	for _, link := range chain {
+		names = append(names, link.Name)
+	}
+	if len(chain) > 0 {
+		names = append(names, chain[len(chain)-1].Target)
+	}
+	return errors.New("CNAME loop: " + strings.Join(names, " -> "))
+}
+
+// ExtendCNAMEChain appends the CNAME record ans to chain and reports whether
+// its target is an alias already on the chain, i.e., the chain loops.
+func ExtendCNAMEChain(chain []CNAMELink, ans Answer) ([]CNAMELink, bool) {
+	target := strings.TrimSuffix(ans.Answer, ".")
+	chain = append(chain, CNAMELink{Name: strings.TrimSuffix(ans.Name, "."), Target: target, Ttl: ans.Ttl})
+	for _, link := range chain {
+		if strings.EqualFold(link.Name, target) {
+			return chain, true
+		}
+	}
+	return chain, false
+}
+
+// FollowCNAMEs walks the CNAME records among the answers of a single
+// response, starting at name. It returns the chain in order and the name at
+// its end, whose records answer the query.
+func FollowCNAMEs(answers []interface{}, name string) ([]CNAMELink, string, error) {
+	var chain []CNAMELink
+	for {
+		var next *Answer
+		for _, a := range answers {
+			if ans, ok := a.(Answer); ok && ans.Type == "CNAME" && strings.EqualFold(strings.TrimSuffix(ans.Name, "."), name) {
+				next = &ans
+				break
+			}
+		}
+		if next == nil {
+			return chain, name, nil
+		}
+		var loops bool
+		if chain, loops = ExtendCNAMEChain(chain, *next); loops {
+			return chain, name, CNAMELoopError(chain)
+		}
+		name = chain[len(chain)-1].Target
+	}
+}
+
 type MXAnswer struct {
 	Answer
 	Preference uint16 `json:"preference" groups:"short,normal,long,trace"`
@@ -185,6 +257,13 @@ type NAPTRAnswer struct {
 	Replacement string `json:"replacement" groups:"short,normal,long,trace"`
 }
 
+type SSHFPAnswer struct {
+	Answer
+	Algorithm   uint8  `json:"algorithm" groups:"short,normal,long,trace"`
+	FpType      uint8  `json:"fingerprint_type" groups:"short,normal,long,trace"`
+	Fingerprint string `json:"fingerprint" groups:"short,normal,long,trace"`
+}
+
 type RRSIGAnswer struct {
 	Answer
 	TypeCovered uint16 `json:"type_covered" groups:"short,normal,long,trace"`
@@ -198,6 +277,16 @@ type RRSIGAnswer struct {
 	Signature   string `json:"signature" groups:"short,normal,long,trace"`
 }
 
+// UnknownAnswer is a record of a type without a specific parser. Its RDATA is
+// given in hex, and Answer holds the generic presentation format of RFC 3597
+// (e.g., \# 4 c0000201), or the type's own if miekg/dns knows it.
+type UnknownAnswer struct {
+	Answer
+	TypeNumber uint16 `json:"type_number" groups:"short,normal,long,trace"`
+	RDLength   uint16 `json:"rdlength" groups:"short,normal,long,trace"`
+	RData      string `json:"rdata" groups:"short,normal,long,trace"`
+}
+
 type DNSFlags struct {
 	Response           bool `json:"response" groups:"flags,long,trace"`
 	Opcode             int  `json:"opcode" groups:"flags,long,trace"`
@@ -210,6 +299,47 @@ type DNSFlags struct {
 	ErrorCode          int  `json:"error_code" groups:"flags,long,trace"`
 }
 
+type ClientSubnet struct {
+	Family       uint16 `json:"family" groups:"normal,long,trace"`
+	Address      string `json:"address" groups:"normal,long,trace"`
+	SourcePrefix uint8  `json:"source_prefix" groups:"normal,long,trace"`
+	ScopePrefix  uint8  `json:"scope_prefix" groups:"normal,long,trace"`
+}
+
+// DNS Cookie states, from the client's point of view
+const (
+	CookieValid    = "valid"
+	CookieMissing  = "missing" // the server doesn't support cookies
+	CookieMismatch = "mismatch"
+)
+
+// an EDNS0 option of a response, with its value in hex
+type EDNSOption struct {
+	Code uint16 `json:"code" groups:"trace"`
+	Data string `json:"data" groups:"trace"`
+}
+
+// MessageSizes are the sizes of a query and its response in bytes, as sent
+// over the network, and the amplification factor of the response.
+type MessageSizes struct {
+	Query    int     `json:"query_bytes" groups:"amplification,trace"`
+	Response int     `json:"response_bytes" groups:"amplification,trace"`
+	Factor   float64 `json:"factor" groups:"amplification,trace"`
+}
+
+// Amplification reports the sizes of the exchange that produced a result, over
+// UDP, TCP (or TLS), or both if a truncated UDP response was retried over TCP.
+type Amplification struct {
+	UDP *MessageSizes `json:"udp,omitempty" groups:"amplification,trace"`
+	TCP *MessageSizes `json:"tcp,omitempty" groups:"amplification,trace"`
+}
+
+type Cookie struct {
+	Client string `json:"client" groups:"normal,long,trace"`
+	Server string `json:"server,omitempty" groups:"normal,long,trace"`
+	Status string `json:"status" groups:"normal,long,trace"`
+}
+
 // result to be returned by scan of host
 type Result struct {
 	Answers     []interface{} `json:"answers" groups:"short,normal,long,trace"`
@@ -218,6 +348,114 @@ type Result struct {
 	Protocol    string        `json:"protocol" groups:"protocol,normal,long,trace"`
 	Resolver    string        `json:"resolver" groups:"resolver,normal,long,trace"`
 	Flags       DNSFlags      `json:"flags" groups:"flags,long,trace"`
+	// the address of the server that gave the authoritative answer to an
+	// iterative lookup, unless it was answered from the cache
+	AuthoritativeServer string `json:"authoritative_server,omitempty" groups:"short,normal,long,trace"`
+	// the EDNS0 client subnet echoed back by the server, if we sent one
+	ClientSubnet *ClientSubnet `json:"client_subnet,omitempty" groups:"normal,long,trace"`
+	// DNSSEC validation state, only set with --dnssec-validate
+	DNSSEC *DNSSECResult `json:"dnssec,omitempty" groups:"short,normal,long,trace"`
+	// the EDNS0 UDP payload size advertised in the query, if any
+	UDPSize uint16 `json:"udp_payload_size,omitempty" groups:"trace"`
+	// the TCP connection was opened with TCP Fast Open (--tcp-fastopen)
+	TCPFastOpen bool `json:"tcp_fastopen,omitempty" groups:"trace"`
+	// the idle timeout of a pooled TCP connection that the server advertised
+	// with EDNS0 TCP Keepalive, in milliseconds
+	TCPKeepalive *uint32 `json:"tcp_keepalive_ms,omitempty" groups:"trace"`
+	// the EDNS0 options of the response, whatever their code
+	EDNSOptions []EDNSOption `json:"edns_options,omitempty" groups:"trace"`
+	// the whole response re-encoded in wire format, only set with
+	// --raw-response. Encoded as base64 in JSON.
+	RawResponse []byte `json:"raw_response,omitempty" groups:"trace"`
+	// only set with --dns-cookies
+	Cookie *Cookie `json:"cookie,omitempty" groups:"normal,long,trace"`
+	// the ID of the query that produced this result, and the source port it
+	// was sent from
+	QueryID    uint16 `json:"query_id" groups:"trace"`
+	SourcePort int    `json:"source_port,omitempty" groups:"trace"`
+	// network round-trip time of the query that produced this result
+	Duration int64 `json:"duration_ns" groups:"duration,trace"`
+	// time from sending the first attempt to receiving the final response,
+	// including waits between retries
+	TotalDuration int64 `json:"total_duration_ns" groups:"duration,trace"`
+	Attempts      int   `json:"attempts" groups:"duration,trace"`
+	// sizes of the query and response on the wire
+	Amplification *Amplification `json:"amplification,omitempty" groups:"amplification,trace"`
+	// how long a negative answer may be cached, from its SOA. Not output.
+	NegativeTTL uint32 `json:"-"`
+	// the SOA record in the authority section, which lookups keep when the
+	// answer is negative. Not output.
+	NegativeSOA *SOAAnswer `json:"-"`
+}
+
+// AnswerSet renders the answers for comparison across --repeat queries,
+// leaving out their TTLs, which count down from one query to the next.
+func (r Result) AnswerSet() []string {
+	answers := make([]string, 0, len(r.Answers))
+	for _, a := range r.Answers {
+		if ans, ok := a.(Answer); ok {
+			answers = append(answers, ans.Type+" "+ans.Answer)
+			continue
+		}
+		// answers with more fields than Answer are compared as JSON
+		data, _ := json.Marshal(a)
+		var fields map[string]interface{}
+		if json.Unmarshal(data, &fields) == nil {
+			delete(fields, "ttl")
+			data, _ = json.Marshal(fields)
+		}
+		answers = append(answers, string(data))
+	}
+	return answers
+}
+
+// Settings applied to each outgoing query. The zero value sends a plain
+// query without any EDNS0 options.
+type QueryOptions struct {
+	ClientSubnet *dns.EDNS0_SUBNET
+	// set the DO bit so that servers include DNSSEC records
+	DNSSEC bool
+	// the EDNS0 UDP payload size to advertise. Zero leaves out the OPT
+	// record unless another option needs one, which then advertises the
+	// default of 4096 bytes.
+	UDPSize uint16
+	// raw options to attach, which also need an OPT record
+	EDNSOptions []*dns.EDNS0_LOCAL
+	// local addresses to send queries from, taken in turn among those of the
+	// name server's address family
+	LocalAddrs []net.IP
+	// send DNS Cookies and remember the servers' cookies here
+	Cookies *zdns.CookieJar
+	// randomize the case of query names (DNS 0x20) and reject responses
+	// whose question doesn't echo it exactly
+	RandomizeCase bool
+	// attach the response, in wire format, to the result
+	RawResponse bool
+	// report truncated UDP responses, with what they hold, instead of
+	// resending the query over TCP
+	NoTCPFallback bool
+	// record queries here instead of sending them (--dry-run)
+	DryRun *zdns.QueryPlan
+	// keep TCP connections open between queries
+	TCPPool *zdns.ConnPool
+	// tunnel TCP connections through this proxy, with --socks5
+	SOCKS5 *zdns.SOCKS5Proxy
+	// open TCP connections with TCP Fast Open, with --tcp-fastopen where
+	// the platform supports it
+	TCPFastOpen bool
+	// the client for DNS over HTTPS queries. Nil uses http.DefaultClient.
+	HTTPSClient *http.Client
+	// the connections of DNS over QUIC queries. Nil opens a connection per
+	// query.
+	QUICConns *QUICConns
+	// sign queries with this key and require signed responses. The clients
+	// must hold its secret.
+	TSIG *zdns.TSIGKey
+	// header bits to set in queries, with --query-flags
+	QueryFlags zdns.QueryFlags
+	// the query is being resent with the server cookie from a BADCOOKIE
+	// response, which happens only once
+	retriedBadCookie bool
 }
 
 type TraceStep struct {
@@ -229,6 +467,40 @@ type TraceStep struct {
 	Depth      int      `json:"depth" groups:"trace"`
 	Layer      string   `json:"layer" groups:"trace"`
 	Cached     IsCached `json:"cached" groups:"trace"`
+	Minimized  bool     `json:"minimized,omitempty" groups:"trace"`
+	Status     string   `json:"status" groups:"trace"`
+	Error      string   `json:"error,omitempty" groups:"trace"`
+	// the name servers the response delegated to, if it was a referral
+	Referral []string `json:"referral,omitempty" groups:"trace"`
+}
+
+// newTraceStep records one query sent while resolving a name, whatever its
+// outcome.
+func newTraceStep(result Result, status zdns.Status, err error, cached IsCached, dnsType uint16, dnsClass uint16,
+	name string, nameServer string, layer string, depth int, minimized bool) TraceStep {
+	t := TraceStep{
+		Result:     result,
+		DnsType:    dnsType,
+		DnsClass:   dnsClass,
+		Name:       name,
+		NameServer: nameServer,
+		Depth:      depth,
+		Layer:      layer,
+		Cached:     cached,
+		Minimized:  minimized,
+		Status:     string(status),
+	}
+	if err != nil {
+		t.Error = err.Error()
+	}
+	if status == zdns.STATUS_NOERROR && len(result.Answers) == 0 && !result.Flags.Authoritative {
+		for _, a := range result.Authorities {
+			if ans, ok := a.(Answer); ok && ans.Type == "NS" {
+				t.Referral = append(t.Referral, strings.TrimSuffix(ans.Answer, "."))
+			}
+		}
+	}
+	return t
 }
 
 type TimedAnswer struct {
@@ -240,6 +512,12 @@ type CachedResult struct {
 	Answers map[interface{}]TimedAnswer
 }
 
+// a cached NXDOMAIN or NODATA answer
+type NegativeCachedResult struct {
+	Status    zdns.Status
+	ExpiresAt time.Time
+}
+
 type IsCached bool
 
 // Helpers
@@ -555,6 +833,20 @@ func ParseAnswer(ans dns.RR) interface{} {
 			Regexp:      naptr.Regexp,
 			Replacement: naptr.Replacement,
 		}
+	} else if sshfp, ok := ans.(*dns.SSHFP); ok {
+		return SSHFPAnswer{
+			Answer: Answer{
+				Name:    strings.TrimSuffix(sshfp.Hdr.Name, "."),
+				Type:    dns.Type(sshfp.Hdr.Rrtype).String(),
+				rrType:  sshfp.Hdr.Rrtype,
+				Class:   dns.Class(sshfp.Hdr.Class).String(),
+				rrClass: sshfp.Hdr.Class,
+				Ttl:     sshfp.Hdr.Ttl,
+			},
+			Algorithm:   sshfp.Algorithm,
+			FpType:      sshfp.Type,
+			Fingerprint: sshfp.FingerPrint,
+		}
 	} else if rrsig, ok := ans.(*dns.RRSIG); ok {
 		return RRSIGAnswer{
 			Answer: Answer{
@@ -576,24 +868,65 @@ func ParseAnswer(ans dns.RR) interface{} {
 			Signature:   rrsig.Signature,
 		}
 	} else {
-		return struct {
-			Type     string `json:"type"`
-			rrType   uint16
-			Class    string `json:"class"`
-			rrClass  uint16
-			Unparsed dns.RR `json:"-"`
-		}{
-			Type:     dns.Type(ans.Header().Rrtype).String(),
-			rrType:   ans.Header().Rrtype,
-			Class:    dns.Class(ans.Header().Class).String(),
-			rrClass:  ans.Header().Class,
-			Unparsed: ans,
-		}
+		return parseUnknown(ans)
 	}
 	retv.Name = strings.TrimSuffix(retv.Name, ".")
 	return retv
 }
 
+// parseUnknown renders a record in the generic format of RFC 3597, reading
+// its RDATA back from the wire format so that no type-specific knowledge is
+// needed. Records of types that miekg/dns parses (e.g., HINFO or LOC) keep
+// their presentation format in Answer.
+func parseUnknown(ans dns.RR) interface{} {
+	hdr := ans.Header()
+	retv := UnknownAnswer{
+		Answer: Answer{
+			Name:    strings.TrimSuffix(hdr.Name, "."),
+			Type:    dns.Type(hdr.Rrtype).String(),
+			rrType:  hdr.Rrtype,
+			Class:   dns.Class(hdr.Class).String(),
+			rrClass: hdr.Class,
+			Ttl:     hdr.Ttl,
+		},
+		TypeNumber: hdr.Rrtype,
+	}
+	if rdata, err := rdataOf(ans); err == nil {
+		retv.RDLength = uint16(len(rdata))
+		retv.RData = hex.EncodeToString(rdata)
+		retv.Answer.Answer = fmt.Sprintf("\\# %d %s", len(rdata), retv.RData)
+	}
+	if _, ok := ans.(*dns.RFC3597); !ok {
+		retv.Answer.Answer = strings.TrimPrefix(ans.String(), hdr.String())
+	}
+	return retv
+}
+
+// rdataOf packs a record, without name compression, and returns the RDLENGTH
+// bytes of RDATA that follow its fixed header.
+func rdataOf(rr dns.RR) ([]byte, error) {
+	buf := make([]byte, dns.MaxMsgSize)
+	end, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return nil, err
+	}
+	_, off, err := dns.UnpackDomainName(buf, 0)
+	if err != nil {
+		return nil, err
+	}
+	// TYPE, CLASS, and TTL precede RDLENGTH
+	off += 8
+	if off+2 > end {
+		return nil, errors.New("record too short")
+	}
+	rdlength := int(binary.BigEndian.Uint16(buf[off:]))
+	off += 2
+	if off+rdlength != end {
+		return nil, fmt.Errorf("RDLENGTH %d doesn't match the %d bytes of RDATA", rdlength, end-off)
+	}
+	return buf[off:end], nil
+}
+
 func TranslateMiekgErrorCode(err int) zdns.Status {
 	return zdns.Status(dns.RcodeToString[err])
 }
@@ -609,6 +942,21 @@ type GlobalLookupFactory struct {
 	BlacklistPath  string
 	Blacklist      *blacklist.Blacklist
 	BlMu           sync.Mutex
+	TrustAnchors   []*dns.DS
+	TrustCache     cachehash.CacheHash
+	TrustMutex     sync.Mutex
+	// shared by all DNS over HTTPS queries, so that connections to a server
+	// are reused
+	HTTPSClient *http.Client
+	// shared by all DNS over QUIC queries, which each open a stream on the
+	// connection to their server
+	QUICConns *QUICConns
+
+	// queries of iterative lookups answered from the cache, and those that
+	// went to the wire
+	cacheStatsMutex sync.Mutex
+	cacheHits       uint64
+	cacheMisses     uint64
 }
 
 func (s *GlobalLookupFactory) BlacklistInit() error {
@@ -634,11 +982,48 @@ func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
 	}
 	s.IterativeCache.Init(c.CacheSize)
 	s.CacheMutex = &sync.RWMutex{}
+	if c.CacheFile != "" {
+		if err := s.loadCache(c.CacheFile); err != nil {
+			return err
+		}
+	}
 	s.DNSClass = dns.ClassINET
+	if c.TCPFastOpen && !fastOpenSupported {
+		log.Warn("TCP Fast Open isn't supported on this platform; TCP connections are opened as usual")
+	}
+	s.HTTPSClient = &http.Client{}
+	if c.SOCKS5 != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(c.SOCKS5.URL())
+		s.HTTPSClient = &http.Client{Transport: transport}
+	}
+	s.QUICConns = new(QUICConns)
+	if c.DNSSECValidate {
+		s.TrustAnchors, err = LoadTrustAnchors(c.TrustAnchorFile)
+		if err != nil {
+			return err
+		}
+		s.TrustCache.Init(c.CacheSize)
+	}
 
 	return nil
 }
 
+func (s *GlobalLookupFactory) Finalize() error {
+	var err error
+	if s.GlobalConf != nil && s.GlobalConf.CacheFile != "" {
+		err = s.saveCache(s.GlobalConf.CacheFile)
+	}
+	if s.QUICConns != nil {
+		s.QUICConns.Close()
+	}
+	// closes the metrics endpoint
+	if baseErr := s.BaseGlobalLookupFactory.Finalize(); err == nil {
+		err = baseErr
+	}
+	return err
+}
+
 func (s *GlobalLookupFactory) SetDNSType(dnsType uint16) {
 	s.DNSType = dnsType
 }
@@ -656,11 +1041,60 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+// WarmCache looks up the NS records of zones iteratively, with up to threads
+// lookups at once, caching the delegations along the way.
+func (s *GlobalLookupFactory) WarmCache(zones []string, threads int, stop <-chan struct{}) {
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < threads && i < len(zones); i++ {
+		r := new(RoutineLookupFactory)
+		r.Factory = s
+		r.Initialize(s.GlobalConf)
+		r.ThreadID = i
+		r.WarmingCache = true
+		var l Lookup
+		l.Initialize(s.RandomNameServer(), dns.TypeNS, s.DNSClass, r)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for zone := range work {
+				l.ResetQueries()
+				if _, _, status, err := l.DoTypedMiekgLookup(zone, dns.TypeNS); status != zdns.STATUS_NOERROR {
+					l.VerboseLog(0, "warming the cache for ", zone, " failed: ", status, " ", err)
+				}
+			}
+		}()
+	}
+feed:
+	for _, zone := range zones {
+		select {
+		case work <- zone:
+		case <-stop:
+			break feed
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+type cacheKey struct {
+	Name    string
+	DnsType uint16
+}
+
+// negative answers are cached under their own key type, next to the
+// positive answers for the same name and type
+type negativeCacheKey cacheKey
+
 func makeCacheKey(name string, dnsType uint16) interface{} {
-	return struct {
-		Name    string
-		DnsType uint16
-	}{
+	return cacheKey{
+		Name:    strings.ToLower(name),
+		DnsType: dnsType,
+	}
+}
+
+func makeNegativeCacheKey(name string, dnsType uint16) interface{} {
+	return negativeCacheKey{
 		Name:    strings.ToLower(name),
 		DnsType: dnsType,
 	}
@@ -754,19 +1188,104 @@ func (s *GlobalLookupFactory) GetCachedResult(name string, dnsType uint16, isAut
 	return retv, true
 }
 
+// AddNegativeCachedResult remembers that name has no records of dnsType, for
+// ttl seconds.
+func (s *GlobalLookupFactory) AddNegativeCachedResult(name string, dnsType uint16, status zdns.Status, ttl uint32, depth int, threadID int) {
+	key := makeNegativeCacheKey(name, dnsType)
+	nr := NegativeCachedResult{
+		Status:    status,
+		ExpiresAt: time.Now().Add(time.Duration(ttl) * time.Second),
+	}
+	s.CacheMutex.Lock()
+	s.IterativeCache.Add(key, nr)
+	s.CacheMutex.Unlock()
+	s.VerboseGlobalLog(depth+1, threadID, "Add negative cached result ", key, " ", nr)
+}
+
+func (s *GlobalLookupFactory) GetNegativeCachedResult(name string, dnsType uint16, depth int, threadID int) (zdns.Status, bool) {
+	key := makeNegativeCacheKey(name, dnsType)
+	s.CacheMutex.Lock()
+	defer s.CacheMutex.Unlock()
+	unres, ok := s.IterativeCache.Get(key)
+	if !ok {
+		return "", false
+	}
+	nr, ok := unres.(NegativeCachedResult)
+	if !ok {
+		panic("bad negative cache entry")
+	}
+	if nr.ExpiresAt.Before(time.Now()) {
+		s.VerboseGlobalLog(depth+2, threadID, "Expiring negative cache entry ", key)
+		s.IterativeCache.Delete(key)
+		return "", false
+	}
+	s.VerboseGlobalLog(depth+2, threadID, "Negative cache hit: ", key, " ", nr.Status)
+	return nr.Status, true
+}
+
+// countCacheLookup records whether the cache answered a query of an
+// iterative lookup. Queries made to warm the cache aren't counted.
+func (s *Lookup) countCacheLookup(hit IsCached) {
+	if !s.Factory.WarmingCache {
+		s.Factory.Factory.countCacheLookup(hit)
+	}
+}
+
+func (s *GlobalLookupFactory) countCacheLookup(hit IsCached) {
+	s.cacheStatsMutex.Lock()
+	if hit {
+		s.cacheHits++
+	} else {
+		s.cacheMisses++
+	}
+	s.cacheStatsMutex.Unlock()
+}
+
+// CacheStats reports the cache hits and misses of iterative lookups. Other
+// lookups don't use the cache.
+func (s *GlobalLookupFactory) CacheStats() *zdns.CacheStats {
+	if s.GlobalConf == nil || !s.GlobalConf.IterativeResolution {
+		return nil
+	}
+	s.cacheStatsMutex.Lock()
+	defer s.cacheStatsMutex.Unlock()
+	stats := &zdns.CacheStats{Hits: s.cacheHits, Misses: s.cacheMisses}
+	if total := s.cacheHits + s.cacheMisses; total > 0 {
+		stats.HitRate = math.Round(float64(s.cacheHits)/float64(total)*10000) / 10000
+	}
+	return stats
+}
+
 type RoutineLookupFactory struct {
 	Factory             *GlobalLookupFactory
 	Client              *dns.Client
 	TCPClient           *dns.Client
 	Retries             int
+	RetryBackoff        time.Duration
+	RcodeRetryBackoff   time.Duration
+	RetryRcodes         map[zdns.Status]bool
+	ClassRetries        map[zdns.Status]int
 	MaxDepth            int
+	MaxQueriesPerName   int
+	FullAnswer          bool
 	Timeout             time.Duration
 	IterativeTimeout    time.Duration
 	IterativeResolution bool
+	DNSSECValidate      bool
+	QNameMinimization   bool
+	NoRecurse           bool
+	RaceServers         int
 	Trace               bool
 	DNSType             uint16
 	DNSClass            uint16
 	ThreadID            int
+	QueryOptions        QueryOptions
+	Metrics             *zdns.Metrics
+	RateLimiter         *zdns.RateLimiter
+	CircuitBreaker      *zdns.CircuitBreaker
+	// the lookups warm the cache before the scan, and aren't counted in
+	// its stats
+	WarmingCache bool
 }
 
 func (s *RoutineLookupFactory) Initialize(c *zdns.GlobalConf) {
@@ -779,18 +1298,47 @@ func (s *RoutineLookupFactory) Initialize(c *zdns.GlobalConf) {
 	if !c.TCPOnly {
 		s.Client = new(dns.Client)
 		s.Client.Timeout = s.Timeout
+		if c.TSIG != nil {
+			s.Client.TsigSecret = c.TSIG.Secrets()
+		}
 	}
 
 	if !c.UDPOnly {
 		s.TCPClient = new(dns.Client)
 		s.TCPClient.Net = "tcp"
 		s.TCPClient.Timeout = s.Timeout
+		if c.TSIG != nil {
+			s.TCPClient.TsigSecret = c.TSIG.Secrets()
+		}
 	}
 
 	s.IterativeTimeout = c.Timeout
 	s.Retries = c.Retries
+	s.RetryBackoff = c.RetryBackoff
+	s.RcodeRetryBackoff = c.RcodeRetryBackoff
+	s.RetryRcodes = make(map[zdns.Status]bool, len(c.RetryRcodes))
+	for _, rcode := range c.RetryRcodes {
+		s.RetryRcodes[rcode] = true
+	}
+	s.ClassRetries = make(map[zdns.Status]int)
+	if c.TimeoutRetries >= 0 {
+		s.ClassRetries[zdns.STATUS_TIMEOUT] = c.TimeoutRetries
+		s.ClassRetries[zdns.STATUS_TEMPORARY] = c.TimeoutRetries
+	}
+	if c.ServfailRetries >= 0 {
+		s.ClassRetries[zdns.STATUS_SERVFAIL] = c.ServfailRetries
+	}
+	if c.RefusedRetries >= 0 {
+		s.ClassRetries[zdns.STATUS_REFUSED] = c.RefusedRetries
+	}
 	s.MaxDepth = c.MaxDepth
+	s.MaxQueriesPerName = c.MaxQueriesPerName
+	s.FullAnswer = c.FullAnswer
 	s.IterativeResolution = c.IterativeResolution
+	s.DNSSECValidate = c.DNSSECValidate
+	s.QNameMinimization = c.QNameMinimization
+	s.NoRecurse = c.NoRecurse
+	s.RaceServers = c.RaceServers
 	if c.ResultVerbosity == "trace" {
 		s.Trace = true
 	} else {
@@ -798,6 +1346,24 @@ func (s *RoutineLookupFactory) Initialize(c *zdns.GlobalConf) {
 	}
 
 	s.DNSClass = c.Class
+	s.QueryOptions.ClientSubnet = c.ClientSubnet
+	s.QueryOptions.DNSSEC = c.DNSSECValidate
+	s.QueryOptions.UDPSize = c.UDPPayloadSize
+	s.QueryOptions.EDNSOptions = c.EDNSOptions
+	s.QueryOptions.LocalAddrs = c.LocalAddrs
+	s.QueryOptions.Cookies = c.Cookies
+	s.QueryOptions.RandomizeCase = c.RandomizeCase
+	s.QueryOptions.RawResponse = c.RawResponse
+	s.QueryOptions.NoTCPFallback = c.NoTCPFallback
+	s.QueryOptions.DryRun = c.QueryPlan
+	s.QueryOptions.TCPPool = c.TCPPool
+	s.QueryOptions.SOCKS5 = c.SOCKS5
+	s.QueryOptions.TCPFastOpen = c.TCPFastOpen && fastOpenSupported
+	s.QueryOptions.TSIG = c.TSIG
+	s.QueryOptions.QueryFlags = c.QueryFlags
+	s.Metrics = c.Metrics
+	s.RateLimiter = c.RateLimiter
+	s.CircuitBreaker = c.CircuitBreaker
 }
 
 func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
@@ -816,6 +1382,19 @@ type Lookup struct {
 	Prefix        string
 	NameServer    string
 	IterativeStop time.Time
+	// queries sent for the current name, and whether --max-queries-per-name
+	// refused any more
+	queries      int
+	queryLimited bool
+	// the SOA record of the last negative answer received for the current
+	// name
+	negativeSOA *SOAAnswer
+	// the answer sections received for the current name, with --full-answer
+	answers []interface{}
+	// the header flags of the last response received for the current name
+	responseFlags *zdns.ResponseFlags
+	// whether the last answer received for the current name was NODATA
+	noData bool
 }
 
 func (s *Lookup) Initialize(nameServer string, dnsType uint16, dnsClass uint16, factory *RoutineLookupFactory) error {
@@ -826,138 +1405,1256 @@ func (s *Lookup) Initialize(nameServer string, dnsType uint16, dnsClass uint16,
 	return nil
 }
 
-func (s *Lookup) doLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
-	return DoLookupWorker(s.Factory.Client, s.Factory.TCPClient, dnsType, dnsClass, name, nameServer, recursive)
+func (s *Lookup) ResetQueries() {
+	s.queries = 0
+	s.queryLimited = false
 }
 
-// Expose the inner logic so other tools can use it
-func DoLookupWorker(udp *dns.Client, tcp *dns.Client, dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
-	res := Result{Answers: []interface{}{}, Authorities: []interface{}{}, Additional: []interface{}{}}
-	res.Resolver = nameServer
+func (s *Lookup) Queries() (int, bool) {
+	return s.queries, s.queryLimited
+}
 
-	m := new(dns.Msg)
-	m.SetQuestion(dotName(name), dnsType)
-	m.Question[0].Qclass = dnsClass
-	m.RecursionDesired = recursive
+func (s *Lookup) ResetAnswers() {
+	s.answers = nil
+}
 
-	var r *dns.Msg
-	var err error
-	if udp != nil {
-		res.Protocol = "udp"
-		r, _, err = udp.Exchange(m, nameServer)
-		// if record comes back truncated, but we have a TCP connection, try again with that
-		if r != nil && (r.Truncated || r.Rcode == dns.RcodeBadTrunc) {
-			if tcp != nil {
-				return DoLookupWorker(nil, tcp, dnsType, dnsClass, name, nameServer, recursive)
-			} else {
-				return res, zdns.STATUS_TRUNCATED, err
-			}
-		}
-	} else {
-		res.Protocol = "tcp"
-		r, _, err = tcp.Exchange(m, nameServer)
+func (s *Lookup) Answers() []interface{} {
+	return s.answers
+}
+
+func (s *Lookup) ResetResponseFlags() {
+	s.responseFlags = nil
+}
+
+func (s *Lookup) ResponseFlags() *zdns.ResponseFlags {
+	return s.responseFlags
+}
+
+func (s *Lookup) ResetNegativeSOA() {
+	s.negativeSOA = nil
+}
+
+func (s *Lookup) NegativeSOA() interface{} {
+	if s.negativeSOA == nil {
+		return nil
 	}
-	if err != nil || r == nil {
-		if nerr, ok := err.(net.Error); ok {
-			if nerr.Timeout() {
-				return res, zdns.STATUS_TIMEOUT, nil
-			} else if nerr.Temporary() {
-				return res, zdns.STATUS_TEMPORARY, err
-			}
-		}
-		return res, zdns.STATUS_ERROR, err
+	return *s.negativeSOA
+}
+
+func (s *Lookup) ResetNoData() {
+	s.noData = false
+}
+
+func (s *Lookup) NoData() bool {
+	return s.noData
+}
+
+// noteNoData records whether the answer to a query for dnsType was NODATA.
+func (s *Lookup) noteNoData(result Result, status zdns.Status, dnsType uint16) {
+	s.noData = status == zdns.STATUS_NOERROR && IsNoData(result, dnsType)
+}
+
+func (s *Lookup) SetDNSClass(dnsClass uint16) {
+	s.DNSClass = dnsClass
+}
+
+func (s *Lookup) SetNameServer(nameServer string) {
+	s.NameServer = nameServer
+}
+
+// queryOptions returns the routine's query options along with the HTTPS
+// client and QUIC connections of the global factory, which routines don't
+// have yet when they are initialized.
+func (s *Lookup) queryOptions() QueryOptions {
+	opts := s.Factory.QueryOptions
+	if s.Factory.Factory != nil {
+		opts.HTTPSClient = s.Factory.Factory.HTTPSClient
+		opts.QUICConns = s.Factory.Factory.QUICConns
 	}
+	return opts
+}
 
-	if err != nil || r == nil {
-		return res, zdns.STATUS_ERROR, err
+func (s *Lookup) doLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
+	if s.Factory.RaceServers > 1 && recursive {
+		return s.raceLookup(dnsType, dnsClass, name, nameServer)
 	}
-	if r.Rcode != dns.RcodeSuccess {
-		return res, TranslateMiekgErrorCode(r.Rcode), nil
+	s.Factory.RateLimiter.Wait(nameServer)
+	s.Factory.CircuitBreaker.Sending(nameServer)
+	res, status, err := DoLookupWorkerWithOptions(s.Factory.Client, s.Factory.TCPClient, dnsType, dnsClass, name, nameServer, recursive, s.queryOptions())
+	s.Factory.CircuitBreaker.Record(nameServer, status)
+	if recursive {
+		s.observeQuery(nameServer, res)
 	}
+	return res, status, err
+}
 
-	res.Flags.Response = r.Response
-	res.Flags.Opcode = r.Opcode
-	res.Flags.Authoritative = r.Authoritative
-	res.Flags.Truncated = r.Truncated
-	res.Flags.RecursionDesired = r.RecursionDesired
-	res.Flags.RecursionAvailable = r.RecursionAvailable
-	res.Flags.Authenticated = r.AuthenticatedData
-	res.Flags.CheckingDisabled = r.CheckingDisabled
-	res.Flags.ErrorCode = r.Rcode
+// observeQuery adds the latency of a query that was sent to the metrics of
+// nameServer. Only recursive queries count, as iteration would give every
+// authoritative server it comes across a series of its own.
+func (s *Lookup) observeQuery(nameServer string, res Result) {
+	if res.Duration > 0 {
+		s.Factory.Metrics.ObserveQuery(nameServer, time.Duration(res.Duration))
+	}
+}
 
-	for _, ans := range r.Answer {
-		inner := ParseAnswer(ans)
-		if inner != nil {
-			res.Answers = append(res.Answers, inner)
+// raceServers returns the first name servers, in the order they were
+// configured, up to the number of servers to race. Servers whose circuit
+// breaker is open are left out, unless every breaker is. A nameServer that
+// isn't among them, e.g., one set for the lookup, takes the place of the
+// last one.
+func (s *Lookup) raceServers(nameServer string) []string {
+	all := s.Factory.Factory.GlobalConf.NameServers
+	var candidates []string
+	for _, ns := range all {
+		if !s.Factory.CircuitBreaker.Skipping(ns) {
+			candidates = append(candidates, ns)
 		}
 	}
-	for _, ans := range r.Extra {
-		inner := ParseAnswer(ans)
-		if inner != nil {
-			res.Additional = append(res.Additional, inner)
-		}
+	if len(candidates) == 0 {
+		candidates = all
 	}
-	for _, ans := range r.Ns {
-		inner := ParseAnswer(ans)
-		if inner != nil {
-			res.Authorities = append(res.Authorities, inner)
+	n := s.Factory.RaceServers
+	if n > len(candidates) {
+		n = len(candidates)
+	}
+	servers := append([]string{}, candidates[:n]...)
+	for _, ns := range servers {
+		if ns == nameServer {
+			return servers
 		}
 	}
-	return res, zdns.STATUS_NOERROR, nil
+	if n == 0 {
+		return []string{nameServer}
+	}
+	servers[n-1] = nameServer
+	return servers
 }
 
-func (s *Lookup) SafeAddCachedAnswer(a interface{}, layer string, debugType string, depth int) {
-	ans, ok := a.(Answer)
-	if !ok {
-		s.VerboseLog(depth+1, "unable to cast ", debugType, ": ", layer, ": ", a)
-		return
-	}
-	ok, _ = nameIsBeneath(ans.Name, layer)
-	if !ok {
-		log.Info("detected poison ", debugType, ": ", ans.Name, "(", ans.Type, "): ", layer, ": ", a)
-		return
+// raceLookup sends the same query to several name servers at once and
+// returns the first definitive response (an answer or NXDOMAIN), closing the
+// sockets of the queries still in flight. If no server gives a definitive
+// response, the first failure is returned. With a circuit breaker, the
+// queries still in flight are left to finish instead, in the background, so
+// that the breaker counts the timeouts and failures of every server raced.
+func (s *Lookup) raceLookup(dnsType uint16, dnsClass uint16, name string, nameServer string) (Result, zdns.Status, error) {
+	type response struct {
+		result Result
+		status zdns.Status
+		err    error
+	}
+	servers := s.raceServers(nameServer)
+	// retries adjust the timeouts of the routine's clients while racers may
+	// still be in flight, so the racers get clients of their own
+	timeout := s.Factory.Timeout
+	if s.Factory.Client != nil {
+		timeout = s.Factory.Client.Timeout
+	} else if s.Factory.TCPClient != nil {
+		timeout = s.Factory.TCPClient.Timeout
+	}
+	udp := clientWithTimeout(s.Factory.Client, timeout)
+	tcp := clientWithTimeout(s.Factory.TCPClient, timeout)
+	opts := s.queryOptions()
+	breaker := s.Factory.CircuitBreaker
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	var racers sync.WaitGroup
+	racers.Add(len(servers))
+	if breaker == nil {
+		defer cancel()
+	} else {
+		go func() {
+			racers.Wait()
+			cancel()
+		}()
+	}
+	responses := make(chan response, len(servers))
+	for _, ns := range servers {
+		go func(ns string) {
+			defer racers.Done()
+			s.Factory.RateLimiter.Wait(ns)
+			breaker.Sending(ns)
+			res, status, err := doLookupWorker(ctx, udp, tcp, dnsType, dnsClass, name, ns, true, opts)
+			breaker.Record(ns, status)
+			s.observeQuery(ns, res)
+			responses <- response{res, status, err}
+		}(ns)
+	}
+	var first *response
+	for range servers {
+		r := <-responses
+		if r.status == zdns.STATUS_NOERROR || r.status == zdns.STATUS_NXDOMAIN {
+			return r.result, r.status, r.err
+		}
+		if first == nil {
+			first = &r
+		}
 	}
-	s.Factory.Factory.AddCachedAnswer(a, ans.Name, ans.rrType, ans.Ttl, depth, s.Factory.ThreadID)
+	return first.result, first.status, first.err
 }
 
-func (s *Lookup) cacheUpdate(layer string, result Result, depth int) {
-	for _, a := range result.Additional {
-		s.SafeAddCachedAnswer(a, layer, "additional", depth)
-	}
-	for _, a := range result.Authorities {
-		s.SafeAddCachedAnswer(a, layer, "authority", depth)
-	}
-	if result.Flags.Authoritative == true {
-		for _, a := range result.Answers {
-			s.SafeAddCachedAnswer(a, layer, "anwer", depth)
-		}
+// clientWithTimeout returns a copy of c with the given timeout, or nil if c
+// is nil.
+func clientWithTimeout(c *dns.Client, timeout time.Duration) *dns.Client {
+	if c == nil {
+		return nil
 	}
+	return &dns.Client{Net: c.Net, Timeout: timeout, TsigSecret: c.TsigSecret}
 }
 
-func (s *Lookup) tracedRetryingLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, []interface{}, zdns.Status, error) {
+// Expose the inner logic so other tools can use it
+func DoLookupWorker(udp *dns.Client, tcp *dns.Client, dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
+	return DoLookupWorkerWithOptions(udp, tcp, dnsType, dnsClass, name, nameServer, recursive, QueryOptions{})
+}
 
-	res, status, err := s.retryingLookup(dnsType, dnsClass, name, nameServer, recursive)
+func DoLookupWorkerWithOptions(udp *dns.Client, tcp *dns.Client, dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool, opts QueryOptions) (Result, zdns.Status, error) {
+	return doLookupWorker(context.Background(), udp, tcp, dnsType, dnsClass, name, nameServer, recursive, opts)
+}
 
-	trace := make([]interface{}, 0)
+// counts queries across all threads to spread them over the local addresses
+var localAddrCounter uint32
 
-	if s.Factory.Trace {
-		var t TraceStep
-		t.Result = res
-		t.DnsType = dnsType
-		t.DnsClass = dnsClass
-		t.Name = name
-		t.NameServer = nameServer
-		t.Layer = name
-		t.Depth = 1
-		t.Cached = false
-		trace = append(trace, t)
+// pickLocalAddr returns the next local address (round-robin) of the same
+// family as nameServer, or nil if no local addresses are configured.
+func pickLocalAddr(addrs []net.IP, nameServer string) (net.IP, error) {
+	if len(addrs) == 0 {
+		return nil, nil
 	}
+	_, addr := zdns.SplitTransport(nameServer)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	candidates := addrs
+	if ip := net.ParseIP(host); ip != nil {
+		candidates = make([]net.IP, 0, len(addrs))
+		for _, a := range addrs {
+			if (a.To4() != nil) == (ip.To4() != nil) {
+				candidates = append(candidates, a)
+			}
+		}
+		if len(candidates) == 0 {
+			return nil, errors.New("no local address of the same address family as name server " + nameServer)
+		}
+	}
+	n := atomic.AddUint32(&localAddrCounter, 1)
+	return candidates[int(n%uint32(len(candidates)))], nil
+}
 
-	return res, trace, status, err
+// wireExchange describes a query and its response as they went over the
+// network.
+type wireExchange struct {
+	// the source port the query was sent from
+	port int
+	// the sizes of the query and response in bytes, without the length
+	// prefix of TCP messages
+	querySize    int
+	responseSize int
+	// the query went out on a new connection opened with TCP Fast Open
+	fastOpen bool
 }
 
-func (s *Lookup) retryingLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
+// sizes reports the sizes of the exchange, or nil if no response was read.
+func (w wireExchange) sizes() *MessageSizes {
+	if w.querySize == 0 || w.responseSize == 0 {
+		return nil
+	}
+	return &MessageSizes{
+		Query:    w.querySize,
+		Response: w.responseSize,
+		Factor:   math.Round(float64(w.responseSize)/float64(w.querySize)*100) / 100,
+	}
+}
+
+// exchange sends m to nameServer, from localAddr if it isn't nil, on a
+// connection of its own dialed as opts say. The connection is closed as soon
+// as ctx is done, and ctx's deadline replaces the client's timeout.
+func exchange(ctx context.Context, c *dns.Client, m *dns.Msg, nameServer string, localAddr net.IP, opts QueryOptions) (*dns.Msg, wireExchange, error) {
+	conn, err := dial(ctx, c, nameServer, localAddr, opts)
+	if err != nil {
+		return nil, wireExchange{}, err
+	}
+	defer conn.Close()
+	r, wire, err := exchangeOn(ctx, c, conn, m)
+	wire.fastOpen = isFastOpened(conn.TCP, opts.TCPFastOpen)
+	return r, wire, err
+}
+
+// exchangePooled sends m over a TCP connection from opts.TCPPool, which
+// other queries may be using at the same time, and hands the connection back
+// once the response has been read.
+func exchangePooled(ctx context.Context, c *dns.Client, m *dns.Msg, nameServer string, localAddr net.IP, opts QueryOptions) (*dns.Msg, wireExchange, error) {
+	pool := opts.TCPPool
+	key := nameServer
+	if c.Net == "tcp-tls" {
+		key = zdns.TransportTLS + "://" + nameServer
+	}
+	if localAddr != nil {
+		key = localAddr.String() + "-" + key
+	}
+	if conn := pool.Get(key); conn != nil {
+		r, wire, err := exchangePipelined(ctx, c, conn, m)
+		putConn(pool, conn, r)
+		if err == nil {
+			return r, wire, nil
+		}
+		// the server may have closed the connection, or another query on
+		// it may have the same ID, in which case the query is retried on a
+		// new one
+		if nerr, ok := err.(net.Error); (ok && nerr.Timeout()) || ctx.Err() != nil {
+			return r, wire, err
+		}
+	}
+	conn, err := dial(ctx, c, nameServer, localAddr, opts)
+	if err != nil {
+		return nil, wireExchange{}, err
+	}
+	pc := pool.Add(key, conn.TCP)
+	r, wire, err := exchangePipelined(ctx, c, pc, m)
+	wire.fastOpen = isFastOpened(conn.TCP, opts.TCPFastOpen)
+	putConn(pool, pc, r)
+	return r, wire, err
+}
+
+// exchangePipelined sends m over conn and waits for the response with its
+// ID. As other queries share the connection, m is signed and the response
+// verified here rather than by dns.Conn, which only tracks one TSIG
+// exchange at a time.
+func exchangePipelined(ctx context.Context, c *dns.Client, conn *zdns.PooledConn, m *dns.Msg) (*dns.Msg, wireExchange, error) {
+	var wire wireExchange
+	if a, ok := conn.LocalAddr().(*net.TCPAddr); ok {
+		wire.port = a.Port
+	}
+	var out []byte
+	var mac string
+	var err error
+	if t := m.IsTsig(); t != nil {
+		secret, ok := c.TsigSecret[t.Hdr.Name]
+		if !ok {
+			return nil, wire, dns.ErrSecret
+		}
+		out, mac, err = dns.TsigGenerate(m, secret, "", false)
+	} else {
+		out, err = m.Pack()
+	}
+	if err != nil {
+		return nil, wire, err
+	}
+	var deadline time.Time
+	if d, ok := ctx.Deadline(); ok {
+		deadline = d
+	} else if c.Timeout > 0 {
+		deadline = time.Now().Add(c.Timeout)
+	}
+	wire.querySize = len(out)
+	p, err := conn.Exchange(ctx, m.Id, out, deadline)
+	if err != nil {
+		return nil, wire, err
+	}
+	wire.responseSize = len(p)
+	r := new(dns.Msg)
+	if err := r.Unpack(p); err != nil {
+		return r, wire, err
+	}
+	if t := r.IsTsig(); t != nil {
+		secret, ok := c.TsigSecret[t.Hdr.Name]
+		if !ok {
+			return r, wire, dns.ErrSecret
+		}
+		// the MAC covers the message as it was sent
+		err = dns.TsigVerify(p, secret, mac, false)
+	}
+	return r, wire, err
+}
+
+// putConn hands a connection back to pool after the response r, keeping it
+// for as long as the server asked with the EDNS0 TCP Keepalive option, if
+// any. A timeout of 0 asks for the connection to be closed.
+func putConn(pool *zdns.ConnPool, conn *zdns.PooledConn, r *dns.Msg) {
+	timeout, ok := keepaliveTimeout(r)
+	switch {
+	case !ok:
+		pool.Put(conn)
+	case timeout == 0:
+		pool.Retire(conn)
+	default:
+		pool.PutWithTimeout(conn, timeout)
+	}
+}
+
+// keepaliveTimeout returns the idle timeout that the server advertised in
+// the EDNS0 TCP Keepalive option (RFC 7828) of response r.
+func keepaliveTimeout(r *dns.Msg) (time.Duration, bool) {
+	if r == nil {
+		return 0, false
+	}
+	edns := r.IsEdns0()
+	if edns == nil {
+		return 0, false
+	}
+	for _, o := range edns.Option {
+		if k, ok := o.(*dns.EDNS0_TCP_KEEPALIVE); ok {
+			// in units of 100 milliseconds
+			return time.Duration(k.Timeout) * 100 * time.Millisecond, true
+		}
+	}
+	return 0, false
+}
+
+// dial opens a connection to nameServer for c, from localAddr if it isn't
+// nil. TCP connections are tunneled through opts.SOCKS5 if it isn't nil, and
+// opened with TCP Fast Open if opts.TCPFastOpen is set.
+func dial(ctx context.Context, c *dns.Client, nameServer string, localAddr net.IP, opts QueryOptions) (*dns.Conn, error) {
+	network := c.Net
+	if network == "" {
+		network = "udp"
+	}
+	if !strings.HasPrefix(network, "tcp") {
+		var laddr string
+		if localAddr != nil {
+			laddr = net.JoinHostPort(localAddr.String(), "0")
+		}
+		raddr, err := net.ResolveUDPAddr(network, nameServer)
+		if err != nil {
+			return nil, err
+		}
+		var lc net.ListenConfig
+		pc, err := lc.ListenPacket(ctx, network, laddr)
+		if err != nil {
+			return nil, err
+		}
+		// the dns package sends to RemoteAddr, which it can't do over a
+		// connected socket
+		return &dns.Conn{UDP: serverPacketConn{pc, raddr}, RemoteAddr: nameServer}, nil
+	}
+	d := net.Dialer{Timeout: c.Timeout}
+	if opts.TCPFastOpen {
+		d.Control = enableFastOpen
+	}
+	if localAddr != nil {
+		d.LocalAddr = &net.TCPAddr{IP: localAddr}
+	}
+	if network == "tcp-tls" {
+		nc, err := dialTCP(ctx, &d, opts.SOCKS5, "tcp", nameServer)
+		if err != nil {
+			return nil, err
+		}
+		tc, err := tlsHandshake(ctx, nc, nameServer, c.Timeout)
+		if err != nil {
+			return nil, err
+		}
+		return &dns.Conn{TCP: tlsConn{tc.(*tls.Conn), nc}}, nil
+	}
+	nc, err := dialTCP(ctx, &d, opts.SOCKS5, network, nameServer)
+	if err != nil {
+		return nil, err
+	}
+	return &dns.Conn{TCP: nc}, nil
+}
+
+// serverPacketConn drops the datagrams that don't come from the server, as a
+// connected socket would.
+type serverPacketConn struct {
+	net.PacketConn
+	server *net.UDPAddr
+}
+
+func (c serverPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil {
+			return n, addr, err
+		}
+		if a, ok := addr.(*net.UDPAddr); ok && a.IP.Equal(c.server.IP) && a.Port == c.server.Port {
+			return n, addr, nil
+		}
+	}
+}
+
+// tlsConn is a DNS over TLS connection that keeps the TCP connection under
+// it, to tell whether that was opened with TCP Fast Open.
+type tlsConn struct {
+	*tls.Conn
+	tcp net.Conn
+}
+
+// isFastOpened reports whether conn, a TCP or TLS connection, was opened
+// with TCP Fast Open, which it can only have been if fastOpen was asked for.
+func isFastOpened(conn net.Conn, fastOpen bool) bool {
+	if !fastOpen {
+		return false
+	}
+	if tc, ok := conn.(tlsConn); ok {
+		conn = tc.tcp
+	}
+	tcp, ok := conn.(*net.TCPConn)
+	return ok && fastOpened(tcp)
+}
+
+// dialTCP connects to nameServer with d, through proxy if it isn't nil.
+func dialTCP(ctx context.Context, d *net.Dialer, proxy *zdns.SOCKS5Proxy, network string, nameServer string) (net.Conn, error) {
+	if proxy != nil {
+		return proxy.DialContext(ctx, d, network, nameServer)
+	}
+	return d.DialContext(ctx, network, nameServer)
+}
+
+// tlsHandshake starts DNS over TLS (RFC 7858) on nc, checking the server's
+// certificate against the host of nameServer, be it a name or an address.
+func tlsHandshake(ctx context.Context, nc net.Conn, nameServer string, timeout time.Duration) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(nameServer)
+	if err != nil {
+		host = nameServer
+	}
+	tc := tls.Client(nc, &tls.Config{ServerName: host})
+	if deadline, ok := ctx.Deadline(); ok {
+		tc.SetDeadline(deadline)
+	} else if timeout > 0 {
+		tc.SetDeadline(time.Now().Add(timeout))
+	}
+	if err := tc.Handshake(); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// exchangeHTTPS sends m to the DNS over HTTPS (RFC 8484) server at url, as
+// the body of a POST request made with hc.
+func exchangeHTTPS(ctx context.Context, hc *http.Client, c *dns.Client, m *dns.Msg, url string) (*dns.Msg, error) {
+	wire, err := m.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok && c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(wire))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, err
+	}
+	r := new(dns.Msg)
+	if err := r.Unpack(body); err != nil {
+		return nil, err
+	}
+	if r.Id != m.Id {
+		return r, errIDMismatch(m.Id, r.Id)
+	}
+	return r, nil
+}
+
+// transportClients returns the clients to query a server with the given
+// transport, which overrides --tcp-only and --udp-only: udp and tcp servers
+// are only queried over that protocol, and tls servers over TLS. Servers
+// without a transport use the clients they're given.
+func transportClients(transport string, udp *dns.Client, tcp *dns.Client) (*dns.Client, *dns.Client) {
+	base := udp
+	if base == nil {
+		base = tcp
+	}
+	switch transport {
+	case zdns.TransportUDP:
+		if udp == nil {
+			udp = &dns.Client{Timeout: base.Timeout, TsigSecret: base.TsigSecret}
+		}
+		return udp, nil
+	case zdns.TransportTCP:
+		if tcp == nil {
+			tcp = &dns.Client{Net: "tcp", Timeout: base.Timeout, TsigSecret: base.TsigSecret}
+		}
+		return nil, tcp
+	case zdns.TransportTLS:
+		return nil, &dns.Client{Net: "tcp-tls", Timeout: base.Timeout, TsigSecret: base.TsigSecret}
+	}
+	return udp, tcp
+}
+
+// protocolName is the protocol reported for queries sent with client c.
+func protocolName(c *dns.Client) string {
+	switch c.Net {
+	case "tcp-tls":
+		return zdns.TransportTLS
+	case "tcp", "tcp4", "tcp6":
+		return "tcp"
+	}
+	return "udp"
+}
+
+// queryID returns a random query ID from crypto/rand, which unlike math/rand
+// can't be predicted by an off-path attacker who wants to spoof responses.
+func queryID() uint16 {
+	var b [2]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return dns.Id()
+	}
+	return binary.BigEndian.Uint16(b[:])
+}
+
+// errIDMismatch wraps dns.ErrId with the IDs of a response that doesn't
+// answer our query, which may be spoofed.
+func errIDMismatch(query, response uint16) error {
+	return fmt.Errorf("response ID %d doesn't match query ID %d: %w", response, query, dns.ErrId)
+}
+
+// localPort returns the source port of conn, or 0 if it has none.
+func localPort(conn *dns.Conn) int {
+	var addr net.Addr
+	if conn.TCP != nil {
+		addr = conn.TCP.LocalAddr()
+	} else if conn.UDP != nil {
+		addr = conn.UDP.LocalAddr()
+	}
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.Port
+	case *net.TCPAddr:
+		return a.Port
+	}
+	return 0
+}
+
+// byteCounter counts the bytes read from and written to a connection.
+type byteCounter struct {
+	read    int
+	written int
+}
+
+// countingConn is a TCP connection that counts the bytes that go over it.
+type countingConn struct {
+	net.Conn
+	*byteCounter
+}
+
+func (c countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read += n
+	return n, err
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written += n
+	return n, err
+}
+
+// countingPacketConn is a UDP socket that counts the bytes of the datagrams
+// that go over it.
+type countingPacketConn struct {
+	net.PacketConn
+	*byteCounter
+}
+
+func (c countingPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(p)
+	c.read += n
+	return n, addr, err
+}
+
+func (c countingPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(p, addr)
+	c.written += n
+	return n, err
+}
+
+// countBytes returns a copy of conn that counts the bytes that go over it,
+// leaving conn as is for the pool.
+func countBytes(conn *dns.Conn) (*dns.Conn, *byteCounter) {
+	c := new(byteCounter)
+	cc := &dns.Conn{RemoteAddr: conn.RemoteAddr, UDPSize: conn.UDPSize, TsigSecret: conn.TsigSecret}
+	if conn.TCP != nil {
+		cc.TCP = countingConn{conn.TCP, c}
+	} else {
+		cc.UDP = countingPacketConn{conn.UDP, c}
+	}
+	return cc, c
+}
+
+// exchangeOn sends m over conn and reads the response, also returning the
+// source port the query was sent from and the sizes of both messages. The
+// connection is closed if ctx is done first.
+func exchangeOn(ctx context.Context, c *dns.Client, conn *dns.Conn, m *dns.Msg) (*dns.Msg, wireExchange, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	cc, counter := countBytes(conn)
+	if opt := m.IsEdns0(); opt != nil && opt.UDPSize() >= dns.MinMsgSize {
+		cc.UDPSize = opt.UDPSize()
+	}
+	cc.TsigSecret = c.TsigSecret
+	if deadline, ok := ctx.Deadline(); ok {
+		setDeadline(conn, deadline)
+	} else if c.Timeout > 0 {
+		setDeadline(conn, time.Now().Add(c.Timeout))
+	}
+	wire := wireExchange{port: localPort(conn)}
+	// TCP messages are preceded by their length, in two bytes
+	framing := 2
+	if conn.TCP == nil {
+		framing = 0
+	}
+	err := cc.WriteMsg(m)
+	if counter.written > framing {
+		wire.querySize = counter.written - framing
+	}
+	if err != nil {
+		return nil, wire, err
+	}
+	// a response with another ID may be spoofed, so we keep reading until
+	// the deadline for one that answers m
+	var mismatch error
+	for {
+		read := counter.read
+		r, err := cc.ReadMsg()
+		if err == nil && r.Id != m.Id {
+			mismatch = errIDMismatch(m.Id, r.Id)
+			continue
+		}
+		if err != nil && mismatch != nil {
+			return nil, wire, mismatch
+		}
+		if counter.read-read > framing {
+			wire.responseSize = counter.read - read - framing
+		}
+		return r, wire, err
+	}
+}
+
+// setDeadline sets the read and write deadlines of conn, which unlike a
+// net.Conn has no SetDeadline.
+func setDeadline(conn *dns.Conn, t time.Time) {
+	conn.SetReadDeadline(t)
+	conn.SetWriteDeadline(t)
+}
+
+// checkTSIG classifies the TSIG failures of a signed query, which got the
+// response r (if any) and the error err. The dns package verifies the MAC of
+// signed responses as it reads them, but accepts unsigned ones.
+func checkTSIG(r *dns.Msg, err error) (zdns.Status, error) {
+	if r != nil {
+		if t := r.IsTsig(); t != nil && t.Error != dns.RcodeSuccess {
+			return zdns.STATUS_TSIG_ERROR, fmt.Errorf("server rejected the TSIG signature: %s", dns.RcodeToString[int(t.Error)])
+		}
+	}
+	if zdns.IsTSIGError(err) {
+		return zdns.STATUS_TSIG_ERROR, fmt.Errorf("TSIG verification of the response failed: %v", err)
+	}
+	if err == nil && r != nil && r.IsTsig() == nil {
+		return zdns.STATUS_TSIG_ERROR, errors.New("response to a TSIG-signed query is not signed")
+	}
+	return zdns.STATUS_NOERROR, nil
+}
+
+var errCookieMismatch = zdns.NewDetailedError(zdns.ERROR_DETAIL_COOKIE_MISMATCH, errors.New("DNS cookie mismatch: response doesn't echo our client cookie"))
+
+// checkCookie inspects the COOKIE option of response r, remembering the
+// server cookie for the next query to nameServer. A server that ignores
+// cookies (no option in the response) is fine; one that echoes a different
+// client cookie is not, as the response may be spoofed (RFC 7873, 5.3).
+func checkCookie(r *dns.Msg, jar *zdns.CookieJar, nameServer string, clientCookie string) *Cookie {
+	if jar == nil || r == nil {
+		return nil
+	}
+	c := &Cookie{Client: clientCookie, Status: CookieMissing}
+	edns := r.IsEdns0()
+	if edns == nil {
+		return c
+	}
+	for _, o := range edns.Option {
+		cookie, ok := o.(*dns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+		if len(cookie.Cookie) < len(clientCookie) || !strings.EqualFold(cookie.Cookie[:len(clientCookie)], clientCookie) {
+			c.Status = CookieMismatch
+			return c
+		}
+		c.Status = CookieValid
+		// a server cookie is 8 to 32 bytes long
+		if server := cookie.Cookie[len(clientCookie):]; len(server) >= 16 && len(server) <= 64 {
+			c.Server = strings.ToLower(server)
+			jar.SetServerCookie(nameServer, c.Server)
+		}
+	}
+	return c
+}
+
+// randomizeCase flips the case of each letter of name at random, for DNS 0x20
+// (draft-vixie-dnsext-dns0x20): a spoofed response must guess the pattern
+// as well as the query ID and port.
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+			if rand.Intn(2) == 0 {
+				b[i] = c ^ 0x20
+			}
+		}
+	}
+	return string(b)
+}
+
+func doLookupWorker(ctx context.Context, udp *dns.Client, tcp *dns.Client, dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool, opts QueryOptions) (Result, zdns.Status, error) {
+	res := Result{Answers: []interface{}{}, Authorities: []interface{}{}, Additional: []interface{}{}}
+	res.Resolver = nameServer
+	// the transport of the name server, if it was given one, decides how
+	// it's queried
+	transport, server := zdns.SplitTransport(nameServer)
+	udp, tcp = transportClients(transport, udp, tcp)
+
+	qname := dotName(name)
+	if opts.RandomizeCase {
+		qname = randomizeCase(qname)
+	}
+	m := new(dns.Msg)
+	m.SetQuestion(qname, dnsType)
+	m.Id = queryID()
+	if transport == zdns.TransportQUIC {
+		// the stream, not the ID, pairs the response with the query
+		// (RFC 9250, section 4.2.1)
+		m.Id = 0
+	}
+	res.QueryID = m.Id
+	m.Question[0].Qclass = dnsClass
+	m.RecursionDesired = recursive
+	opts.QueryFlags.Apply(m)
+	if opts.ClientSubnet != nil || opts.DNSSEC || opts.UDPSize > 0 || opts.Cookies != nil || len(opts.EDNSOptions) > 0 {
+		res.UDPSize = opts.UDPSize
+		if res.UDPSize == 0 {
+			res.UDPSize = dns.DefaultMsgSize
+		}
+		m.SetEdns0(res.UDPSize, opts.DNSSEC)
+	}
+	if opts.ClientSubnet != nil {
+		edns := m.IsEdns0()
+		edns.Option = append(edns.Option, opts.ClientSubnet)
+	}
+	for _, o := range opts.EDNSOptions {
+		edns := m.IsEdns0()
+		edns.Option = append(edns.Option, o)
+	}
+	var clientCookie string
+	if opts.Cookies != nil {
+		clientCookie = opts.Cookies.ClientCookie(nameServer)
+		edns := m.IsEdns0()
+		edns.Option = append(edns.Option, &dns.EDNS0_COOKIE{
+			Code:   dns.EDNS0COOKIE,
+			Cookie: clientCookie + opts.Cookies.ServerCookie(nameServer),
+		})
+	}
+
+	localAddr, err := pickLocalAddr(opts.LocalAddrs, nameServer)
+	if err != nil {
+		return res, zdns.STATUS_ERROR, err
+	}
+	if opts.DryRun != nil {
+		if transport == zdns.TransportHTTPS || transport == zdns.TransportQUIC {
+			res.Protocol = transport
+		} else if udp != nil {
+			res.Protocol = "udp"
+		} else {
+			res.Protocol = protocolName(tcp)
+		}
+		opts.DryRun.Record(zdns.PlannedQuery{
+			Name:      qname,
+			Type:      dns.TypeToString[dnsType],
+			Class:     dns.ClassToString[dnsClass],
+			Server:    nameServer,
+			Transport: res.Protocol,
+			Recursive: recursive,
+		})
+		return res, zdns.STATUS_DRY_RUN, nil
+	}
+	var r *dns.Msg
+	var wire wireExchange
+	var truncated bool
+	start := time.Now()
+	if transport == zdns.TransportHTTPS {
+		res.Protocol = transport
+		client := udp
+		if client == nil {
+			client = tcp
+		}
+		r, err = exchangeHTTPS(ctx, opts.HTTPSClient, client, m, server)
+		res.Duration = time.Since(start).Nanoseconds()
+		if res.Cookie = checkCookie(r, opts.Cookies, nameServer, clientCookie); res.Cookie != nil && res.Cookie.Status == CookieMismatch {
+			return res, zdns.STATUS_ERROR, errCookieMismatch
+		}
+	} else if transport == zdns.TransportQUIC {
+		res.Protocol = transport
+		client := udp
+		if client == nil {
+			client = tcp
+		}
+		r, wire, err = exchangeQUIC(ctx, opts.QUICConns, client, m, server)
+		res.Duration = time.Since(start).Nanoseconds()
+		res.SourcePort = wire.port
+		if res.Cookie = checkCookie(r, opts.Cookies, nameServer, clientCookie); res.Cookie != nil && res.Cookie.Status == CookieMismatch {
+			return res, zdns.STATUS_ERROR, errCookieMismatch
+		}
+	} else if udp != nil {
+		res.Protocol = "udp"
+		if opts.TSIG != nil {
+			opts.TSIG.Sign(m)
+		}
+		r, wire, err = exchange(ctx, udp, m, server, localAddr, opts)
+		res.Duration = time.Since(start).Nanoseconds()
+		res.SourcePort = wire.port
+		if sizes := wire.sizes(); sizes != nil {
+			res.Amplification = &Amplification{UDP: sizes}
+		}
+		if res.Cookie = checkCookie(r, opts.Cookies, nameServer, clientCookie); res.Cookie != nil && res.Cookie.Status == CookieMismatch {
+			return res, zdns.STATUS_ERROR, errCookieMismatch
+		}
+		// if record comes back truncated, but we have a TCP connection, try again with that
+		if r != nil && (r.Truncated || r.Rcode == dns.RcodeBadTrunc) {
+			if opts.NoTCPFallback {
+				// keep what fit in the response, which is reported as
+				// truncated once parsed
+				truncated = true
+			} else if tcp != nil {
+				tcpRes, status, err := doLookupWorker(ctx, nil, tcp, dnsType, dnsClass, name, nameServer, recursive, opts)
+				if res.Amplification != nil {
+					if tcpRes.Amplification == nil {
+						tcpRes.Amplification = new(Amplification)
+					}
+					tcpRes.Amplification.UDP = res.Amplification.UDP
+				}
+				return tcpRes, status, err
+			} else {
+				return res, zdns.STATUS_TRUNCATED, err
+			}
+		}
+	} else {
+		res.Protocol = protocolName(tcp)
+		if opts.TCPPool != nil {
+			// ask the server how long the connection may stay open
+			if m.IsEdns0() == nil {
+				m.SetEdns0(dns.DefaultMsgSize, false)
+			}
+			edns := m.IsEdns0()
+			edns.Option = append(edns.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+			if opts.TSIG != nil {
+				opts.TSIG.Sign(m)
+			}
+			r, wire, err = exchangePooled(ctx, tcp, m, server, localAddr, opts)
+			if timeout, ok := keepaliveTimeout(r); ok {
+				ms := uint32(timeout / time.Millisecond)
+				res.TCPKeepalive = &ms
+			}
+		} else {
+			if opts.TSIG != nil {
+				opts.TSIG.Sign(m)
+			}
+			r, wire, err = exchange(ctx, tcp, m, server, localAddr, opts)
+		}
+		res.Duration = time.Since(start).Nanoseconds()
+		res.SourcePort = wire.port
+		res.TCPFastOpen = wire.fastOpen
+		if sizes := wire.sizes(); sizes != nil {
+			res.Amplification = &Amplification{TCP: sizes}
+		}
+		if res.Cookie = checkCookie(r, opts.Cookies, nameServer, clientCookie); res.Cookie != nil && res.Cookie.Status == CookieMismatch {
+			return res, zdns.STATUS_ERROR, errCookieMismatch
+		}
+	}
+	if opts.TSIG != nil {
+		if status, err := checkTSIG(r, err); status != zdns.STATUS_NOERROR {
+			return res, status, err
+		}
+	}
+	if errors.Is(err, dns.ErrId) {
+		return res, zdns.STATUS_ID_MISMATCH, err
+	}
+	if isQUICError(err) {
+		// some QUIC errors, e.g., an idle connection, are also timeouts,
+		// which must not be mistaken for lost datagrams
+		return res, zdns.STATUS_QUIC_ERROR, err
+	}
+	if err != nil || r == nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			// the socket may have been closed as the deadline passed,
+			// before its own read deadline fired
+			return res, zdns.STATUS_TIMEOUT, nil
+		}
+		if nerr, ok := err.(net.Error); ok {
+			if nerr.Timeout() {
+				return res, zdns.STATUS_TIMEOUT, nil
+			} else if nerr.Temporary() {
+				return res, zdns.STATUS_TEMPORARY, err
+			}
+		}
+		return res, zdns.STATUS_ERROR, err
+	}
+
+	if err != nil || r == nil {
+		return res, zdns.STATUS_ERROR, err
+	}
+	if opts.RandomizeCase && len(r.Question) > 0 && r.Question[0].Name != qname {
+		return res, zdns.STATUS_CASE_MISMATCH, fmt.Errorf("response question %s doesn't echo the case of query %s", r.Question[0].Name, qname)
+	}
+	if r.Rcode == dns.RcodeBadCookie && res.Cookie != nil && res.Cookie.Server != "" && !opts.retriedBadCookie {
+		// the server wants to see its current cookie, which we now have
+		opts.retriedBadCookie = true
+		return doLookupWorker(ctx, udp, tcp, dnsType, dnsClass, name, nameServer, recursive, opts)
+	}
+	if opts.RawResponse {
+		if wire, err := r.Pack(); err == nil {
+			res.RawResponse = wire
+		}
+	}
+	res.Flags.Response = r.Response
+	res.Flags.Opcode = r.Opcode
+	res.Flags.Authoritative = r.Authoritative
+	res.Flags.Truncated = r.Truncated
+	res.Flags.RecursionDesired = r.RecursionDesired
+	res.Flags.RecursionAvailable = r.RecursionAvailable
+	res.Flags.Authenticated = r.AuthenticatedData
+	res.Flags.CheckingDisabled = r.CheckingDisabled
+	res.Flags.ErrorCode = r.Rcode
+
+	res.NegativeTTL = negativeTTL(r.Ns)
+	res.NegativeSOA = soaRecord(r.Ns)
+	if r.Rcode != dns.RcodeSuccess {
+		if truncated {
+			return res, zdns.STATUS_TRUNCATED, nil
+		}
+		return res, TranslateMiekgErrorCode(r.Rcode), nil
+	}
+
+	if edns := r.IsEdns0(); edns != nil && opts.ClientSubnet != nil {
+		for _, o := range edns.Option {
+			if subnet, ok := o.(*dns.EDNS0_SUBNET); ok {
+				res.ClientSubnet = &ClientSubnet{
+					Family:       subnet.Family,
+					Address:      subnet.Address.String(),
+					SourcePrefix: subnet.SourceNetmask,
+					ScopePrefix:  subnet.SourceScope,
+				}
+			}
+		}
+	}
+
+	if edns := r.IsEdns0(); edns != nil && len(edns.Option) > 0 {
+		res.EDNSOptions = ednsOptions(edns)
+	}
+
+	for _, ans := range r.Answer {
+		inner := ParseAnswer(ans)
+		if inner != nil {
+			res.Answers = append(res.Answers, inner)
+		}
+	}
+	for _, ans := range r.Extra {
+		inner := ParseAnswer(ans)
+		if inner != nil {
+			res.Additional = append(res.Additional, inner)
+		}
+	}
+	for _, ans := range r.Ns {
+		inner := ParseAnswer(ans)
+		if inner != nil {
+			res.Authorities = append(res.Authorities, inner)
+		}
+	}
+	if truncated {
+		return res, zdns.STATUS_TRUNCATED, nil
+	}
+	return res, zdns.STATUS_NOERROR, nil
+}
+
+// ednsOptions returns the options of OPT record opt as they appear on the
+// wire, since miekg/dns only exposes the values of those it knows.
+func ednsOptions(opt *dns.OPT) []EDNSOption {
+	rdata, err := rdataOf(opt)
+	if err != nil {
+		return nil
+	}
+	var options []EDNSOption
+	for len(rdata) >= 4 {
+		code := binary.BigEndian.Uint16(rdata)
+		length := int(binary.BigEndian.Uint16(rdata[2:]))
+		if 4+length > len(rdata) {
+			break
+		}
+		options = append(options, EDNSOption{Code: code, Data: hex.EncodeToString(rdata[4 : 4+length])})
+		rdata = rdata[4+length:]
+	}
+	return options
+}
+
+// negativeTTL returns how long a negative answer may be cached (RFC 2308,
+// section 5): the lesser of the TTL and the minimum field of the SOA in its
+// authority section. Without an SOA, it must not be cached at all.
+func negativeTTL(ns []dns.RR) uint32 {
+	for _, rr := range ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if soa.Minttl < soa.Hdr.Ttl {
+				return soa.Minttl
+			}
+			return soa.Hdr.Ttl
+		}
+	}
+	return 0
+}
+
+// soaRecord returns the first SOA record of an authority section, if any.
+func soaRecord(ns []dns.RR) *SOAAnswer {
+	for _, rr := range ns {
+		if _, ok := rr.(*dns.SOA); ok {
+			soa := ParseAnswer(rr).(SOAAnswer)
+			return &soa
+		}
+	}
+	return nil
+}
+
+// isNegative reports whether a wire lookup got an NXDOMAIN or an
+// authoritative NODATA answer, and how long that can be cached.
+func isNegative(result Result, status zdns.Status) bool {
+	if result.NegativeTTL == 0 {
+		return false
+	}
+	return status == zdns.STATUS_NXDOMAIN || (status == zdns.STATUS_NOERROR && result.Flags.Authoritative && len(result.Answers) == 0)
+}
+
+// IsNoData reports whether a NOERROR result is a NODATA answer (RFC 2308,
+// section 2.2): the name exists, but has no records of dnsType. The answer
+// section is then empty, or holds only the CNAME and DNAME records leading
+// to a name without them. A referral, with NS but no SOA records in the
+// authority section, is not NODATA.
+func IsNoData(result Result, dnsType uint16) bool {
+	if dnsType == dns.TypeANY {
+		return len(result.Answers) == 0 && !isDelegation(result)
+	}
+	for _, a := range result.Answers {
+		ans, ok := a.(Answer)
+		if !ok || ans.rrType == dnsType || (ans.rrType != dns.TypeCNAME && ans.rrType != dns.TypeDNAME) {
+			return false
+		}
+	}
+	return !isDelegation(result)
+}
+
+// isDelegation reports whether the authority section of result delegates to
+// other name servers, rather than holding the SOA of a negative answer.
+func isDelegation(result Result) bool {
+	delegates := false
+	for _, a := range result.Authorities {
+		switch ans := a.(type) {
+		case SOAAnswer:
+			return false
+		case Answer:
+			delegates = delegates || ans.rrType == dns.TypeNS
+		}
+	}
+	return delegates
+}
+
+func (s *Lookup) SafeAddCachedAnswer(a interface{}, layer string, debugType string, depth int) {
+	ans, ok := a.(Answer)
+	if !ok {
+		s.VerboseLog(depth+1, "unable to cast ", debugType, ": ", layer, ": ", a)
+		return
+	}
+	ok, _ = nameIsBeneath(ans.Name, layer)
+	if !ok {
+		log.Info("detected poison ", debugType, ": ", ans.Name, "(", ans.Type, "): ", layer, ": ", a)
+		return
+	}
+	s.Factory.Factory.AddCachedAnswer(a, ans.Name, ans.rrType, ans.Ttl, depth, s.Factory.ThreadID)
+}
+
+func (s *Lookup) cacheUpdate(layer string, result Result, depth int) {
+	for _, a := range result.Additional {
+		s.SafeAddCachedAnswer(a, layer, "additional", depth)
+	}
+	for _, a := range result.Authorities {
+		s.SafeAddCachedAnswer(a, layer, "authority", depth)
+	}
+	if result.Flags.Authoritative == true {
+		for _, a := range result.Answers {
+			s.SafeAddCachedAnswer(a, layer, "anwer", depth)
+		}
+	}
+}
+
+func (s *Lookup) tracedRetryingLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, []interface{}, zdns.Status, error) {
+
+	res, status, err := s.retryingLookup(dnsType, dnsClass, name, nameServer, recursive)
+	s.noteNoData(res, status, dnsType)
+
+	trace := make([]interface{}, 0)
+
+	if s.Factory.Trace {
+		trace = append(trace, newTraceStep(res, status, err, false, dnsType, dnsClass, name, nameServer, name, 1, false))
+	}
+
+	return res, trace, status, err
+}
+
+// maximum number of times the retry backoff base is doubled
+const maxBackoffDoublings = 16
+
+// retryDelay returns how long to wait before retry number attempt (starting at
+// zero): exponential in attempt, with "equal jitter" so that the workers that
+// saw the same failure don't all retry in lockstep. A zero base means retry
+// immediately.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	if base <= 0 {
+		return 0
+	}
+	if attempt > maxBackoffDoublings {
+		attempt = maxBackoffDoublings
+	}
+	d := base << uint(attempt)
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// maxAttempts returns how many times a query may be sent while it fails with
+// status: --retries for timeouts and --retry-rcodes, unless overridden for the
+// class of failure, and once otherwise.
+func (f *RoutineLookupFactory) maxAttempts(status zdns.Status) int {
+	if n, ok := f.ClassRetries[status]; ok {
+		return n
+	}
+	if status == zdns.STATUS_TIMEOUT || status == zdns.STATUS_TEMPORARY || f.RetryRcodes[status] {
+		return f.Retries
+	}
+	return 1
+}
+
+func (s *Lookup) retryingLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, recursive bool) (Result, zdns.Status, error) {
 	s.VerboseLog(1, "****WIRE LOOKUP*** ", typeNames[dnsType], " ", name, " ", nameServer)
+	// retries of a query count once
+	if s.Factory.MaxQueriesPerName > 0 && s.queries >= s.Factory.MaxQueriesPerName {
+		s.queryLimited = true
+		return Result{}, zdns.STATUS_QUERY_LIMIT, nil
+	}
+	s.queries++
 
 	var origTimeout time.Duration
 	if s.Factory.Client != nil {
@@ -965,9 +2662,29 @@ func (s *Lookup) retryingLookup(dnsType uint16, dnsClass uint16, name string, na
 	} else {
 		origTimeout = s.Factory.TCPClient.Timeout
 	}
-	for i := 0; i < s.Factory.Retries; i++ {
+	start := time.Now()
+	for i := 0; ; i++ {
 		result, status, err := s.doLookup(dnsType, dnsClass, name, nameServer, recursive)
-		if (status != zdns.STATUS_TIMEOUT && status != zdns.STATUS_TEMPORARY) || i+1 == s.Factory.Retries {
+		result.TotalDuration = time.Since(start).Nanoseconds()
+		result.Attempts = i + 1
+		timedOut := status == zdns.STATUS_TIMEOUT || status == zdns.STATUS_TEMPORARY
+		if i+1 >= s.Factory.maxAttempts(status) {
+			if result.NegativeSOA != nil && (status == zdns.STATUS_NXDOMAIN || (status == zdns.STATUS_NOERROR && IsNoData(result, dnsType))) {
+				s.negativeSOA = result.NegativeSOA
+			}
+			if s.Factory.FullAnswer {
+				s.answers = append(s.answers, result.Answers...)
+			}
+			if result.Flags.Response {
+				s.responseFlags = &zdns.ResponseFlags{
+					Authoritative:      result.Flags.Authoritative,
+					Truncated:          result.Flags.Truncated,
+					RecursionDesired:   result.Flags.RecursionDesired,
+					RecursionAvailable: result.Flags.RecursionAvailable,
+					AuthenticatedData:  result.Flags.Authenticated,
+					CheckingDisabled:   result.Flags.CheckingDisabled,
+				}
+			}
 			if s.Factory.Client != nil {
 				s.Factory.Client.Timeout = origTimeout
 			}
@@ -976,14 +2693,20 @@ func (s *Lookup) retryingLookup(dnsType uint16, dnsClass uint16, name string, na
 			}
 			return result, status, err
 		}
+		s.Factory.Metrics.AddRetry()
+		if !timedOut {
+			// the server answered, so there's no reason to wait longer for it
+			time.Sleep(retryDelay(s.Factory.RcodeRetryBackoff, i))
+			continue
+		}
 		if s.Factory.Client != nil {
 			s.Factory.Client.Timeout = 2 * s.Factory.Client.Timeout
 		}
 		if s.Factory.TCPClient != nil {
 			s.Factory.TCPClient.Timeout = 2 * s.Factory.TCPClient.Timeout
 		}
+		time.Sleep(retryDelay(s.Factory.RetryBackoff, i))
 	}
-	panic("loop must return")
 }
 
 func (s *Lookup) cachedRetryingLookup(dnsType uint16, dnsClass uint16, name string, nameServer string, layer string, depth int) (Result, IsCached, zdns.Status, error) {
@@ -999,8 +2722,18 @@ func (s *Lookup) cachedRetryingLookup(dnsType uint16, dnsClass uint16, name stri
 	cachedResult, ok := s.Factory.Factory.GetCachedResult(name, dnsType, false, depth+1, s.Factory.ThreadID)
 	if ok {
 		isCached = true
+		s.countCacheLookup(isCached)
 		return cachedResult, isCached, zdns.STATUS_NOERROR, nil
 	}
+	if status, ok := s.Factory.Factory.GetNegativeCachedResult(name, dnsType, depth+1, s.Factory.ThreadID); ok {
+		isCached = true
+		s.countCacheLookup(isCached)
+		r := Result{Answers: []interface{}{}, Authorities: []interface{}{}, Additional: []interface{}{}}
+		// a NODATA answer came from the zone's authority, so no further
+		// iteration is needed
+		r.Flags.Authoritative = true
+		return r, isCached, status, nil
+	}
 
 	nameServerIP, _, err := net.SplitHostPort(nameServer)
 	// Stop if we hit a nameserver we don't want to hit
@@ -1039,15 +2772,20 @@ func (s *Lookup) cachedRetryingLookup(dnsType uint16, dnsClass uint16, name stri
 		cachedResult, ok = s.Factory.Factory.GetCachedResult(authName, dns.TypeNS, true, depth+2, s.Factory.ThreadID)
 		if ok {
 			isCached = true
+			s.countCacheLookup(isCached)
 			return cachedResult, isCached, zdns.STATUS_NOERROR, nil
 		}
 	}
 
 	// Alright, we're not sure what to do, go to the wire.
 	s.VerboseLog(depth+2, "Wire lookup for name: ", name, " (", dnsType, ") at nameserver: ", nameServer)
+	s.countCacheLookup(isCached)
 	result, status, err := s.retryingLookup(dnsType, dnsClass, name, nameServer, false)
 
 	s.cacheUpdate(layer, result, depth+2)
+	if isNegative(result, status) {
+		s.Factory.Factory.AddNegativeCachedResult(name, dnsType, status, result.NegativeTTL, depth+2, s.Factory.ThreadID)
+	}
 	return result, isCached, status, err
 }
 
@@ -1065,6 +2803,33 @@ func nameIsBeneath(name string, layer string) (bool, string) {
 	return false, ""
 }
 
+// minimizedQuery returns the query to send to the servers of layer when
+// resolving name with QNAME minimization (RFC 7816): an NS query for the name
+// one label below layer, or the original query once that is the full name.
+func minimizedQuery(name string, layer string, dnsType uint16) (string, uint16) {
+	next, err := nextAuthority(strings.ToLower(name), strings.ToLower(layer))
+	if err != nil || next == "" || strings.EqualFold(next, name) {
+		return name, dnsType
+	}
+	return next, dns.TypeNS
+}
+
+// isReferral reports whether the response to a minimized NS query for qname
+// delegates qname. Servers authoritative for both sides of the cut, and the
+// cache, return the NS records as answers rather than authorities.
+func isReferral(result Result, qname string) bool {
+	if len(result.Answers) == 0 {
+		return !result.Flags.Authoritative && len(result.Authorities) != 0
+	}
+	for _, a := range result.Answers {
+		ans, ok := a.(Answer)
+		if !ok || ans.Type != "NS" || !strings.EqualFold(strings.TrimSuffix(ans.Name, "."), qname) {
+			return false
+		}
+	}
+	return true
+}
+
 func nextAuthority(name string, layer string) (string, error) {
 	// We are our own authority for PTRs
 	// (This is dealt with elsewhere)
@@ -1141,7 +2906,7 @@ func (s *Lookup) extractAuthority(authority interface{}, layer string, depth int
 		// Fall through to normal query
 		res, trace, status, _ = s.iterativeLookup(dns.TypeA, dns.ClassINET, server, s.NameServer, depth+1, ".", trace)
 	}
-	if status == zdns.STATUS_ITER_TIMEOUT {
+	if status == zdns.STATUS_ITER_TIMEOUT || status == zdns.STATUS_QUERY_LIMIT {
 		return "", status, "", trace
 	}
 	if status == zdns.STATUS_NOERROR {
@@ -1171,7 +2936,7 @@ func debugReverseLookup(name string) string {
 
 func handleStatus(status *zdns.Status, err error) (*zdns.Status, error) {
 	switch *status {
-	case zdns.STATUS_ITER_TIMEOUT:
+	case zdns.STATUS_ITER_TIMEOUT, zdns.STATUS_QUERY_LIMIT:
 		return status, err
 	case zdns.STATUS_NXDOMAIN:
 		return status, nil
@@ -1261,19 +3026,45 @@ func (s *Lookup) iterativeLookup(dnsType uint16, dnsClass uint16, name string, n
 		s.VerboseLog((depth + 1), "-> Max recursion depth reached")
 		return r, trace, zdns.STATUS_ERROR, errors.New("Max recursion depth reached")
 	}
-	result, isCached, status, err := s.cachedRetryingLookup(dnsType, dnsClass, name, nameServer, layer, depth)
-	if s.Factory.Trace && status == zdns.STATUS_NOERROR {
-		var t TraceStep
-		t.Result = result
-		t.DnsType = dnsType
-		t.DnsClass = dnsClass
-		t.Name = name
-		t.NameServer = nameServer
-		t.Layer = layer
-		t.Depth = depth
-		t.Cached = isCached
-		trace = append(trace, t)
-
+	qname, qtype := name, dnsType
+	if s.Factory.QNameMinimization {
+		qname, qtype = minimizedQuery(name, layer, dnsType)
+	}
+	result, isCached, status, err := s.cachedRetryingLookup(qtype, dnsClass, qname, nameServer, layer, depth)
+	if s.Factory.Trace {
+		trace = append(trace, newTraceStep(result, status, err, isCached, qtype, dnsClass, qname, nameServer, layer, depth, qname != name))
+	}
+	for qname != name {
+		if status == zdns.STATUS_NOERROR && isReferral(result, qname) {
+			// qname is a zone cut: follow the referral, minimizing again
+			// below it
+			if len(result.Answers) != 0 {
+				result.Authorities = result.Answers
+				result.Answers = make([]interface{}, 0)
+			}
+			result.Flags.Authoritative = false
+			break
+		}
+		if status == zdns.STATUS_NOERROR {
+			// no zone cut at qname, so the same servers are asked about
+			// one more label of the name
+			s.VerboseLog((depth + 1), "-> no zone cut at ", qname)
+			qname, qtype = minimizedQuery(name, qname, dnsType)
+		} else {
+			// servers that mishandle empty non-terminals can fail the
+			// minimized query; fall back to asking for the full name
+			s.VerboseLog((depth + 1), "-> minimized query for ", qname, " failed, sending full query")
+			qname, qtype = name, dnsType
+		}
+		result, isCached, status, err = s.cachedRetryingLookup(qtype, dnsClass, qname, nameServer, layer, depth)
+		if s.Factory.Trace {
+			trace = append(trace, newTraceStep(result, status, err, isCached, qtype, dnsClass, qname, nameServer, layer, depth, qname != name))
+		}
+	}
+	if result.Flags.Authoritative && !bool(isCached) && (status == zdns.STATUS_NOERROR || status == zdns.STATUS_NXDOMAIN) {
+		if ip, _, err := net.SplitHostPort(nameServer); err == nil {
+			result.AuthoritativeServer = ip
+		}
 	}
 	if status != zdns.STATUS_NOERROR {
 		s.VerboseLog((depth + 1), "-> error occurred during lookup")
@@ -1302,6 +3093,16 @@ func (s *Lookup) iterativeLookup(dnsType uint16, dnsClass uint16, name string, n
 	}
 }
 
+// attachDNSSEC adds the DNSSEC validation state of an iterative lookup's
+// answer, or proof of nonexistence, to its result.
+func (s *Lookup) attachDNSSEC(result Result, name string, dnsType uint16, dnsClass uint16, status zdns.Status) Result {
+	if !s.Factory.DNSSECValidate || (status != zdns.STATUS_NOERROR && status != zdns.STATUS_NXDOMAIN) {
+		return result
+	}
+	result.DNSSEC = s.validateDNSSEC(name, dnsType, dnsClass)
+	return result
+}
+
 func (s *Lookup) DoMiekgLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
 	if s.DNSType == dns.TypePTR {
 		var err error
@@ -1315,6 +3116,8 @@ func (s *Lookup) DoMiekgLookup(name string) (interface{}, []interface{}, zdns.St
 		s.VerboseLog(0, "MIEKG-IN: iterative lookup for ", name, " (", s.DNSType, ")")
 		s.IterativeStop = time.Now().Add(time.Duration(s.Factory.IterativeTimeout))
 		result, trace, status, err := s.iterativeLookup(s.DNSType, s.DNSClass, name, s.NameServer, 1, ".", make([]interface{}, 0))
+		result = s.attachDNSSEC(result, name, s.DNSType, s.DNSClass, status)
+		s.noteNoData(result, status, s.DNSType)
 		s.VerboseLog(0, "MIEKG-OUT: iterative lookup for ", name, " (", s.DNSType, "): status: ", status, " , err: ", err)
 		if s.Factory.Trace {
 			return result, trace, status, err
@@ -1322,7 +3125,7 @@ func (s *Lookup) DoMiekgLookup(name string) (interface{}, []interface{}, zdns.St
 		return result, trace, status, err
 
 	} else {
-		return s.tracedRetryingLookup(s.DNSType, s.DNSClass, name, s.NameServer, true)
+		return s.tracedRetryingLookup(s.DNSType, s.DNSClass, name, s.NameServer, !s.Factory.NoRecurse)
 	}
 }
 
@@ -1331,6 +3134,8 @@ func (s *Lookup) DoMiekgLookupForClass(name string, dnsClass uint16) (interface{
 		s.VerboseLog(0, "MIEKG-IN: iterative lookup for ", name, " (", s.DNSType, ") in class ", dnsClass)
 		s.IterativeStop = time.Now().Add(time.Duration(s.Factory.IterativeTimeout))
 		result, trace, status, err := s.iterativeLookup(s.DNSType, s.DNSClass, name, s.NameServer, 1, ".", make([]interface{}, 0))
+		result = s.attachDNSSEC(result, name, s.DNSType, s.DNSClass, status)
+		s.noteNoData(result, status, s.DNSType)
 		s.VerboseLog(0, "MIEKG-OUT: iterative lookup for ", name, " (", s.DNSType, "): status: ", status, " , err: ", err)
 		if s.Factory.Trace {
 			return result, trace, status, err
@@ -1338,25 +3143,33 @@ func (s *Lookup) DoMiekgLookupForClass(name string, dnsClass uint16) (interface{
 		return result, trace, status, err
 
 	} else {
-		return s.tracedRetryingLookup(s.DNSType, s.DNSClass, name, s.NameServer, true)
+		return s.tracedRetryingLookup(s.DNSType, s.DNSClass, name, s.NameServer, !s.Factory.NoRecurse)
 	}
 }
 
 func (s *Lookup) DoTypedMiekgLookup(name string, dnsType uint16) (interface{}, []interface{}, zdns.Status, error) {
+	return s.DoTargetedMiekgLookup(name, dnsType, s.NameServer)
+}
+
+// DoTargetedMiekgLookup is like DoTypedMiekgLookup, but queries nameServer
+// rather than the lookup's own name server.
+func (s *Lookup) DoTargetedMiekgLookup(name string, dnsType uint16, nameServer string) (interface{}, []interface{}, zdns.Status, error) {
 	if s.Factory == nil {
 		panic("factory not defined")
 	}
 	if s.Factory.IterativeResolution {
 		s.VerboseLog(0, "MIEKG-IN: iterative lookup for ", name, " (", dnsType, ")")
 		s.IterativeStop = time.Now().Add(time.Duration(s.Factory.IterativeTimeout))
-		result, trace, status, err := s.iterativeLookup(dnsType, s.DNSClass, name, s.NameServer, 1, ".", make([]interface{}, 0))
+		result, trace, status, err := s.iterativeLookup(dnsType, s.DNSClass, name, nameServer, 1, ".", make([]interface{}, 0))
+		result = s.attachDNSSEC(result, name, dnsType, s.DNSClass, status)
+		s.noteNoData(result, status, dnsType)
 		s.VerboseLog(0, "MIEKG-OUT: iterative lookup for ", name, " (", dnsType, "): status: ", status, " , err: ", err)
 		if s.Factory.Trace {
 			return result, trace, status, err
 		}
 		return result, trace, status, err
 	} else {
-		return s.tracedRetryingLookup(dnsType, s.DNSClass, name, s.NameServer, true)
+		return s.tracedRetryingLookup(dnsType, s.DNSClass, name, nameServer, !s.Factory.NoRecurse)
 	}
 }
 
@@ -1368,16 +3181,24 @@ func (s *Lookup) DoTypedMiekgLookupInClass(name string, dnsType uint16, dnsClass
 		s.VerboseLog(0, "MIEKG-IN: iterative lookup for ", name, " (", dnsType, ") in class ", dnsClass)
 		s.IterativeStop = time.Now().Add(time.Duration(s.Factory.IterativeTimeout))
 		result, trace, status, err := s.iterativeLookup(dnsType, dnsClass, name, s.NameServer, 1, ".", make([]interface{}, 0))
+		result = s.attachDNSSEC(result, name, dnsType, dnsClass, status)
+		s.noteNoData(result, status, dnsType)
 		s.VerboseLog(0, "MIEKG-OUT: iterative lookup for ", name, " (", dnsType, "): status: ", status, " , err: ", err)
 		if s.Factory.Trace {
 			return result, trace, status, err
 		}
 		return result, trace, status, err
 	} else {
-		return s.tracedRetryingLookup(dnsType, dnsClass, name, s.NameServer, true)
+		return s.tracedRetryingLookup(dnsType, dnsClass, name, s.NameServer, !s.Factory.NoRecurse)
 	}
 }
 
+// DoServerLookup asks nameServer (host:port) directly for name without
+// requesting recursion, e.g., to query each authoritative server of a zone.
+func (s *Lookup) DoServerLookup(name string, dnsType uint16, nameServer string) (Result, []interface{}, zdns.Status, error) {
+	return s.tracedRetryingLookup(dnsType, s.DNSClass, name, nameServer, false)
+}
+
 func (s *Lookup) DoTxtLookup(name string) (string, []interface{}, zdns.Status, error) {
 	res, trace, status, err := s.DoMiekgLookup(name)
 	if status != zdns.STATUS_NOERROR {
@@ -1394,15 +3215,102 @@ func (s *Lookup) DoTxtLookup(name string) (string, []interface{}, zdns.Status, e
 	return "", trace, zdns.STATUS_NO_RECORD, nil
 }
 
-// allow miekg to be used as a ZDNS module
+// DoTxtLookupAll returns every TXT record of name that starts with Prefix,
+// e.g., to detect a policy that is published more than once.
+func (s *Lookup) DoTxtLookupAll(name string) ([]string, []interface{}, zdns.Status, error) {
+	res, trace, status, err := s.DoMiekgLookup(name)
+	if status != zdns.STATUS_NOERROR {
+		return nil, trace, status, err
+	}
+	var records []string
+	if parsedResult, ok := res.(Result); ok {
+		for _, a := range parsedResult.Answers {
+			ans, _ := a.(Answer)
+			if ans.Type == "TXT" && strings.HasPrefix(ans.Answer, s.Prefix) {
+				records = append(records, ans.Answer)
+			}
+		}
+	}
+	if len(records) == 0 {
+		return nil, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return records, trace, zdns.STATUS_NOERROR, err
+}
+
+// allow miekg to be used as a ZDNS module. Unlike the lookups other modules
+// build on, it tells NODATA answers apart from those with records.
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
-	return s.DoMiekgLookup(name)
+	res, trace, status, err := s.DoMiekgLookup(name)
+	if result, ok := res.(Result); ok && status == zdns.STATUS_NOERROR && IsNoData(result, s.DNSType) {
+		status = zdns.STATUS_NODATA
+	}
+	return res, trace, status, err
 }
 
 func (s *GlobalLookupFactory) Help() string {
 	return ""
 }
 
+// ResultType describes the data of the results, for --dump-schema.
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// RawLookupFactory looks up an arbitrary record type, given by the flag
+// named flagName (--raw-type for RAW, --query-type for MIEKG). Types without
+// a specific parser are output as UnknownAnswer.
+type RawLookupFactory struct {
+	GlobalLookupFactory
+	RawType  string
+	flagName string
+}
+
+func (s *RawLookupFactory) AddFlags(f *flag.FlagSet) {
+	s.GlobalLookupFactory.AddFlags(f)
+	f.StringVar(&s.RawType, s.flagName, "", "record type to look up, as a number (e.g., 65280), a mnemonic (e.g., CAA), or TYPEnnn (e.g., TYPE38 for A6)")
+}
+
+func (s *RawLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	dnsType, err := parseRawType(s.flagName, s.RawType)
+	if err != nil {
+		return err
+	}
+	if err := s.GlobalLookupFactory.Initialize(c); err != nil {
+		return err
+	}
+	s.SetDNSType(dnsType)
+	return nil
+}
+
+func (s *RawLookupFactory) Help() string {
+	return "looks up the record type given by --" + s.flagName + "; records of types without a specific parser are output in the generic format of RFC 3597"
+}
+
+// parseRawType parses the value of the type flag flagName, given as a
+// number, a mnemonic, or in the TYPEnnn form of RFC 3597. Types that can't
+// be asked for in an ordinary query are rejected.
+func parseRawType(flagName, s string) (uint16, error) {
+	if s == "" {
+		return 0, errors.New("--" + flagName + " is required")
+	}
+	s = strings.ToUpper(s)
+	t, ok := dns.StringToType[s]
+	if !ok {
+		n, err := strconv.ParseUint(strings.TrimPrefix(s, "TYPE"), 10, 16)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("invalid --%s %q: expected a type number between 1 and 65535, a mnemonic, or TYPEnnn", flagName, s)
+		}
+		t = uint16(n)
+	}
+	switch t {
+	case dns.TypeOPT, dns.TypeTSIG, dns.TypeTKEY, dns.TypeIXFR:
+		return 0, fmt.Errorf("invalid --%s %q: not a type that can be queried", flagName, s)
+	case dns.TypeAXFR:
+		return 0, fmt.Errorf("invalid --%s %q: use the AXFR module for zone transfers", flagName, s)
+	}
+	return t, nil
+}
+
 // let's register some modules!
 func init() {
 	a := new(GlobalLookupFactory)
@@ -1492,4 +3400,10 @@ func init() {
 	rrsig := new(GlobalLookupFactory)
 	rrsig.SetDNSType(dns.TypeRRSIG)
 	zdns.RegisterLookup("RRSIG", rrsig)
+
+	raw := &RawLookupFactory{flagName: "raw-type"}
+	zdns.RegisterLookup("RAW", raw)
+
+	generic := &RawLookupFactory{flagName: "query-type"}
+	zdns.RegisterLookup("MIEKG", generic)
 }
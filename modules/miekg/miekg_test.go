@@ -1,9 +1,28 @@
 package miekg
 
 import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+	"github.com/zmap/zdns"
+	"io"
+	"io/ioutil"
+	"math"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestParseAnswer(t *testing.T) {
@@ -139,6 +158,80 @@ func TestParseAnswer(t *testing.T) {
 	// TODO: test remaining RR types
 }
 
+func TestParseUnknownAnswer(t *testing.T) {
+	rr := &dns.RFC3597{
+		Hdr: dns.RR_Header{
+			Name:   "unknown.example.com.",
+			Rrtype: 65280,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		Rdata: "c000020100ff",
+	}
+	answer, ok := ParseAnswer(rr).(UnknownAnswer)
+	if !ok {
+		t.Fatal("Failed to parse record")
+	}
+	if answer.Name != "unknown.example.com" || answer.Type != "TYPE65280" || answer.TypeNumber != 65280 {
+		t.Errorf("Unexpected header: %+v", answer.Answer)
+	}
+	if answer.RDLength != 6 || answer.RData != "c000020100ff" {
+		t.Errorf("Unexpected RDATA. Expected 6 bytes c000020100ff, got %d bytes %s", answer.RDLength, answer.RData)
+	}
+	if answer.Answer.Answer != "\\# 6 c000020100ff" {
+		t.Errorf("Unexpected answer: %s", answer.Answer.Answer)
+	}
+
+	// empty RDATA
+	rr.Rdata = ""
+	answer = ParseAnswer(rr).(UnknownAnswer)
+	if answer.RDLength != 0 || answer.RData != "" || answer.Answer.Answer != "\\# 0 " {
+		t.Errorf("Unexpected empty RDATA: %+v", answer)
+	}
+}
+
+func TestParseKnownUnparsedAnswer(t *testing.T) {
+	rr := &dns.HINFO{
+		Hdr: dns.RR_Header{
+			Name:   "host.example.com.",
+			Rrtype: dns.TypeHINFO,
+			Class:  dns.ClassINET,
+			Ttl:    60,
+		},
+		Cpu: "x86",
+		Os:  "linux",
+	}
+	answer, ok := ParseAnswer(rr).(UnknownAnswer)
+	if !ok {
+		t.Fatal("Failed to parse record")
+	}
+	if answer.Type != "HINFO" || answer.RDLength != 10 || answer.RData != "03783836056c696e7578" {
+		t.Errorf("Unexpected record: %+v", answer)
+	}
+	if answer.Answer.Answer != "\"x86\" \"linux\"" {
+		t.Errorf("Unexpected answer: %s", answer.Answer.Answer)
+	}
+}
+
+func TestParseRawType(t *testing.T) {
+	tests := map[string]uint16{
+		"38":        38,
+		"caa":       dns.TypeCAA,
+		"TYPE65280": 65280,
+		"type1":     dns.TypeA,
+	}
+	for s, expected := range tests {
+		if got, err := parseRawType("query-type", s); err != nil || got != expected {
+			t.Errorf("%s: expected %d, got %d (%v)", s, expected, got, err)
+		}
+	}
+	for _, s := range []string{"", "0", "65536", "TYPE", "NOTATYPE", "AXFR", "OPT", "41"} {
+		if _, err := parseRawType("query-type", s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
 func verifyResult(t *testing.T, answer interface{}, original dns.RR, expectedAnswer string) {
 	ans, ok := answer.(Answer)
 	if !ok {
@@ -165,3 +258,1190 @@ func verifyResult(t *testing.T, answer interface{}, original dns.RR, expectedAns
 		t.Errorf("Unxpected answer. Expected %v, got %v", expectedAnswer, ans.Answer)
 	}
 }
+
+func TestRetryDelay(t *testing.T) {
+	if d := retryDelay(0, 3); d != 0 {
+		t.Errorf("Expected immediate retry without a backoff, got %v", d)
+	}
+	base := 100 * time.Millisecond
+	for attempt := 0; attempt < 4; attempt++ {
+		max := base << uint(attempt)
+		for i := 0; i < 100; i++ {
+			d := retryDelay(base, attempt)
+			if d < max/2 || d > max {
+				t.Fatalf("Retry delay %v for attempt %d outside [%v, %v]", d, attempt, max/2, max)
+			}
+		}
+	}
+	if d := retryDelay(time.Second, 1000); d <= 0 {
+		t.Errorf("Expected a positive capped delay, got %v", d)
+	}
+}
+
+func TestMaxAttempts(t *testing.T) {
+	f := &RoutineLookupFactory{
+		Retries:      3,
+		RetryRcodes:  map[zdns.Status]bool{zdns.STATUS_REFUSED: true},
+		ClassRetries: map[zdns.Status]int{zdns.STATUS_SERVFAIL: 5},
+	}
+	cases := map[zdns.Status]int{
+		zdns.STATUS_NOERROR:   1,
+		zdns.STATUS_NXDOMAIN:  1,
+		zdns.STATUS_TIMEOUT:   3,
+		zdns.STATUS_TEMPORARY: 3,
+		zdns.STATUS_REFUSED:   3,
+		zdns.STATUS_SERVFAIL:  5,
+	}
+	for status, expected := range cases {
+		if n := f.maxAttempts(status); n != expected {
+			t.Errorf("expected %d attempts on %s, got %d", expected, status, n)
+		}
+	}
+}
+
+func TestPickLocalAddr(t *testing.T) {
+	if a, err := pickLocalAddr(nil, "192.0.2.53:53"); a != nil || err != nil {
+		t.Errorf("Expected no local address without configuration, got %v, %v", a, err)
+	}
+	addrs := []net.IP{net.ParseIP("198.51.100.1"), net.ParseIP("2001:db8::1"), net.ParseIP("198.51.100.2")}
+	seen := make(map[string]bool)
+	for i := 0; i < 4; i++ {
+		a, err := pickLocalAddr(addrs, "192.0.2.53:53")
+		if err != nil || a.To4() == nil {
+			t.Fatalf("Expected an IPv4 local address, got %v, %v", a, err)
+		}
+		seen[a.String()] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("Expected queries to alternate between both IPv4 addresses, got %v", seen)
+	}
+	if a, err := pickLocalAddr(addrs, "[2001:db8::53]:53"); err != nil || !a.Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("Expected the IPv6 local address, got %v, %v", a, err)
+	}
+	if _, err := pickLocalAddr(addrs[:1], "[2001:db8::53]:53"); err == nil {
+		t.Error("Expected an address family mismatch to be rejected")
+	}
+}
+
+func TestCheckCookie(t *testing.T) {
+	jar, err := zdns.NewCookieJar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := "192.0.2.53:53"
+	client := jar.ClientCookie(ns)
+	if len(client) != 16 || client == jar.ClientCookie("192.0.2.54:53") {
+		t.Errorf("Expected distinct 8 byte client cookies per server, got %s", client)
+	}
+	reply := func(cookie string) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetEdns0(dns.DefaultMsgSize, false)
+		if cookie != "" {
+			opt := r.IsEdns0()
+			opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: cookie})
+		}
+		return r
+	}
+	if c := checkCookie(reply(""), jar, ns, client); c.Status != CookieMissing {
+		t.Errorf("Expected server without cookie support to be tolerated, got %+v", c)
+	}
+	server := "0123456789abcdef0123456789abcdef"
+	if c := checkCookie(reply(client+server), jar, ns, client); c.Status != CookieValid || c.Server != server {
+		t.Errorf("Expected valid cookie, got %+v", c)
+	}
+	if jar.ServerCookie(ns) != server {
+		t.Errorf("Expected server cookie to be remembered, got %s", jar.ServerCookie(ns))
+	}
+	if c := checkCookie(reply("ffffffffffffffff"+server), jar, ns, client); c.Status != CookieMismatch {
+		t.Errorf("Expected foreign client cookie to be a mismatch, got %+v", c)
+	}
+	if c := checkCookie(reply(client), nil, ns, client); c != nil {
+		t.Errorf("Expected no cookie report without a jar, got %+v", c)
+	}
+}
+
+func TestNegativeTTL(t *testing.T) {
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns.example.com.",
+		Mbox:   "hostmaster.example.com.",
+		Minttl: 300,
+	}
+	if ttl := negativeTTL([]dns.RR{soa}); ttl != 300 {
+		t.Errorf("expected the SOA minimum of 300, got %d", ttl)
+	}
+	soa.Hdr.Ttl = 60
+	if ttl := negativeTTL([]dns.RR{soa}); ttl != 60 {
+		t.Errorf("expected the SOA TTL of 60, got %d", ttl)
+	}
+	if ttl := negativeTTL(nil); ttl != 0 {
+		t.Errorf("expected no negative TTL without an SOA, got %d", ttl)
+	}
+}
+
+func newCacheFactory() *GlobalLookupFactory {
+	f := new(GlobalLookupFactory)
+	f.GlobalConf = new(zdns.GlobalConf)
+	f.IterativeCache.Init(10)
+	f.CacheMutex = &sync.RWMutex{}
+	return f
+}
+
+func TestCacheFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zdns-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.json")
+
+	f := newCacheFactory()
+	if err := f.loadCache(path); err != nil {
+		t.Fatalf("a missing cache file should be ignored: %v", err)
+	}
+	a := ParseAnswer(&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	f.AddCachedAnswer(a, "example.com", dns.TypeA, 300, 0, 0)
+	f.AddNegativeCachedResult("missing.example.com", dns.TypeA, zdns.STATUS_NXDOMAIN, 60, 0, 0)
+	if err := f.saveCache(path); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newCacheFactory()
+	if err := g.loadCache(path); err != nil {
+		t.Fatal(err)
+	}
+	res, ok := g.GetCachedResult("example.com", dns.TypeA, false, 0, 0)
+	if !ok || len(res.Answers) != 1 || res.Answers[0] != a {
+		t.Errorf("positive entry not restored: %v", res.Answers)
+	}
+	if status, ok := g.GetNegativeCachedResult("missing.example.com", dns.TypeA, 0, 0); !ok || status != zdns.STATUS_NXDOMAIN {
+		t.Errorf("negative entry not restored: %v %v", ok, status)
+	}
+
+	// entries whose TTL ran out between runs are dropped on load
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cf cacheFile
+	if err := json.Unmarshal(raw, &cf); err != nil {
+		t.Fatal(err)
+	}
+	h := newCacheFactory()
+	h.loadCacheEntries(cf.Entries, time.Now().Add(2*time.Minute))
+	if h.IterativeCache.Len() != 1 {
+		t.Errorf("expected only the positive entry to outlive its TTL, got %d entries", h.IterativeCache.Len())
+	}
+	if _, ok := h.GetNegativeCachedResult("missing.example.com", dns.TypeA, 0, 0); ok {
+		t.Error("expired negative entry was loaded")
+	}
+}
+
+func TestFinalizeClosesMetrics(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	f := newCacheFactory()
+	f.GlobalConf.Metrics = zdns.NewMetrics()
+	if err := f.GlobalConf.Metrics.Listen(addr); err != nil {
+		t.Skip(err)
+	}
+	if err := f.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := http.Get("http://" + addr + "/metrics"); err == nil {
+		resp.Body.Close()
+		t.Error("metrics endpoint still served after Finalize")
+	}
+}
+
+func TestMinimizedQuery(t *testing.T) {
+	cases := []struct {
+		layer string
+		qname string
+		qtype uint16
+	}{
+		{".", "com", dns.TypeNS},
+		{"com", "example.com", dns.TypeNS},
+		{"example.com", "www.example.com", dns.TypeA},
+	}
+	for _, c := range cases {
+		qname, qtype := minimizedQuery("www.example.com", c.layer, dns.TypeA)
+		if qname != c.qname || qtype != c.qtype {
+			t.Errorf("layer %s: got %s/%d, expected %s/%d", c.layer, qname, qtype, c.qname, c.qtype)
+		}
+	}
+}
+
+func TestIsReferral(t *testing.T) {
+	ns := Answer{Type: "NS", Name: "example.com", Answer: "ns1.example.com"}
+	referral := Result{Authorities: []interface{}{ns}}
+	if !isReferral(referral, "example.com") {
+		t.Error("delegation in authorities not treated as a referral")
+	}
+	answered := Result{Answers: []interface{}{ns}}
+	answered.Flags.Authoritative = true
+	if !isReferral(answered, "example.com") {
+		t.Error("NS records answered for qname not treated as a referral")
+	}
+	nodata := Result{Authorities: []interface{}{Answer{Type: "SOA", Name: "example.com"}}}
+	nodata.Flags.Authoritative = true
+	if isReferral(nodata, "www.example.com") {
+		t.Error("authoritative NODATA treated as a referral")
+	}
+}
+
+func TestRandomizeCase(t *testing.T) {
+	name := "www-1.example.com."
+	changed := false
+	for i := 0; i < 20; i++ {
+		r := randomizeCase(name)
+		if !strings.EqualFold(r, name) {
+			t.Fatalf("%s is not %s with its case changed", r, name)
+		}
+		if r != name {
+			changed = true
+		}
+	}
+	if !changed {
+		t.Error("case was never randomized")
+	}
+}
+
+func TestNewTraceStep(t *testing.T) {
+	referral := Result{Authorities: []interface{}{
+		Answer{Type: "NS", Name: "example.com", Answer: "a.iana-servers.net."},
+		Answer{Type: "NS", Name: "example.com", Answer: "b.iana-servers.net."},
+	}}
+	step := newTraceStep(referral, zdns.STATUS_NOERROR, nil, false, dns.TypeA, dns.ClassINET, "www.example.com", "192.5.6.30:53", "com", 2, false)
+	if step.Status != "NOERROR" || len(step.Referral) != 2 || step.Referral[0] != "a.iana-servers.net" {
+		t.Errorf("unexpected referral step: %+v", step)
+	}
+	step = newTraceStep(Result{}, zdns.STATUS_SERVFAIL, nil, false, dns.TypeA, dns.ClassINET, "www.example.com", "192.0.2.1:53", "example.com", 3, false)
+	if step.Status != "SERVFAIL" || step.Referral != nil {
+		t.Errorf("unexpected failed step: %+v", step)
+	}
+}
+
+func TestFollowCNAMEs(t *testing.T) {
+	answers := []interface{}{
+		Answer{Type: "CNAME", Name: "www.example.org", Answer: "cdn.example.net.", Ttl: 300},
+		Answer{Type: "A", Name: "edge.example.com", Answer: "192.0.2.10"},
+		Answer{Type: "CNAME", Name: "cdn.example.net", Answer: "edge.example.com.", Ttl: 60},
+	}
+	chain, target, err := FollowCNAMEs(answers, "www.example.org")
+	if err != nil || target != "edge.example.com" || len(chain) != 2 || chain[1].Name != "cdn.example.net" || chain[1].Ttl != 60 {
+		t.Errorf("unexpected chain %v to %s: %v", chain, target, err)
+	}
+	chain, target, err = FollowCNAMEs(answers, "edge.example.com")
+	if err != nil || target != "edge.example.com" || len(chain) != 0 {
+		t.Errorf("unexpected chain %v to %s: %v", chain, target, err)
+	}
+	loop := []interface{}{
+		Answer{Type: "CNAME", Name: "a.example.org", Answer: "b.example.org."},
+		Answer{Type: "CNAME", Name: "b.example.org", Answer: "A.example.org."},
+	}
+	if _, _, err = FollowCNAMEs(loop, "a.example.org"); err == nil || err.Error() != "CNAME loop: a.example.org -> b.example.org -> A.example.org" {
+		t.Errorf("unexpected loop error: %v", err)
+	}
+}
+
+func TestKeepaliveTimeout(t *testing.T) {
+	r := new(dns.Msg)
+	if _, ok := keepaliveTimeout(r); ok {
+		t.Error("unexpected keepalive timeout without EDNS0")
+	}
+	r.SetEdns0(dns.DefaultMsgSize, false)
+	if _, ok := keepaliveTimeout(r); ok {
+		t.Error("unexpected keepalive timeout without the option")
+	}
+	edns := r.IsEdns0()
+	edns.Option = append(edns.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE, Timeout: 150})
+	if timeout, ok := keepaliveTimeout(r); !ok || timeout != 15*time.Second {
+		t.Errorf("expected a 15s keepalive timeout, got %v", timeout)
+	}
+}
+
+func TestEDNSOptions(t *testing.T) {
+	r := new(dns.Msg)
+	r.SetEdns0(dns.DefaultMsgSize, false)
+	edns := r.IsEdns0()
+	edns.Option = append(edns.Option,
+		&dns.EDNS0_NSID{Code: dns.EDNS0NSID, Nsid: "6e7331"},
+		&dns.EDNS0_LOCAL{Code: 65001, Data: []byte{0xc0, 0xff, 0xee}},
+		&dns.EDNS0_LOCAL{Code: 65002})
+	want := []EDNSOption{{Code: dns.EDNS0NSID, Data: "6e7331"}, {Code: 65001, Data: "c0ffee"}, {Code: 65002, Data: ""}}
+	if got := ednsOptions(edns); !reflect.DeepEqual(got, want) {
+		t.Errorf("got options %v, expected %v", got, want)
+	}
+}
+
+func TestIDMismatch(t *testing.T) {
+	err := errIDMismatch(1, 2)
+	if !errors.Is(err, dns.ErrId) {
+		t.Errorf("%v isn't dns.ErrId", err)
+	}
+	if detail := zdns.ErrorDetail(zdns.STATUS_ID_MISMATCH, err); detail != zdns.ERROR_DETAIL_ID_MISMATCH {
+		t.Errorf("expected error detail %s, got %s", zdns.ERROR_DETAIL_ID_MISMATCH, detail)
+	}
+}
+
+func TestLocalPort(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	conn := &dns.Conn{UDP: pc}
+	defer conn.Close()
+	if port := localPort(conn); port != pc.LocalAddr().(*net.UDPAddr).Port || port == 0 {
+		t.Errorf("unexpected source port %d of %v", port, pc.LocalAddr())
+	}
+}
+
+func TestSpoofedResponse(t *testing.T) {
+	spoofed := func(m *dns.Msg) *dns.Msg {
+		r := replyA(m)
+		r.Id = m.Id + 1
+		return r
+	}
+	// the spoofed response arrives first, then the real one
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if m.Unpack(buf[:n]) != nil || len(m.Question) != 1 {
+				continue
+			}
+			for _, r := range []*dns.Msg{spoofed(m), replyA(m)} {
+				wire, _ := r.Pack()
+				pc.WriteTo(wire, from)
+			}
+		}
+	}()
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	m.Id = 1
+	c := &dns.Client{Timeout: time.Second}
+	if r, _, err := exchange(context.Background(), c, m, pc.LocalAddr().String(), nil, QueryOptions{}); err != nil || r.Id != m.Id {
+		t.Errorf("the real response after a spoofed one wasn't read: %v %v", r, err)
+	}
+
+	addr, stop := serveUDP(t, spoofed)
+	defer stop()
+	c.Timeout = 100 * time.Millisecond
+	if _, _, err := exchange(context.Background(), c, m, addr, nil, QueryOptions{}); !errors.Is(err, dns.ErrId) {
+		t.Errorf("expected an ID mismatch without the real response, got %v", err)
+	}
+}
+
+func TestMaxQueriesPerName(t *testing.T) {
+	s := Lookup{Factory: &RoutineLookupFactory{MaxQueriesPerName: 2}}
+	s.queries = 2
+	if _, status, _ := s.retryingLookup(dns.TypeA, dns.ClassINET, "example.com", "192.0.2.1:53", true); status != zdns.STATUS_QUERY_LIMIT {
+		t.Errorf("expected status %s past the limit, got %s", zdns.STATUS_QUERY_LIMIT, status)
+	}
+	if queries, limited := s.Queries(); queries != 2 || !limited {
+		t.Errorf("expected 2 queries and the limit hit, got %d, %v", queries, limited)
+	}
+	s.ResetQueries()
+	if queries, limited := s.Queries(); queries != 0 || limited {
+		t.Errorf("expected a reset count, got %d, %v", queries, limited)
+	}
+	status := zdns.STATUS_QUERY_LIMIT
+	if newStatus, _ := handleStatus(&status, nil); newStatus == nil || *newStatus != zdns.STATUS_QUERY_LIMIT {
+		t.Error("the query limit doesn't stop iteration over other authorities")
+	}
+}
+
+func TestRaceServers(t *testing.T) {
+	f := newCacheFactory()
+	f.GlobalConf.NameServers = []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}
+	s := Lookup{Factory: &RoutineLookupFactory{Factory: f, RaceServers: 2}}
+	if servers := s.raceServers("192.0.2.2:53"); !reflect.DeepEqual(servers, []string{"192.0.2.1:53", "192.0.2.2:53"}) {
+		t.Errorf("expected the first 2 servers, got %v", servers)
+	}
+	// a server set for the lookup is raced with the first others
+	if servers := s.raceServers("192.0.2.9:53"); !reflect.DeepEqual(servers, []string{"192.0.2.1:53", "192.0.2.9:53"}) {
+		t.Errorf("expected the lookup's server with the first one, got %v", servers)
+	}
+}
+
+// replyServfail fails every query.
+func replyServfail(m *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetRcode(m, dns.RcodeServerFailure)
+	return r
+}
+
+func TestRaceLookup(t *testing.T) {
+	failing, stopFailing := serveUDP(t, replyServfail)
+	defer stopFailing()
+	answering, stopAnswering := serveUDP(t, replyA)
+	defer stopAnswering()
+	// never answers, so the race must not wait for it
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer silent.Close()
+
+	f := newCacheFactory()
+	f.GlobalConf.NameServers = []string{silent.LocalAddr().String(), failing, answering}
+	s := Lookup{Factory: &RoutineLookupFactory{Factory: f, Client: &dns.Client{Timeout: 5 * time.Second}, RaceServers: 3}}
+	start := time.Now()
+	res, status, err := s.raceLookup(dns.TypeA, dns.ClassINET, "example.com", failing)
+	if status != zdns.STATUS_NOERROR || err != nil {
+		t.Fatalf("expected the answer to win, got %s: %v", status, err)
+	}
+	if res.Resolver != answering || len(res.Answers) != 1 {
+		t.Errorf("unexpected winning result %+v", res)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("race waited %v for the silent server", elapsed)
+	}
+
+	f.GlobalConf.NameServers = []string{failing}
+	s.Factory.RaceServers = 1
+	if _, status, _ := s.raceLookup(dns.TypeA, dns.ClassINET, "example.com", failing); status != zdns.STATUS_SERVFAIL {
+		t.Errorf("expected the failure without an answer, got %s", status)
+	}
+}
+
+func TestRaceLookupCircuitBreaker(t *testing.T) {
+	answering, stopAnswering := serveUDP(t, replyA)
+	defer stopAnswering()
+	dead, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer dead.Close()
+
+	f := newCacheFactory()
+	f.GlobalConf.NameServers = []string{dead.LocalAddr().String(), answering}
+	breaker := zdns.NewCircuitBreaker(f.GlobalConf.NameServers, 0.5, 1, time.Minute)
+	s := Lookup{Factory: &RoutineLookupFactory{Factory: f, Client: &dns.Client{Timeout: 200 * time.Millisecond}, RaceServers: 2, CircuitBreaker: breaker}}
+	if _, status, err := s.raceLookup(dns.TypeA, dns.ClassINET, "example.com", answering); status != zdns.STATUS_NOERROR {
+		t.Fatalf("expected the answer to win, got %s: %v", status, err)
+	}
+	// the dead server's query times out after the race was won
+	deadline := time.Now().Add(2 * time.Second)
+	for !breaker.Skipping(dead.LocalAddr().String()) {
+		if time.Now().After(deadline) {
+			t.Fatal("the timeout of the losing server wasn't recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if servers := s.raceServers(answering); !reflect.DeepEqual(servers, []string{answering}) {
+		t.Errorf("expected the server with an open breaker to sit out the race, got %v", servers)
+	}
+}
+
+func TestRaceLookupCircuitBreakerRetries(t *testing.T) {
+	var queries int32
+	answering, stopAnswering := serveTCP(t, func(m *dns.Msg) *dns.Msg {
+		if atomic.AddInt32(&queries, 1) == 1 {
+			r := new(dns.Msg)
+			r.SetRcode(m, dns.RcodeServerFailure)
+			return r
+		}
+		return replyA(m)
+	})
+	defer stopAnswering()
+	// connections to the dead server are established, but never answered
+	dead, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer dead.Close()
+
+	f := newCacheFactory()
+	f.GlobalConf.NameServers = []string{dead.Addr().String(), answering}
+	breaker := zdns.NewCircuitBreaker(f.GlobalConf.NameServers, 0.5, 10, time.Minute)
+	s := Lookup{Factory: &RoutineLookupFactory{
+		Factory:        f,
+		TCPClient:      &dns.Client{Net: "tcp", Timeout: 100 * time.Millisecond},
+		RaceServers:    2,
+		CircuitBreaker: breaker,
+		// the dead server's racer of the retry is held back until after
+		// the answer won, and only then dials with its client
+		RateLimiter: zdns.NewRateLimiter([]string{dead.Addr().String()}, 1),
+		Retries:     2,
+		RetryRcodes: map[zdns.Status]bool{zdns.STATUS_SERVFAIL: true},
+	}}
+	res, status, err := s.retryingLookup(dns.TypeA, dns.ClassINET, "example.com", answering, true)
+	if status != zdns.STATUS_NOERROR || res.Attempts != 2 {
+		t.Fatalf("expected the retry to be answered, got %s after %d attempts: %v", status, res.Attempts, err)
+	}
+	time.Sleep(1500 * time.Millisecond)
+}
+
+func TestCheckTSIG(t *testing.T) {
+	signed := func(rcode uint16) *dns.Msg {
+		r := new(dns.Msg)
+		r.SetTsig("key.", dns.HmacSHA256, 300, time.Now().Unix())
+		r.IsTsig().Error = rcode
+		return r
+	}
+	if status, err := checkTSIG(signed(dns.RcodeSuccess), nil); status != zdns.STATUS_NOERROR || err != nil {
+		t.Errorf("expected a verified response to pass, got %s (%v)", status, err)
+	}
+	if status, _ := checkTSIG(signed(dns.RcodeBadSig), nil); status != zdns.STATUS_TSIG_ERROR {
+		t.Errorf("expected BADSIG from the server to fail, got %s", status)
+	}
+	if status, _ := checkTSIG(new(dns.Msg), nil); status != zdns.STATUS_TSIG_ERROR {
+		t.Errorf("expected an unsigned response to fail, got %s", status)
+	}
+	if status, _ := checkTSIG(signed(dns.RcodeSuccess), dns.ErrSig); status != zdns.STATUS_TSIG_ERROR {
+		t.Errorf("expected a bad MAC to fail, got %s", status)
+	}
+	// other failures are left to the usual handling
+	if status, _ := checkTSIG(nil, errors.New("connection refused")); status != zdns.STATUS_NOERROR {
+		t.Errorf("expected a network error to be left alone, got %s", status)
+	}
+}
+
+func TestIsNoData(t *testing.T) {
+	cname := ParseAnswer(&dns.CNAME{
+		Hdr:    dns.RR_Header{Name: "www.example.com.", Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 60},
+		Target: "example.com.",
+	})
+	a := ParseAnswer(&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	soa := ParseAnswer(&dns.SOA{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 60},
+		Ns:  "ns.example.com.", Mbox: "hostmaster.example.com.", Minttl: 60,
+	})
+	ns := ParseAnswer(&dns.NS{
+		Hdr: dns.RR_Header{Name: "sub.example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 60},
+		Ns:  "ns.sub.example.com.",
+	})
+	tests := []struct {
+		desc        string
+		answers     []interface{}
+		authorities []interface{}
+		dnsType     uint16
+		expected    bool
+	}{
+		{"empty answer with SOA", nil, []interface{}{soa}, dns.TypeAAAA, true},
+		{"empty answer without authority", nil, nil, dns.TypeAAAA, true},
+		{"CNAME to a name without the type", []interface{}{cname}, []interface{}{soa}, dns.TypeAAAA, true},
+		{"CNAME to a name with the type", []interface{}{cname, a}, nil, dns.TypeA, false},
+		{"CNAME asked for", []interface{}{cname}, nil, dns.TypeCNAME, false},
+		{"referral", nil, []interface{}{ns}, dns.TypeA, false},
+		{"ANY with records", []interface{}{a}, nil, dns.TypeANY, false},
+	}
+	for _, test := range tests {
+		result := Result{Answers: test.answers, Authorities: test.authorities}
+		if got := IsNoData(result, test.dnsType); got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.desc, test.expected, got)
+		}
+	}
+}
+
+func TestTransportClients(t *testing.T) {
+	udp := &dns.Client{Timeout: time.Second}
+	if u, c := transportClients(zdns.TransportTCP, udp, nil); u != nil || c == nil || protocolName(c) != "tcp" || c.Timeout != time.Second {
+		t.Errorf("tcp server isn't queried over TCP alone: %v %v", u, c)
+	}
+	if u, c := transportClients(zdns.TransportTLS, udp, nil); u != nil || c == nil || protocolName(c) != zdns.TransportTLS {
+		t.Errorf("tls server isn't queried over TLS alone: %v %v", u, c)
+	}
+	tcp := &dns.Client{Net: "tcp"}
+	if u, c := transportClients(zdns.TransportUDP, nil, tcp); u == nil || c != nil || protocolName(u) != "udp" {
+		t.Errorf("udp server isn't queried over UDP alone: %v %v", u, c)
+	}
+	if u, c := transportClients("", udp, tcp); u != udp || c != tcp {
+		t.Error("server without a transport didn't keep its clients")
+	}
+}
+
+func TestExchangeHTTPS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		m := new(dns.Msg)
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != "application/dns-message" || m.Unpack(body) != nil || len(m.Question) != 1 {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Answer = append(r.Answer, &dns.A{
+			Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		wire, _ := r.Pack()
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(wire)
+	}))
+	defer srv.Close()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	m.Id = queryID()
+	r, err := exchangeHTTPS(context.Background(), srv.Client(), &dns.Client{Timeout: time.Second}, m, srv.URL+"/dns-query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Id != m.Id || len(r.Answer) != 1 {
+		t.Errorf("unexpected response %v", r)
+	}
+	if _, err := exchangeHTTPS(context.Background(), srv.Client(), &dns.Client{Timeout: time.Second}, new(dns.Msg), srv.URL+"/dns-query"); err == nil {
+		t.Error("expected an error for a rejected query")
+	}
+}
+
+// serveQUIC answers the DNS over QUIC queries sent to the returned address
+// with reply, resetting the stream if reply returns nil. It counts the
+// connections it accepts in conns, and returns the TLS settings that trust
+// its certificate.
+func serveQUIC(t *testing.T, reply func(m *dns.Msg) *dns.Msg, conns *int32) (string, *tls.Config, func()) {
+	// borrow the certificate of an httptest server, which is valid for
+	// 127.0.0.1
+	srv := httptest.NewTLSServer(http.NotFoundHandler())
+	roots := srv.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: srv.TLS.Certificates, NextProtos: []string{doqALPN}}, nil)
+	if err != nil {
+		srv.Close()
+		t.Skip(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(conns, 1)
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					var length [2]byte
+					if _, err := io.ReadFull(stream, length[:]); err != nil {
+						return
+					}
+					p := make([]byte, binary.BigEndian.Uint16(length[:]))
+					if _, err := io.ReadFull(stream, p); err != nil {
+						return
+					}
+					m := new(dns.Msg)
+					var r *dns.Msg
+					if m.Unpack(p) == nil && m.Id == 0 && len(m.Question) == 1 {
+						r = reply(m)
+					}
+					if r == nil {
+						// DOQ_PROTOCOL_ERROR
+						stream.CancelWrite(2)
+						continue
+					}
+					wire, _ := r.Pack()
+					out := make([]byte, 2+len(wire))
+					binary.BigEndian.PutUint16(out, uint16(len(wire)))
+					copy(out[2:], wire)
+					stream.Write(out)
+					stream.Close()
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), &tls.Config{RootCAs: roots}, func() {
+		ln.Close()
+		srv.Close()
+	}
+}
+
+func TestExchangeQUIC(t *testing.T) {
+	var fail, accepted int32
+	addr, tlsConf, stop := serveQUIC(t, func(m *dns.Msg) *dns.Msg {
+		if atomic.LoadInt32(&fail) == 1 {
+			return nil
+		}
+		return replyA(m)
+	}, &accepted)
+	defer stop()
+
+	udp := &dns.Client{Timeout: 5 * time.Second}
+	conns := &QUICConns{TLSConfig: tlsConf}
+	defer conns.Close()
+	opts := QueryOptions{QUICConns: conns}
+	for i := 0; i < 2; i++ {
+		res, status, err := doLookupWorker(context.Background(), udp, nil, dns.TypeA, dns.ClassINET, "example.com", "quic://"+addr, true, opts)
+		if status != zdns.STATUS_NOERROR || err != nil {
+			t.Fatalf("expected status %s, got %s: %v", zdns.STATUS_NOERROR, status, err)
+		}
+		if res.Protocol != zdns.TransportQUIC || res.QueryID != 0 || len(res.Answers) != 1 {
+			t.Errorf("unexpected result %+v", res)
+		}
+	}
+	if n := atomic.LoadInt32(&accepted); n != 1 {
+		t.Errorf("expected the queries to share one connection, got %d", n)
+	}
+
+	// a reset stream is a failure of the QUIC layer, not a timeout
+	atomic.StoreInt32(&fail, 1)
+	if _, status, err := doLookupWorker(context.Background(), udp, nil, dns.TypeA, dns.ClassINET, "example.com", "quic://"+addr, true, opts); status != zdns.STATUS_QUIC_ERROR {
+		t.Errorf("expected status %s for a reset stream, got %s: %v", zdns.STATUS_QUIC_ERROR, status, err)
+	}
+	atomic.StoreInt32(&fail, 0)
+	if _, status, err := doLookupWorker(context.Background(), udp, nil, dns.TypeA, dns.ClassINET, "example.com", "quic://"+addr, true, opts); status != zdns.STATUS_NOERROR {
+		t.Errorf("expected status %s after reconnecting, got %s: %v", zdns.STATUS_NOERROR, status, err)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 2 {
+		t.Errorf("expected a new connection after the QUIC error, got %d connections", n)
+	}
+}
+
+// serveUDP answers the queries sent to the returned address with reply.
+func serveUDP(t *testing.T, reply func(m *dns.Msg) *dns.Msg) (string, func()) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if m.Unpack(buf[:n]) != nil || len(m.Question) != 1 {
+				continue
+			}
+			wire, _ := reply(m).Pack()
+			conn.WriteTo(wire, addr)
+		}
+	}()
+	return conn.LocalAddr().String(), func() { conn.Close() }
+}
+
+// serveTCP answers the queries sent to it over TCP with reply, one per
+// connection.
+func serveTCP(t *testing.T, reply func(m *dns.Msg) *dns.Msg) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				conn := &dns.Conn{TCP: c}
+				if m, err := conn.ReadMsg(); err == nil {
+					conn.WriteMsg(reply(m))
+				}
+			}()
+		}
+	}()
+	return l.Addr().String(), func() { l.Close() }
+}
+
+// replyA answers a query with one A record.
+func replyA(m *dns.Msg) *dns.Msg {
+	r := new(dns.Msg)
+	r.SetReply(m)
+	r.Answer = append(r.Answer, &dns.A{
+		Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	return r
+}
+
+func TestTCPFastOpen(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn := &dns.Conn{TCP: c}
+			if m, err := conn.ReadMsg(); err == nil {
+				conn.WriteMsg(replyA(m))
+			}
+			c.Close()
+		}
+	}()
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	r, wire, err := exchange(context.Background(), &dns.Client{Net: "tcp", Timeout: time.Second}, m, l.Addr().String(), nil, QueryOptions{TCPFastOpen: fastOpenSupported})
+	if err != nil || len(r.Answer) != 1 {
+		t.Fatalf("exchange failed: %v %v", r, err)
+	}
+	// the listener doesn't accept data in the SYN
+	if wire.fastOpen {
+		t.Error("TCP Fast Open reported for a server without it")
+	}
+}
+
+// readFrame reads a length-prefixed DNS message from a TCP stream.
+func readFrame(r io.Reader) (*dns.Msg, error) {
+	var length [2]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	m := new(dns.Msg)
+	return m, m.Unpack(buf)
+}
+
+func TestPipelinedExchange(t *testing.T) {
+	key, _ := zdns.NewTSIGKey("key.example.", "hmac-sha256", "c2VjcmV0c2VjcmV0c2VjcmV0")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer l.Close()
+	received := make(chan struct{}, 2)
+	accepted := 0
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted++
+			// holds the first query until the second arrives, and then
+			// answers them in reverse order
+			go func(c net.Conn) {
+				defer c.Close()
+				var queries []*dns.Msg
+				for len(queries) < 2 {
+					m, err := readFrame(c)
+					if err != nil {
+						return
+					}
+					queries = append(queries, m)
+					received <- struct{}{}
+				}
+				for i := len(queries) - 1; i >= 0; i-- {
+					q := queries[i]
+					r := replyA(q)
+					r.SetTsig(key.Name, key.Algorithm, zdns.TSIGFudge, time.Now().Unix())
+					wire, _, err := dns.TsigGenerate(r, key.Secret, q.IsTsig().MAC, false)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					frame := make([]byte, 2+len(wire))
+					binary.BigEndian.PutUint16(frame, uint16(len(wire)))
+					copy(frame[2:], wire)
+					c.Write(frame)
+				}
+			}(c)
+		}
+	}()
+
+	pool := zdns.NewConnPool(1, 2, time.Minute)
+	defer pool.Close()
+	client := &dns.Client{Net: "tcp", Timeout: time.Second, TsigSecret: key.Secrets()}
+	var wg sync.WaitGroup
+	for i, name := range []string{"first.example.", "second.example."} {
+		if i > 0 {
+			// the second query joins the connection of the first
+			<-received
+		}
+		wg.Add(1)
+		go func(name string, id uint16) {
+			defer wg.Done()
+			m := new(dns.Msg)
+			m.SetQuestion(name, dns.TypeA)
+			m.Id = id
+			key.Sign(m)
+			r, wire, err := exchangePooled(context.Background(), client, m, l.Addr().String(), nil, QueryOptions{TCPPool: pool})
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				return
+			}
+			if r.Question[0].Name != name || len(r.Answer) != 1 {
+				t.Errorf("%s: unexpected response %v", name, r)
+			}
+			if wire.sizes() == nil || wire.port == 0 {
+				t.Errorf("%s: exchange not recorded: %+v", name, wire)
+			}
+		}(name, uint16(i+1))
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("expected both queries on one connection, got %d", accepted)
+	}
+}
+
+func TestNoTCPFallback(t *testing.T) {
+	// answers every query with one record and the TC bit set
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if m.Unpack(buf[:n]) != nil {
+				continue
+			}
+			r := new(dns.Msg)
+			r.SetReply(m)
+			r.Truncated = true
+			r.Answer = append(r.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: m.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("192.0.2.1"),
+			})
+			wire, _ := r.Pack()
+			conn.WriteTo(wire, addr)
+		}
+	}()
+
+	udp := &dns.Client{Timeout: time.Second}
+	tcp := &dns.Client{Net: "tcp", Timeout: time.Second}
+	res, status, err := doLookupWorker(context.Background(), udp, tcp, dns.TypeA, dns.ClassINET, "example.com", conn.LocalAddr().String(), true, QueryOptions{NoTCPFallback: true})
+	if status != zdns.STATUS_TRUNCATED || err != nil {
+		t.Fatalf("expected status %s, got %s: %v", zdns.STATUS_TRUNCATED, status, err)
+	}
+	if len(res.Answers) != 1 || !res.Flags.Truncated || res.Protocol != "udp" {
+		t.Errorf("truncated response wasn't reported: %+v", res)
+	}
+}
+
+func TestNegativeSOA(t *testing.T) {
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns.example.com.",
+		Mbox:   "hostmaster.example.com.",
+		Minttl: 300,
+	}
+	ns := &dns.NS{Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: 3600}, Ns: "ns.example.com."}
+	got := soaRecord([]dns.RR{ns, soa})
+	if got == nil || got.Name != "example.com" || got.Ttl != 3600 || got.Minttl != 300 {
+		t.Errorf("unexpected SOA record %+v", got)
+	}
+	if soaRecord([]dns.RR{ns}) != nil {
+		t.Error("referral has an SOA record")
+	}
+
+	var s Lookup
+	if s.NegativeSOA() != nil {
+		t.Error("SOA record kept before any lookup")
+	}
+	s.negativeSOA = got
+	if kept, ok := s.NegativeSOA().(SOAAnswer); !ok || kept.Minttl != 300 {
+		t.Errorf("unexpected SOA record %v", s.NegativeSOA())
+	}
+	s.ResetNegativeSOA()
+	if s.NegativeSOA() != nil {
+		t.Error("SOA record kept after a reset")
+	}
+}
+
+func TestFullAnswer(t *testing.T) {
+	addr, stop := serveUDP(t, replyA)
+	defer stop()
+
+	s := Lookup{Factory: &RoutineLookupFactory{Client: &dns.Client{Timeout: time.Second}, Retries: 1, FullAnswer: true}}
+	for _, name := range []string{"example.com", "www.example.com"} {
+		if _, status, err := s.retryingLookup(dns.TypeA, dns.ClassINET, name, addr, true); status != zdns.STATUS_NOERROR {
+			t.Fatalf("lookup of %s failed with %s: %v", name, status, err)
+		}
+	}
+	answers := s.Answers()
+	if len(answers) != 2 || answers[1].(Answer).Name != "www.example.com" {
+		t.Errorf("unexpected answers %v", answers)
+	}
+	s.ResetAnswers()
+	if len(s.Answers()) != 0 {
+		t.Error("answers kept after a reset")
+	}
+}
+
+func TestNoDataRecorded(t *testing.T) {
+	soa := &dns.SOA{
+		Hdr:    dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: 3600},
+		Ns:     "ns.example.com.",
+		Mbox:   "hostmaster.example.com.",
+		Minttl: 300,
+	}
+	addr, stop := serveUDP(t, func(m *dns.Msg) *dns.Msg {
+		if m.Question[0].Qtype == dns.TypeA {
+			return replyA(m)
+		}
+		r := new(dns.Msg)
+		r.SetReply(m)
+		r.Ns = []dns.RR{soa}
+		return r
+	})
+	defer stop()
+
+	s := Lookup{Factory: &RoutineLookupFactory{Client: &dns.Client{Timeout: time.Second}, Retries: 1}}
+	if _, _, status, err := s.tracedRetryingLookup(dns.TypeMX, dns.ClassINET, "example.com", addr, true); status != zdns.STATUS_NOERROR {
+		t.Fatalf("lookup failed with %s: %v", status, err)
+	}
+	if !s.NoData() {
+		t.Error("NODATA answer wasn't recorded")
+	}
+	// the last answer counts
+	if _, _, status, err := s.tracedRetryingLookup(dns.TypeA, dns.ClassINET, "example.com", addr, true); status != zdns.STATUS_NOERROR {
+		t.Fatalf("lookup failed with %s: %v", status, err)
+	}
+	if s.NoData() {
+		t.Error("answer with records recorded as NODATA")
+	}
+	s.noData = true
+	s.ResetNoData()
+	if s.NoData() {
+		t.Error("NODATA kept after a reset")
+	}
+}
+
+func TestResponseFlags(t *testing.T) {
+	addr, stop := serveUDP(t, func(m *dns.Msg) *dns.Msg {
+		r := replyA(m)
+		r.RecursionAvailable = true
+		r.AuthenticatedData = true
+		return r
+	})
+	defer stop()
+
+	s := Lookup{Factory: &RoutineLookupFactory{Client: &dns.Client{Timeout: time.Second}, Retries: 1}}
+	if _, status, err := s.retryingLookup(dns.TypeA, dns.ClassINET, "example.com", addr, true); status != zdns.STATUS_NOERROR {
+		t.Fatalf("lookup failed with %s: %v", status, err)
+	}
+	want := zdns.ResponseFlags{RecursionDesired: true, RecursionAvailable: true, AuthenticatedData: true}
+	if f := s.ResponseFlags(); f == nil || *f != want {
+		t.Errorf("unexpected flags %+v", f)
+	}
+	s.ResetResponseFlags()
+	if s.ResponseFlags() != nil {
+		t.Error("flags kept after a reset")
+	}
+}
+
+func TestAmplification(t *testing.T) {
+	// the sizes of the query and response, as the server saw them
+	seen := make(chan [2]int, 1)
+	addr, stop := serveUDP(t, func(m *dns.Msg) *dns.Msg {
+		r := replyA(m)
+		q, _ := m.Pack()
+		a, _ := r.Pack()
+		seen <- [2]int{len(q), len(a)}
+		return r
+	})
+	defer stop()
+
+	udp := &dns.Client{Timeout: time.Second}
+	res, status, err := doLookupWorker(context.Background(), udp, nil, dns.TypeA, dns.ClassINET, "example.com", addr, true, QueryOptions{})
+	if status != zdns.STATUS_NOERROR {
+		t.Fatalf("lookup failed with %s: %v", status, err)
+	}
+	served := <-seen
+	query, response := served[0], served[1]
+	if res.Amplification == nil || res.Amplification.UDP == nil || res.Amplification.TCP != nil {
+		t.Fatalf("unexpected amplification %+v", res.Amplification)
+	}
+	sizes := res.Amplification.UDP
+	if sizes.Query != query || sizes.Response != response {
+		t.Errorf("expected %d and %d bytes, got %+v", query, response, sizes)
+	}
+	if sizes.Factor <= 1 || sizes.Factor != math.Round(float64(response)/float64(query)*100)/100 {
+		t.Errorf("unexpected amplification factor %v", sizes.Factor)
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	defer conn.Close()
+	counted, _ := countBytes(&dns.Conn{UDP: conn, RemoteAddr: addr})
+	if counted.UDP == nil || counted.TCP != nil || counted.RemoteAddr != addr {
+		t.Error("counting copy of a UDP connection isn't one")
+	}
+}
+
+func TestQueryFlags(t *testing.T) {
+	queries := make(chan dns.MsgHdr, 1)
+	addr, stop := serveUDP(t, func(m *dns.Msg) *dns.Msg {
+		queries <- m.MsgHdr
+		return replyA(m)
+	})
+	defer stop()
+
+	udp := &dns.Client{Timeout: time.Second}
+	opts := QueryOptions{QueryFlags: zdns.QueryFlags{Authoritative: true, Zero: true}}
+	if _, status, err := doLookupWorker(context.Background(), udp, nil, dns.TypeA, dns.ClassINET, "example.com", addr, false, opts); status != zdns.STATUS_NOERROR {
+		t.Fatalf("lookup failed with %s: %v", status, err)
+	}
+	hdr := <-queries
+	if !hdr.Authoritative || !hdr.Zero || hdr.RecursionDesired || hdr.CheckingDisabled {
+		t.Errorf("unexpected query header %+v", hdr)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	addr, stop := serveUDP(t, replyA)
+	defer stop()
+
+	f := newCacheFactory()
+	if f.CacheStats() != nil {
+		t.Error("cache stats reported without iterative lookups")
+	}
+	f.GlobalConf.IterativeResolution = true
+	a := ParseAnswer(&dns.A{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 300},
+		A:   net.ParseIP("192.0.2.1"),
+	})
+	f.AddCachedAnswer(a, "example.com", dns.TypeA, 300, 0, 0)
+	s := Lookup{
+		Factory:       &RoutineLookupFactory{Factory: f, Client: &dns.Client{Timeout: time.Second}, Retries: 1},
+		IterativeStop: time.Now().Add(time.Minute),
+	}
+	if _, cached, _, _ := s.cachedRetryingLookup(dns.TypeA, dns.ClassINET, "example.com", addr, "com", 0); !cached {
+		t.Error("cached answer wasn't used")
+	}
+	if _, cached, status, err := s.cachedRetryingLookup(dns.TypeA, dns.ClassINET, "www.example.com", addr, "example.com", 0); cached || status != zdns.STATUS_NOERROR {
+		t.Errorf("expected a lookup on the wire, got %s (cached: %v): %v", status, cached, err)
+	}
+	if stats := f.CacheStats(); *stats != (zdns.CacheStats{Hits: 1, Misses: 1, HitRate: 0.5}) {
+		t.Errorf("unexpected cache stats %+v", stats)
+	}
+
+	// warming the cache doesn't count
+	s.Factory.WarmingCache = true
+	s.cachedRetryingLookup(dns.TypeA, dns.ClassINET, "example.com", addr, "com", 0)
+	if stats := f.CacheStats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Errorf("warming the cache changed its stats to %+v", stats)
+	}
+}
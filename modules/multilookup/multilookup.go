@@ -0,0 +1,155 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package multilookup
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+// the outcome of the query for one record type
+type TypeResult struct {
+	Status zdns.Status `json:"status" groups:"short,normal,long,trace"`
+	Error  string      `json:"error,omitempty" groups:"short,normal,long,trace"`
+	// the answer of the raw module of that type (e.g., MX), with its records
+	// parsed by miekg.ParseAnswer. The follow-up queries of the lookup
+	// modules (e.g., MXLOOKUP resolving exchanges) aren't made.
+	Data interface{} `json:"data,omitempty" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	// keyed by record type, e.g., "AAAA"
+	Types map[string]TypeResult `json:"types" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// DoLookup queries name for each of the configured record types. The lookup
+// as a whole succeeds if any of the types does; otherwise it takes on the
+// status of the first type.
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Types: make(map[string]TypeResult, len(s.Factory.Factory.Types))}
+	var trace []interface{}
+	var status zdns.Status
+	for i, dnsType := range s.Factory.Factory.Types {
+		res, typeTrace, typeStatus, err := s.DoTypedMiekgLookup(name, dnsType)
+		trace = append(trace, typeTrace...)
+		if result, ok := res.(miekg.Result); ok && typeStatus == zdns.STATUS_NOERROR && miekg.IsNoData(result, dnsType) {
+			typeStatus = zdns.STATUS_NODATA
+		}
+		typeResult := TypeResult{Status: typeStatus, Data: res}
+		if err != nil {
+			typeResult.Error = err.Error()
+		}
+		retv.Types[dns.TypeToString[dnsType]] = typeResult
+		if i == 0 || typeStatus == zdns.STATUS_NOERROR {
+			status = typeStatus
+		}
+	}
+	return retv, trace, status, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, s.Factory.Types[0], s.DNSClass, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	RecordTypes string
+	Types       []uint16
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.StringVar(&s.RecordTypes, "record-types", "A,AAAA", "comma-separated list of record types to query for each name (e.g., A,AAAA,MX,TXT)")
+}
+
+// ParseTypes converts a comma-separated list of record type names into their
+// numeric values, dropping repeats.
+func ParseTypes(list string) ([]uint16, error) {
+	var types []uint16
+	seen := make(map[uint16]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToUpper(strings.TrimSpace(name))
+		dnsType, ok := dns.StringToType[name]
+		if !ok {
+			return nil, errors.New("unknown record type: " + name)
+		}
+		if !seen[dnsType] {
+			seen[dnsType] = true
+			types = append(types, dnsType)
+		}
+	}
+	return types, nil
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	types, err := ParseTypes(s.RecordTypes)
+	if err != nil {
+		return errors.New("invalid --record-types: " + err.Error())
+	}
+	s.Types = types
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("MULTILOOKUP", s)
+}
@@ -0,0 +1,43 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package multilookup
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseTypes(t *testing.T) {
+	types, err := ParseTypes("A, aaaa,MX,TXT,A")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []uint16{dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeTXT}
+	if len(types) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, types)
+		}
+	}
+	if _, err := ParseTypes("A,NOTATYPE"); err == nil {
+		t.Error("Expected unknown record type to be rejected")
+	}
+	if _, err := ParseTypes(""); err == nil {
+		t.Error("Expected empty list to be rejected")
+	}
+}
@@ -15,7 +15,10 @@
 package mxlookup
 
 import (
+	"errors"
 	"flag"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 
@@ -30,6 +33,7 @@ import (
 type CachedAddresses struct {
 	IPv4Addresses []string
 	IPv6Addresses []string
+	CNAMEChain    []miekg.CNAMELink
 }
 
 type MXRecord struct {
@@ -39,7 +43,9 @@ type MXRecord struct {
 	Preference    uint16   `json:"preference" groups:"short,normal,long,trace"`
 	IPv4Addresses []string `json:"ipv4_addresses,omitempty" groups:"short,normal,long,trace"`
 	IPv6Addresses []string `json:"ipv6_addresses,omitempty" groups:"short,normal,long,trace"`
-	TTL           uint32   `json:"ttl" groups:"ttl,normal,long,trace"`
+	// the aliases followed from the exchange to its addresses, in order
+	CNAMEChain []miekg.CNAMELink `json:"cname_chain,omitempty" groups:"short,normal,long,trace"`
+	TTL        uint32            `json:"ttl" groups:"ttl,normal,long,trace"`
 }
 
 type Result struct {
@@ -57,52 +63,90 @@ func dotName(name string) string {
 	return strings.Join([]string{name, "."}, "")
 }
 
+// lookupAddresses resolves one address type of name, following any CNAMEs in
+// the answer.
+func (s *Lookup) lookupAddresses(name string, dnsType uint16) ([]string, []miekg.CNAMELink, []interface{}) {
+	var addresses []string
+	res, trace, status, _ := s.DoTypedMiekgLookup(name, dnsType)
+	if status != zdns.STATUS_NOERROR {
+		return nil, nil, trace
+	}
+	cast, _ := res.(miekg.Result)
+	chain, target, err := miekg.FollowCNAMEs(cast.Answers, name)
+	if err != nil {
+		return nil, chain, trace
+	}
+	for _, innerRes := range cast.Answers {
+		castInnerRes, ok := innerRes.(miekg.Answer)
+		if !ok || castInnerRes.Type != dns.TypeToString[dnsType] || !strings.EqualFold(strings.TrimSuffix(castInnerRes.Name, "."), target) {
+			continue
+		}
+		addresses = append(addresses, castInnerRes.Answer)
+	}
+	return addresses, chain, trace
+}
+
 func (s *Lookup) LookupIPs(name string) (CachedAddresses, []interface{}) {
-	s.Factory.Factory.CHmu.Lock()
-	// XXX this should be changed to a miekglookup
-	res, found := s.Factory.Factory.CacheHash.Get(name)
-	s.Factory.Factory.CHmu.Unlock()
-	if found {
-		return res.(CachedAddresses), make([]interface{}, 0)
+	key := strings.ToLower(name)
+	if s.Factory.Factory.GlobalConf.StickyServer != "" {
+		// addresses resolved by another name's server aren't reused
+		key = s.NameServer + " " + key
+	}
+	if s.Factory.Factory.CacheHash != nil {
+		s.Factory.Factory.CHmu.Lock()
+		res, found := s.Factory.Factory.CacheHash.Get(key)
+		s.Factory.Factory.CHmu.Unlock()
+		if found {
+			return res.(CachedAddresses), make([]interface{}, 0)
+		}
 	}
 	var retv CachedAddresses
 	trace := make([]interface{}, 0)
 	// ipv4
 	if s.Factory.Factory.IPv4Lookup || !s.Factory.Factory.IPv6Lookup {
-		res, secondTrace, status, _ := s.DoTypedMiekgLookup(name, dns.TypeA)
+		addresses, chain, secondTrace := s.lookupAddresses(name, dns.TypeA)
 		trace = append(trace, secondTrace...)
-		if status == zdns.STATUS_NOERROR {
-			cast, _ := res.(miekg.Result)
-			for _, innerRes := range cast.Answers {
-				castInnerRes, ok := innerRes.(miekg.Answer)
-				if !ok {
-					continue
-				}
-				retv.IPv4Addresses = append(retv.IPv4Addresses, castInnerRes.Answer)
-			}
-		}
+		retv.IPv4Addresses = addresses
+		retv.CNAMEChain = chain
 	}
 	// ipv6
 	if s.Factory.Factory.IPv6Lookup {
-		res, secondTrace, status, _ := s.DoTypedMiekgLookup(name, dns.TypeAAAA)
+		addresses, chain, secondTrace := s.lookupAddresses(name, dns.TypeAAAA)
 		trace = append(trace, secondTrace...)
-		if status == zdns.STATUS_NOERROR {
-			cast, _ := res.(miekg.Result)
-			for _, innerRes := range cast.Answers {
-				castInnerRes, ok := innerRes.(miekg.Answer)
-				if !ok {
-					continue
-				}
-				retv.IPv6Addresses = append(retv.IPv6Addresses, castInnerRes.Answer)
-			}
+		retv.IPv6Addresses = addresses
+		if len(chain) > len(retv.CNAMEChain) {
+			retv.CNAMEChain = chain
 		}
 	}
-	s.Factory.Factory.CHmu.Lock()
-	s.Factory.Factory.CacheHash.Add(name, retv)
-	s.Factory.Factory.CHmu.Unlock()
+	if s.Factory.Factory.CacheHash != nil {
+		s.Factory.Factory.CHmu.Lock()
+		s.Factory.Factory.CacheHash.Add(key, retv)
+		s.Factory.Factory.CHmu.Unlock()
+	}
 	return retv, trace
 }
 
+// mxAnswers returns the MX records among answers, most preferred first.
+func mxAnswers(answers []interface{}) []miekg.MXAnswer {
+	var mxs []miekg.MXAnswer
+	for _, ans := range answers {
+		if mxAns, ok := ans.(miekg.MXAnswer); ok {
+			mxs = append(mxs, mxAns)
+		}
+	}
+	sort.SliceStable(mxs, func(i, j int) bool {
+		return mxs[i].Preference < mxs[j].Preference
+	})
+	return mxs
+}
+
+// isNullMX reports whether the MX records are the single record with
+// preference 0 and an empty exchange (".") by which a domain declares that
+// it accepts no mail (RFC 7505).
+func isNullMX(mxs []miekg.MXAnswer) bool {
+	return len(mxs) == 1 && mxs[0].Preference == 0 && strings.TrimSuffix(mxs[0].Answer.Answer, ".") == ""
+}
+
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
 	retv := Result{Servers: []MXRecord{}}
 	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeMX)
@@ -113,16 +157,22 @@ func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status,
 	if !ok {
 		panic("could not cast correctly")
 	}
-	for _, ans := range r.Answers {
-		if mxAns, ok := ans.(miekg.MXAnswer); ok {
-			name = strings.TrimSuffix(mxAns.Answer.Answer, ".")
-			rec := MXRecord{TTL: mxAns.Ttl, Type: mxAns.Type, Class: mxAns.Class, Name: name, Preference: mxAns.Preference}
+	mxs := mxAnswers(r.Answers)
+	if isNullMX(mxs) {
+		return retv, trace, zdns.STATUS_NULL_MX, nil
+	}
+	for _, mxAns := range mxs {
+		name = strings.TrimSuffix(mxAns.Answer.Answer, ".")
+		rec := MXRecord{TTL: mxAns.Ttl, Type: mxAns.Type, Class: mxAns.Class, Name: name, Preference: mxAns.Preference}
+		// a null MX mixed with real ones has nothing to resolve
+		if name != "" {
 			ips, secondTrace := s.LookupIPs(name)
 			rec.IPv4Addresses = ips.IPv4Addresses
 			rec.IPv6Addresses = ips.IPv6Addresses
-			retv.Servers = append(retv.Servers, rec)
+			rec.CNAMEChain = ips.CNAMEChain
 			trace = append(trace, secondTrace...)
 		}
+		retv.Servers = append(retv.Servers, rec)
 	}
 	return retv, trace, zdns.STATUS_NOERROR, nil
 }
@@ -155,14 +205,19 @@ type GlobalLookupFactory struct {
 func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
 	f.BoolVar(&s.IPv4Lookup, "ipv4-lookup", false, "perform A lookups for each MX server")
 	f.BoolVar(&s.IPv6Lookup, "ipv6-lookup", false, "perform AAAA record lookups for each MX server")
-	f.IntVar(&s.MXCacheSize, "mx-cache-size", 1000, "number of records to store in MX -> A/AAAA cache")
+	f.IntVar(&s.MXCacheSize, "mx-cache-size", 1000, "number of exchanges whose addresses are cached, so that exchanges shared by many domains are resolved once. 0 disables the cache")
 }
 
 func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
 	s.GlobalLookupFactory.Initialize(c)
 	s.GlobalConf = c
-	s.CacheHash = new(cachehash.CacheHash)
-	s.CacheHash.Init(s.MXCacheSize)
+	if s.MXCacheSize < 0 {
+		return errors.New("--mx-cache-size must be >= 0")
+	}
+	if s.MXCacheSize > 0 {
+		s.CacheHash = new(cachehash.CacheHash)
+		s.CacheHash.Init(s.MXCacheSize)
+	}
 	return nil
 }
 
@@ -181,6 +236,10 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // Global Registration ========================================================
 //
 func init() {
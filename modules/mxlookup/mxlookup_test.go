@@ -0,0 +1,59 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package mxlookup
+
+import (
+	"testing"
+
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func mx(preference uint16, exchange string) miekg.MXAnswer {
+	return miekg.MXAnswer{Answer: miekg.Answer{Type: "MX", Answer: exchange}, Preference: preference}
+}
+
+func TestMXAnswersOrder(t *testing.T) {
+	mxs := mxAnswers([]interface{}{
+		mx(20, "mx3.example.com"),
+		miekg.Answer{Type: "CNAME", Answer: "example.net."},
+		mx(10, "mx1.example.com"),
+		mx(20, "mx4.example.com"),
+		mx(10, "mx2.example.com"),
+	})
+	expected := []string{"mx1.example.com", "mx2.example.com", "mx3.example.com", "mx4.example.com"}
+	if len(mxs) != len(expected) {
+		t.Fatalf("Expected %d MX records, got %d", len(expected), len(mxs))
+	}
+	for i, name := range expected {
+		if mxs[i].Answer.Answer != name {
+			t.Errorf("Expected %s at position %d, got %s", name, i, mxs[i].Answer.Answer)
+		}
+	}
+}
+
+func TestIsNullMX(t *testing.T) {
+	if !isNullMX([]miekg.MXAnswer{mx(0, "")}) || !isNullMX([]miekg.MXAnswer{mx(0, ".")}) {
+		t.Error("Expected a null MX")
+	}
+	if isNullMX([]miekg.MXAnswer{mx(10, "")}) {
+		t.Error("Unexpected null MX with a non-zero preference")
+	}
+	if isNullMX([]miekg.MXAnswer{mx(0, ""), mx(10, "mx.example.com")}) {
+		t.Error("Unexpected null MX alongside another exchange")
+	}
+	if isNullMX(nil) {
+		t.Error("Unexpected null MX without records")
+	}
+}
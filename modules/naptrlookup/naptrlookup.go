@@ -0,0 +1,174 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package naptrlookup
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+type NAPTRRecord struct {
+	Order       uint16 `json:"order" groups:"short,normal,long,trace"`
+	Preference  uint16 `json:"preference" groups:"short,normal,long,trace"`
+	Flags       string `json:"flags" groups:"short,normal,long,trace"`
+	Service     string `json:"service" groups:"short,normal,long,trace"`
+	Regexp      string `json:"regexp" groups:"short,normal,long,trace"`
+	Replacement string `json:"replacement" groups:"short,normal,long,trace"`
+	TTL         uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	// records in the order a client should process them: ascending order and,
+	// within an order, ascending preference
+	Records []NAPTRRecord `json:"records" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// unescape turns a character-string in presentation format, as miekg
+// renders it (with \", \\, and \DDD escapes), back into its raw value so that
+// regexp backreferences like \1 come out as written in the zone.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+3 < len(s) && isDigit(s[i+1]) && isDigit(s[i+2]) && isDigit(s[i+3]) {
+			v := int(s[i+1]-'0')*100 + int(s[i+2]-'0')*10 + int(s[i+3]-'0')
+			if v <= 255 {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i+1])
+		i++
+	}
+	return b.String()
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func makeRecord(ans miekg.NAPTRAnswer) NAPTRRecord {
+	replacement := ans.Replacement
+	if replacement != "." {
+		replacement = strings.TrimSuffix(replacement, ".")
+	}
+	return NAPTRRecord{
+		Order:       ans.Order,
+		Preference:  ans.Preference,
+		Flags:       unescape(ans.Flags),
+		Service:     unescape(ans.Service),
+		Regexp:      unescape(ans.Regexp),
+		Replacement: replacement,
+		TTL:         ans.Ttl,
+	}
+}
+
+func sortRecords(records []NAPTRRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Order != records[j].Order {
+			return records[i].Order < records[j].Order
+		}
+		return records[i].Preference < records[j].Preference
+	})
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []NAPTRRecord{}}
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeNAPTR)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		if naptr, ok := a.(miekg.NAPTRAnswer); ok {
+			retv.Records = append(retv.Records, makeRecord(naptr))
+		}
+	}
+	if len(retv.Records) == 0 {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	sortRecords(retv.Records)
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeNAPTR, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("NAPTRLOOKUP", s)
+}
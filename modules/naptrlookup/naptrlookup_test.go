@@ -0,0 +1,51 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package naptrlookup
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnescape(t *testing.T) {
+	for in, expected := range map[string]string{
+		`!^.*$!sip:info@example.com!`:      `!^.*$!sip:info@example.com!`,
+		`!^\\+1(.*)$!sip:\\1@example.com!`: `!^\+1(.*)$!sip:\1@example.com!`,
+		`say \"hi\"`:                       `say "hi"`,
+		`tab\009here`:                      "tab\there",
+		`trailing\`:                        `trailing\`,
+	} {
+		if out := unescape(in); out != expected {
+			t.Errorf("Unexpected unescape(%q). Expected %q, got %q", in, expected, out)
+		}
+	}
+}
+
+func TestSortRecords(t *testing.T) {
+	records := []NAPTRRecord{
+		{Order: 100, Preference: 20, Service: "c"},
+		{Order: 50, Preference: 30, Service: "b"},
+		{Order: 100, Preference: 10, Service: "d"},
+		{Order: 50, Preference: 10, Service: "a"},
+	}
+	sortRecords(records)
+	var services []string
+	for _, r := range records {
+		services = append(services, r.Service)
+	}
+	if !reflect.DeepEqual(services, []string{"a", "b", "d", "c"}) {
+		t.Errorf("Unexpected record order: %v", services)
+	}
+}
@@ -16,6 +16,7 @@ package nslookup
 
 import (
 	"flag"
+	"reflect"
 	"strings"
 
 	"github.com/miekg/dns"
@@ -23,6 +24,18 @@ import (
 	"github.com/zmap/zdns/modules/miekg"
 )
 
+// where the address of a name server came from, with --follow-ns-glue
+const (
+	AddressGlue     = "glue" // the additional section of the NS response
+	AddressResolved = "resolved"
+)
+
+type NSAddress struct {
+	Address string `json:"address"`
+	Type    string `json:"type"`
+	Source  string `json:"source"`
+}
+
 // result to be returned by scan of host
 
 type NSRecord struct {
@@ -31,12 +44,30 @@ type NSRecord struct {
 	IPv4Addresses []string `json:"ipv4_addresses,omitempty"`
 	IPv6Addresses []string `json:"ipv6_addresses,omitempty"`
 	TTL           uint32   `json:"ttl"`
+	// aliases the name server's name led to; it shouldn't have any
+	CNAMEChain []miekg.CNAMELink `json:"cname_chain,omitempty"`
+	// each address along with its source, only set with --follow-ns-glue
+	Addresses []NSAddress `json:"addresses,omitempty"`
+	// the name server has neither glue nor addresses of its own, only set
+	// with --follow-ns-glue
+	Lame bool `json:"lame,omitempty"`
 }
 
 type Result struct {
 	Servers []NSRecord `json:"servers,omitempty"`
 }
 
+// How DoNSLookupWithOptions finds the addresses of name servers. The zero
+// value resolves every name server's addresses, following CNAMEs.
+type Options struct {
+	// take addresses from the glue of the NS response, resolving only
+	// those of name servers without glue (--follow-ns-glue)
+	FollowNSGlue bool
+	// report the CNAME of a name server's name instead of following it
+	// (--no-follow-cname)
+	NoFollowCNAME bool
+}
+
 // Per Connection Lookup ======================================================
 //
 type Lookup struct {
@@ -48,39 +79,108 @@ func dotName(name string) string {
 	return strings.Join([]string{name, "."}, "")
 }
 
-func (s *Lookup) lookupIPs(name string, dnsType uint16) ([]string, []interface{}) {
+// answerAddresses returns the records of type dnsType among the answers to
+// a query for name, following the CNAMEs among them. With noFollow, only
+// name's own CNAME is reported, without addresses.
+func answerAddresses(answers []interface{}, name string, dnsType uint16, noFollow bool) ([]string, []miekg.CNAMELink, error) {
+	chain, target, err := miekg.FollowCNAMEs(answers, name)
+	if noFollow && len(chain) > 1 {
+		// a loop further down the chain isn't followed
+		chain, err = chain[:1], nil
+	}
+	if err != nil {
+		return nil, chain, err
+	}
+	if noFollow && len(chain) > 0 {
+		return nil, chain, nil
+	}
 	var addresses []string
-	res, trace, status, _ := s.DoTypedMiekgLookup(name, dnsType)
-	if status == zdns.STATUS_NOERROR {
-		cast, _ := res.(miekg.Result)
-		for _, innerRes := range cast.Answers {
-			castInnerRes := innerRes.(miekg.Answer)
-			addresses = append(addresses, castInnerRes.Answer)
+	for _, innerRes := range answers {
+		castInnerRes, ok := innerRes.(miekg.Answer)
+		if !ok || castInnerRes.Type != dns.TypeToString[dnsType] || !strings.EqualFold(strings.TrimSuffix(castInnerRes.Name, "."), target) {
+			continue
 		}
+		addresses = append(addresses, castInnerRes.Answer)
 	}
-	return addresses, trace
+	return addresses, chain, nil
 }
 
-func (s *Lookup) DoNSLookup(name string, lookupIPv4 bool, lookupIPv6 bool) (Result, []interface{}, zdns.Status, error) {
-	var retv Result
-	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeNS)
-	if status != zdns.STATUS_NOERROR || err != nil {
-		return retv, trace, status, nil
+func (s *Lookup) lookupIPs(name string, dnsType uint16, noFollowCNAME bool) ([]string, []miekg.CNAMELink, []interface{}, error) {
+	res, trace, status, _ := s.DoTypedMiekgLookup(name, dnsType)
+	if status != zdns.STATUS_NOERROR {
+		return nil, nil, trace, nil
 	}
-	ns := res.(miekg.Result)
-	ipv4s := make(map[string]string)
-	ipv6s := make(map[string]string)
-	for _, ans := range ns.Additional {
+	cast, _ := res.(miekg.Result)
+	addresses, chain, err := answerAddresses(cast.Answers, name, dnsType, noFollowCNAME)
+	return addresses, chain, trace, err
+}
+
+// glueAddresses returns the A and AAAA records of an additional section,
+// keyed by lowercase name without the trailing dot.
+func glueAddresses(additional []interface{}) (ipv4s map[string][]string, ipv6s map[string][]string) {
+	ipv4s = make(map[string][]string)
+	ipv6s = make(map[string][]string)
+	for _, ans := range additional {
 		a, ok := ans.(miekg.Answer)
 		if !ok {
 			continue
 		}
+		name := strings.ToLower(strings.TrimSuffix(a.Name, "."))
 		if a.Type == "A" {
-			ipv4s[a.Name] = a.Answer
+			ipv4s[name] = append(ipv4s[name], a.Answer)
 		} else if a.Type == "AAAA" {
-			ipv6s[a.Name] = a.Answer
+			ipv6s[name] = append(ipv6s[name], a.Answer)
+		}
+	}
+	return ipv4s, ipv6s
+}
+
+// glueOrResolve returns the glue addresses of type dnsType of name server
+// rec, or resolves them if there are none, adding each to rec.Addresses.
+func (s *Lookup) glueOrResolve(rec *NSRecord, glue []string, dnsType uint16, noFollowCNAME bool, trace []interface{}) ([]string, []interface{}, error) {
+	addresses, source := glue, AddressGlue
+	var err error
+	if len(glue) == 0 {
+		var chain []miekg.CNAMELink
+		var secondTrace []interface{}
+		addresses, chain, secondTrace, err = s.lookupIPs(rec.Name, dnsType, noFollowCNAME)
+		trace = append(trace, secondTrace...)
+		if len(chain) > len(rec.CNAMEChain) {
+			rec.CNAMEChain = chain
 		}
+		source = AddressResolved
+	}
+	for _, a := range addresses {
+		rec.Addresses = append(rec.Addresses, NSAddress{Address: a, Type: dns.TypeToString[dnsType], Source: source})
+	}
+	return addresses, trace, err
+}
+
+// firstError returns first unless it's nil, and err otherwise.
+func firstError(first error, err error) error {
+	if first != nil {
+		return first
+	}
+	return err
+}
+
+// DoNSLookup looks up the name servers of name along with their addresses,
+// with the default Options. Other modules that embed Lookup, whose Factory
+// isn't set, use it.
+func (s *Lookup) DoNSLookup(name string, lookupIPv4 bool, lookupIPv6 bool) (Result, []interface{}, zdns.Status, error) {
+	return s.DoNSLookupWithOptions(name, lookupIPv4, lookupIPv6, Options{})
+}
+
+func (s *Lookup) DoNSLookupWithOptions(name string, lookupIPv4 bool, lookupIPv6 bool, opts Options) (Result, []interface{}, zdns.Status, error) {
+	var retv Result
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeNS)
+	if status != zdns.STATUS_NOERROR || err != nil {
+		return retv, trace, status, nil
 	}
+	ns := res.(miekg.Result)
+	ipv4s, ipv6s := glueAddresses(ns.Additional)
+	// the first CNAME loop among the name servers' names, if any
+	var loopErr error
 	for _, ans := range ns.Answers {
 		a, ok := ans.(miekg.Answer)
 		if !ok {
@@ -94,26 +194,50 @@ func (s *Lookup) DoNSLookup(name string, lookupIPv4 bool, lookupIPv6 bool) (Resu
 		rec.Type = a.Type
 		rec.Name = strings.TrimSuffix(a.Answer, ".")
 		rec.TTL = a.Ttl
+		glueName := strings.ToLower(rec.Name)
+		if opts.FollowNSGlue {
+			if lookupIPv4 || !lookupIPv6 {
+				rec.IPv4Addresses, trace, err = s.glueOrResolve(&rec, ipv4s[glueName], dns.TypeA, opts.NoFollowCNAME, trace)
+				loopErr = firstError(loopErr, err)
+			}
+			if lookupIPv6 {
+				rec.IPv6Addresses, trace, err = s.glueOrResolve(&rec, ipv6s[glueName], dns.TypeAAAA, opts.NoFollowCNAME, trace)
+				loopErr = firstError(loopErr, err)
+			}
+			// an alias that wasn't followed may well have addresses
+			rec.Lame = len(rec.Addresses) == 0 && !(opts.NoFollowCNAME && len(rec.CNAMEChain) > 0)
+			retv.Servers = append(retv.Servers, rec)
+			continue
+		}
 		if lookupIPv4 || !lookupIPv6 {
 			var secondTrace []interface{}
-			rec.IPv4Addresses, secondTrace = s.lookupIPs(rec.Name, dns.TypeA)
+			rec.IPv4Addresses, rec.CNAMEChain, secondTrace, err = s.lookupIPs(rec.Name, dns.TypeA, opts.NoFollowCNAME)
 			trace = append(trace, secondTrace...)
-		} else if ip, ok := ipv4s[rec.Name]; ok {
-			rec.IPv4Addresses = []string{ip}
+			loopErr = firstError(loopErr, err)
+		} else if ips, ok := ipv4s[glueName]; ok {
+			rec.IPv4Addresses = ips
 		} else {
 			rec.IPv4Addresses = []string{}
 		}
 		if lookupIPv6 {
 			var secondTrace []interface{}
-			rec.IPv6Addresses, secondTrace = s.lookupIPs(rec.Name, dns.TypeAAAA)
+			var chain []miekg.CNAMELink
+			rec.IPv6Addresses, chain, secondTrace, err = s.lookupIPs(rec.Name, dns.TypeAAAA, opts.NoFollowCNAME)
+			if len(chain) > len(rec.CNAMEChain) {
+				rec.CNAMEChain = chain
+			}
 			trace = append(trace, secondTrace...)
-		} else if ip, ok := ipv6s[rec.Name]; ok {
-			rec.IPv6Addresses = []string{ip}
+			loopErr = firstError(loopErr, err)
+		} else if ips, ok := ipv6s[glueName]; ok {
+			rec.IPv6Addresses = ips
 		} else {
 			rec.IPv6Addresses = []string{}
 		}
 		retv.Servers = append(retv.Servers, rec)
 	}
+	if loopErr != nil {
+		return retv, trace, zdns.STATUS_CNAME_LOOP, loopErr
+	}
 	if len(retv.Servers) == 0 {
 		return retv, trace, zdns.STATUS_NO_RECORD, nil
 	}
@@ -123,7 +247,8 @@ func (s *Lookup) DoNSLookup(name string, lookupIPv4 bool, lookupIPv6 bool) (Resu
 }
 
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
-	return s.DoNSLookup(name, s.Factory.Factory.IPv4Lookup, s.Factory.Factory.IPv6Lookup)
+	f := s.Factory.Factory
+	return s.DoNSLookupWithOptions(name, f.IPv4Lookup, f.IPv6Lookup, Options{FollowNSGlue: f.FollowNSGlue, NoFollowCNAME: f.NoFollowCNAME})
 }
 
 // Per GoRoutine Factory ======================================================
@@ -144,13 +269,17 @@ func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
 //
 type GlobalLookupFactory struct {
 	miekg.GlobalLookupFactory
-	IPv4Lookup bool
-	IPv6Lookup bool
+	IPv4Lookup    bool
+	IPv6Lookup    bool
+	FollowNSGlue  bool
+	NoFollowCNAME bool
 }
 
 func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
 	f.BoolVar(&s.IPv4Lookup, "ipv4-lookup", false, "perform A lookups for each name server")
 	f.BoolVar(&s.IPv6Lookup, "ipv6-lookup", false, "perform AAAA record lookups for each name server")
+	f.BoolVar(&s.FollowNSGlue, "follow-ns-glue", false, "take each name server's addresses from the glue in the NS response, resolving only those without glue, and report lame name servers")
+	f.BoolVar(&s.NoFollowCNAME, "no-follow-cname", false, "report the CNAME record of a name server that is an alias instead of following it to the addresses of its target")
 }
 
 // Command-line Help Documentation. This is the descriptive text what is
@@ -168,6 +297,10 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // Global Registration ========================================================
 //
 func init() {
@@ -0,0 +1,90 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package nslookup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func TestGlueAddresses(t *testing.T) {
+	ipv4s, ipv6s := glueAddresses([]interface{}{
+		miekg.Answer{Type: "A", Name: "NS1.example.com.", Answer: "192.0.2.1"},
+		miekg.Answer{Type: "A", Name: "ns1.example.com.", Answer: "192.0.2.2"},
+		miekg.Answer{Type: "AAAA", Name: "ns1.example.com.", Answer: "2001:db8::1"},
+		miekg.Answer{Type: "TXT", Name: "ns2.example.com.", Answer: "not glue"},
+	})
+	if want := map[string][]string{"ns1.example.com": {"192.0.2.1", "192.0.2.2"}}; !reflect.DeepEqual(ipv4s, want) {
+		t.Errorf("IPv4 glue is %v, expected %v", ipv4s, want)
+	}
+	if want := map[string][]string{"ns1.example.com": {"2001:db8::1"}}; !reflect.DeepEqual(ipv6s, want) {
+		t.Errorf("IPv6 glue is %v, expected %v", ipv6s, want)
+	}
+}
+
+func TestAnswerAddresses(t *testing.T) {
+	answers := []interface{}{
+		miekg.Answer{Type: "CNAME", Name: "ns1.example.com.", Answer: "alias.example.net.", Ttl: 60},
+		miekg.Answer{Type: "CNAME", Name: "alias.example.net.", Answer: "host.example.org.", Ttl: 30},
+		miekg.Answer{Type: "A", Name: "host.example.org.", Answer: "192.0.2.1"},
+		miekg.Answer{Type: "A", Name: "other.example.org.", Answer: "192.0.2.2"},
+	}
+	addresses, chain, err := answerAddresses(answers, "ns1.example.com", dns.TypeA, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"192.0.2.1"}; !reflect.DeepEqual(addresses, want) {
+		t.Errorf("Addresses are %v, expected %v", addresses, want)
+	}
+	want := []miekg.CNAMELink{
+		{Name: "ns1.example.com", Target: "alias.example.net", Ttl: 60},
+		{Name: "alias.example.net", Target: "host.example.org", Ttl: 30},
+	}
+	if !reflect.DeepEqual(chain, want) {
+		t.Errorf("CNAME chain is %v, expected %v", chain, want)
+	}
+
+	addresses, chain, err = answerAddresses(answers, "ns1.example.com", dns.TypeA, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 0 {
+		t.Errorf("Expected no addresses without following, got %v", addresses)
+	}
+	if !reflect.DeepEqual(chain, want[:1]) {
+		t.Errorf("CNAME chain is %v, expected %v", chain, want[:1])
+	}
+}
+
+func TestAnswerAddressesLoop(t *testing.T) {
+	answers := []interface{}{
+		miekg.Answer{Type: "CNAME", Name: "ns1.example.com.", Answer: "alias.example.net."},
+		miekg.Answer{Type: "CNAME", Name: "alias.example.net.", Answer: "ns1.example.com."},
+	}
+	if _, chain, err := answerAddresses(answers, "ns1.example.com", dns.TypeA, false); err == nil || len(chain) != 2 {
+		t.Errorf("Expected a CNAME loop over 2 links, got %v (%v)", chain, err)
+	}
+	// without following, only a name that is its own alias loops
+	if _, chain, err := answerAddresses(answers, "ns1.example.com", dns.TypeA, true); err != nil || len(chain) != 1 {
+		t.Errorf("Expected the first link without an error, got %v (%v)", chain, err)
+	}
+	self := []interface{}{miekg.Answer{Type: "CNAME", Name: "ns1.example.com.", Answer: "ns1.example.com."}}
+	if _, _, err := answerAddresses(self, "ns1.example.com", dns.TypeA, true); err == nil {
+		t.Error("Expected a name that is its own alias to loop")
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ptrlookup
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+type PTRRecord struct {
+	Name string `json:"name" groups:"short,normal,long,trace"`
+	TTL  uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	ReverseName string      `json:"reverse_name" groups:"short,normal,long,trace"`
+	Records     []PTRRecord `json:"ptr" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// reverseName builds the in-addr.arpa (IPv4) or nibble-format ip6.arpa (IPv6)
+// name for an address, without the trailing dot.
+func reverseName(addr string) (string, error) {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return "", errors.New("input is not an IP address")
+	}
+	name, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(name, "."), nil
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []PTRRecord{}}
+	rev, err := reverseName(name)
+	if err != nil {
+		return nil, nil, zdns.STATUS_ILLEGAL_INPUT, err
+	}
+	retv.ReverseName = rev
+	res, trace, status, err := s.DoTypedMiekgLookup(rev, dns.TypePTR)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		ans, ok := a.(miekg.Answer)
+		if !ok || ans.Type != "PTR" {
+			continue
+		}
+		retv.Records = append(retv.Records, PTRRecord{
+			Name: strings.TrimSuffix(ans.Answer, "."),
+			TTL:  ans.Ttl,
+		})
+	}
+	if len(retv.Records) == 0 {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypePTR, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("PTRLOOKUP", s)
+}
@@ -0,0 +1,48 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package ptrlookup
+
+import (
+	"testing"
+)
+
+func TestReverseName(t *testing.T) {
+	tests := []struct {
+		addr, expected string
+	}{
+		{"192.0.2.1", "1.2.0.192.in-addr.arpa"},
+		{" 198.51.100.254\n", "254.100.51.198.in-addr.arpa"},
+		{"2001:db8::1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		// upper case and zero compression don't change the name
+		{"2001:DB8:0:0:0:0:0:1", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa"},
+		// IPv4-mapped addresses are looked up as IPv4
+		{"::ffff:192.0.2.1", "1.2.0.192.in-addr.arpa"},
+	}
+	for _, test := range tests {
+		name, err := reverseName(test.addr)
+		if err != nil {
+			t.Errorf("%q: %v", test.addr, err)
+			continue
+		}
+		if name != test.expected {
+			t.Errorf("%q: got %s, expected %s", test.addr, name, test.expected)
+		}
+	}
+	for _, bad := range []string{"", "example.com", "192.0.2", "192.0.2.256", "192.0.2.0/24", "2001:db8::g", "1.2.0.192.in-addr.arpa"} {
+		if name, err := reverseName(bad); err == nil {
+			t.Errorf("%q accepted as %s", bad, name)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package soalookup
+
+import (
+	"flag"
+	"net"
+	"reflect"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+	"github.com/zmap/zdns/modules/nslookup"
+)
+
+// result to be returned by scan of host
+
+type SOARecord struct {
+	PrimaryNS          string `json:"primary_ns" groups:"short,normal,long,trace"`
+	ResponsibleMailbox string `json:"responsible_mailbox" groups:"short,normal,long,trace"`
+	Serial             uint32 `json:"serial" groups:"short,normal,long,trace"`
+	Refresh            uint32 `json:"refresh" groups:"short,normal,long,trace"`
+	Retry              uint32 `json:"retry" groups:"short,normal,long,trace"`
+	Expire             uint32 `json:"expire" groups:"short,normal,long,trace"`
+	MinTTL             uint32 `json:"min_ttl" groups:"short,normal,long,trace"`
+	TTL                uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+// the SOA serial reported by one of the zone's authoritative name servers
+type ServerSerial struct {
+	Name          string      `json:"name" groups:"short,normal,long,trace"`
+	Address       string      `json:"address,omitempty" groups:"short,normal,long,trace"`
+	Status        zdns.Status `json:"status" groups:"short,normal,long,trace"`
+	Authoritative bool        `json:"authoritative" groups:"short,normal,long,trace"`
+	Serial        uint32      `json:"serial" groups:"short,normal,long,trace"`
+	// the server answered with an older serial than the newest one seen
+	OutOfSync bool `json:"out_of_sync" groups:"short,normal,long,trace"`
+}
+
+type SerialCheck struct {
+	Servers      []ServerSerial `json:"servers" groups:"short,normal,long,trace"`
+	LatestSerial uint32         `json:"latest_serial" groups:"short,normal,long,trace"`
+	// every server that answered reported the same serial
+	InSync bool `json:"in_sync" groups:"short,normal,long,trace"`
+}
+
+type Result struct {
+	SOA *SOARecord `json:"soa,omitempty" groups:"short,normal,long,trace"`
+	// only set with --check-serials
+	SerialCheck *SerialCheck `json:"serial_check,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	nslookup.Lookup
+}
+
+func makeRecord(ans miekg.SOAAnswer) *SOARecord {
+	return &SOARecord{
+		PrimaryNS:          ans.Ns,
+		ResponsibleMailbox: ans.Mbox,
+		Serial:             ans.Serial,
+		Refresh:            ans.Refresh,
+		Retry:              ans.Retry,
+		Expire:             ans.Expire,
+		MinTTL:             ans.Minttl,
+		TTL:                ans.Ttl,
+	}
+}
+
+// findSOA returns the SOA record in the answer section of res, if any
+func findSOA(res interface{}) *SOARecord {
+	r, ok := res.(miekg.Result)
+	if !ok {
+		return nil
+	}
+	for _, a := range r.Answers {
+		if soa, ok := a.(miekg.SOAAnswer); ok {
+			return makeRecord(soa)
+		}
+	}
+	return nil
+}
+
+// serialNewer reports whether serial a is newer than b using the sequence
+// space arithmetic of RFC 1982, so that a serial that wrapped around
+// still counts as newer.
+func serialNewer(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// compareSerials picks the newest serial among the servers that answered and
+// flags every server behind it.
+func compareSerials(servers []ServerSerial) SerialCheck {
+	check := SerialCheck{Servers: servers, InSync: true}
+	answered := false
+	for _, server := range servers {
+		if server.Status != zdns.STATUS_NOERROR {
+			continue
+		}
+		if !answered || serialNewer(server.Serial, check.LatestSerial) {
+			check.LatestSerial = server.Serial
+		}
+		answered = true
+	}
+	for i := range check.Servers {
+		if check.Servers[i].Status == zdns.STATUS_NOERROR && check.Servers[i].Serial != check.LatestSerial {
+			check.Servers[i].OutOfSync = true
+			check.InSync = false
+		}
+	}
+	return check
+}
+
+// checkSerials discovers the name servers of zone name and asks each of them
+// directly for its SOA serial.
+func (s *Lookup) checkSerials(name string) (*SerialCheck, []interface{}) {
+	ns, trace, status, _ := s.DoNSLookup(name, true, false)
+	if status != zdns.STATUS_NOERROR {
+		return &SerialCheck{Servers: []ServerSerial{}}, trace
+	}
+	servers := make([]ServerSerial, 0, len(ns.Servers))
+	for _, server := range ns.Servers {
+		serial := ServerSerial{Name: server.Name}
+		if len(server.IPv4Addresses) == 0 {
+			serial.Status = zdns.STATUS_NO_RECORD
+			servers = append(servers, serial)
+			continue
+		}
+		serial.Address = server.IPv4Addresses[0]
+		res, serverTrace, serverStatus, _ := s.DoServerLookup(name, dns.TypeSOA, net.JoinHostPort(serial.Address, "53"))
+		trace = append(trace, serverTrace...)
+		serial.Status = serverStatus
+		serial.Authoritative = res.Flags.Authoritative
+		if serverStatus == zdns.STATUS_NOERROR {
+			if soa := findSOA(res); soa != nil {
+				serial.Serial = soa.Serial
+			} else {
+				serial.Status = zdns.STATUS_NO_RECORD
+			}
+		}
+		servers = append(servers, serial)
+	}
+	check := compareSerials(servers)
+	return &check, trace
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	var retv Result
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeSOA)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	if retv.SOA = findSOA(res); retv.SOA == nil {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	if s.Factory.Factory.CheckSerials {
+		var checkTrace []interface{}
+		retv.SerialCheck, checkTrace = s.checkSerials(name)
+		trace = append(trace, checkTrace...)
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeSOA, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	CheckSerials bool
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.BoolVar(&s.CheckSerials, "check-serials", false, "also query every authoritative name server of the zone and report whether their SOA serials agree")
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("SOALOOKUP", s)
+}
@@ -0,0 +1,118 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package soalookup
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+)
+
+func TestSerialNewer(t *testing.T) {
+	if !serialNewer(2, 1) || serialNewer(1, 2) || serialNewer(5, 5) {
+		t.Error("Unexpected ordering of plain serials")
+	}
+	// RFC 1982: incrementing past 2^32-1 wraps around to a newer serial
+	if !serialNewer(3, 4294967290) || serialNewer(4294967290, 3) {
+		t.Error("Unexpected ordering of wrapped serials")
+	}
+}
+
+func TestCompareSerials(t *testing.T) {
+	check := compareSerials([]ServerSerial{
+		{Name: "ns1.example.com", Status: zdns.STATUS_NOERROR, Serial: 2020010102},
+		{Name: "ns2.example.com", Status: zdns.STATUS_NOERROR, Serial: 2020010101},
+		{Name: "ns3.example.com", Status: zdns.STATUS_TIMEOUT},
+	})
+	if check.InSync || check.LatestSerial != 2020010102 {
+		t.Errorf("Expected out of sync zone at serial 2020010102, got %+v", check)
+	}
+	if check.Servers[0].OutOfSync || !check.Servers[1].OutOfSync || check.Servers[2].OutOfSync {
+		t.Errorf("Unexpected out of sync servers: %+v", check.Servers)
+	}
+	check = compareSerials([]ServerSerial{
+		{Name: "ns1.example.com", Status: zdns.STATUS_NOERROR, Serial: 7},
+		{Name: "ns2.example.com", Status: zdns.STATUS_NOERROR, Serial: 7},
+	})
+	if !check.InSync || check.LatestSerial != 7 {
+		t.Errorf("Expected zone in sync at serial 7, got %+v", check)
+	}
+}
+
+// serveNS answers queries for the name servers of example.com, which is
+// served by ns1.example.com at 127.0.0.1, on the returned address.
+func serveNS(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, dns.MaxMsgSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			m := new(dns.Msg)
+			if m.Unpack(buf[:n]) != nil || len(m.Question) != 1 {
+				continue
+			}
+			r := new(dns.Msg)
+			r.SetReply(m)
+			q := m.Question[0]
+			hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 300}
+			switch {
+			case q.Qtype == dns.TypeNS && q.Name == "example.com.":
+				r.Answer = append(r.Answer, &dns.NS{Hdr: hdr, Ns: "ns1.example.com."})
+			case q.Qtype == dns.TypeSOA && q.Name == "example.com.":
+				r.Answer = append(r.Answer, &dns.SOA{Hdr: hdr, Ns: "ns1.example.com.", Mbox: "hostmaster.example.com.", Serial: 1})
+			case q.Qtype == dns.TypeA && q.Name == "ns1.example.com.":
+				r.Answer = append(r.Answer, &dns.A{Hdr: hdr, A: net.ParseIP("127.0.0.1")})
+			}
+			wire, _ := r.Pack()
+			conn.WriteTo(wire, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestCheckSerialsFromMakeLookup(t *testing.T) {
+	gc := &zdns.GlobalConf{NameServers: []string{serveNS(t)}, Timeout: 2 * time.Second, Class: dns.ClassINET}
+	glf := new(GlobalLookupFactory)
+	glf.CheckSerials = true
+	if err := glf.Initialize(gc); err != nil {
+		t.Fatal(err)
+	}
+	rlf, err := glf.MakeRoutineFactory(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := rlf.MakeLookup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _, status, err := l.DoLookup("example.com")
+	if status != zdns.STATUS_NOERROR || err != nil {
+		t.Fatalf("expected status %s, got %s: %v", zdns.STATUS_NOERROR, status, err)
+	}
+	check := res.(Result).SerialCheck
+	if check == nil || len(check.Servers) != 1 || check.Servers[0].Name != "ns1.example.com" || check.Servers[0].Address != "127.0.0.1" {
+		t.Errorf("expected the serial of ns1.example.com to be checked, got %+v", check)
+	}
+}
@@ -15,14 +15,165 @@
 package spf
 
 import (
+	"flag"
+	"reflect"
+	"strings"
+
 	"github.com/miekg/dns"
 	"github.com/zmap/zdns"
 	"github.com/zmap/zdns/modules/miekg"
 )
 
+// the most DNS lookups that evaluating a record may cause (RFC 7208, 4.6.4)
+const lookupLimit = 10
+
 // result to be returned by scan of host
 type Result struct {
 	Spf string `json:"spf,omitempty" groups:"short,normal,long,trace"`
+	// the following are only set with --follow-includes
+	Tree *Record `json:"tree,omitempty" groups:"short,normal,long,trace"`
+	// the mechanisms of the record and of those it includes or redirects to,
+	// with the domain of a, mx, and ptr made explicit
+	Mechanisms          []string `json:"mechanisms,omitempty" groups:"short,normal,long,trace"`
+	Lookups             int      `json:"dns_lookups,omitempty" groups:"short,normal,long,trace"`
+	LookupLimitExceeded bool     `json:"lookup_limit_exceeded,omitempty" groups:"short,normal,long,trace"`
+	IncludeLoop         bool     `json:"include_loop,omitempty" groups:"short,normal,long,trace"`
+}
+
+// Record is the SPF record of a domain, along with the records it includes
+// and redirects to.
+type Record struct {
+	Domain string `json:"domain" groups:"short,normal,long,trace"`
+	Record string `json:"record,omitempty" groups:"short,normal,long,trace"`
+	Status string `json:"status,omitempty" groups:"short,normal,long,trace"`
+	Error  string `json:"error,omitempty" groups:"short,normal,long,trace"`
+	// the records of the include: mechanisms, in order
+	Includes []*Record `json:"includes,omitempty" groups:"short,normal,long,trace"`
+	Redirect *Record   `json:"redirect,omitempty" groups:"short,normal,long,trace"`
+}
+
+// resolver follows the includes and redirects of an SPF record.
+type resolver struct {
+	// looks up the records starting with v=spf of a name
+	txt   func(name string) ([]string, []interface{}, zdns.Status, error)
+	res   *Result
+	trace []interface{}
+}
+
+// spf1Records returns the SPF version 1 records among records starting with
+// v=spf, reading records split into several strings as their concatenation.
+func spf1Records(records []string) []string {
+	var spf1 []string
+	for _, r := range records {
+		r = strings.Replace(r, "\n", "", -1)
+		if fields := strings.Fields(r); len(fields) > 0 && strings.EqualFold(fields[0], "v=spf1") {
+			spf1 = append(spf1, r)
+		}
+	}
+	return spf1
+}
+
+// countLookup counts a term that causes a DNS lookup and reports whether
+// the limit still allows it.
+func (r *resolver) countLookup() bool {
+	r.res.Lookups++
+	if r.res.Lookups > lookupLimit {
+		r.res.LookupLimitExceeded = true
+		return false
+	}
+	return true
+}
+
+// follow resolves the target of an include or redirect, unless the lookup
+// limit is exceeded or the target can't be known without evaluating macros.
+func (r *resolver) follow(target string, path []string, included bool) *Record {
+	if !r.countLookup() {
+		return &Record{Domain: target, Error: "not followed: DNS lookup limit exceeded"}
+	}
+	if strings.Contains(target, "%") {
+		return &Record{Domain: target, Error: "not followed: macros need a sender to expand"}
+	}
+	return r.resolve(target, path, included)
+}
+
+// resolve looks up the SPF record of domain and follows its includes and
+// redirect. path holds the domains being resolved further up, for detecting
+// loops, and included tells whether the record is evaluated for an include.
+func (r *resolver) resolve(domain string, path []string, included bool) *Record {
+	node := &Record{Domain: domain}
+	for _, d := range path {
+		if strings.EqualFold(strings.TrimSuffix(d, "."), strings.TrimSuffix(domain, ".")) {
+			r.res.IncludeLoop = true
+			node.Error = "include loop"
+			return node
+		}
+	}
+	records, trace, status, err := r.txt(domain)
+	r.trace = append(r.trace, trace...)
+	if status == zdns.STATUS_NOERROR {
+		records = spf1Records(records)
+		if len(records) == 0 {
+			status = zdns.STATUS_NO_RECORD
+		} else if len(records) > 1 {
+			status = zdns.STATUS_MULTI_RECORD
+		}
+	}
+	node.Status = string(status)
+	if err != nil {
+		node.Error = err.Error()
+	}
+	if status != zdns.STATUS_NOERROR {
+		return node
+	}
+	node.Record = records[0]
+	path = append(path[:len(path):len(path)], domain)
+	var redirect string
+	hasAll := false
+	for _, term := range strings.Fields(node.Record)[1:] {
+		mechanism := strings.TrimLeft(term, "+-~?")
+		name := strings.ToLower(mechanism)
+		if i := strings.IndexAny(name, ":/="); i >= 0 {
+			name = name[:i]
+		}
+		switch name {
+		case "include", "redirect":
+			if len(mechanism) <= len(name)+1 || mechanism[len(name)] == '/' {
+				node.Error = "malformed term " + term
+				continue
+			}
+			if name == "include" {
+				node.Includes = append(node.Includes, r.follow(mechanism[len(name)+1:], path, true))
+			} else {
+				redirect = mechanism[len(name)+1:]
+			}
+		case "exp":
+		case "a", "mx", "ptr":
+			r.countLookup()
+			if !strings.HasPrefix(mechanism[len(name):], ":") {
+				i := len(term) - len(mechanism) + len(name)
+				term = term[:i] + ":" + strings.TrimSuffix(domain, ".") + term[i:]
+			}
+			r.res.Mechanisms = append(r.res.Mechanisms, term)
+		case "exists":
+			r.countLookup()
+			r.res.Mechanisms = append(r.res.Mechanisms, term)
+		case "all":
+			hasAll = true
+			// the all of an included record only ends its own evaluation
+			if !included {
+				r.res.Mechanisms = append(r.res.Mechanisms, term)
+			}
+		default:
+			if !strings.Contains(mechanism, "=") {
+				r.res.Mechanisms = append(r.res.Mechanisms, term)
+			}
+		}
+	}
+	// a redirect only applies to records without an all mechanism
+	if redirect != "" && !hasAll {
+		node.Redirect = r.follow(redirect, path, included)
+	}
+	return node
 }
 
 // Per Connection Lookup ======================================================
@@ -34,6 +185,12 @@ type Lookup struct {
 
 func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
 	var res Result
+	if s.Factory.Factory.FollowIncludes {
+		r := resolver{txt: s.DoTxtLookupAll, res: &res}
+		res.Tree = r.resolve(name, nil, false)
+		res.Spf = res.Tree.Record
+		return res, r.trace, zdns.Status(res.Tree.Status), nil
+	}
 	innerRes, trace, status, err := s.DoTxtLookup(name)
 	if status != zdns.STATUS_NOERROR {
 		return res, trace, status, err
@@ -61,6 +218,11 @@ func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
 //
 type GlobalLookupFactory struct {
 	miekg.GlobalLookupFactory
+	FollowIncludes bool
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.BoolVar(&s.FollowIncludes, "follow-includes", false, "follow the include: mechanisms and redirect= modifiers of SPF records, up to the limit of 10 DNS lookups, and report the tree of records and the mechanisms they add up to")
 }
 
 func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
@@ -72,6 +234,10 @@ func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLook
 	return r, nil
 }
 
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
 // Global Registration ========================================================
 //
 func init() {
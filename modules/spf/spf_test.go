@@ -0,0 +1,121 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package spf
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zmap/zdns"
+)
+
+// fakeTXT answers TXT lookups from a map of names to records.
+func fakeTXT(records map[string][]string) func(string) ([]string, []interface{}, zdns.Status, error) {
+	return func(name string) ([]string, []interface{}, zdns.Status, error) {
+		r, ok := records[name]
+		if !ok {
+			return nil, nil, zdns.STATUS_NXDOMAIN, nil
+		}
+		return r, []interface{}{name}, zdns.STATUS_NOERROR, nil
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	var res Result
+	// _spf.example.net is split into two strings
+	r := resolver{res: &res, txt: fakeTXT(map[string][]string{
+		"example.com":       {"v=spf1 a mx/24 include:_spf.example.net ip4:192.0.2.0/24 redirect=_spf.example.org -all"},
+		"_spf.example.net":  {"v=spf1 ip6:2001:db8::/32 \ninclude:_spf2.example.net ~all"},
+		"_spf2.example.net": {"v=spf1 -a:mail.example.net ?all"},
+	})}
+	tree := r.resolve("example.com", nil, false)
+	expected := []string{"a:example.com", "mx:example.com/24", "ip6:2001:db8::/32", "-a:mail.example.net", "ip4:192.0.2.0/24", "-all"}
+	if !reflect.DeepEqual(res.Mechanisms, expected) {
+		t.Errorf("Expected mechanisms %v, got %v", expected, res.Mechanisms)
+	}
+	if res.Lookups != 5 || res.LookupLimitExceeded || res.IncludeLoop {
+		t.Errorf("Expected 5 lookups without problems, got %+v", res)
+	}
+	if tree.Redirect != nil {
+		t.Error("Unexpected redirect of a record with an all mechanism")
+	}
+	if len(tree.Includes) != 1 || len(tree.Includes[0].Includes) != 1 || tree.Includes[0].Includes[0].Domain != "_spf2.example.net" {
+		t.Errorf("Unexpected tree %+v", tree)
+	}
+	if len(r.trace) != 3 {
+		t.Errorf("Expected the traces of 3 lookups, got %d", len(r.trace))
+	}
+}
+
+func TestResolveRedirect(t *testing.T) {
+	var res Result
+	r := resolver{res: &res, txt: fakeTXT(map[string][]string{
+		"example.com":      {"v=spf1 redirect=_spf.example.org"},
+		"_spf.example.org": {"v=spf1 ip4:198.51.100.1 -all"},
+	})}
+	tree := r.resolve("example.com", nil, false)
+	if tree.Redirect == nil || tree.Redirect.Record != "v=spf1 ip4:198.51.100.1 -all" {
+		t.Fatalf("Expected the redirect to be followed, got %+v", tree)
+	}
+	// the all of a redirect target applies
+	expected := []string{"ip4:198.51.100.1", "-all"}
+	if !reflect.DeepEqual(res.Mechanisms, expected) {
+		t.Errorf("Expected mechanisms %v, got %v", expected, res.Mechanisms)
+	}
+}
+
+func TestResolveLoop(t *testing.T) {
+	var res Result
+	r := resolver{res: &res, txt: fakeTXT(map[string][]string{
+		"example.com":      {"v=spf1 include:_spf.example.net -all"},
+		"_spf.example.net": {"v=spf1 include:example.com -all"},
+	})}
+	tree := r.resolve("example.com", nil, false)
+	if !res.IncludeLoop || tree.Includes[0].Includes[0].Error != "include loop" {
+		t.Errorf("Expected an include loop, got %+v", tree.Includes[0].Includes[0])
+	}
+}
+
+func TestResolveLookupLimit(t *testing.T) {
+	var res Result
+	r := resolver{res: &res, txt: fakeTXT(map[string][]string{
+		"example.com":      {"v=spf1 a mx a:a.example.com a:b.example.com a:c.example.com a:d.example.com a:e.example.com a:f.example.com a:g.example.com a:h.example.com include:_spf.example.net -all"},
+		"_spf.example.net": {"v=spf1 ip4:192.0.2.1 -all"},
+	})}
+	tree := r.resolve("example.com", nil, false)
+	if !res.LookupLimitExceeded || res.Lookups != 11 {
+		t.Errorf("Expected the lookup limit to be exceeded, got %d lookups", res.Lookups)
+	}
+	if tree.Includes[0].Record != "" || tree.Includes[0].Error == "" {
+		t.Errorf("Expected the include past the limit not to be followed, got %+v", tree.Includes[0])
+	}
+}
+
+func TestResolveStatuses(t *testing.T) {
+	var res Result
+	r := resolver{res: &res, txt: fakeTXT(map[string][]string{
+		"example.com": {"v=spf1 -all", "v=spf1 +all"},
+		"example.net": {"v=spf2.0/pra -all"},
+	})}
+	if tree := r.resolve("example.com", nil, false); tree.Status != string(zdns.STATUS_MULTI_RECORD) {
+		t.Errorf("Expected MULTIPLE_RECORDS, got %s", tree.Status)
+	}
+	if tree := r.resolve("example.net", nil, false); tree.Status != string(zdns.STATUS_NO_RECORD) {
+		t.Errorf("Expected NORECORD for a Sender ID record, got %s", tree.Status)
+	}
+	if tree := r.resolve("example.org", nil, false); tree.Status != string(zdns.STATUS_NXDOMAIN) {
+		t.Errorf("Expected NXDOMAIN, got %s", tree.Status)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package srvlookup
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+type SRVRecord struct {
+	Name     string `json:"name" groups:"short,normal,long,trace"`
+	Priority uint16 `json:"priority" groups:"short,normal,long,trace"`
+	Weight   uint16 `json:"weight" groups:"short,normal,long,trace"`
+	Port     uint16 `json:"port" groups:"short,normal,long,trace"`
+	Target   string `json:"target" groups:"short,normal,long,trace"`
+	TTL      uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	// the name that was actually queried (i.e., _service._proto.name)
+	QueryName string `json:"query_name,omitempty" groups:"short,normal,long,trace"`
+	// records in the order a client should try them: ascending priority and,
+	// within a priority, the weighted random order of RFC 2782
+	Records []SRVRecord `json:"records" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// ServiceName prepends the _service._proto labels to name. If no service is
+// given, name is returned untouched.
+func ServiceName(name string, service string, proto string) string {
+	if service == "" {
+		return name
+	}
+	service = "_" + strings.TrimPrefix(service, "_")
+	proto = "_" + strings.TrimPrefix(proto, "_")
+	return strings.Join([]string{service, proto, name}, ".")
+}
+
+// orderRecords puts records in the order a client should try them (RFC
+// 2782): by ascending priority and, within a priority, by repeated weighted
+// random selection, which tends to put heavier records first but leaves
+// records of weight 0 a small chance of coming early. intn returns a number
+// drawn uniformly from [0, n).
+func orderRecords(records []SRVRecord, intn func(n int) int) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		// records of weight 0 go first, as the selection expects
+		return records[i].Weight == 0 && records[j].Weight != 0
+	})
+	for start := 0; start < len(records); {
+		end := start
+		for end < len(records) && records[end].Priority == records[start].Priority {
+			end++
+		}
+		for i := start; i < end-1; i++ {
+			sum := 0
+			for _, r := range records[i:end] {
+				sum += int(r.Weight)
+			}
+			n, running := intn(sum+1), 0
+			for j := i; j < end; j++ {
+				running += int(records[j].Weight)
+				if running >= n {
+					// move the selected record up, keeping the rest in order
+					selected := records[j]
+					copy(records[i+1:j+1], records[i:j])
+					records[i] = selected
+					break
+				}
+			}
+		}
+		start = end
+	}
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []SRVRecord{}}
+	retv.QueryName = ServiceName(name, s.Factory.Factory.Service, s.Factory.Factory.Proto)
+	res, trace, status, err := s.DoTypedMiekgLookup(retv.QueryName, dns.TypeSRV)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	unavailable := false
+	for _, a := range r.Answers {
+		srv, ok := a.(miekg.SRVAnswer)
+		if !ok {
+			continue
+		}
+		// RFC 2782: a target of "." means the service is decidedly not
+		// available at this domain
+		if srv.Target == "." {
+			unavailable = true
+			continue
+		}
+		retv.Records = append(retv.Records, SRVRecord{
+			Name:     srv.Name,
+			Priority: srv.Priority,
+			Weight:   srv.Weight,
+			Port:     srv.Port,
+			Target:   strings.TrimSuffix(srv.Target, "."),
+			TTL:      srv.Ttl,
+		})
+	}
+	if len(retv.Records) == 0 {
+		if unavailable {
+			return retv, trace, zdns.STATUS_NO_SERVICE, nil
+		}
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	orderRecords(retv.Records, rand.Intn)
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeSRV, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	Service string
+	Proto   string
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.StringVar(&s.Service, "service", "", "service name (e.g., sip) used to build the _service._proto.name query")
+	f.StringVar(&s.Proto, "proto", "tcp", "protocol (e.g., tcp, udp) used to build the _service._proto.name query")
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	if s.Service != "" && s.Proto == "" {
+		return errors.New("--proto must be set when --service is used")
+	}
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("SRVLOOKUP", s)
+}
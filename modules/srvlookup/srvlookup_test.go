@@ -0,0 +1,95 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package srvlookup
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestServiceName(t *testing.T) {
+	tests := []struct {
+		service, proto, expected string
+	}{
+		{"", "tcp", "example.com"},
+		{"sip", "tcp", "_sip._tcp.example.com"},
+		{"_xmpp-server", "_tcp", "_xmpp-server._tcp.example.com"},
+	}
+	for _, test := range tests {
+		if got := ServiceName("example.com", test.service, test.proto); got != test.expected {
+			t.Errorf("ServiceName(%q, %q) = %q, expected %q", test.service, test.proto, got, test.expected)
+		}
+	}
+}
+
+func targets(records []SRVRecord) []string {
+	var names []string
+	for _, r := range records {
+		names = append(names, r.Target)
+	}
+	return names
+}
+
+func TestOrderRecords(t *testing.T) {
+	records := []SRVRecord{
+		{Priority: 20, Weight: 0, Target: "backup"},
+		{Priority: 10, Weight: 60, Target: "a"},
+		{Priority: 10, Weight: 0, Target: "zero"},
+		{Priority: 10, Weight: 40, Target: "b"},
+	}
+	// the smallest draw selects the first record left, i.e., a record of
+	// weight 0 if there is one
+	orderRecords(records, func(n int) int { return 0 })
+	expected := []string{"zero", "a", "b", "backup"}
+	for i, target := range targets(records) {
+		if target != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, targets(records))
+		}
+	}
+	// the largest draw selects the last record left
+	orderRecords(records, func(n int) int { return n - 1 })
+	expected = []string{"b", "a", "zero", "backup"}
+	for i, target := range targets(records) {
+		if target != expected[i] {
+			t.Fatalf("Expected %v, got %v", expected, targets(records))
+		}
+	}
+}
+
+func TestOrderRecordsWeights(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	first := make(map[string]int)
+	const rounds = 10000
+	for i := 0; i < rounds; i++ {
+		records := []SRVRecord{
+			{Priority: 10, Weight: 75, Target: "heavy"},
+			{Priority: 10, Weight: 25, Target: "light"},
+			{Priority: 10, Weight: 0, Target: "zero"},
+		}
+		orderRecords(records, r.Intn)
+		first[records[0].Target]++
+	}
+	// heavy is selected first with probability 75/101, light 25/101, and
+	// zero 1/101
+	if first["heavy"] < rounds*70/100 || first["heavy"] > rounds*78/100 {
+		t.Errorf("heavy came first %d times out of %d", first["heavy"], rounds)
+	}
+	if first["light"] < rounds*21/100 || first["light"] > rounds*29/100 {
+		t.Errorf("light came first %d times out of %d", first["light"], rounds)
+	}
+	if first["zero"] == 0 || first["zero"] > rounds*3/100 {
+		t.Errorf("zero came first %d times out of %d", first["zero"], rounds)
+	}
+}
@@ -0,0 +1,140 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sshfplookup
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// SSHFP parameter names from the IANA registry (RFC 4255, 6594, 7479, 8709)
+var algorithms = map[uint8]string{
+	1: "RSA",
+	2: "DSA",
+	3: "ECDSA",
+	4: "Ed25519",
+	6: "Ed448",
+}
+
+var fingerprintTypes = map[uint8]string{
+	1: "SHA-1",
+	2: "SHA-256",
+}
+
+// result to be returned by scan of host
+
+type SSHFPRecord struct {
+	Algorithm uint8 `json:"algorithm" groups:"short,normal,long,trace"`
+	// left out for algorithm numbers we don't know
+	AlgorithmName       string `json:"algorithm_name,omitempty" groups:"short,normal,long,trace"`
+	FingerprintType     uint8  `json:"fingerprint_type" groups:"short,normal,long,trace"`
+	FingerprintTypeName string `json:"fingerprint_type_name,omitempty" groups:"short,normal,long,trace"`
+	// hex-encoded fingerprint of the host key
+	Fingerprint string `json:"fingerprint" groups:"short,normal,long,trace"`
+	TTL         uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	Records []SSHFPRecord `json:"records" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+func makeRecord(ans miekg.SSHFPAnswer) SSHFPRecord {
+	return SSHFPRecord{
+		Algorithm:           ans.Algorithm,
+		AlgorithmName:       algorithms[ans.Algorithm],
+		FingerprintType:     ans.FpType,
+		FingerprintTypeName: fingerprintTypes[ans.FpType],
+		Fingerprint:         strings.ToLower(ans.Fingerprint),
+		TTL:                 ans.Ttl,
+	}
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []SSHFPRecord{}}
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeSSHFP)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		if sshfp, ok := a.(miekg.SSHFPAnswer); ok {
+			retv.Records = append(retv.Records, makeRecord(sshfp))
+		}
+	}
+	if len(retv.Records) == 0 {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeSSHFP, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("SSHFPLOOKUP", s)
+}
@@ -0,0 +1,39 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package sshfplookup
+
+import (
+	"testing"
+
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func TestMakeRecord(t *testing.T) {
+	r := makeRecord(miekg.SSHFPAnswer{
+		Algorithm:   4,
+		FpType:      2,
+		Fingerprint: "F1D4E1B8D1C1DD2BB0B7C5D6CE3E2A3E4C1B6E4B2D1F3A9C8B7E6D5C4B3A2918",
+	})
+	if r.AlgorithmName != "Ed25519" || r.FingerprintTypeName != "SHA-256" {
+		t.Errorf("Unexpected parameter names: %+v", r)
+	}
+	if r.Fingerprint != "f1d4e1b8d1c1dd2bb0b7c5d6ce3e2a3e4c1b6e4b2d1f3a9c8b7e6d5c4b3a2918" {
+		t.Errorf("Unexpected fingerprint: %s", r.Fingerprint)
+	}
+	r = makeRecord(miekg.SSHFPAnswer{Algorithm: 42, FpType: 9, Fingerprint: "00"})
+	if r.Algorithm != 42 || r.AlgorithmName != "" || r.FingerprintType != 9 || r.FingerprintTypeName != "" {
+		t.Errorf("Expected unknown parameters to be kept without names, got %+v", r)
+	}
+}
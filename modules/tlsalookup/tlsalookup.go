@@ -0,0 +1,183 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package tlsalookup
+
+import (
+	"errors"
+	"flag"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// mnemonics for the TLSA parameters, from RFC 7218
+var certUsages = map[uint8]string{
+	0: "PKIX-TA",
+	1: "PKIX-EE",
+	2: "DANE-TA",
+	3: "DANE-EE",
+}
+
+var selectors = map[uint8]string{
+	0: "Cert",
+	1: "SPKI",
+}
+
+var matchingTypes = map[uint8]string{
+	0: "Full",
+	1: "SHA2-256",
+	2: "SHA2-512",
+}
+
+// result to be returned by scan of host
+
+type TLSARecord struct {
+	CertUsage        uint8  `json:"cert_usage" groups:"short,normal,long,trace"`
+	CertUsageName    string `json:"cert_usage_name,omitempty" groups:"short,normal,long,trace"`
+	Selector         uint8  `json:"selector" groups:"short,normal,long,trace"`
+	SelectorName     string `json:"selector_name,omitempty" groups:"short,normal,long,trace"`
+	MatchingType     uint8  `json:"matching_type" groups:"short,normal,long,trace"`
+	MatchingTypeName string `json:"matching_type_name,omitempty" groups:"short,normal,long,trace"`
+	// hex-encoded certificate association data
+	Data string `json:"data" groups:"short,normal,long,trace"`
+	TTL  uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	// the name that was actually queried (i.e., _port._proto.name)
+	QueryName string       `json:"query_name" groups:"short,normal,long,trace"`
+	Records   []TLSARecord `json:"records" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// QueryName prepends the _port._proto labels to name. A port of 0 leaves
+// name untouched, for inputs that already are TLSA owner names.
+func QueryName(name string, port int, proto string) string {
+	if port == 0 {
+		return name
+	}
+	proto = "_" + strings.TrimPrefix(proto, "_")
+	return strings.Join([]string{"_" + strconv.Itoa(port), proto, name}, ".")
+}
+
+func makeRecord(ans miekg.TLSAAnswer) TLSARecord {
+	return TLSARecord{
+		CertUsage:        ans.CertUsage,
+		CertUsageName:    certUsages[ans.CertUsage],
+		Selector:         ans.Selector,
+		SelectorName:     selectors[ans.Selector],
+		MatchingType:     ans.MatchingType,
+		MatchingTypeName: matchingTypes[ans.MatchingType],
+		Data:             strings.ToLower(ans.Certificate),
+		TTL:              ans.Ttl,
+	}
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []TLSARecord{}}
+	retv.QueryName = QueryName(name, s.Factory.Factory.Port, s.Factory.Factory.Proto)
+	res, trace, status, err := s.DoTypedMiekgLookup(retv.QueryName, dns.TypeTLSA)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		if tlsa, ok := a.(miekg.TLSAAnswer); ok {
+			retv.Records = append(retv.Records, makeRecord(tlsa))
+		}
+	}
+	// the name exists (or is a CNAME to a name that does) but has no TLSA
+	// records. NXDOMAIN is passed through unchanged
+	if len(retv.Records) == 0 {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeTLSA, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	Port  int
+	Proto string
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.IntVar(&s.Port, "port", 443, "port used to build the _port._proto.name query. 0 queries input names as given")
+	f.StringVar(&s.Proto, "proto", "tcp", "protocol (e.g., tcp, udp) used to build the _port._proto.name query")
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	if s.Port < 0 || s.Port > 65535 {
+		return errors.New("--port must be between 0 and 65535")
+	}
+	if s.Port != 0 && s.Proto == "" {
+		return errors.New("--proto must be set when --port is used")
+	}
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("TLSALOOKUP", s)
+}
@@ -0,0 +1,52 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package tlsalookup
+
+import (
+	"testing"
+
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+func TestQueryName(t *testing.T) {
+	if n := QueryName("example.com", 443, "tcp"); n != "_443._tcp.example.com" {
+		t.Errorf("Unexpected query name: %s", n)
+	}
+	if n := QueryName("mail.example.com", 25, "_tcp"); n != "_25._tcp.mail.example.com" {
+		t.Errorf("Unexpected query name: %s", n)
+	}
+	if n := QueryName("_443._tcp.example.com", 0, "tcp"); n != "_443._tcp.example.com" {
+		t.Errorf("Expected port 0 to leave the name untouched, got %s", n)
+	}
+}
+
+func TestMakeRecord(t *testing.T) {
+	ans := miekg.TLSAAnswer{
+		CertUsage:    3,
+		Selector:     1,
+		MatchingType: 1,
+		Certificate:  "8CB0FC6C527506A053F4F14C8464BEBBD6DEDE2738D11468DD953D7D6A3021F1",
+	}
+	r := makeRecord(ans)
+	if r.CertUsageName != "DANE-EE" || r.SelectorName != "SPKI" || r.MatchingTypeName != "SHA2-256" {
+		t.Errorf("Unexpected parameter names: %+v", r)
+	}
+	if r.Data != "8cb0fc6c527506a053f4f14c8464bebbd6dede2738d11468dd953d7d6a3021f1" {
+		t.Errorf("Unexpected association data: %s", r.Data)
+	}
+	if r := makeRecord(miekg.TLSAAnswer{CertUsage: 255}); r.CertUsageName != "" {
+		t.Errorf("Expected no name for an unassigned usage, got %s", r.CertUsageName)
+	}
+}
@@ -0,0 +1,150 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package txtlookup
+
+import (
+	"flag"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// result to be returned by scan of host
+
+type TXTRecord struct {
+	Name     string   `json:"name" groups:"short,normal,long,trace"`
+	Segments []string `json:"segments" groups:"short,normal,long,trace"`
+	TTL      uint32   `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	Records []TXTRecord `json:"txt" groups:"short,normal,long,trace"`
+}
+
+// Per Connection Lookup ======================================================
+//
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+// miekg keeps the character-strings of a TXT RR in presentation format, where
+// any non-printable byte (including a newline) is escaped as \DDD. ParseAnswer
+// joins them with a literal newline, so splitting on it recovers the original
+// segmentation exactly.
+func splitSegments(answer string) []string {
+	return strings.Split(answer, "\n")
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []TXTRecord{}}
+	res, trace, status, err := s.DoTypedMiekgLookup(name, dns.TypeTXT)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	for _, a := range r.Answers {
+		ans, ok := a.(miekg.Answer)
+		if !ok || ans.Type != "TXT" {
+			continue
+		}
+		segments := splitSegments(ans.Answer)
+		if s.Factory.Factory.Regex != nil && !s.Factory.Factory.Regex.MatchString(strings.Join(segments, "")) {
+			continue
+		}
+		retv.Records = append(retv.Records, TXTRecord{
+			Name:     ans.Name,
+			Segments: segments,
+			TTL:      ans.Ttl,
+		})
+	}
+	if len(retv.Records) == 0 {
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+//
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeTXT, s.DNSClass, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+//
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	RegexString string
+	Regex       *regexp.Regexp
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.StringVar(&s.RegexString, "txt-regex", "", "only return TXT records whose concatenated value matches this regular expression")
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	if err := s.GlobalLookupFactory.Initialize(c); err != nil {
+		return err
+	}
+	if s.RegexString != "" {
+		re, err := regexp.Compile(s.RegexString)
+		if err != nil {
+			return err
+		}
+		s.Regex = re
+	}
+	return nil
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+//
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("TXTLOOKUP", s)
+}
@@ -0,0 +1,118 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package txtlookup
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+)
+
+// Mock the actual Miekg lookup.
+func (s *Lookup) DoTypedMiekgLookup(name string, dnsType uint16) (interface{}, []interface{}, zdns.Status, error) {
+	if res, ok := mockResults[name]; ok {
+		return res, nil, zdns.STATUS_NOERROR, nil
+	} else {
+		return nil, nil, zdns.STATUS_NO_ANSWER, nil
+	}
+}
+
+var mockResults = make(map[string]miekg.Result)
+
+func makeLookup(t *testing.T, regex string) *Lookup {
+	gc := new(zdns.GlobalConf)
+	gc.NameServers = []string{"127.0.0.1"}
+
+	glf := new(GlobalLookupFactory)
+	glf.GlobalConf = gc
+	if regex != "" {
+		glf.Regex = regexp.MustCompile(regex)
+	}
+
+	rlf := new(RoutineLookupFactory)
+	rlf.Factory = glf
+
+	l, err := rlf.MakeLookup()
+	if l == nil || err != nil {
+		t.Fatal("Failed to initialize lookup")
+	}
+	return l.(*Lookup)
+}
+
+func TestDoLookup(t *testing.T) {
+	l := makeLookup(t, "")
+
+	mockResults["example.com"] = miekg.Result{
+		Answers: []interface{}{
+			miekg.Answer{
+				Ttl:    3600,
+				Type:   "TXT",
+				Class:  "IN",
+				Name:   "example.com",
+				Answer: "v=spf1 include:_spf.example.com ~all",
+			},
+			miekg.Answer{
+				Ttl:    3600,
+				Type:   "TXT",
+				Class:  "IN",
+				Name:   "example.com",
+				Answer: "first segment\nsecond\\010segment",
+			},
+		},
+	}
+	res, _, status, _ := l.DoLookup("example.com")
+	if status != zdns.STATUS_NOERROR {
+		t.Fatalf("Unexpected status. Expected %v, got %v", zdns.STATUS_NOERROR, status)
+	}
+	records := res.(Result).Records
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 TXT records, got %v", len(records))
+	}
+	if !reflect.DeepEqual(records[0].Segments, []string{"v=spf1 include:_spf.example.com ~all"}) {
+		t.Errorf("Unexpected segments: %v", records[0].Segments)
+	}
+	if !reflect.DeepEqual(records[1].Segments, []string{"first segment", "second\\010segment"}) {
+		t.Errorf("Unexpected segments: %v", records[1].Segments)
+	}
+
+	// no TXT records
+	mockResults["empty.example.com"] = miekg.Result{Answers: []interface{}{}}
+	_, _, status, _ = l.DoLookup("empty.example.com")
+	if status != zdns.STATUS_NO_RECORD {
+		t.Errorf("Unexpected status. Expected %v, got %v", zdns.STATUS_NO_RECORD, status)
+	}
+}
+
+func TestRegexFilter(t *testing.T) {
+	l := makeLookup(t, "^v=spf1")
+
+	mockResults["filtered.example.com"] = miekg.Result{
+		Answers: []interface{}{
+			miekg.Answer{Type: "TXT", Name: "filtered.example.com", Answer: "google-site-verification=abc"},
+			miekg.Answer{Type: "TXT", Name: "filtered.example.com", Answer: "v=sp\nf1 -all"},
+		},
+	}
+	res, _, status, _ := l.DoLookup("filtered.example.com")
+	if status != zdns.STATUS_NOERROR {
+		t.Fatalf("Unexpected status. Expected %v, got %v", zdns.STATUS_NOERROR, status)
+	}
+	records := res.(Result).Records
+	if len(records) != 1 || !reflect.DeepEqual(records[0].Segments, []string{"v=sp", "f1 -all"}) {
+		t.Errorf("Unexpected filtered records: %v", records)
+	}
+}
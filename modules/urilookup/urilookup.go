@@ -0,0 +1,179 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package urilookup
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"reflect"
+	"sort"
+
+	"github.com/miekg/dns"
+	"github.com/zmap/zdns"
+	"github.com/zmap/zdns/modules/miekg"
+	"github.com/zmap/zdns/modules/srvlookup"
+)
+
+// result to be returned by scan of host
+
+type URIRecord struct {
+	Name     string `json:"name" groups:"short,normal,long,trace"`
+	Priority uint16 `json:"priority" groups:"short,normal,long,trace"`
+	Weight   uint16 `json:"weight" groups:"short,normal,long,trace"`
+	// the URI as sent, without the escaping of the presentation format
+	Target string `json:"target" groups:"short,normal,long,trace"`
+	TTL    uint32 `json:"ttl" groups:"ttl,normal,long,trace"`
+}
+
+type Result struct {
+	// the name that was actually queried (i.e., _service._proto.name)
+	QueryName string `json:"query_name,omitempty" groups:"short,normal,long,trace"`
+	// records in the order a client should try them: ascending priority and,
+	// within a priority, descending weight
+	Records []URIRecord `json:"records" groups:"short,normal,long,trace"`
+}
+
+// parseURI reads the priority, weight, and target of URI RDATA (RFC 7553,
+// section 4.5). The target is the rest of the RDATA, without a length octet.
+func parseURI(rdata []byte) (URIRecord, error) {
+	if len(rdata) < 4 {
+		return URIRecord{}, errors.New("URI RDATA shorter than its priority and weight")
+	}
+	if len(rdata) == 4 {
+		return URIRecord{}, errors.New("empty URI target")
+	}
+	return URIRecord{
+		Priority: binary.BigEndian.Uint16(rdata[0:2]),
+		Weight:   binary.BigEndian.Uint16(rdata[2:4]),
+		Target:   string(rdata[4:]),
+	}, nil
+}
+
+func sortRecords(records []URIRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+}
+
+// Per Connection Lookup ======================================================
+type Lookup struct {
+	Factory *RoutineLookupFactory
+	miekg.Lookup
+}
+
+func (s *Lookup) DoLookup(name string) (interface{}, []interface{}, zdns.Status, error) {
+	retv := Result{Records: []URIRecord{}}
+	retv.QueryName = srvlookup.ServiceName(name, s.Factory.Factory.Service, s.Factory.Factory.Proto)
+	res, trace, status, err := s.DoTypedMiekgLookup(retv.QueryName, dns.TypeURI)
+	if status != zdns.STATUS_NOERROR {
+		return retv, trace, status, err
+	}
+	r, ok := res.(miekg.Result)
+	if !ok {
+		panic("could not cast correctly")
+	}
+	var parseErr error
+	for _, a := range r.Answers {
+		// URI records aren't parsed by miekg.ParseAnswer, so they come as
+		// generic records with their RDATA in wire format
+		ans, ok := a.(miekg.UnknownAnswer)
+		if !ok || ans.TypeNumber != dns.TypeURI {
+			continue
+		}
+		rdata, err := hex.DecodeString(ans.RData)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		record, err := parseURI(rdata)
+		if err != nil {
+			parseErr = err
+			continue
+		}
+		record.Name = ans.Name
+		record.TTL = ans.Ttl
+		retv.Records = append(retv.Records, record)
+	}
+	if len(retv.Records) == 0 {
+		if parseErr != nil {
+			return retv, trace, zdns.STATUS_ERROR, parseErr
+		}
+		return retv, trace, zdns.STATUS_NO_RECORD, nil
+	}
+	sortRecords(retv.Records)
+	return retv, trace, zdns.STATUS_NOERROR, nil
+}
+
+// Per GoRoutine Factory ======================================================
+type RoutineLookupFactory struct {
+	miekg.RoutineLookupFactory
+	Factory *GlobalLookupFactory
+}
+
+func (s *RoutineLookupFactory) MakeLookup() (zdns.Lookup, error) {
+	a := Lookup{Factory: s}
+	nameServer := s.Factory.RandomNameServer()
+	a.Initialize(nameServer, dns.TypeURI, dns.ClassINET, &s.RoutineLookupFactory)
+	return &a, nil
+}
+
+// Global Factory =============================================================
+type GlobalLookupFactory struct {
+	miekg.GlobalLookupFactory
+	Service string
+	Proto   string
+}
+
+func (s *GlobalLookupFactory) AddFlags(f *flag.FlagSet) {
+	f.StringVar(&s.Service, "service", "", "service name (e.g., ftp) used to build the _service._proto.name query")
+	f.StringVar(&s.Proto, "proto", "tcp", "protocol (e.g., tcp, udp) used to build the _service._proto.name query")
+}
+
+func (s *GlobalLookupFactory) Initialize(c *zdns.GlobalConf) error {
+	if s.Service != "" && s.Proto == "" {
+		return errors.New("--proto must be set when --service is used")
+	}
+	return s.GlobalLookupFactory.Initialize(c)
+}
+
+// Command-line Help Documentation. This is the descriptive text what is
+// returned when you run zdns module --help
+func (s *GlobalLookupFactory) Help() string {
+	return ""
+}
+
+func (s *GlobalLookupFactory) MakeRoutineFactory(threadID int) (zdns.RoutineLookupFactory, error) {
+	r := new(RoutineLookupFactory)
+	r.Initialize(s.GlobalConf)
+	r.RoutineLookupFactory.Factory = &s.GlobalLookupFactory
+	r.Factory = s
+	r.ThreadID = threadID
+	return r, nil
+}
+
+func (s *GlobalLookupFactory) ResultType() reflect.Type {
+	return reflect.TypeOf(Result{})
+}
+
+// Global Registration ========================================================
+func init() {
+	s := new(GlobalLookupFactory)
+	zdns.RegisterLookup("URILOOKUP", s)
+}
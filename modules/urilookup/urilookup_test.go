@@ -0,0 +1,49 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package urilookup
+
+import (
+	"testing"
+)
+
+func TestParseURI(t *testing.T) {
+	r, err := parseURI([]byte("\x00\x0a\x00\x01ftp://ftp1.example.com/public\"dir\""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Priority != 10 || r.Weight != 1 || r.Target != "ftp://ftp1.example.com/public\"dir\"" {
+		t.Errorf("Unexpected record: %+v", r)
+	}
+	for _, bad := range []string{"", "\x00\x0a\x00", "\x00\x0a\x00\x01"} {
+		if _, err := parseURI([]byte(bad)); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
+
+func TestSortRecords(t *testing.T) {
+	records := []URIRecord{
+		{Priority: 20, Weight: 5, Target: "c"},
+		{Priority: 10, Weight: 1, Target: "b"},
+		{Priority: 10, Weight: 9, Target: "a"},
+	}
+	sortRecords(records)
+	for i, target := range []string{"a", "b", "c"} {
+		if records[i].Target != target {
+			t.Errorf("Unexpected order: %+v", records)
+			break
+		}
+	}
+}
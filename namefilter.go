@@ -0,0 +1,108 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// nameList is a set of names read from a --name-allowlist or
+// --name-blocklist file. An entry of the form *.suffix matches every name
+// below suffix; any other entry matches only that name.
+type nameList struct {
+	names    map[string]struct{}
+	suffixes map[string]struct{}
+}
+
+func loadNameList(path string) (*nameList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	l := &nameList{names: make(map[string]struct{}), suffixes: make(map[string]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		l.add(entry)
+	}
+	return l, scanner.Err()
+}
+
+func (l *nameList) add(entry string) {
+	entry = strings.ToLower(strings.TrimSuffix(entry, "."))
+	if strings.HasPrefix(entry, "*.") {
+		l.suffixes[entry[2:]] = struct{}{}
+	} else {
+		l.names[entry] = struct{}{}
+	}
+}
+
+// contains reports whether name, which must already be normalized as by
+// dedupeKey, matches an entry of the list.
+func (l *nameList) contains(name string) bool {
+	if _, ok := l.names[name]; ok {
+		return true
+	}
+	for i := strings.Index(name, "."); i >= 0; i = strings.Index(name, ".") {
+		name = name[i+1:]
+		if _, ok := l.suffixes[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nameFilter decides which input names may be looked up. A name on the
+// blocklist is always skipped; if there is an allowlist, so is any name
+// not on it.
+type nameFilter struct {
+	allow *nameList
+	block *nameList
+	alexa bool
+}
+
+func newNameFilter(allowPath, blockPath string, alexa bool) (*nameFilter, error) {
+	f := &nameFilter{alexa: alexa}
+	var err error
+	if allowPath != "" {
+		if f.allow, err = loadNameList(allowPath); err != nil {
+			return nil, err
+		}
+	}
+	if blockPath != "" {
+		if f.block, err = loadNameList(blockPath); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// skip reports whether the name on an input line must not be looked up.
+func (f *nameFilter) skip(line string) bool {
+	name := dedupeKey(line, f.alexa)
+	if n, _, ok := splitClass(name); ok {
+		name = dedupeKey(n, false)
+	}
+	if f.block != nil && f.block.contains(name) {
+		return true
+	}
+	return f.allow != nil && !f.allow.contains(name)
+}
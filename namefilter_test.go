@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNameListContains(t *testing.T) {
+	l := &nameList{names: make(map[string]struct{}), suffixes: make(map[string]struct{})}
+	l.add("Example.COM.")
+	l.add("*.internal")
+	for name, expected := range map[string]bool{
+		"example.com":      true,
+		"www.example.com":  false,
+		"internal":         false,
+		"host.internal":    true,
+		"a.b.internal":     true,
+		"notinternal":      false,
+		"host.internal.io": false,
+	} {
+		if l.contains(name) != expected {
+			t.Errorf("Expected contains(%s) to be %v", name, expected)
+		}
+	}
+}
+
+func TestNameFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "zdns-namefilter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	allow := filepath.Join(dir, "allow")
+	block := filepath.Join(dir, "block")
+	if err := ioutil.WriteFile(allow, []byte("# permitted zones\n*.example.com\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(block, []byte("*.corp.example.com\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := newNameFilter(allow, block, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, expected := range map[string]bool{
+		"www.example.com":        false,
+		"WWW.Example.com.":       false,
+		"www.example.com,CH":     false,
+		"example.org":            true,
+		"db.corp.example.com":    true,
+		"db.corp.example.com,IN": true,
+	} {
+		if f.skip(line) != expected {
+			t.Errorf("Expected skip(%s) to be %v", line, expected)
+		}
+	}
+
+	f, err = newNameFilter("", block, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.skip("1,db.corp.example.com") || f.skip("2,example.org") {
+		t.Error("Expected Alexa entries to be filtered by name only")
+	}
+
+	if _, err := newNameFilter(filepath.Join(dir, "missing"), "", false); err == nil {
+		t.Error("Expected an error for a missing allowlist")
+	}
+}
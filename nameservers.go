@@ -0,0 +1,266 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// the most name servers a single port range may expand into
+const maxPortRange = 1024
+
+// transports that a name server can be given with, as the scheme of a
+// --name-servers entry (e.g., tls://1.1.1.1)
+const (
+	TransportUDP   = "udp"
+	TransportTCP   = "tcp"
+	TransportTLS   = "tls"   // DNS over TLS (RFC 7858)
+	TransportHTTPS = "https" // DNS over HTTPS (RFC 8484)
+	TransportQUIC  = "quic"  // DNS over QUIC (RFC 9250)
+)
+
+// default ports of the transports that take a host:port address
+var transportPorts = map[string]string{
+	TransportUDP:  "53",
+	TransportTCP:  "53",
+	TransportTLS:  "853",
+	TransportQUIC: "853",
+}
+
+// SplitTransport splits a name server of the form scheme://address into its
+// transport and address. A name server without a scheme has no transport
+// of its own, and is returned unchanged with an empty transport. The
+// address of an https server is its whole URL.
+func SplitTransport(server string) (string, string) {
+	i := strings.Index(server, "://")
+	if i < 0 {
+		return "", server
+	}
+	transport := strings.ToLower(server[:i])
+	if transport == TransportHTTPS {
+		return transport, server
+	}
+	return transport, server[i+3:]
+}
+
+// NormalizeNameServer checks the transport of a --name-servers entry, if it
+// has one, and adds the default port of the transport (53, or 853 for tls
+// and quic) to addresses without a port. https servers must be URLs with a host, and
+// get the path /dns-query if they have none.
+func NormalizeNameServer(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	transport, addr := SplitTransport(server)
+	switch transport {
+	case "":
+		if !strings.Contains(addr, ":") {
+			addr += ":53"
+		}
+		return addr, nil
+	case TransportHTTPS:
+		u, err := url.Parse(addr)
+		if err != nil || u.Host == "" {
+			return "", errors.New("invalid URL for name server " + server)
+		}
+		if u.Path == "" {
+			u.Path = "/dns-query"
+		}
+		return u.String(), nil
+	}
+	port, ok := transportPorts[transport]
+	if !ok {
+		return "", errors.New("unknown transport " + transport + " for name server " + server + ": must be udp, tcp, tls, quic or https")
+	}
+	if addr == "" {
+		return "", errors.New("missing address for name server " + server)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return transport + "://" + addr, nil
+}
+
+// ParseNameServerWeights strips the optional weight suffix (e.g., the *3 in
+// 1.1.1.1:53*3) from each name server. Servers without a suffix have weight
+// 1. If no server has a weight, nil is returned for the weights so that
+// servers are picked uniformly.
+func ParseNameServerWeights(servers []string) ([]string, []int, error) {
+	names := make([]string, len(servers))
+	weights := make([]int, len(servers))
+	weighted := false
+	for i, s := range servers {
+		s = strings.TrimSpace(s)
+		weights[i] = 1
+		if star := strings.LastIndex(s, "*"); star >= 0 {
+			w, err := strconv.Atoi(s[star+1:])
+			if err != nil || w <= 0 {
+				return nil, nil, errors.New("invalid weight for name server " + s + ": must be a positive integer")
+			}
+			weights[i] = w
+			weighted = true
+			s = s[:star]
+		}
+		names[i] = s
+	}
+	if !weighted {
+		return names, nil, nil
+	}
+	return names, weights, nil
+}
+
+// ExpandPortRanges replaces each name server given with a range of ports
+// (e.g., 192.0.2.1:5300-5310) by one server per port, each with the weight of
+// the range. weights may be nil, as returned by ParseNameServerWeights.
+func ExpandPortRanges(servers []string, weights []int) ([]string, []int, error) {
+	var expanded []string
+	var expandedWeights []int
+	for i, s := range servers {
+		ports := []string{""}
+		transport, addr := SplitTransport(s)
+		host, port, err := net.SplitHostPort(addr)
+		if err == nil && strings.Contains(port, "-") {
+			if ports, err = portRange(port); err != nil {
+				return nil, nil, fmt.Errorf("invalid port range for name server %s: %v", s, err)
+			}
+		}
+		for _, p := range ports {
+			if p == "" {
+				expanded = append(expanded, s)
+			} else if transport != "" {
+				expanded = append(expanded, transport+"://"+net.JoinHostPort(host, p))
+			} else {
+				expanded = append(expanded, net.JoinHostPort(host, p))
+			}
+			if weights != nil {
+				expandedWeights = append(expandedWeights, weights[i])
+			}
+		}
+	}
+	return expanded, expandedWeights, nil
+}
+
+// ParseModuleNameServers parses --module-name-servers, a semicolon-delimited
+// list of MODULE=servers entries, each servers in the form --name-servers
+// takes (e.g., AXFR=@auth.txt;A=1.1.1.1,8.8.8.8). Module names are returned
+// in uppercase, as modules are registered.
+func ParseModuleNameServers(s string) (map[string]string, error) {
+	mapping := make(map[string]string)
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		module := strings.ToUpper(strings.TrimSpace(parts[0]))
+		if len(parts) != 2 || module == "" || strings.TrimSpace(parts[1]) == "" {
+			return nil, fmt.Errorf("entry %q isn't of the form MODULE=servers", entry)
+		}
+		if _, ok := mapping[module]; ok {
+			return nil, fmt.Errorf("module %s is given twice", module)
+		}
+		mapping[module] = strings.TrimSpace(parts[1])
+	}
+	return mapping, nil
+}
+
+// portRange lists the ports from first to last of a range such as 5300-5310.
+func portRange(r string) ([]string, error) {
+	bounds := strings.SplitN(r, "-", 2)
+	first, err := strconv.ParseUint(bounds[0], 10, 16)
+	if err != nil || first == 0 {
+		return nil, errors.New("ports must be between 1 and 65535")
+	}
+	last, err := strconv.ParseUint(bounds[1], 10, 16)
+	if err != nil || last == 0 {
+		return nil, errors.New("ports must be between 1 and 65535")
+	}
+	if first > last {
+		return nil, errors.New("the first port is larger than the last")
+	}
+	if last-first+1 > maxPortRange {
+		return nil, fmt.Errorf("ranges may span at most %d ports", maxPortRange)
+	}
+	var ports []string
+	for p := first; p <= last; p++ {
+		ports = append(ports, strconv.FormatUint(p, 10))
+	}
+	return ports, nil
+}
+
+// pickWeighted returns the index of the weight that n falls under, where n
+// is drawn uniformly from [0, sum of weights).
+func pickWeighted(weights []int, n int) int {
+	for i, w := range weights {
+		if n < w {
+			return i
+		}
+		n -= w
+	}
+	return len(weights) - 1
+}
+
+func sumWeights(weights []int) int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	return total
+}
+
+// --sticky-server modes
+const (
+	StickyHash       = "hash"
+	StickyRoundRobin = "round-robin"
+)
+
+// stickyServers picks the one name server that all queries for an input name
+// are sent to (--sticky-server). In hash mode, a name always gets the same
+// server; in round-robin mode, names take the servers in turn. Weights, if
+// any, are honored either way.
+type stickyServers struct {
+	mode    string
+	servers []string
+	weights []int
+	total   int
+	next    uint64
+}
+
+func newStickyServers(mode string, servers []string, weights []int) *stickyServers {
+	if len(weights) != len(servers) {
+		weights = make([]int, len(servers))
+		for i := range weights {
+			weights[i] = 1
+		}
+	}
+	return &stickyServers{mode: mode, servers: servers, weights: weights, total: sumWeights(weights)}
+}
+
+// pick returns the server for name.
+func (s *stickyServers) pick(name string) string {
+	var n uint64
+	if s.mode == StickyHash {
+		h := fnv.New64a()
+		h.Write([]byte(strings.ToLower(strings.TrimSuffix(name, "."))))
+		n = h.Sum64()
+	} else {
+		n = atomic.AddUint64(&s.next, 1) - 1
+	}
+	return s.servers[pickWeighted(s.weights, int(n%uint64(s.total)))]
+}
@@ -0,0 +1,167 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseNameServerWeights(t *testing.T) {
+	names, weights, err := ParseNameServerWeights([]string{"1.1.1.1:53", "8.8.8.8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"1.1.1.1:53", "8.8.8.8"}) || weights != nil {
+		t.Errorf("unweighted servers changed: %v %v", names, weights)
+	}
+
+	names, weights, err = ParseNameServerWeights([]string{"1.1.1.1:53*3", " 8.8.8.8", "[2001:db8::1]:53*2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"1.1.1.1:53", "8.8.8.8", "[2001:db8::1]:53"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+	if !reflect.DeepEqual(weights, []int{3, 1, 2}) {
+		t.Errorf("unexpected weights: %v", weights)
+	}
+
+	for _, bad := range []string{"1.1.1.1:53*0", "1.1.1.1:53*-1", "1.1.1.1:53*x", "1.1.1.1:53*"} {
+		if _, _, err := ParseNameServerWeights([]string{bad}); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestPickWeighted(t *testing.T) {
+	weights := []int{3, 1, 2}
+	expected := []int{0, 0, 0, 1, 2, 2}
+	if total := sumWeights(weights); total != len(expected) {
+		t.Fatalf("expected total weight %d, got %d", len(expected), total)
+	}
+	for n, i := range expected {
+		if got := pickWeighted(weights, n); got != i {
+			t.Errorf("n=%d: expected server %d, got %d", n, i, got)
+		}
+	}
+}
+
+func TestExpandPortRanges(t *testing.T) {
+	names, weights, err := ExpandPortRanges([]string{"192.0.2.1:5300-5302", "8.8.8.8", "[2001:db8::1]:53-54"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"192.0.2.1:5300", "192.0.2.1:5301", "192.0.2.1:5302", "8.8.8.8", "[2001:db8::1]:53", "[2001:db8::1]:54"}
+	if !reflect.DeepEqual(names, expected) || weights != nil {
+		t.Errorf("unexpected expansion: %v %v", names, weights)
+	}
+
+	names, weights, err = ExpandPortRanges([]string{"192.0.2.1:53-54", "8.8.8.8:53"}, []int{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(weights, []int{3, 3, 1}) {
+		t.Errorf("unexpected weights for %v: %v", names, weights)
+	}
+
+	for _, bad := range []string{"192.0.2.1:54-53", "192.0.2.1:0-10", "192.0.2.1:1-70000", "192.0.2.1:1-", "192.0.2.1:1-2000"} {
+		if _, _, err := ExpandPortRanges([]string{bad}, nil); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+}
+
+func TestNormalizeNameServer(t *testing.T) {
+	for in, want := range map[string]string{
+		"8.8.8.8":                              "8.8.8.8:53",
+		"8.8.8.8:5353":                         "8.8.8.8:5353",
+		"udp://1.1.1.1":                        "udp://1.1.1.1:53",
+		"TCP://1.1.1.1:5353":                   "tcp://1.1.1.1:5353",
+		"tls://1.1.1.1":                        "tls://1.1.1.1:853",
+		"tls://[2606:4700::1111]":              "tls://[2606:4700::1111]:853",
+		"tls://2606:4700::1111":                "tls://[2606:4700::1111]:853",
+		"quic://94.140.14.14":                  "quic://94.140.14.14:853",
+		"QUIC://94.140.14.14:784":              "quic://94.140.14.14:784",
+		"https://cloudflare-dns.com":           "https://cloudflare-dns.com/dns-query",
+		"https://cloudflare-dns.com/dns-query": "https://cloudflare-dns.com/dns-query",
+	} {
+		got, err := NormalizeNameServer(in)
+		if err != nil || got != want {
+			t.Errorf("NormalizeNameServer(%q) = %q, %v, expected %q", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"doq://1.1.1.1", "tls://", "quic://", "https:///dns-query"} {
+		if _, err := NormalizeNameServer(bad); err == nil {
+			t.Errorf("expected an error for %s", bad)
+		}
+	}
+
+	if transport, addr := SplitTransport("tls://1.1.1.1:853"); transport != TransportTLS || addr != "1.1.1.1:853" {
+		t.Errorf("tls server split into %q %q", transport, addr)
+	}
+	if transport, addr := SplitTransport("1.1.1.1:53"); transport != "" || addr != "1.1.1.1:53" {
+		t.Errorf("server without a transport split into %q %q", transport, addr)
+	}
+	names, _, err := ExpandPortRanges([]string{"tcp://192.0.2.1:5300-5301"}, nil)
+	if err != nil || !reflect.DeepEqual(names, []string{"tcp://192.0.2.1:5300", "tcp://192.0.2.1:5301"}) {
+		t.Errorf("unexpected expansion of a tcp range: %v %v", names, err)
+	}
+}
+
+func TestStickyServers(t *testing.T) {
+	servers := []string{"192.0.2.1:53", "192.0.2.2:53", "192.0.2.3:53"}
+	hash := newStickyServers(StickyHash, servers, nil)
+	picked := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		name := "name" + strconv.Itoa(i) + ".example.com"
+		server := hash.pick(name)
+		if hash.pick(strings.ToUpper(name)+".") != server {
+			t.Fatalf("%s: expected the same server for every spelling of the name", name)
+		}
+		picked[server] = true
+	}
+	if len(picked) != len(servers) {
+		t.Errorf("expected names to be spread over all servers, got %v", picked)
+	}
+
+	roundRobin := newStickyServers(StickyRoundRobin, servers, []int{1, 2, 1})
+	var order []string
+	for i := 0; i < 8; i++ {
+		order = append(order, roundRobin.pick("example.com"))
+	}
+	expected := []string{servers[0], servers[1], servers[1], servers[2], servers[0], servers[1], servers[1], servers[2]}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
+
+func TestParseModuleNameServers(t *testing.T) {
+	mapping, err := ParseModuleNameServers("axfr=@auth.txt; A = 1.1.1.1,8.8.8.8 ;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"AXFR": "@auth.txt", "A": "1.1.1.1,8.8.8.8"}
+	if !reflect.DeepEqual(mapping, expected) {
+		t.Errorf("Unexpected mapping %v", mapping)
+	}
+	for _, bad := range []string{"AXFR", "=1.1.1.1", "A=", "A=1.1.1.1;a=8.8.8.8"} {
+		if _, err := ParseModuleNameServers(bad); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
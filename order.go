@@ -0,0 +1,87 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import "container/heap"
+
+// seqHeap is a min-heap of the sequence numbers in a reorder buffer.
+type seqHeap []int
+
+func (h seqHeap) Len() int            { return len(h) }
+func (h seqHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h seqHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *seqHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *seqHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// reorderBuffer puts lookup outputs back in the order their inputs were
+// handed out (--preserve-order), holding at most window of them. Once the
+// window is full, it stops waiting for the outputs it is missing, which are
+// released as they arrive instead and counted as late.
+type reorderBuffer struct {
+	window  int
+	next    int
+	pending map[int]lookupOutput
+	seqs    seqHeap
+	// outputs released out of order
+	late int
+}
+
+func newReorderBuffer(window int) *reorderBuffer {
+	return &reorderBuffer{
+		window:  window,
+		pending: make(map[int]lookupOutput),
+	}
+}
+
+// push adds out to the buffer and returns the outputs that can be written
+// now, in order.
+func (b *reorderBuffer) push(out lookupOutput) []lookupOutput {
+	if out.seq < b.next {
+		// given up on, so already out of order
+		b.late++
+		return []lookupOutput{out}
+	}
+	b.pending[out.seq] = out
+	heap.Push(&b.seqs, out.seq)
+	if len(b.pending) > b.window {
+		b.next = b.seqs[0]
+	}
+	var ready []lookupOutput
+	for len(b.seqs) > 0 && b.seqs[0] == b.next {
+		heap.Pop(&b.seqs)
+		ready = append(ready, b.pending[b.next])
+		delete(b.pending, b.next)
+		b.next++
+	}
+	return ready
+}
+
+// drain empties the buffer and returns what it held in order, skipping
+// outputs that never arrived.
+func (b *reorderBuffer) drain() []lookupOutput {
+	var ready []lookupOutput
+	for len(b.seqs) > 0 {
+		seq := heap.Pop(&b.seqs).(int)
+		ready = append(ready, b.pending[seq])
+		delete(b.pending, seq)
+		b.next = seq + 1
+	}
+	return ready
+}
@@ -0,0 +1,70 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+// reorderAll runs outputs with the given sequence numbers through a reorder
+// buffer and returns the order in which they come out.
+func reorderAll(b *reorderBuffer, seqs []int) []int {
+	var order []int
+	for _, seq := range seqs {
+		for _, out := range b.push(lookupOutput{seq: seq}) {
+			order = append(order, out.seq)
+		}
+		if len(b.pending) > b.window {
+			panic("reorder buffer exceeded its window")
+		}
+	}
+	for _, out := range b.drain() {
+		order = append(order, out.seq)
+	}
+	return order
+}
+
+func TestReorderBuffer(t *testing.T) {
+	b := newReorderBuffer(10)
+	order := reorderAll(b, []int{3, 1, 0, 2, 5, 4, 6})
+	if expected := []int{0, 1, 2, 3, 4, 5, 6}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+	if b.late != 0 {
+		t.Errorf("expected no late results, got %d", b.late)
+	}
+}
+
+func TestReorderBufferWindow(t *testing.T) {
+	// 0 is delayed until the window of 2 has overflowed
+	b := newReorderBuffer(2)
+	order := reorderAll(b, []int{1, 2, 3, 0, 5, 4})
+	if expected := []int{1, 2, 3, 0, 4, 5}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+	if b.late != 1 {
+		t.Errorf("expected 1 late result, got %d", b.late)
+	}
+}
+
+func TestReorderBufferDrain(t *testing.T) {
+	// outputs that never arrive don't hold back the others at the end
+	b := newReorderBuffer(10)
+	order := reorderAll(b, []int{4, 2, 1})
+	if expected := []int{1, 2, 4}; !reflect.DeepEqual(order, expected) {
+		t.Errorf("expected %v, got %v", expected, order)
+	}
+}
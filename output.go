@@ -0,0 +1,150 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// fanOut writes every result to each of several output handlers. A handler
+// that fails is dropped, and the results meant for it are discarded, so that
+// the others keep receiving results.
+type fanOut struct {
+	names    []string
+	handlers []OutputHandler
+
+	mu     sync.Mutex
+	failed int
+	// the error each failed handler returned, keyed by handler name
+	errors map[string]string
+}
+
+func newFanOut(names []string) *fanOut {
+	f := &fanOut{names: names, errors: make(map[string]string)}
+	for _, name := range names {
+		f.handlers = append(f.handlers, GetOutputHandler(name))
+	}
+	return f
+}
+
+func (f *fanOut) Initialize(conf *GlobalConf) {
+	for _, h := range f.handlers {
+		h.Initialize(conf)
+	}
+}
+
+func (f *fanOut) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+
+	var handlersWG sync.WaitGroup
+	handlersWG.Add(len(f.handlers))
+	chans := make([]chan string, len(f.handlers))
+	for i := range f.handlers {
+		chans[i] = make(chan string)
+		go f.write(i, chans[i], &handlersWG)
+	}
+	for result := range results {
+		for _, c := range chans {
+			c <- result
+		}
+	}
+	for _, c := range chans {
+		close(c)
+	}
+	handlersWG.Wait()
+	return nil
+}
+
+func (f *fanOut) write(i int, results chan string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	var handlerWG sync.WaitGroup
+	handlerWG.Add(1)
+	if err := f.handlers[i].WriteResults(results, &handlerWG); err != nil {
+		f.fail(f.names[i], err)
+		for range results {
+		}
+	}
+}
+
+func (f *fanOut) fail(name string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errors[name] = err.Error()
+	f.failed++
+	if f.failed == len(f.handlers) {
+		log.Error("output handler ", name, " failed: ", err.Error())
+		return
+	}
+	log.Error("output handler ", name, " failed, continuing with the others: ", err.Error())
+}
+
+// err returns an error once every handler has failed, so that the scan can
+// exit with one after writing its metadata.
+func (f *fanOut) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.handlers) == 0 || f.failed < len(f.handlers) {
+		return nil
+	}
+	var failed []string
+	for _, name := range f.names {
+		failed = append(failed, name+": "+f.errors[name])
+	}
+	return fmt.Errorf("every output handler failed (%s)", strings.Join(failed, "; "))
+}
+
+// outputErrors returns the errors of the handlers that failed, or nil if none
+// did.
+func (f *fanOut) outputErrors() map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.errors) == 0 {
+		return nil
+	}
+	return f.errors
+}
+
+// invalidResults sums the results that the handlers dropped because they
+// failed --validate-output.
+func (f *fanOut) invalidResults() int {
+	invalid := 0
+	for _, h := range f.handlers {
+		if v, ok := h.(OutputValidator); ok {
+			invalid += v.InvalidResults()
+		}
+	}
+	return invalid
+}
+
+// ParseOutputHandlers splits a comma-separated --output-handler value and
+// checks each name is a registered handler listed once.
+func ParseOutputHandlers(s string) ([]string, error) {
+	names := strings.Split(s, ",")
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if GetOutputHandler(name) == nil {
+			return nil, fmt.Errorf("unknown output handler %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("output handler %q is listed more than once", name)
+		}
+		seen[name] = true
+	}
+	return names, nil
+}
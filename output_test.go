@@ -0,0 +1,107 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type recordingOutput struct {
+	fail    bool
+	results []string
+}
+
+func (h *recordingOutput) Initialize(conf *GlobalConf) {}
+
+func (h *recordingOutput) WriteResults(results <-chan string, wg *sync.WaitGroup) error {
+	defer (*wg).Done()
+	if h.fail {
+		return errors.New("sink unavailable")
+	}
+	for r := range results {
+		h.results = append(h.results, r)
+	}
+	return nil
+}
+
+func TestFanOut(t *testing.T) {
+	good := new(recordingOutput)
+	bad := &recordingOutput{fail: true}
+	RegisterOutputHandler("test-good", good)
+	RegisterOutputHandler("test-bad", bad)
+
+	f := newFanOut([]string{"test-bad", "test-good"})
+	results := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.WriteResults(results, &wg)
+	for _, r := range []string{"a", "b", "c"} {
+		results <- r
+	}
+	close(results)
+	wg.Wait()
+
+	if !reflect.DeepEqual(good.results, []string{"a", "b", "c"}) {
+		t.Errorf("Expected the working handler to get every result, got %v", good.results)
+	}
+	expected := map[string]string{"test-bad": "sink unavailable"}
+	if errs := f.outputErrors(); !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Expected output errors %v, got %v", expected, errs)
+	}
+	if err := f.err(); err != nil {
+		t.Errorf("Unexpected error with a working handler left: %v", err)
+	}
+}
+
+func TestFanOutAllFailed(t *testing.T) {
+	RegisterOutputHandler("test-bad", &recordingOutput{fail: true})
+
+	f := newFanOut([]string{"test-bad"})
+	results := make(chan string)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go f.WriteResults(results, &wg)
+	// the results are still consumed, so the scan can finish
+	for _, r := range []string{"a", "b"} {
+		results <- r
+	}
+	close(results)
+	wg.Wait()
+
+	expected := map[string]string{"test-bad": "sink unavailable"}
+	if errs := f.outputErrors(); !reflect.DeepEqual(errs, expected) {
+		t.Errorf("Expected output errors %v, got %v", expected, errs)
+	}
+	if err := f.err(); err == nil {
+		t.Error("Expected an error once every handler failed")
+	}
+}
+
+func TestParseOutputHandlers(t *testing.T) {
+	RegisterOutputHandler("test-a", new(recordingOutput))
+	RegisterOutputHandler("test-b", new(recordingOutput))
+	names, err := ParseOutputHandlers("test-a,test-b")
+	if err != nil || !reflect.DeepEqual(names, []string{"test-a", "test-b"}) {
+		t.Errorf("Unexpected result %v, %v", names, err)
+	}
+	for _, s := range []string{"test-a,test-a", "test-a,nonexistent"} {
+		if _, err := ParseOutputHandlers(s); err == nil {
+			t.Errorf("Expected an error for %q", s)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// PrimeRootServers sends a priming query (RFC 8109), NS for the root, to each
+// of servers in turn until one answers, and returns the addresses (host:53) of
+// the current root servers from its additional section.
+func PrimeRootServers(servers []string, timeout time.Duration) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(".", dns.TypeNS)
+	m.RecursionDesired = false
+	// the full set of root server addresses doesn't fit in 512 bytes
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	udp := &dns.Client{Timeout: timeout}
+	tcp := &dns.Client{Net: "tcp", Timeout: timeout}
+	err := errors.New("no servers to prime from")
+	for _, server := range servers {
+		var r *dns.Msg
+		r, _, err = udp.Exchange(m, server)
+		if err == nil && r.Truncated {
+			r, _, err = tcp.Exchange(m, server)
+		}
+		if err != nil {
+			continue
+		}
+		var roots []string
+		if roots, err = rootServersFromResponse(r); err == nil {
+			return roots, nil
+		}
+	}
+	return nil, err
+}
+
+// rootServersFromResponse extracts the IPv4 addresses of the root name
+// servers named in the answer of a priming response.
+func rootServersFromResponse(r *dns.Msg) ([]string, error) {
+	if r.Rcode != dns.RcodeSuccess {
+		return nil, errors.New("priming query failed with " + dns.RcodeToString[r.Rcode])
+	}
+	names := make(map[string]bool)
+	for _, rr := range r.Answer {
+		if ns, ok := rr.(*dns.NS); ok && ns.Hdr.Name == "." {
+			names[strings.ToLower(ns.Ns)] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil, errors.New("priming response has no root NS records")
+	}
+	var roots []string
+	for _, rr := range r.Extra {
+		if a, ok := rr.(*dns.A); ok && names[strings.ToLower(a.Hdr.Name)] {
+			roots = append(roots, net.JoinHostPort(a.A.String(), "53"))
+		}
+	}
+	if len(roots) == 0 {
+		return nil, errors.New("priming response has no root server addresses")
+	}
+	return roots, nil
+}
+
+// DiffServers returns the servers that are only in updated and those that are
+// only in old, each sorted.
+func DiffServers(old []string, updated []string) (added []string, removed []string) {
+	inOld := make(map[string]bool, len(old))
+	for _, s := range old {
+		inOld[s] = true
+	}
+	inUpdated := make(map[string]bool, len(updated))
+	for _, s := range updated {
+		inUpdated[s] = true
+		if !inOld[s] {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if !inUpdated[s] {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
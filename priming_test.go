@@ -0,0 +1,64 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestRootServersFromResponse(t *testing.T) {
+	r := new(dns.Msg)
+	r.Answer = []dns.RR{
+		&dns.NS{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeNS, Class: dns.ClassINET}, Ns: "a.root-servers.net."},
+		&dns.NS{Hdr: dns.RR_Header{Name: ".", Rrtype: dns.TypeNS, Class: dns.ClassINET}, Ns: "b.root-servers.net."},
+	}
+	r.Extra = []dns.RR{
+		&dns.A{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("198.41.0.4")},
+		&dns.AAAA{Hdr: dns.RR_Header{Name: "a.root-servers.net.", Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("2001:503:ba3e::2:30")},
+		&dns.A{Hdr: dns.RR_Header{Name: "B.root-servers.net.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("170.247.170.2")},
+		// not one of the root servers named in the answer
+		&dns.A{Hdr: dns.RR_Header{Name: "ns.example.com.", Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("192.0.2.1")},
+	}
+	roots, err := rootServersFromResponse(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(roots, []string{"198.41.0.4:53", "170.247.170.2:53"}) {
+		t.Errorf("Unexpected root servers: %v", roots)
+	}
+
+	r.Extra = nil
+	if _, err := rootServersFromResponse(r); err == nil {
+		t.Error("Expected a response without addresses to be rejected")
+	}
+	r.Rcode = dns.RcodeRefused
+	if _, err := rootServersFromResponse(r); err == nil {
+		t.Error("Expected a REFUSED response to be rejected")
+	}
+}
+
+func TestDiffServers(t *testing.T) {
+	added, removed := DiffServers([]string{"198.41.0.4:53", "199.9.14.201:53"}, []string{"198.41.0.4:53", "170.247.170.2:53"})
+	if !reflect.DeepEqual(added, []string{"170.247.170.2:53"}) || !reflect.DeepEqual(removed, []string{"199.9.14.201:53"}) {
+		t.Errorf("Unexpected difference: added %v, removed %v", added, removed)
+	}
+	if added, removed = DiffServers([]string{"198.41.0.4:53"}, []string{"198.41.0.4:53"}); added != nil || removed != nil {
+		t.Errorf("Unexpected difference between identical sets: %v %v", added, removed)
+	}
+}
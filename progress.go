@@ -0,0 +1,134 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// how often --progress prints a status line
+const progressInterval = 5 * time.Second
+
+// progress counts processed inputs and periodically reports the throughput
+// and, when the size of the input is known, the estimated time remaining.
+type progress struct {
+	// both are accessed atomically. total is -1 until the input is counted.
+	processed int64
+	total     int64
+	start     time.Time
+}
+
+func newProgress(alreadyProcessed int) *progress {
+	return &progress{
+		processed: int64(alreadyProcessed),
+		total:     -1,
+		start:     time.Now(),
+	}
+}
+
+func (p *progress) add() {
+	atomic.AddInt64(&p.processed, 1)
+}
+
+// countInput counts the lines of the input file in the background, so that
+// large inputs don't delay the start of the scan.
+func (p *progress) countInput(path string) {
+	go func() {
+		f, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		var r io.Reader = bufio.NewReader(f)
+		magic, _ := r.(*bufio.Reader).Peek(2)
+		if strings.HasSuffix(path, ".gz") || bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			if r, err = gzip.NewReader(r); err != nil {
+				return
+			}
+		}
+		lines, err := countLines(r)
+		if err != nil {
+			return
+		}
+		atomic.StoreInt64(&p.total, lines)
+	}()
+}
+
+// countLines counts the lines in r, including a last one without a newline.
+func countLines(r io.Reader) (int64, error) {
+	var lines int64
+	buf := make([]byte, 64*1024)
+	last := byte('\n')
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			lines += int64(bytes.Count(buf[:n], []byte{'\n'}))
+			last = buf[n-1]
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if last != '\n' {
+		lines++
+	}
+	return lines, nil
+}
+
+// formatProgress builds the status line. A negative total means the size of
+// the input isn't known, in which case no ETA is given.
+func formatProgress(processed int64, total int64, rate float64, elapsed time.Duration) string {
+	line := fmt.Sprintf("%s elapsed; %d names processed", elapsed.Truncate(time.Second), processed)
+	if total > 0 {
+		line += fmt.Sprintf(" of %d (%.1f%%)", total, 100*float64(processed)/float64(total))
+	}
+	line += fmt.Sprintf("; %.1f names/s", rate)
+	if total > 0 && rate > 0 && processed < total {
+		remaining := time.Duration(float64(total-processed) / rate * float64(time.Second))
+		line += fmt.Sprintf("; ETA %s", remaining.Truncate(time.Second))
+	}
+	return line
+}
+
+// run prints a status line to w every progressInterval until stop is closed.
+// The rate is that of the last interval, so that it follows the current
+// speed of the scan rather than its average.
+func (p *progress) run(w io.Writer, stop <-chan struct{}) {
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	last := atomic.LoadInt64(&p.processed)
+	lastTime := p.start
+	for {
+		select {
+		case now := <-ticker.C:
+			processed := atomic.LoadInt64(&p.processed)
+			rate := float64(processed-last) / now.Sub(lastTime).Seconds()
+			fmt.Fprintln(w, formatProgress(processed, atomic.LoadInt64(&p.total), rate, now.Sub(p.start)))
+			last, lastTime = processed, now
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCountLines(t *testing.T) {
+	cases := map[string]int64{
+		"":                 0,
+		"a.com\n":          1,
+		"a.com\nb.com\n":   2,
+		"a.com\nb.com":     2,
+		"a.com\n\nb.com\n": 3,
+	}
+	for input, expected := range cases {
+		lines, err := countLines(strings.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lines != expected {
+			t.Errorf("%q: expected %d lines, got %d", input, expected, lines)
+		}
+	}
+}
+
+func TestFormatProgress(t *testing.T) {
+	line := formatProgress(250, 1000, 50, 5*time.Second+300*time.Millisecond)
+	expected := "5s elapsed; 250 names processed of 1000 (25.0%); 50.0 names/s; ETA 15s"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+	// unknown input size, e.g., stdin
+	line = formatProgress(250, -1, 50, 5*time.Second)
+	expected = "5s elapsed; 250 names processed; 50.0 names/s"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+	// stalled scans have no ETA
+	line = formatProgress(250, 1000, 0, 5*time.Second)
+	expected = "5s elapsed; 250 names processed of 1000 (25.0%); 0.0 names/s"
+	if line != expected {
+		t.Errorf("expected %q, got %q", expected, line)
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/proxy"
+)
+
+// SOCKS5Proxy is a SOCKS5 server (RFC 1928) through which TCP connections to
+// name servers are tunneled.
+type SOCKS5Proxy struct {
+	Addr string
+	// nil if the proxy doesn't require authentication (RFC 1929)
+	Auth *proxy.Auth
+}
+
+// ParseSOCKS5Proxy parses [socks5://][user:password@]host:port.
+func ParseSOCKS5Proxy(s string) (*SOCKS5Proxy, error) {
+	if !strings.Contains(s, "://") {
+		s = "socks5://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" {
+		return nil, errors.New("unsupported proxy scheme " + u.Scheme)
+	}
+	if u.Path != "" || u.RawQuery != "" {
+		return nil, errors.New("proxy must be given as host:port")
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, err
+	}
+	p := &SOCKS5Proxy{Addr: u.Host}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		p.Auth = &proxy.Auth{User: u.User.Username(), Password: password}
+	}
+	return p, nil
+}
+
+// URL returns the proxy as a socks5:// URL, with its credentials, for use by
+// HTTP clients.
+func (p *SOCKS5Proxy) URL() *url.URL {
+	u := &url.URL{Scheme: "socks5", Host: p.Addr}
+	if p.Auth != nil {
+		u.User = url.UserPassword(p.Auth.User, p.Auth.Password)
+	}
+	return u
+}
+
+// DialContext connects to address through the proxy, reaching the proxy
+// itself with forward.
+func (p *SOCKS5Proxy) DialContext(ctx context.Context, forward *net.Dialer, network, address string) (net.Conn, error) {
+	d, err := proxy.SOCKS5("tcp", p.Addr, p.Auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	if cd, ok := d.(interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	}); ok {
+		return cd.DialContext(ctx, network, address)
+	}
+	return d.Dial(network, address)
+}
+
+// MarshalJSON leaves the credentials of the proxy out of the metadata.
+func (p *SOCKS5Proxy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(p.Addr)
+}
@@ -0,0 +1,78 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// QueryFlags are header bits to set in every query, on top of those set
+// otherwise (e.g., RD unless --no-recurse or --iterative). They are sent as
+// given, even where they make no sense in a query.
+type QueryFlags struct {
+	Response           bool `json:"qr,omitempty"`
+	Authoritative      bool `json:"aa,omitempty"`
+	Truncated          bool `json:"tc,omitempty"`
+	RecursionDesired   bool `json:"rd,omitempty"`
+	RecursionAvailable bool `json:"ra,omitempty"`
+	Zero               bool `json:"z,omitempty"`
+	AuthenticatedData  bool `json:"ad,omitempty"`
+	CheckingDisabled   bool `json:"cd,omitempty"`
+}
+
+// ParseQueryFlags parses a comma-delimited list of header bits by their
+// mnemonics, in any case: qr, aa, tc, rd, ra, z, ad, and cd.
+func ParseQueryFlags(s string) (QueryFlags, error) {
+	var f QueryFlags
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "qr":
+			f.Response = true
+		case "aa":
+			f.Authoritative = true
+		case "tc":
+			f.Truncated = true
+		case "rd":
+			f.RecursionDesired = true
+		case "ra":
+			f.RecursionAvailable = true
+		case "z":
+			f.Zero = true
+		case "ad":
+			f.AuthenticatedData = true
+		case "cd":
+			f.CheckingDisabled = true
+		case "":
+		default:
+			return QueryFlags{}, fmt.Errorf("unknown header flag %q", name)
+		}
+	}
+	return f, nil
+}
+
+// Apply sets the flags in the header of query m.
+func (f QueryFlags) Apply(m *dns.Msg) {
+	m.Response = m.Response || f.Response
+	m.Authoritative = m.Authoritative || f.Authoritative
+	m.Truncated = m.Truncated || f.Truncated
+	m.RecursionDesired = m.RecursionDesired || f.RecursionDesired
+	m.RecursionAvailable = m.RecursionAvailable || f.RecursionAvailable
+	m.Zero = m.Zero || f.Zero
+	m.AuthenticatedData = m.AuthenticatedData || f.AuthenticatedData
+	m.CheckingDisabled = m.CheckingDisabled || f.CheckingDisabled
+}
@@ -0,0 +1,45 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseQueryFlags(t *testing.T) {
+	f, err := ParseQueryFlags("AA, z,cd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f != (QueryFlags{Authoritative: true, Zero: true, CheckingDisabled: true}) {
+		t.Errorf("Unexpected flags %+v", f)
+	}
+	if f, err := ParseQueryFlags(""); err != nil || f != (QueryFlags{}) {
+		t.Errorf("Unexpected flags %+v for an empty list: %v", f, err)
+	}
+	if _, err := ParseQueryFlags("rd,do"); err == nil {
+		t.Error("Expected an unknown flag to be rejected")
+	}
+
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeA)
+	m.RecursionDesired = true
+	QueryFlags{Response: true, AuthenticatedData: true}.Apply(m)
+	if !m.Response || !m.AuthenticatedData || !m.RecursionDesired || m.Authoritative || m.Zero {
+		t.Errorf("Unexpected header %+v", m.MsgHdr)
+	}
+}
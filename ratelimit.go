@@ -0,0 +1,88 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// reserve takes a token from the bucket and returns how long the caller must
+// wait before the token is actually available. Letting the balance go negative
+// queues callers in arrival order instead of having them race for refills.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter throttles the queries sent to each configured name server with
+// an independent token bucket. It is safe for use by all worker goroutines.
+type RateLimiter struct {
+	// populated once at construction and read-only afterwards
+	buckets map[string]*tokenBucket
+}
+
+func NewRateLimiter(nameServers []string, qps float64) *RateLimiter {
+	r := new(RateLimiter)
+	r.buckets = make(map[string]*tokenBucket, len(nameServers))
+	burst := qps
+	if burst < 1 {
+		burst = 1
+	}
+	for _, ns := range nameServers {
+		r.buckets[ns] = &tokenBucket{
+			rate:   qps,
+			burst:  burst,
+			tokens: burst,
+			last:   time.Now(),
+		}
+	}
+	return r
+}
+
+// Wait blocks until a query may be sent to the given name server. Servers that
+// aren't in the configured set (e.g., authoritative servers discovered during
+// iteration) are not limited. Wait is a no-op on a nil *RateLimiter.
+func (r *RateLimiter) Wait(nameServer string) {
+	if r == nil {
+		return
+	}
+	b, ok := r.buckets[nameServer]
+	if !ok {
+		return
+	}
+	if d := b.reserve(); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,44 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReserveWithinBurst(t *testing.T) {
+	r := NewRateLimiter([]string{"192.0.2.1:53"}, 10)
+	b := r.buckets["192.0.2.1:53"]
+	for i := 0; i < 10; i++ {
+		if d := b.reserve(); d != 0 {
+			t.Fatalf("Expected token %d to be available immediately, got wait of %v", i, d)
+		}
+	}
+	if d := b.reserve(); d < 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Errorf("Expected a wait of ~100ms once the burst is spent, got %v", d)
+	}
+}
+
+func TestWaitUnknownServer(t *testing.T) {
+	r := NewRateLimiter([]string{"192.0.2.1:53"}, 0.001)
+	start := time.Now()
+	r.Wait("198.51.100.1:53")
+	if time.Since(start) > 10*time.Millisecond {
+		t.Error("Expected servers outside the configured set not to be limited")
+	}
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait("192.0.2.1:53")
+}
@@ -0,0 +1,95 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AnswerSetter is implemented by lookup results whose answers can be
+// compared across the repeated queries of --repeat, e.g., the addresses
+// found by alookup. Each answer is rendered as a string; order doesn't
+// matter.
+type AnswerSetter interface {
+	AnswerSet() []string
+}
+
+// RepeatResult aggregates the results of looking up a name --repeat times.
+type RepeatResult struct {
+	Queries  int            `json:"queries" groups:"short,normal,long,trace"`
+	Statuses map[string]int `json:"statuses" groups:"short,normal,long,trace"`
+	// the distinct answer sets of the successful queries, most frequent first
+	AnswerSets []AnswerSetCount `json:"answer_sets,omitempty" groups:"short,normal,long,trace"`
+}
+
+type AnswerSetCount struct {
+	Answers []string `json:"answers" groups:"short,normal,long,trace"`
+	Count   int      `json:"count" groups:"short,normal,long,trace"`
+}
+
+// answerSet renders the answers of a lookup result for comparison. Results
+// of modules that don't implement AnswerSetter are compared whole.
+func answerSet(innerRes interface{}) []string {
+	if s, ok := innerRes.(AnswerSetter); ok {
+		answers := append([]string{}, s.AnswerSet()...)
+		sort.Strings(answers)
+		return answers
+	}
+	data, err := json.Marshal(innerRes)
+	if err != nil {
+		return nil
+	}
+	return []string{string(data)}
+}
+
+// repeatLookup looks name up n times, delay apart, and aggregates the
+// results. The status is NOERROR if any query succeeded and otherwise that of
+// the last query, along with its error. Traces are concatenated.
+func repeatLookup(lookup func(string) (interface{}, []interface{}, Status, error), name string, n int, delay time.Duration) (interface{}, []interface{}, Status, error) {
+	res := RepeatResult{Queries: n, Statuses: make(map[string]int)}
+	var trace []interface{}
+	status := STATUS_ERROR
+	var err error
+	index := make(map[string]int)
+	for i := 0; i < n; i++ {
+		if i > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+		innerRes, t, s, e := lookup(name)
+		trace = append(trace, t...)
+		res.Statuses[string(s)]++
+		if status != STATUS_NOERROR {
+			status, err = s, e
+		}
+		if s != STATUS_NOERROR {
+			continue
+		}
+		answers := answerSet(innerRes)
+		key := strings.Join(answers, "\n")
+		if j, ok := index[key]; ok {
+			res.AnswerSets[j].Count++
+		} else {
+			index[key] = len(res.AnswerSets)
+			res.AnswerSets = append(res.AnswerSets, AnswerSetCount{Answers: answers, Count: 1})
+		}
+	}
+	sort.SliceStable(res.AnswerSets, func(i, j int) bool {
+		return res.AnswerSets[i].Count > res.AnswerSets[j].Count
+	})
+	return res, trace, status, err
+}
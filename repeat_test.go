@@ -0,0 +1,80 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type fakeAnswers []string
+
+func (a fakeAnswers) AnswerSet() []string {
+	return a
+}
+
+func TestRepeatLookup(t *testing.T) {
+	responses := []struct {
+		res    interface{}
+		status Status
+	}{
+		{fakeAnswers{"192.0.2.2", "192.0.2.1"}, STATUS_NOERROR},
+		{nil, STATUS_TIMEOUT},
+		{fakeAnswers{"192.0.2.3"}, STATUS_NOERROR},
+		{fakeAnswers{"192.0.2.1", "192.0.2.2"}, STATUS_NOERROR},
+	}
+	i := 0
+	lookup := func(name string) (interface{}, []interface{}, Status, error) {
+		r := responses[i]
+		i++
+		return r.res, []interface{}{i}, r.status, nil
+	}
+	innerRes, trace, status, err := repeatLookup(lookup, "example.com", len(responses), 0)
+	if status != STATUS_NOERROR || err != nil {
+		t.Errorf("expected NOERROR, got %s (%v)", status, err)
+	}
+	if len(trace) != len(responses) {
+		t.Errorf("expected %d trace steps, got %d", len(responses), len(trace))
+	}
+	expected := RepeatResult{
+		Queries:  4,
+		Statuses: map[string]int{"NOERROR": 3, "TIMEOUT": 1},
+		AnswerSets: []AnswerSetCount{
+			{Answers: []string{"192.0.2.1", "192.0.2.2"}, Count: 2},
+			{Answers: []string{"192.0.2.3"}, Count: 1},
+		},
+	}
+	if !reflect.DeepEqual(innerRes, expected) {
+		t.Errorf("expected %+v, got %+v", expected, innerRes)
+	}
+}
+
+func TestRepeatLookupFailure(t *testing.T) {
+	statuses := []Status{STATUS_SERVFAIL, STATUS_TIMEOUT}
+	i := 0
+	lookup := func(name string) (interface{}, []interface{}, Status, error) {
+		s := statuses[i]
+		i++
+		return nil, nil, s, errors.New(string(s))
+	}
+	innerRes, _, status, err := repeatLookup(lookup, "example.com", 2, 0)
+	if status != STATUS_TIMEOUT || err == nil || err.Error() != "TIMEOUT" {
+		t.Errorf("expected the last status and error, got %s (%v)", status, err)
+	}
+	if sets := innerRes.(RepeatResult).AnswerSets; len(sets) != 0 {
+		t.Errorf("expected no answer sets, got %v", sets)
+	}
+}
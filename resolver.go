@@ -0,0 +1,178 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultGlobalConf returns a configuration for the given lookup module with
+// the same defaults as the zdns command line tool.
+func DefaultGlobalConf(module string) *GlobalConf {
+	return &GlobalConf{
+		Threads:          1000,
+		Timeout:          15 * time.Second,
+		IterationTimeout: 4 * time.Second,
+		Retries:          1,
+		MaxDepth:         10,
+		CacheSize:        10000,
+		Verbosity:        3,
+		ResultVerbosity:  "normal",
+		OutputGroups:     []string{"normal"},
+		TimeFormat:       time.RFC3339,
+		Module:           strings.ToUpper(module),
+		Class:            dns.ClassINET,
+	}
+}
+
+// Resolver performs lookups of single names with one of the registered
+// lookup modules, for use of zdns as a library. Modules register themselves
+// when imported, so import the ones you need, e.g.,
+//
+//	import _ "github.com/zmap/zdns/modules/alookup"
+//
+// A Resolver is safe for concurrent use.
+type Resolver struct {
+	conf    *GlobalConf
+	factory GlobalLookupFactory
+
+	mu       sync.Mutex
+	idle     []RoutineLookupFactory
+	threadID int
+}
+
+// NewResolver initializes the lookup module named by conf.Module. Module
+// specific settings are passed in conf.ModuleOptions, keyed by the name of
+// the corresponding command line flag without dashes (e.g., "service").
+// Unset options take the same defaults as on the command line. Each Resolver
+// gets a factory of its own, so Resolvers with different options can be used
+// side by side.
+func NewResolver(conf *GlobalConf) (*Resolver, error) {
+	factory := newLookupFactory(strings.ToUpper(conf.Module))
+	if factory == nil {
+		return nil, errors.New("unknown lookup module: " + conf.Module)
+	}
+	flags := flag.NewFlagSet(conf.Module, flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	factory.AddFlags(flags)
+	for name, value := range conf.ModuleOptions {
+		if err := flags.Set(name, value); err != nil {
+			return nil, errors.New("invalid module option " + name + ": " + err.Error())
+		}
+	}
+	return NewResolverWithFactory(conf, factory)
+}
+
+// newLookupFactory returns a copy of the factory registered under name, which
+// keeps what the module set up when registering it (e.g., the query type of
+// the A module) but shares none of the flags or state set later, or nil if
+// there is no such module.
+func newLookupFactory(name string) GlobalLookupFactory {
+	registered := GetLookup(name)
+	if registered == nil {
+		return nil
+	}
+	v := reflect.ValueOf(registered)
+	if v.Kind() != reflect.Ptr {
+		return registered
+	}
+	factory := reflect.New(v.Elem().Type())
+	factory.Elem().Set(v.Elem())
+	return factory.Interface().(GlobalLookupFactory)
+}
+
+// NewResolverWithFactory initializes a module factory that the caller has
+// constructed and configured directly, bypassing the module registry.
+func NewResolverWithFactory(conf *GlobalConf, factory GlobalLookupFactory) (*Resolver, error) {
+	if factory.ZonefileInput() {
+		return nil, errors.New("lookup modules that read zone files can't be used by a Resolver")
+	}
+	if len(conf.NameServers) == 0 {
+		if conf.IterativeResolution {
+			conf.NameServers = RootServers[:]
+		} else {
+			ns, err := GetDNSServers("/etc/resolv.conf")
+			if err != nil {
+				return nil, err
+			}
+			conf.NameServers = ns
+		}
+	}
+	if conf.DNSCookies && conf.Cookies == nil {
+		jar, err := NewCookieJar()
+		if err != nil {
+			return nil, err
+		}
+		conf.Cookies = jar
+	}
+	if err := factory.Initialize(conf); err != nil {
+		return nil, err
+	}
+	return &Resolver{conf: conf, factory: factory}, nil
+}
+
+// routine factories aren't safe for concurrent use, so each lookup borrows one
+func (r *Resolver) acquire() (RoutineLookupFactory, error) {
+	r.mu.Lock()
+	if n := len(r.idle); n > 0 {
+		f := r.idle[n-1]
+		r.idle = r.idle[:n-1]
+		r.mu.Unlock()
+		return f, nil
+	}
+	threadID := r.threadID
+	r.threadID++
+	r.mu.Unlock()
+	return r.factory.MakeRoutineFactory(threadID)
+}
+
+func (r *Resolver) release(f RoutineLookupFactory) {
+	r.mu.Lock()
+	r.idle = append(r.idle, f)
+	r.mu.Unlock()
+}
+
+// Lookup resolves a single name and returns the module's result for it, which
+// is the same value found in the data field of the command line output.
+func (r *Resolver) Lookup(name string) (interface{}, Status, error) {
+	f, err := r.acquire()
+	if err != nil {
+		return nil, STATUS_ERROR, err
+	}
+	defer r.release(f)
+	l, err := f.MakeLookup()
+	if err != nil {
+		return nil, STATUS_ERROR, err
+	}
+	lookupName, _ := makeName(appendSuffix(name, r.conf.NameSuffix), r.conf.NamePrefix)
+	start := time.Now()
+	r.conf.Metrics.StartLookup()
+	res, _, status, err := l.DoLookup(lookupName)
+	r.conf.Metrics.FinishLookup(status, time.Since(start))
+	return res, status, err
+}
+
+// Close releases the resources held by the lookup module.
+func (r *Resolver) Close() error {
+	return r.factory.Finalize()
+}
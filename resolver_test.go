@@ -0,0 +1,131 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"flag"
+	"sync"
+	"testing"
+)
+
+type echoLookup struct {
+	BaseLookup
+	suffix string
+}
+
+func (l *echoLookup) DoLookup(name string) (interface{}, []interface{}, Status, error) {
+	return name + l.suffix, nil, STATUS_NOERROR, nil
+}
+
+type echoRoutineFactory struct {
+	factory *echoFactory
+}
+
+func (f *echoRoutineFactory) MakeLookup() (Lookup, error) {
+	return &echoLookup{suffix: f.factory.Suffix}, nil
+}
+
+type echoFactory struct {
+	BaseGlobalLookupFactory
+	Suffix string
+}
+
+func (f *echoFactory) AddFlags(flags *flag.FlagSet) {
+	flags.StringVar(&f.Suffix, "suffix", ".example", "appended to every name")
+}
+
+func (f *echoFactory) MakeRoutineFactory(threadID int) (RoutineLookupFactory, error) {
+	return &echoRoutineFactory{factory: f}, nil
+}
+
+func init() {
+	RegisterLookup("ECHOTEST", new(echoFactory))
+}
+
+func TestResolverLookup(t *testing.T) {
+	conf := DefaultGlobalConf("echotest")
+	conf.NameServers = []string{"192.0.2.1:53"}
+	r, err := NewResolver(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, status, err := r.Lookup("zdns")
+			if err != nil || status != STATUS_NOERROR || res.(string) != "zdns.example" {
+				t.Errorf("Unexpected lookup result: %v, %v, %v", res, status, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestResolverModuleOptions(t *testing.T) {
+	conf := DefaultGlobalConf("ECHOTEST")
+	conf.NameServers = []string{"192.0.2.1:53"}
+	conf.ModuleOptions = map[string]string{"suffix": ".test"}
+	r, err := NewResolver(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, _, _ := r.Lookup("zdns"); res.(string) != "zdns.test" {
+		t.Errorf("Expected module option to apply, got %v", res)
+	}
+	conf.ModuleOptions = map[string]string{"no-such-option": "1"}
+	if _, err := NewResolver(conf); err == nil {
+		t.Error("Expected unknown module option to be rejected")
+	}
+}
+
+func TestResolversSideBySide(t *testing.T) {
+	var resolvers []*Resolver
+	for _, suffix := range []string{".one", ".two"} {
+		conf := DefaultGlobalConf("ECHOTEST")
+		conf.NameServers = []string{"192.0.2.1:53"}
+		conf.ModuleOptions = map[string]string{"suffix": suffix}
+		r, err := NewResolver(conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		resolvers = append(resolvers, r)
+	}
+	var wg sync.WaitGroup
+	for i, expected := range []string{"zdns.one", "zdns.two"} {
+		wg.Add(1)
+		go func(r *Resolver, expected string) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if res, _, _ := r.Lookup("zdns"); res.(string) != expected {
+					t.Errorf("Expected %s, got %v", expected, res)
+				}
+			}
+		}(resolvers[i], expected)
+	}
+	wg.Wait()
+	if suffix := GetLookup("ECHOTEST").(*echoFactory).Suffix; suffix != "" {
+		t.Errorf("Expected the registered factory to be left alone, got suffix %q", suffix)
+	}
+}
+
+func TestResolverUnknownModule(t *testing.T) {
+	if _, err := NewResolver(DefaultGlobalConf("NOSUCHMODULE")); err == nil {
+		t.Error("Expected unknown module to be rejected")
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ResultTyper is implemented by lookup modules that can describe the data of
+// their results, for --dump-schema.
+type ResultTyper interface {
+	// ResultType returns the type of the data that lookups return.
+	ResultType() reflect.Type
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// inGroups reports whether a field with the groups tag tag is output at the
+// given verbosity, the way sheriff decides it.
+func inGroups(tag string, groups []string) bool {
+	for _, g := range strings.Split(tag, ",") {
+		for _, want := range groups {
+			if g != "" && g == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// typeSchema describes how values of t are output as a JSON Schema. Nil
+// pointers, slices, and maps are output as null.
+func typeSchema(t reflect.Type, groups []string, seen map[reflect.Type]bool) map[string]interface{} {
+	switch {
+	case t.Kind() == reflect.Ptr:
+		return nullable(typeSchema(t.Elem(), groups, seen))
+	case t.Kind() == reflect.Map, t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8:
+		return nullable(valueSchema(t, groups, seen))
+	}
+	return valueSchema(t, groups, seen)
+}
+
+// valueSchema describes the non-null values of t. Interface values can hold
+// anything and are left unconstrained, as are types that marshal themselves
+// and types already being described further up, which would otherwise
+// recurse forever.
+func valueSchema(t reflect.Type, groups []string, seen map[reflect.Type]bool) map[string]interface{} {
+	if t.Implements(jsonMarshalerType) || reflect.PtrTo(t).Implements(jsonMarshalerType) {
+		return map[string]interface{}{}
+	}
+	if t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType) {
+		return map[string]interface{}{"type": "string"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// base64, as encoding/json writes byte slices
+			return map[string]interface{}{"type": "string"}
+		}
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), groups, seen)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), groups, seen)}
+	case reflect.Struct:
+		if seen[t] {
+			return map[string]interface{}{}
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		properties := make(map[string]interface{})
+		var required []string
+		structFields(t, groups, seen, properties, &required)
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			sort.Strings(required)
+			schema["required"] = required
+		}
+		return schema
+	}
+	return map[string]interface{}{}
+}
+
+// nullable extends a schema to also allow null.
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if typ, ok := schema["type"].(string); ok {
+		schema["type"] = []string{typ, "null"}
+	}
+	return schema
+}
+
+// structFields adds the output fields of struct t to properties. Fields of
+// embedded structs are output inline, whatever their groups.
+func structFields(t reflect.Type, groups []string, seen map[reflect.Type]bool, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		tag := strings.Split(field.Tag.Get("json"), ",")
+		if tag[0] == "-" {
+			continue
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && ft.Kind() == reflect.Struct {
+			structFields(ft, groups, seen, properties, required)
+			continue
+		}
+		if field.PkgPath != "" || !inGroups(field.Tag.Get("groups"), groups) {
+			continue
+		}
+		name := tag[0]
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = typeSchema(field.Type, groups, seen)
+		omitEmpty := false
+		for _, opt := range tag[1:] {
+			omitEmpty = omitEmpty || opt == "omitempty"
+		}
+		if !omitEmpty {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// ResultSchema returns a JSON Schema of the records that the module writes
+// with the output settings in gc. The data of modules that don't implement
+// ResultTyper is left unconstrained.
+func ResultSchema(gc *GlobalConf, factory GlobalLookupFactory) map[string]interface{} {
+	schema := typeSchema(reflect.TypeOf(Result{}), gc.OutputGroups, make(map[reflect.Type]bool))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = gc.Module
+	properties := schema["properties"].(map[string]interface{})
+	if _, ok := properties["data"]; ok {
+		if len(gc.CompareServers) > 0 {
+			properties["data"] = typeSchema(reflect.TypeOf(CompareResult{}), gc.OutputGroups, make(map[reflect.Type]bool))
+		} else if gc.Repeat > 1 {
+			properties["data"] = typeSchema(reflect.TypeOf(RepeatResult{}), gc.OutputGroups, make(map[reflect.Type]bool))
+		} else if typer, ok := factory.(ResultTyper); ok {
+			properties["data"] = typeSchema(typer.ResultType(), gc.OutputGroups, make(map[reflect.Type]bool))
+		}
+	}
+	if len(gc.FieldMap) > 0 {
+		renameFields(properties, gc.FieldMap)
+	}
+	return schema
+}
@@ -0,0 +1,85 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"testing"
+)
+
+type schemaInner struct {
+	Value string `json:"value" groups:"short,normal,long,trace"`
+}
+
+type schemaEmbedded struct {
+	Extra int `json:"extra" groups:"long,trace"`
+}
+
+type schemaTest struct {
+	schemaEmbedded
+	Name     string         `json:"name" groups:"short,normal,long,trace"`
+	TTL      uint32         `json:"ttl,omitempty" groups:"ttl,long,trace"`
+	Flags    []bool         `json:"flags,omitempty" groups:"normal,long,trace"`
+	Inner    *schemaInner   `json:"inner" groups:"normal,long,trace"`
+	Counts   map[string]int `json:"counts" groups:"normal,long,trace"`
+	Address  net.IP         `json:"address" groups:"normal,long,trace"`
+	Any      interface{}    `json:"any" groups:"normal,long,trace"`
+	Self     []schemaTest   `json:"self,omitempty" groups:"normal,long,trace"`
+	Hidden   string         `json:"-"`
+	internal string
+}
+
+func TestTypeSchema(t *testing.T) {
+	schema := typeSchema(reflect.TypeOf(schemaTest{}), []string{"normal"}, make(map[reflect.Type]bool))
+	data, _ := json.Marshal(schema)
+	expected := `{"properties":{` +
+		`"address":{"type":"string"},` +
+		`"any":{},` +
+		`"counts":{"additionalProperties":{"type":"integer"},"type":["object","null"]},` +
+		`"flags":{"items":{"type":"boolean"},"type":["array","null"]},` +
+		`"inner":{"properties":{"value":{"type":"string"}},"required":["value"],"type":["object","null"]},` +
+		`"name":{"type":"string"},` +
+		`"self":{"items":{},"type":["array","null"]}},` +
+		`"required":["address","any","counts","inner","name"],"type":"object"}`
+	if string(data) != expected {
+		t.Errorf("Unexpected schema:\n%s\nexpected:\n%s", data, expected)
+	}
+	// embedded fields are inlined, and ttl is only output with its group
+	schema = typeSchema(reflect.TypeOf(schemaTest{}), []string{"long"}, make(map[reflect.Type]bool))
+	properties := schema["properties"].(map[string]interface{})
+	if _, ok := properties["extra"]; !ok {
+		t.Error("Expected the embedded extra field at the long verbosity")
+	}
+	if _, ok := properties["ttl"]; !ok {
+		t.Error("Expected the ttl field at the long verbosity")
+	}
+}
+
+func TestResultSchema(t *testing.T) {
+	gc := &GlobalConf{Module: "TEST", OutputGroups: []string{"short"}, FieldMap: map[string]string{"name": "domain"}}
+	schema := ResultSchema(gc, nil)
+	properties := schema["properties"].(map[string]interface{})
+	if _, ok := properties["domain"]; !ok {
+		t.Error("Expected name to be renamed to domain")
+	}
+	if _, ok := properties["nameserver"]; ok {
+		t.Error("Unexpected nameserver field at the short verbosity")
+	}
+	if data, ok := properties["data"]; !ok || len(data.(map[string]interface{})) != 0 {
+		t.Errorf("Expected unconstrained data, got %v", data)
+	}
+}
@@ -0,0 +1,60 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"math/rand"
+	"time"
+)
+
+// shuffleBuffer randomizes the order of a stream of inputs within a bounded
+// window. Once the window is full, each new input takes the place of a
+// random buffered one, which is handed out instead.
+type shuffleBuffer struct {
+	// 0 buffers the entire input
+	window int
+	inputs []lookupInput
+	rand   *rand.Rand
+}
+
+func newShuffleBuffer(window int) *shuffleBuffer {
+	return &shuffleBuffer{
+		window: window,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// push adds in to the buffer and returns the input to hand out next, if the
+// buffer is full.
+func (b *shuffleBuffer) push(in lookupInput) (lookupInput, bool) {
+	if b.window == 0 || len(b.inputs) < b.window {
+		b.inputs = append(b.inputs, in)
+		return lookupInput{}, false
+	}
+	i := b.rand.Intn(len(b.inputs))
+	out := b.inputs[i]
+	b.inputs[i] = in
+	return out, true
+}
+
+// drain empties the buffer and returns what it held in random order.
+func (b *shuffleBuffer) drain() []lookupInput {
+	inputs := b.inputs
+	b.rand.Shuffle(len(inputs), func(i, j int) {
+		inputs[i], inputs[j] = inputs[j], inputs[i]
+	})
+	b.inputs = nil
+	return inputs
+}
@@ -0,0 +1,58 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"sort"
+	"testing"
+)
+
+// shuffleAll runs n inputs through a shuffle buffer and returns the order in
+// which they come out.
+func shuffleAll(window int, n int) []int {
+	b := newShuffleBuffer(window)
+	var order []int
+	for i := 0; i < n; i++ {
+		if out, ok := b.push(lookupInput{index: i}); ok {
+			order = append(order, out.index)
+		}
+		if window > 0 && len(b.inputs) > window {
+			panic("shuffle buffer exceeded its window")
+		}
+	}
+	for _, in := range b.drain() {
+		order = append(order, in.index)
+	}
+	return order
+}
+
+func TestShuffleBuffer(t *testing.T) {
+	for _, window := range []int{0, 1, 10, 1000} {
+		order := shuffleAll(window, 500)
+		if len(order) != 500 {
+			t.Fatalf("window %d: expected 500 inputs, got %d", window, len(order))
+		}
+		sorted := append([]int{}, order...)
+		sort.Ints(sorted)
+		for i, index := range sorted {
+			if index != i {
+				t.Fatalf("window %d: input %d lost or repeated", window, i)
+			}
+		}
+		if window != 1 && sort.IntsAreSorted(order) {
+			t.Errorf("window %d: inputs were not shuffled", window)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// TSIGFudge is the clock skew allowed between the signer and the verifier of
+// a TSIG-signed message (RFC 8945 recommends 300 seconds).
+const TSIGFudge = 300
+
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGKey is a shared secret with which queries are signed and responses
+// verified (RFC 8945).
+type TSIGKey struct {
+	// fully qualified key name
+	Name string
+	// algorithm name as it appears in TSIG records, e.g., hmac-sha256.
+	Algorithm string
+	// base64-encoded secret
+	Secret string
+}
+
+// NewTSIGKey checks the settings of a TSIG key, given by its name, the
+// algorithm (e.g., hmac-sha256), and the secret in base64.
+func NewTSIGKey(name string, algorithm string, secret string) (*TSIGKey, error) {
+	if name == "" || secret == "" {
+		return nil, errors.New("a TSIG key needs both a name and a secret")
+	}
+	alg, ok := tsigAlgorithms[strings.TrimSuffix(strings.ToLower(algorithm), ".")]
+	if !ok {
+		return nil, errors.New("unsupported TSIG algorithm " + algorithm + ": expected hmac-md5, hmac-sha1, hmac-sha256, or hmac-sha512")
+	}
+	if _, err := base64.StdEncoding.DecodeString(secret); err != nil {
+		return nil, errors.New("TSIG secret is not valid base64: " + err.Error())
+	}
+	return &TSIGKey{Name: dns.Fqdn(strings.ToLower(name)), Algorithm: alg, Secret: secret}, nil
+}
+
+// Secrets returns the key in the form that dns.Client and dns.Transfer
+// expect.
+func (k *TSIGKey) Secrets() map[string]string {
+	return map[string]string{k.Name: k.Secret}
+}
+
+// Sign adds a TSIG record to m, which must be the last change to m before
+// it is sent. The MAC itself is computed as the message is written.
+func (k *TSIGKey) Sign(m *dns.Msg) {
+	m.SetTsig(k.Name, k.Algorithm, TSIGFudge, time.Now().Unix())
+}
+
+// IsTSIGError reports whether err is a failure to verify the TSIG record of
+// a response, as returned by the dns package when reading it.
+func IsTSIGError(err error) bool {
+	return err == dns.ErrSig || err == dns.ErrTime || err == dns.ErrSecret || err == dns.ErrKeyAlg
+}
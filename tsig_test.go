@@ -0,0 +1,75 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestNewTSIGKey(t *testing.T) {
+	key, err := NewTSIGKey("Transfer.Example.com", "HMAC-SHA256", "c2VjcmV0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Name != "transfer.example.com." || key.Algorithm != dns.HmacSHA256 {
+		t.Errorf("unexpected key %+v", key)
+	}
+	if secrets := key.Secrets(); secrets["transfer.example.com."] != "c2VjcmV0" {
+		t.Errorf("unexpected secrets %v", secrets)
+	}
+	invalid := []struct{ name, algorithm, secret string }{
+		{"", "hmac-sha256", "c2VjcmV0"},
+		{"key.", "hmac-sha256", ""},
+		{"key.", "hmac-sha3", "c2VjcmV0"},
+		{"key.", "hmac-sha256", "not base64!"},
+	}
+	for _, k := range invalid {
+		if _, err := NewTSIGKey(k.name, k.algorithm, k.secret); err == nil {
+			t.Errorf("expected an error for %+v", k)
+		}
+	}
+}
+
+func TestTSIGSign(t *testing.T) {
+	key, _ := NewTSIGKey("key.", "hmac-sha1", "c2VjcmV0")
+	m := new(dns.Msg)
+	m.SetQuestion("example.com.", dns.TypeSOA)
+	m.SetEdns0(dns.DefaultMsgSize, false)
+	key.Sign(m)
+	tsig := m.IsTsig()
+	if tsig == nil || tsig.Algorithm != dns.HmacSHA1 || tsig.Fudge != TSIGFudge {
+		t.Fatalf("expected a TSIG record last, got %v", m.Extra)
+	}
+	// the MAC is computed when the message is packed
+	if _, _, err := dns.TsigGenerate(m, key.Secret, "", false); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIsTSIGError(t *testing.T) {
+	for _, err := range []error{dns.ErrSig, dns.ErrTime, dns.ErrSecret, dns.ErrKeyAlg} {
+		if !IsTSIGError(err) {
+			t.Errorf("expected %v to be a TSIG error", err)
+		}
+	}
+	for _, err := range []error{nil, dns.ErrId, errors.New("i/o timeout")} {
+		if IsTSIGError(err) {
+			t.Errorf("expected %v not to be a TSIG error", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+// clampTTL limits ttl to [min, max]. A max of 0 means no upper bound.
+func clampTTL(ttl uint32, min uint32, max uint32) uint32 {
+	if ttl < min {
+		return min
+	}
+	if max > 0 && ttl > max {
+		return max
+	}
+	return ttl
+}
+
+// clampTTLs walks a result that sheriff has marshaled and clamps the ttl of
+// every record in it, whichever module produced it. Only the output changes;
+// lookups and the cache still see the TTLs on the wire. With keepRaw, each
+// clamped record also reports its original TTL as raw_ttl.
+func clampTTLs(v interface{}, min uint32, max uint32, keepRaw bool) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, inner := range t {
+			if k == "ttl" {
+				if ttl, ok := inner.(uint32); ok {
+					if clamped := clampTTL(ttl, min, max); clamped != ttl {
+						t[k] = clamped
+						if keepRaw {
+							t["raw_ttl"] = ttl
+						}
+					}
+				}
+				continue
+			}
+			clampTTLs(inner, min, max, keepRaw)
+		}
+	case []interface{}:
+		for _, inner := range t {
+			clampTTLs(inner, min, max, keepRaw)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func makeTTLResult() map[string]interface{} {
+	return map[string]interface{}{
+		"name": "example.com",
+		"data": map[string]interface{}{
+			"answers": []interface{}{
+				map[string]interface{}{"ttl": uint32(30), "answer": "192.0.2.1"},
+				map[string]interface{}{"ttl": uint32(600), "answer": "192.0.2.2"},
+				map[string]interface{}{"ttl": uint32(86400), "answer": "192.0.2.3"},
+			},
+			"soa": map[string]interface{}{"min_ttl": uint32(5), "ttl": uint32(3600)},
+		},
+	}
+}
+
+func TestClampTTLs(t *testing.T) {
+	res := makeTTLResult()
+	clampTTLs(res, 60, 3600, false)
+	data := res["data"].(map[string]interface{})
+	answers := data["answers"].([]interface{})
+	expected := []interface{}{
+		map[string]interface{}{"ttl": uint32(60), "answer": "192.0.2.1"},
+		map[string]interface{}{"ttl": uint32(600), "answer": "192.0.2.2"},
+		map[string]interface{}{"ttl": uint32(3600), "answer": "192.0.2.3"},
+	}
+	if !reflect.DeepEqual(answers, expected) {
+		t.Errorf("expected %v, got %v", expected, answers)
+	}
+	// only fields named ttl are clamped
+	soa := data["soa"].(map[string]interface{})
+	if soa["min_ttl"] != uint32(5) || soa["ttl"] != uint32(3600) {
+		t.Errorf("unexpected SOA: %v", soa)
+	}
+}
+
+func TestClampTTLsKeepRaw(t *testing.T) {
+	res := makeTTLResult()
+	clampTTLs(res, 0, 300, true)
+	answers := res["data"].(map[string]interface{})["answers"].([]interface{})
+	first := answers[0].(map[string]interface{})
+	if _, ok := first["raw_ttl"]; ok {
+		t.Error("raw_ttl added to a record that wasn't clamped")
+	}
+	third := answers[2].(map[string]interface{})
+	if third["ttl"] != uint32(300) || third["raw_ttl"] != uint32(86400) {
+		t.Errorf("unexpected clamped record: %v", third)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// parentZones returns the distinct parent zones of the names on the input
+// lines, in the order they were first seen. The parent of a name is taken
+// to be the name without its first label; names directly under the root
+// have none worth warming.
+func parentZones(lines []string, c *GlobalConf) []string {
+	seen := make(map[string]bool)
+	var zones []string
+	for _, line := range lines {
+		name := line
+		if c.AlexaFormat {
+			if i := strings.Index(name, ","); i >= 0 {
+				name = name[i+1:]
+			}
+		}
+		if n, _, ok := splitClass(name); ok {
+			name = n
+		}
+		name = appendSuffix(strings.TrimSpace(name), c.NameSuffix)
+		if !c.NoIDNA {
+			ascii, _, err := toASCIIName(name)
+			if err != nil {
+				continue
+			}
+			name = ascii
+		}
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		i := strings.Index(name, ".")
+		if i < 0 || i == len(name)-1 {
+			continue
+		}
+		if zone := name[i+1:]; !seen[zone] {
+			seen[zone] = true
+			zones = append(zones, zone)
+		}
+	}
+	return zones
+}
+
+// warmCache reads up to c.WarmCache inputs from in, has w cache the
+// delegations of their parent zones, and returns a channel that yields the
+// inputs read followed by the rest of in. Warming ends early once stop is
+// closed, e.g., when the scan is interrupted.
+func warmCache(w CacheWarmer, in <-chan interface{}, c *GlobalConf, stop <-chan struct{}) <-chan interface{} {
+	var inputs []interface{}
+	var lines []string
+read:
+	for len(inputs) < c.WarmCache {
+		select {
+		case input, ok := <-in:
+			if !ok {
+				break read
+			}
+			inputs = append(inputs, input)
+			lines = append(lines, inputLine(input))
+		case <-stop:
+			break read
+		}
+	}
+	zones := parentZones(lines, c)
+	start := time.Now()
+	log.Info("warming the cache with the delegations of ", len(zones), " zones")
+	w.WarmCache(zones, c.Threads, stop)
+	log.Info("warmed the cache in ", time.Since(start).Round(time.Millisecond))
+
+	out := make(chan interface{})
+	go func() {
+		defer close(out)
+		for _, input := range inputs {
+			out <- input
+		}
+		for input := range in {
+			out <- input
+		}
+	}()
+	return out
+}
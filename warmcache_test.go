@@ -0,0 +1,86 @@
+/*
+ * ZDNS Copyright 2020 Regents of the University of Michigan
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License"); you may not
+ * use this file except in compliance with the License. You may obtain a copy
+ * of the License at http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+ * implied. See the License for the specific language governing
+ * permissions and limitations under the License.
+ */
+
+package zdns
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParentZones(t *testing.T) {
+	c := &GlobalConf{NoIDNA: true}
+	lines := []string{"www.example.com", "MAIL.Example.com.", "example.org", "com", "a.b.example.net,CH", ""}
+	expected := []string{"example.com", "org", "b.example.net"}
+	if zones := parentZones(lines, c); !reflect.DeepEqual(zones, expected) {
+		t.Errorf("Expected %v, got %v", expected, zones)
+	}
+
+	c = &GlobalConf{NoIDNA: true, AlexaFormat: true, NameSuffix: "example.com"}
+	if zones := parentZones([]string{"1,www", "2,mail", "3,host.example.org."}, c); !reflect.DeepEqual(zones, []string{"example.com", "example.org"}) {
+		t.Errorf("Unexpected zones %v", zones)
+	}
+}
+
+type fakeWarmer struct {
+	zones []string
+}
+
+func (w *fakeWarmer) WarmCache(zones []string, threads int, stop <-chan struct{}) {
+	w.zones = zones
+}
+
+func TestWarmCache(t *testing.T) {
+	in := make(chan interface{})
+	go func() {
+		for _, name := range []string{"a.example.com", "b.example.org", "c.example.net"} {
+			in <- name
+		}
+		close(in)
+	}()
+	w := new(fakeWarmer)
+	out := warmCache(w, in, &GlobalConf{WarmCache: 2, NoIDNA: true, Threads: 1}, nil)
+	if !reflect.DeepEqual(w.zones, []string{"example.com", "example.org"}) {
+		t.Errorf("Unexpected zones %v", w.zones)
+	}
+	var names []interface{}
+	for name := range out {
+		names = append(names, name)
+	}
+	if !reflect.DeepEqual(names, []interface{}{"a.example.com", "b.example.org", "c.example.net"}) {
+		t.Errorf("Inputs weren't all handed out in order: %v", names)
+	}
+}
+
+func TestWarmCacheStop(t *testing.T) {
+	// the input never comes, as from an idle stdin
+	in := make(chan interface{})
+	stop := make(chan struct{})
+	close(stop)
+	w := new(fakeWarmer)
+	done := make(chan struct{})
+	go func() {
+		warmCache(w, in, &GlobalConf{WarmCache: 2, NoIDNA: true, Threads: 1}, stop)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("warming the cache didn't stop")
+	}
+	if len(w.zones) != 0 {
+		t.Errorf("Unexpected zones %v", w.zones)
+	}
+}
@@ -15,26 +15,48 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
 	"os"
 	"runtime"
 	"strings"
 	"time"
 	"io/ioutil"
+	"math"
 	"math/rand"
+	"strconv"
+	"unicode/utf8"
 
 	"github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 	"github.com/zmap/zdns"
 	_ "github.com/zmap/zdns/modules/alookup"
 	_ "github.com/zmap/zdns/modules/axfr"
+	_ "github.com/zmap/zdns/modules/caalookup"
 	_ "github.com/zmap/zdns/modules/dmarc"
+	_ "github.com/zmap/zdns/modules/dnskeylookup"
+	_ "github.com/zmap/zdns/modules/hinfolookup"
 	_ "github.com/zmap/zdns/modules/miekg"
+	_ "github.com/zmap/zdns/modules/multilookup"
 	_ "github.com/zmap/zdns/modules/mxlookup"
+	_ "github.com/zmap/zdns/modules/naptrlookup"
 	_ "github.com/zmap/zdns/modules/nslookup"
+	_ "github.com/zmap/zdns/modules/ptrlookup"
+	_ "github.com/zmap/zdns/modules/soalookup"
 	_ "github.com/zmap/zdns/modules/spf"
+	_ "github.com/zmap/zdns/modules/srvlookup"
+	_ "github.com/zmap/zdns/modules/sshfplookup"
+	_ "github.com/zmap/zdns/modules/tlsalookup"
+	_ "github.com/zmap/zdns/modules/txtlookup"
+	_ "github.com/zmap/zdns/modules/urilookup"
 
+	_ "github.com/zmap/zdns/iohandlers/avro"
+	_ "github.com/zmap/zdns/iohandlers/csv"
 	_ "github.com/zmap/zdns/iohandlers/file"
+	_ "github.com/zmap/zdns/iohandlers/http"
+	_ "github.com/zmap/zdns/iohandlers/redis"
+	_ "github.com/zmap/zdns/iohandlers/s3"
 )
 
 func main() {
@@ -42,33 +64,125 @@ func main() {
 	var gc zdns.GlobalConf
 	// global flags relevant to every lookup module
 	flags := flag.NewFlagSet("flags", flag.ExitOnError)
-	flags.IntVar(&gc.Threads, "threads", 1000, "number of lightweight go threads")
+	threads := flags.String("threads", "1000", "number of lightweight go threads, or auto to adapt the number of concurrent lookups to the resolvers' timeouts and latency")
+	flags.IntVar(&gc.MinThreads, "min-threads", 10, "fewest concurrent lookups with --threads auto")
+	maxThreads := flags.Int("max-threads", 5000, "most concurrent lookups with --threads auto")
 	flags.IntVar(&gc.GoMaxProcs, "go-processes", 0, "number of OS processes (GOMAXPROCS)")
+	flags.BoolVar(&gc.NoIDNA, "no-idna", false, "look up names with non-ASCII characters as given, instead of converting them to A-labels (punycode)")
 	flags.StringVar(&gc.NamePrefix, "prefix", "", "name to be prepended to what's passed in (e.g., www.)")
+	flags.StringVar(&gc.NameSuffix, "suffix", "", "domain to be appended to what's passed in, after a dot (e.g., example.com turns host into host.example.com). Names ending with a dot are left as is")
+	prefixes := flags.String("prefixes", "", "comma-delimited list of prefixes (e.g., www.,mail.) or templates with {} in place of the name (e.g., _dmarc.{}) each looked up for every input name")
 	flags.BoolVar(&gc.AlexaFormat, "alexa", false, "is input file from Alexa Top Million download")
 	flags.BoolVar(&gc.IterativeResolution, "iterative", false, "Perform own iteration instead of relying on recursive resolver")
+	flags.BoolVar(&gc.DNSSECValidate, "dnssec-validate", false, "Validate the DNSSEC chain of trust from the root for each answer. Requires --iterative")
+	primingQuery := flags.Bool("priming-query", false, "ask the root servers for the current root server set at startup and iterate from it. Requires --iterative")
+	flags.BoolVar(&gc.QNameMinimization, "qname-minimization", false, "Send each authority only as much of the name as it needs to refer onward (RFC 7816). Requires --iterative")
+	queryFlags := flags.String("query-flags", "", "comma-delimited list of header bits to set in every query, even those that make no sense in one, for conformance testing. Options: qr, aa, tc, rd, ra, z, ad, cd")
+	flags.BoolVar(&gc.NoRecurse, "no-recurse", false, "clear the recursion desired (RD) bit in queries, e.g., to ask authoritative servers directly. The response flags, including recursion available (RA), are added to the output")
+	flags.StringVar(&gc.TrustAnchorFile, "trust-anchor-file", "", "zone file with the root DS or DNSKEY records to trust for --dnssec-validate. Defaults to the IANA root anchors")
 	flags.StringVar(&gc.InputFilePath, "input-file", "-", "names to read")
+	flags.IntVar(&gc.InputColumn, "input-column", 0, "read names from this column (starting at 1) of delimited input lines, such as CSV, instead of whole lines. Quoted fields are supported")
+	inputDelimiter := flags.String("input-delimiter", ",", "column delimiter of --input-column lines, a single character or \\t for tabs")
+	flags.BoolVar(&gc.InputPassthrough, "input-passthrough", false, "attach the other columns of each --input-column line to its result, under columns")
 	flags.StringVar(&gc.OutputFilePath, "output-file", "-", "where should JSON output be saved")
 	flags.StringVar(&gc.MetadataFilePath, "metadata-file", "", "where should JSON metadata be saved")
 	flags.StringVar(&gc.LogFilePath, "log-file", "", "where should JSON logs be saved")
+	flags.StringVar(&gc.CheckpointFilePath, "checkpoint-file", "", "where should scan progress be periodically recorded")
+	flags.BoolVar(&gc.Resume, "resume", false, "skip input lines already processed according to --checkpoint-file")
+	flags.DurationVar(&gc.MaxRuntime, "max-runtime", 0, "stop looking up new names after this long (e.g., 30m), finish those in flight, and exit. 0 means no limit")
+	flags.BoolVar(&gc.DedupeInput, "dedupe-input", false, "look up each name only once per scan. Repeats are reported with status DUPLICATE")
+	flags.StringVar(&gc.NameAllowlist, "name-allowlist", "", "file of names (one per line, *.suffix for all names below suffix) to restrict lookups to. Others are reported with status SKIPPED")
+	flags.StringVar(&gc.NameBlocklist, "name-blocklist", "", "file of names (one per line, *.suffix for all names below suffix) never to look up. They are reported with status SKIPPED")
+	flags.IntVar(&gc.DedupeMaxNames, "dedupe-max-names", 0, "with --dedupe-input, remember at most this many distinct names to bound memory use. 0 means no limit")
+	flags.IntVar(&gc.Repeat, "repeat", 1, "look each name up this many times and output one record counting the distinct answer sets, e.g., to observe round-robin rotation")
+	flags.DurationVar(&gc.RepeatDelay, "repeat-delay", 0, "wait this long (e.g., 1s) between the queries of --repeat")
+	flags.StringVar(&gc.StickyServer, "sticky-server", "", "send all queries for an input name, including follow-ups such as CNAME targets, to one name server, picked by hash of the name (hash) or in turn (round-robin)")
+	compareServers := flags.String("compare-servers", "", "two comma-separated name servers (e.g., 1.1.1.1,8.8.8.8) against which to look up each name, reporting whether their answers agree")
+	flags.BoolVar(&gc.ShuffleInput, "shuffle-input", false, "look names up in random order, within a window of --shuffle-window names, to spread the load on authoritative servers")
+	flags.IntVar(&gc.ShuffleWindow, "shuffle-window", 100000, "how many input names --shuffle-input holds in memory to shuffle. 0 shuffles the entire input")
+	flags.BoolVar(&gc.PreserveOrder, "preserve-order", false, "write results in the order of the input, holding up to --reorder-window results that finished early")
+	flags.IntVar(&gc.ReorderWindow, "reorder-window", 10000, "how many results --preserve-order holds in memory while waiting for an earlier one. Results delayed beyond it are written when they arrive")
+	flags.BoolVar(&gc.Progress, "progress", false, "periodically print names processed, throughput, and (for input files) the estimated time remaining to stderr")
 
+	flags.BoolVar(&gc.DryRun, "dry-run", false, "check the configuration and print the queries that would be sent for the first 10 names, without sending any")
+	dumpSchema := flags.Bool("dump-schema", false, "print a JSON Schema of the output records of the module, at the selected verbosity and fields, and exit without looking anything up")
 	flags.StringVar(&gc.ResultVerbosity, "result-verbosity", "normal", "Sets verbosity of each output record. Options: short, normal, long, trace")
-	flags.StringVar(&gc.IncludeInOutput, "include-fields", "", "Comma separated list of fields to additionally output beyond result verbosity. Options: class, protocol, ttl, resolver, flags")
+	flags.StringVar(&gc.IncludeInOutput, "include-fields", "", "Comma separated list of fields to additionally output beyond result verbosity. Options: class, protocol, ttl, resolver, flags, duration, amplification")
+	fieldMap := flags.String("field-map", "", "comma-separated list of from:to pairs that rename top-level result fields (e.g., name:domain,data:results)")
+	minTTL := flags.Uint("min-ttl", 0, "report TTLs below this many seconds as this value. Queries and the cache are unaffected")
+	maxTTL := flags.Uint("max-ttl", 0, "report TTLs above this many seconds as this value. Queries and the cache are unaffected. 0 means no limit")
 
 	flags.IntVar(&gc.Verbosity, "verbosity", 3, "log verbosity: 1 (lowest)--5 (highest)")
 	flags.IntVar(&gc.Retries, "retries", 1, "how many times should zdns retry query if timeout or temporary failure")
+	flags.DurationVar(&gc.RetryBackoff, "retry-backoff", 0, "base delay (e.g., 100ms) before retrying after a timeout; doubled, with jitter, on each retry. 0 retries immediately")
+	flags.DurationVar(&gc.RcodeRetryBackoff, "rcode-retry-backoff", 0, "base delay before retrying after one of --retry-rcodes; doubled, with jitter, on each retry. 0 retries immediately")
+	flags.IntVar(&gc.TimeoutRetries, "timeout-retries", -1, "how many times should zdns retry query if timeout or temporary failure, in place of --retries. -1 uses --retries")
+	flags.IntVar(&gc.ServfailRetries, "servfail-retries", -1, "how many times should zdns retry query on SERVFAIL, in place of --retries; SERVFAIL is then retried even if not in --retry-rcodes. -1 leaves it to --retry-rcodes")
+	flags.IntVar(&gc.RefusedRetries, "refused-retries", -1, "how many times should zdns retry query on REFUSED, in place of --retries; REFUSED is then retried even if not in --retry-rcodes. -1 leaves it to --retry-rcodes")
+	retryRcodes := flags.String("retry-rcodes", "", "comma-delimited list of response codes (e.g., SERVFAIL,REFUSED) that should also be retried. By default only timeouts and temporary failures are")
+	flags.Float64Var(&gc.SampleRate, "sample-rate", 1, "fraction (0.0-1.0) of results to output, picked by name. All results still count in the metadata")
+	flags.Int64Var(&gc.SampleSeed, "sample-seed", 0, "seed with which --sample-rate picks results. Runs with the same seed output the same names")
+	filterStatus := flags.String("filter-status", "", "comma-delimited list of statuses (e.g., NOERROR,NXDOMAIN); only results with one of them are output. Others still count in the metadata")
 	flags.IntVar(&gc.MaxDepth, "max-depth", 10, "how deep should we recurse when performing iterative lookups")
+	flags.IntVar(&gc.MaxQueriesPerName, "max-queries-per-name", 0, "give up on a name with status QUERY_LIMIT once its lookup has sent this many queries, counting retries once. 0 means unlimited")
 	flags.IntVar(&gc.CacheSize, "cache-size", 10000, "how many items can be stored in internal recursive cache")
-	flags.StringVar(&gc.InputHandler, "input-handler", "file", "handler to input names")
-	flags.StringVar(&gc.OutputHandler, "output-handler", "file", "handler to output names")
+	flags.StringVar(&gc.CacheFile, "cache-file", "", "file in which the internal recursive cache is kept between runs. Loaded at startup, if it exists, and saved at exit")
+	flags.IntVar(&gc.WarmCache, "warm-cache", 0, "before the scan, read up to this many input names ahead and cache the delegations of their distinct parent zones. Requires --iterative. 0 disables")
+	flags.StringVar(&gc.InputHandler, "input-handler", "file", "handler to input names. Options: file, redis, s3, http")
+	flags.StringVar(&gc.RedisAddr, "redis-addr", "localhost:6379", "address of the redis server used by the redis input handler")
+	flags.StringVar(&gc.RedisPassword, "redis-password", "", "password for the redis server used by the redis input handler")
+	flags.StringVar(&gc.RedisKey, "redis-key", "zdns:input", "redis list from which the redis input handler pops names")
+	flags.StringVar(&gc.S3Input, "s3-input", "", "s3://bucket/key URL of the object from which the s3 input handler reads names. Credentials are taken from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY, the shared credentials file, or EC2 instance metadata")
+	flags.StringVar(&gc.S3Region, "s3-region", "", "AWS region of the --s3-input bucket. Defaults to $AWS_REGION, $AWS_DEFAULT_REGION, or us-east-1")
+	flags.StringVar(&gc.S3Endpoint, "s3-endpoint", "", "base URL of an S3-compatible service (e.g., http://localhost:9000) to use instead of AWS")
+	flags.StringVar(&gc.HTTPInputURL, "http-input", "", "URL from which the http input handler reads names, one per line")
+	flags.StringVar(&gc.HTTPInputBasicAuth, "http-input-user", "", "user:password with which the http input handler authenticates (basic authentication)")
+	flags.StringVar(&gc.HTTPInputToken, "http-input-token", "", "bearer token with which the http input handler authenticates")
+	flags.DurationVar(&gc.RedisIdleTimeout, "redis-idle-timeout", 30*time.Second, "stop reading input once the redis list has been empty for this long. 0 waits forever")
+	flags.StringVar(&gc.OutputHandler, "output-handler", "file", "comma-delimited list of handlers to output results to, each of which receives every result. Options: file, csv, http, avro")
+	flags.StringVar(&gc.HTTPOutputURL, "http-url", "", "URL to which the http output handler POSTs batches of results as JSON arrays")
+	flags.IntVar(&gc.HTTPBatchSize, "http-batch-size", 100, "maximum number of results the http output handler sends per request")
+	flags.DurationVar(&gc.HTTPFlushInterval, "http-flush-interval", 5*time.Second, "longest time the http output handler holds on to a partial batch. 0 waits for full batches")
+	flags.DurationVar(&gc.HTTPTimeout, "http-timeout", 30*time.Second, "how long the http output handler waits for the endpoint to accept a batch before retrying it. 0 waits forever")
+	flags.StringVar(&gc.AvroCodec, "avro-codec", "null", "codec with which the avro output handler compresses blocks. Options: null, deflate, snappy")
+	flags.StringVar(&gc.AvroSchemaFile, "avro-schema", "", "Avro schema (.avsc) with which the avro output handler writes results. Derived from the module by default")
+	flags.StringVar(&gc.OutputFormat, "output-format", "jsonl", "how the file output handler writes results. Options: jsonl (one JSON object per line), json-array")
+	flags.StringVar(&gc.OutputCompression, "output-compression", "auto", "how the file output handler compresses its output, whatever the file name. Options: auto (gzip if --output-file ends in .gz, zstd if it ends in .zst), none, gzip, zstd")
+	flags.BoolVar(&gc.ValidateOutput, "validate-output", false, "have the file output handler check that each result is valid JSON, dropping and counting those that aren't")
 	flags.BoolVar(&gc.TCPOnly, "tcp-only", false, "Only perform lookups over TCP")
 	flags.BoolVar(&gc.UDPOnly, "udp-only", false, "Only perform lookups over UDP")
-	servers_string := flags.String("name-servers", "", "List of DNS servers to use. Can be passed as comma-delimited string or via @/path/to/file. If no port is specified, defaults to 53.")
+	flags.BoolVar(&gc.NoTCPFallback, "no-tcp-fallback", false, "report truncated UDP responses, with the records they hold, as TRUNCATED instead of retrying them over TCP")
+	socks5 := flags.String("socks5", "", "tunnel queries through this SOCKS5 proxy ([user:password@]host:port). Since UDP can't be tunneled, queries are sent over TCP")
+	flags.Float64Var(&gc.MaxQPSPerServer, "max-qps-per-server", 0, "maximum queries per second to send to each name server. 0 means unlimited")
+	flags.Float64Var(&gc.BreakerThreshold, "breaker-threshold", 0, "stop picking a name server for --breaker-cooldown once this fraction (e.g., 0.5) of its last --breaker-window queries timed out or got SERVFAIL, then probe it with a single query. 0 disables the circuit breaker")
+	flags.IntVar(&gc.BreakerWindow, "breaker-window", 20, "number of recent queries to each name server over which --breaker-threshold is measured")
+	flags.DurationVar(&gc.BreakerCooldown, "breaker-cooldown", 30*time.Second, "how long a name server is skipped once its circuit breaker opens")
+	flags.StringVar(&gc.MetricsListen, "metrics-listen", "", "address (e.g., :9100) on which to serve Prometheus metrics. Disabled by default")
+	flags.IntVar(&gc.RaceServers, "race-servers", 0, "send each query to this many name servers at once and use the first answer. 0 or 1 disables racing")
+	flags.BoolVar(&gc.FullAnswer, "full-answer", false, "attach the records of every answer section received for a name, whatever their type, to its result in full_answer, alongside the module's own output")
+	flags.BoolVar(&gc.RawResponse, "raw-response", false, "attach every response, base64-encoded in wire format, to the results. Requires --result-verbosity trace")
+	flags.BoolVar(&gc.RandomizeCase, "0x20", false, "randomize the case of each query name (DNS 0x20) and report responses that don't echo it with status CASE_MISMATCH")
+	flags.IntVar(&gc.TCPMaxIdle, "tcp-max-idle", 0, "number of TCP connections to keep open to each name server for reuse by later queries. 0 opens a new connection for every query")
+	flags.IntVar(&gc.TCPMaxPipelined, "tcp-max-pipelined", 8, "number of queries that may be in flight at once on each TCP connection kept by --tcp-max-idle. 1 sends one query at a time")
+	flags.DurationVar(&gc.TCPIdleTimeout, "tcp-idle-timeout", 10*time.Second, "close TCP connections kept by --tcp-max-idle after they have been idle this long")
+	flags.BoolVar(&gc.TCPFastOpen, "tcp-fastopen", false, "open TCP connections with TCP Fast Open where the OS supports it, sending the query in the SYN")
+	flags.BoolVar(&gc.DNSCookies, "dns-cookies", false, "send DNS Cookies (RFC 7873) and report responses whose cookie doesn't match")
+	tsigKey := flags.String("tsig-key", "", "name of the TSIG key (RFC 8945) with which to sign queries and zone transfers. Responses must be signed with it too")
+	tsigAlgo := flags.String("tsig-algo", "hmac-sha256", "TSIG algorithm: hmac-md5, hmac-sha1, hmac-sha256, or hmac-sha512")
+	tsigSecret := flags.String("tsig-secret", "", "base64-encoded secret of the TSIG key")
+	moduleServers := flags.String("module-name-servers", "", "semicolon-delimited list of MODULE=servers entries (e.g., AXFR=@auth.txt;A=1.1.1.1,8.8.8.8), each giving the name servers of a module in the form of --name-servers. The entry of the module being run, if any, replaces --name-servers")
+	servers_string := flags.String("name-servers", "", "List of DNS servers to use. Can be passed as comma-delimited string or via @/path/to/file. If no port is specified, defaults to 53. Append *N (e.g., 1.1.1.1:53*3) to send a server N times its share of queries. Prefix a server with udp://, tcp://, tls://, quic:// or https:// to pick its transport.")
+	doq := flags.Bool("doq", false, "query the --name-servers given without a transport over DNS over QUIC (RFC 9250), on port 853 unless another is given, as if they were prefixed with quic://")
 	config_file := flags.String("conf-file", "/etc/resolv.conf", "config file for DNS servers")
 	timeout := flags.Int("timeout", 15, "timeout for resolving an individual name")
 	iterationTimeout := flags.Int("iteration-timeout", 4, "timeout for resolving a single iteration in an iterative query")
 	class_string := flags.String("class", "INET", "DNS class to query. Options: INET, CSNET, CHAOS, HESIOD, NONE, ANY. Default: INET.")
 	nanoSeconds := flags.Bool("nanoseconds", false, "Use nanosecond resolution timestamps")
+	clientSubnet := flags.String("client-subnet", "", "Client subnet in CIDR notation to send as an EDNS0 Client Subnet option (e.g., 192.0.2.0/24). Use 0.0.0.0/0 to ask resolvers not to use ECS.")
+	udpPayloadSize := flags.Uint("udp-payload-size", 0, "EDNS0 UDP payload size to advertise (e.g., 4096) so that large responses fit without falling back to TCP. 0 sends no EDNS0 OPT record unless another option requires one")
+	var ednsOptions stringList
+	flags.Var(&ednsOptions, "edns-option", "raw EDNS0 option to attach to queries, as code:hexvalue (e.g., 65001:c0ffee). May be repeated")
+	localAddrs := flags.String("local-addr", "", "local IP address to send queries from. Pass a comma-separated list to alternate between several; each name server needs an address of its own family")
 	// allow module to initialize and add its own flags before we parse
 	if len(os.Args) < 2 {
 		log.Fatal("No lookup module specified. Valid modules: ", zdns.ValidlookupsString())
@@ -109,22 +223,46 @@ func main() {
 	gc.Timeout = time.Duration(time.Second * time.Duration(*timeout))
 	gc.IterationTimeout = time.Duration(time.Second * time.Duration(*iterationTimeout))
 	// class initialization
-	switch strings.ToUpper(*class_string) {
-	case "INET", "IN":
-		gc.Class = dns.ClassINET
-	case "CSNET", "CS":
-		gc.Class = dns.ClassCSNET
-	case "CHAOS", "CH":
-		gc.Class = dns.ClassCHAOS
-	case "HESIOD", "HS":
-		gc.Class = dns.ClassHESIOD
-	case "NONE":
-		gc.Class = dns.ClassNONE
-	case "ANY":
-		gc.Class = dns.ClassANY
-	default:
+	if class, ok := zdns.ParseClass(*class_string); ok {
+		gc.Class = class
+	} else {
 		log.Fatal("Unknown record class specified. Valid valued are INET (default), CSNET, CHAOS, HESIOD, NONE, ANY")
-
+	}
+	if *clientSubnet != "" {
+		subnet, err := zdns.ParseClientSubnet(*clientSubnet)
+		if err != nil {
+			log.Fatalf("Invalid client subnet (%s): %s", *clientSubnet, err.Error())
+		}
+		gc.ClientSubnet = subnet
+	}
+	for _, o := range ednsOptions {
+		option, err := zdns.ParseEDNSOption(o)
+		if err != nil {
+			log.Fatalf("Invalid argument for --edns-option (%s): %s", o, err.Error())
+		}
+		gc.EDNSOptions = append(gc.EDNSOptions, option)
+	}
+	if *udpPayloadSize != 0 && (*udpPayloadSize < dns.MinMsgSize || *udpPayloadSize > dns.MaxMsgSize) {
+		log.Fatal("Invalid argument for --udp-payload-size. Must be between 512 and 65535.")
+	}
+	gc.UDPPayloadSize = uint16(*udpPayloadSize)
+	if *moduleServers != "" {
+		mapping, err := zdns.ParseModuleNameServers(*moduleServers)
+		if err != nil {
+			log.Fatal("Invalid argument for --module-name-servers: ", err.Error())
+		}
+		for module := range mapping {
+			if zdns.GetLookup(module) == nil {
+				log.Fatal("Invalid argument for --module-name-servers: unknown module ", module)
+			}
+		}
+		if servers, ok := mapping[gc.Module]; ok {
+			log.Info("using the --module-name-servers entry of ", gc.Module, ": ", servers)
+			*servers_string = servers
+		}
+	}
+	if *doq && *servers_string == "" {
+		log.Fatal("--doq requires --name-servers")
 	}
 	if *servers_string == "" {
 		// if we're doing recursive resolution, figure out default OS name servers
@@ -155,66 +293,455 @@ func main() {
 		} else {
 			ns = strings.Split(*servers_string, ",")
 		}
+		ns, weights, err := zdns.ParseNameServerWeights(ns)
+		if err != nil {
+			log.Fatal("Invalid argument for --name-servers: ", err.Error())
+		}
+		ns, weights, err = zdns.ExpandPortRanges(ns, weights)
+		if err != nil {
+			log.Fatal("Invalid argument for --name-servers: ", err.Error())
+		}
+		gc.NameServerWeights = weights
 		for i, s := range ns {
-			if !strings.Contains(s, ":") {
-				ns[i] = strings.TrimSpace(s) + ":53"
+			if transport, _ := zdns.SplitTransport(s); *doq && transport == "" {
+				s = zdns.TransportQUIC + "://" + strings.TrimSpace(s)
+			}
+			if ns[i], err = zdns.NormalizeNameServer(s); err != nil {
+				log.Fatal("Invalid argument for --name-servers: ", err.Error())
+			}
+			if transport, _ := zdns.SplitTransport(ns[i]); transport != "" && gc.IterativeResolution {
+				log.Fatal("name servers with a transport (e.g., tls://) can't be combined with --iterative")
 			}
 		}
 		gc.NameServers = ns
 		gc.NameServersSpecified = true
 	}
+	if gc.WarmCache < 0 {
+		log.Fatal("Invalid argument for --warm-cache. Must be >= 0.")
+	}
+	if gc.WarmCache > 0 && !gc.IterativeResolution {
+		log.Fatal("--warm-cache requires --iterative")
+	}
+	if *primingQuery && !gc.IterativeResolution {
+		log.Fatal("--priming-query requires --iterative")
+	}
+	if *primingQuery && gc.DryRun {
+		log.Info("--dry-run: not sending the priming query")
+	} else if *primingQuery {
+		roots, err := zdns.PrimeRootServers(gc.NameServers, gc.Timeout)
+		if err != nil {
+			log.Warn("Priming query failed, using the configured root servers: ", err.Error())
+		} else {
+			added, removed := zdns.DiffServers(gc.NameServers, roots)
+			if len(added) > 0 || len(removed) > 0 {
+				log.Warn("Live root server set differs from the configured one; added: ", added, ", removed: ", removed)
+			} else {
+				log.Info("Live root server set matches the configured one")
+			}
+			gc.NameServers = roots
+			gc.NameServerWeights = nil
+		}
+	}
+	if gc.RetryBackoff < 0 || gc.RcodeRetryBackoff < 0 {
+		log.Fatal("Invalid argument for --retry-backoff or --rcode-retry-backoff. Must be >= 0.")
+	}
+	if gc.TimeoutRetries < -1 || gc.ServfailRetries < -1 || gc.RefusedRetries < -1 {
+		log.Fatal("Invalid argument for --timeout-retries, --servfail-retries, or --refused-retries. Must be >= 0, or -1 for the default.")
+	}
+	if *retryRcodes != "" {
+		for _, rcode := range strings.Split(*retryRcodes, ",") {
+			rcode = strings.ToUpper(strings.TrimSpace(rcode))
+			if _, ok := dns.StringToRcode[rcode]; !ok || rcode == "NOERROR" {
+				log.Fatal("Invalid response code in --retry-rcodes: ", rcode)
+			}
+			gc.RetryRcodes = append(gc.RetryRcodes, zdns.Status(rcode))
+		}
+	}
+	if *filterStatus != "" {
+		statuses, err := zdns.ParseStatuses(*filterStatus)
+		if err != nil {
+			log.Fatal("Invalid argument for --filter-status: ", err.Error())
+		}
+		gc.FilterStatuses = statuses
+	}
+	if gc.SampleRate < 0 || gc.SampleRate > 1 {
+		log.Fatal("Invalid argument for --sample-rate. Must be between 0 and 1.")
+	}
+	if *localAddrs != "" {
+		addrs, err := zdns.ParseLocalAddrs(*localAddrs, gc.NameServers)
+		if err != nil {
+			log.Fatal("Invalid argument for --local-addr: ", err.Error())
+		}
+		for _, s := range gc.NameServers {
+			if transport, _ := zdns.SplitTransport(s); transport == zdns.TransportHTTPS || transport == zdns.TransportQUIC {
+				log.Fatal("--local-addr can't be combined with https:// or quic:// name servers")
+			}
+		}
+		gc.LocalAddrs = addrs
+	}
+	if gc.RaceServers < 0 || gc.RaceServers > len(gc.NameServers) {
+		log.Fatal("Invalid argument for --race-servers. Must be between 0 and the number of name servers.")
+	}
+	if gc.RaceServers > 1 && gc.IterativeResolution {
+		log.Fatal("--race-servers can't be combined with --iterative")
+	}
+	if gc.DNSCookies {
+		jar, err := zdns.NewCookieJar()
+		if err != nil {
+			log.Fatal("Unable to initialize DNS cookies: ", err.Error())
+		}
+		gc.Cookies = jar
+	}
+	if *tsigKey != "" || *tsigSecret != "" {
+		key, err := zdns.NewTSIGKey(*tsigKey, *tsigAlgo, *tsigSecret)
+		if err != nil {
+			log.Fatal("Invalid TSIG settings: ", err.Error())
+		}
+		if gc.IterativeResolution {
+			log.Fatal("--tsig-key can't be combined with --iterative")
+		}
+		for _, s := range gc.NameServers {
+			if transport, _ := zdns.SplitTransport(s); transport == zdns.TransportHTTPS || transport == zdns.TransportQUIC {
+				log.Fatal("--tsig-key can't be combined with https:// or quic:// name servers")
+			}
+		}
+		gc.TSIG = key
+	}
+	if gc.MaxQPSPerServer < 0 {
+		log.Fatal("Invalid argument for --max-qps-per-server. Must be >= 0.")
+	}
+	if gc.MaxQPSPerServer > 0 {
+		gc.RateLimiter = zdns.NewRateLimiter(gc.NameServers, gc.MaxQPSPerServer)
+	}
+	if gc.BreakerThreshold < 0 || gc.BreakerThreshold > 1 {
+		log.Fatal("Invalid argument for --breaker-threshold. Must be between 0 and 1.")
+	}
+	if gc.BreakerWindow < 1 {
+		log.Fatal("Invalid argument for --breaker-window. Must be > 0.")
+	}
+	if gc.BreakerCooldown <= 0 {
+		log.Fatal("Invalid argument for --breaker-cooldown. Must be > 0.")
+	}
+	if gc.BreakerThreshold > 0 {
+		gc.CircuitBreaker = zdns.NewCircuitBreaker(gc.NameServers, gc.BreakerThreshold, gc.BreakerWindow, gc.BreakerCooldown)
+	}
+	if gc.TCPMaxIdle < 0 {
+		log.Fatal("Invalid argument for --tcp-max-idle. Must be >= 0.")
+	}
+	if gc.TCPMaxPipelined < 1 {
+		log.Fatal("Invalid argument for --tcp-max-pipelined. Must be >= 1.")
+	}
+	if gc.TCPMaxIdle > 0 {
+		gc.TCPPool = zdns.NewConnPool(gc.TCPMaxIdle, gc.TCPMaxPipelined, gc.TCPIdleTimeout)
+	}
 	if *nanoSeconds {
 		gc.TimeFormat = time.RFC3339Nano
 	} else {
 		gc.TimeFormat = time.RFC3339
 	}
+	if *threads == "auto" {
+		if gc.MinThreads < 1 || *maxThreads < gc.MinThreads {
+			log.Fatal("Invalid argument for --min-threads or --max-threads. Must be 1 <= min <= max.")
+		}
+		gc.AutoThreads = true
+		gc.Threads = *maxThreads
+	} else if n, err := strconv.Atoi(*threads); err != nil || n < 1 {
+		log.Fatal("Invalid argument for --threads. Must be a positive number or auto.")
+	} else {
+		gc.Threads = n
+	}
 	if gc.GoMaxProcs < 0 {
 		log.Fatal("Invalid argument for --go-processes. Must be >1.")
 	}
 	if gc.GoMaxProcs != 0 {
 		runtime.GOMAXPROCS(gc.GoMaxProcs)
 	}
+	if gc.DedupeMaxNames < 0 {
+		log.Fatal("Invalid argument for --dedupe-max-names. Must be >= 0.")
+	}
+	if gc.Repeat < 1 {
+		log.Fatal("Invalid argument for --repeat. Must be >= 1.")
+	}
+	if gc.RepeatDelay < 0 {
+		log.Fatal("Invalid argument for --repeat-delay. Must be >= 0.")
+	}
+	if *compareServers != "" {
+		servers := strings.Split(*compareServers, ",")
+		if len(servers) != 2 {
+			log.Fatal("Invalid argument for --compare-servers. Must be two name servers.")
+		}
+		for i, s := range servers {
+			var err error
+			if servers[i], err = zdns.NormalizeNameServer(s); err != nil {
+				log.Fatal("Invalid argument for --compare-servers: ", err.Error())
+			}
+		}
+		if gc.IterativeResolution || gc.Repeat > 1 || gc.RaceServers > 1 {
+			log.Fatal("--compare-servers can't be combined with --iterative, --repeat, or --race-servers")
+		}
+		gc.CompareServers = servers
+	}
+	if gc.StickyServer != "" {
+		if gc.StickyServer != zdns.StickyHash && gc.StickyServer != zdns.StickyRoundRobin {
+			log.Fatal("Invalid argument for --sticky-server. Must be hash or round-robin.")
+		}
+		if gc.RaceServers > 1 || len(gc.CompareServers) > 0 {
+			log.Fatal("--sticky-server can't be combined with --race-servers or --compare-servers")
+		}
+	}
+	if gc.ShuffleWindow < 0 {
+		log.Fatal("Invalid argument for --shuffle-window. Must be >= 0.")
+	}
+	if gc.ReorderWindow < 1 {
+		log.Fatal("Invalid argument for --reorder-window. Must be >= 1.")
+	}
+	if gc.PreserveOrder && gc.ShuffleInput {
+		log.Fatal("--preserve-order can't be combined with --shuffle-input")
+	}
+	if gc.MaxQueriesPerName < 0 {
+		log.Fatal("Invalid argument for --max-queries-per-name. Must be >= 0.")
+	}
+	if gc.MaxRuntime < 0 {
+		log.Fatal("Invalid argument for --max-runtime. Must be >= 0.")
+	}
+	outputHandlers, err := zdns.ParseOutputHandlers(gc.OutputHandler)
+	if err != nil {
+		log.Fatal("Invalid argument for --output-handler: ", err.Error())
+	}
+	gc.OutputHandlers = outputHandlers
+	if gc.ValidateOutput && !hasOutputHandler(&gc, "file") {
+		log.Fatal("--validate-output requires the file output handler")
+	}
+	if hasOutputHandler(&gc, "file") && hasOutputHandler(&gc, "csv") {
+		log.Fatal("the file and csv output handlers can't be combined, since both write to --output-file")
+	}
+	if hasOutputHandler(&gc, "avro") && (hasOutputHandler(&gc, "file") || hasOutputHandler(&gc, "csv")) {
+		log.Fatal("the avro output handler can't be combined with the file or csv output handlers, since they all write to --output-file")
+	}
+	if gc.AvroCodec != "null" && gc.AvroCodec != "deflate" && gc.AvroCodec != "snappy" {
+		log.Fatal("Invalid argument for --avro-codec. Must be null, deflate, or snappy.")
+	}
+	if gc.InputColumn < 0 {
+		log.Fatal("Invalid argument for --input-column. Must be >= 0.")
+	}
+	if gc.InputColumn > 0 {
+		if gc.InputHandler != "file" {
+			log.Fatal("--input-column requires the file input handler")
+		}
+		if gc.AlexaFormat {
+			log.Fatal("--input-column and --alexa are conflicting")
+		}
+		if *inputDelimiter == `\t` {
+			*inputDelimiter = "\t"
+		}
+		delimiter := []rune(*inputDelimiter)
+		if len(delimiter) != 1 || delimiter[0] == '"' || delimiter[0] == '\r' || delimiter[0] == '\n' || delimiter[0] == utf8.RuneError {
+			log.Fatal("Invalid argument for --input-delimiter. Must be a single character other than a quote or newline.")
+		}
+		gc.InputDelimiter = delimiter[0]
+	} else if gc.InputPassthrough {
+		log.Fatal("--input-passthrough requires --input-column")
+	}
+	if gc.InputHandler == "http" && gc.HTTPInputURL == "" {
+		log.Fatal("--input-handler http requires --http-input")
+	}
+	if gc.HTTPInputBasicAuth != "" && gc.HTTPInputToken != "" {
+		log.Fatal("--http-input-user and --http-input-token are conflicting")
+	}
+	if hasOutputHandler(&gc, "http") && gc.HTTPOutputURL == "" {
+		log.Fatal("--output-handler http requires --http-url")
+	}
+	if gc.HTTPBatchSize < 1 || gc.HTTPFlushInterval < 0 {
+		log.Fatal("Invalid argument for --http-batch-size or --http-flush-interval. Must be >= 1 and >= 0.")
+	}
+	if gc.HTTPTimeout < 0 {
+		log.Fatal("Invalid argument for --http-timeout. Must be >= 0.")
+	}
+	if gc.OutputFormat != "jsonl" && gc.OutputFormat != "json-array" {
+		log.Fatal("Invalid argument for --output-format. Must be jsonl or json-array.")
+	}
+	switch gc.OutputCompression {
+	case "auto", "none", "gzip", "zstd":
+	default:
+		log.Fatal("Invalid argument for --output-compression. Must be auto, none, gzip, or zstd.")
+	}
+	if gc.OutputFormat == "json-array" && gc.Resume {
+		log.Fatal("--resume can't append to a JSON array; use --output-format jsonl")
+	}
+	if gc.Resume && gc.CheckpointFilePath == "" {
+		log.Fatal("--resume requires --checkpoint-file")
+	}
 	if gc.UDPOnly && gc.TCPOnly {
 		log.Fatal("TCP Only and UDP Only are conflicting")
 	}
+	if *socks5 != "" {
+		if gc.UDPOnly || gc.NoTCPFallback {
+			log.Fatal("--socks5 only tunnels TCP; it can't be combined with --udp-only or --no-tcp-fallback")
+		}
+		for _, servers := range [][]string{gc.NameServers, gc.CompareServers} {
+			for _, s := range servers {
+				if transport, _ := zdns.SplitTransport(s); transport == zdns.TransportUDP || transport == zdns.TransportQUIC {
+					log.Fatal("--socks5 can't be combined with udp:// or quic:// name servers")
+				}
+			}
+		}
+		p, err := zdns.ParseSOCKS5Proxy(*socks5)
+		if err != nil {
+			log.Fatal("Invalid argument for --socks5: ", err.Error())
+		}
+		gc.SOCKS5 = p
+		gc.TCPOnly = true
+	}
+	if gc.NoTCPFallback && gc.TCPOnly {
+		log.Fatal("--no-tcp-fallback can't be combined with --tcp-only")
+	}
+	if gc.DNSSECValidate && !gc.IterativeResolution {
+		log.Fatal("--dnssec-validate requires --iterative")
+	}
+	if gc.QNameMinimization && !gc.IterativeResolution {
+		log.Fatal("--qname-minimization requires --iterative")
+	}
+	if gc.NoRecurse && gc.IterativeResolution {
+		log.Fatal("--no-recurse and --iterative are conflicting")
+	}
+	if *queryFlags != "" {
+		f, err := zdns.ParseQueryFlags(*queryFlags)
+		if err != nil {
+			log.Fatal("Invalid argument for --query-flags: ", err.Error())
+		}
+		if f.RecursionDesired && gc.NoRecurse {
+			log.Fatal("--query-flags rd and --no-recurse are conflicting")
+		}
+		gc.QueryFlags = f
+	}
 	// Output Groups are defined by a base + any additional fields that the user wants
 	groups := strings.Split(gc.IncludeInOutput, ",")
 	if gc.ResultVerbosity != "short" && gc.ResultVerbosity != "normal" && gc.ResultVerbosity != "long" && gc.ResultVerbosity != "trace" {
 		log.Fatal("Invalid result verbosity. Options: short, normal, long, trace")
 	}
+	if gc.RawResponse && gc.ResultVerbosity != "trace" {
+		log.Fatal("--raw-response requires --result-verbosity trace")
+	}
 
 	gc.OutputGroups = append(gc.OutputGroups, gc.ResultVerbosity)
 	gc.OutputGroups = append(gc.OutputGroups, groups...)
+	if gc.NoRecurse {
+		gc.OutputGroups = append(gc.OutputGroups, "flags")
+	}
+	if *minTTL > math.MaxUint32 || *maxTTL > math.MaxUint32 {
+		log.Fatal("Invalid argument for --min-ttl or --max-ttl. Must fit in 32 bits.")
+	}
+	if *maxTTL > 0 && *minTTL > *maxTTL {
+		log.Fatal("--min-ttl can't be larger than --max-ttl")
+	}
+	gc.MinTTL = uint32(*minTTL)
+	gc.MaxTTL = uint32(*maxTTL)
+	gc.NameSuffix = strings.TrimPrefix(gc.NameSuffix, ".")
+	if strings.Contains(gc.NameSuffix, "..") {
+		log.Fatal("Invalid argument for --suffix. Must be a domain name.")
+	}
+	if *prefixes != "" {
+		if gc.NamePrefix != "" {
+			log.Fatal("--prefix and --prefixes are conflicting")
+		}
+		gc.NamePrefixes = strings.Split(*prefixes, ",")
+	}
+	if *fieldMap != "" {
+		m, err := zdns.ParseFieldMap(*fieldMap)
+		if err != nil {
+			log.Fatal("Invalid argument for --field-map: ", err.Error())
+		}
+		if _, ok := m["data"]; ok && hasOutputHandler(&gc, "csv") {
+			log.Fatal("--field-map can't rename data with the csv output handler, which flattens it into data.* columns")
+		}
+		gc.FieldMap = m
+	}
+	if *dumpSchema {
+		schema, err := json.MarshalIndent(zdns.ResultSchema(&gc, factory), "", "  ")
+		if err != nil {
+			log.Fatal("Unable to marshal JSON schema: ", err.Error())
+		}
+		fmt.Println(string(schema))
+		return
+	}
 
 	if len(flags.Args()) > 0 {
 		stat, _ := os.Stdin.Stat()
 		// If stdin is piped from the terminal, and we havent specified a file, and if we have unparsed args
 		// use them for a dig like reslution
-		if (stat.Mode()&os.ModeCharDevice) != 0 && gc.InputFilePath == "-" && len(flags.Args()) == 1 {
-			gc.PassedName = flags.Args()[0]
+		if (stat.Mode()&os.ModeCharDevice) != 0 && gc.InputFilePath == "-" {
+			gc.PassedNames = flags.Args()
 		} else {
 			log.Fatal("Unused command line flags: ", flags.Args())
 		}
 	}
 
 	// Seeding for RandomNameServer()
-	rand.Seed(time.Now().UnixNano())
+	rand.Seed(zdns.RandomSeed())
 	
 	// some modules require multiple passes over a file (this is really just the case for zone files)
 	if !factory.AllowStdIn() && gc.InputFilePath == "-" {
 		log.Fatal("Specified module does not allow reading from stdin")
 	}
 
+	if gc.DryRun {
+		if gc.InputHandler != "file" {
+			log.Fatal("--dry-run only supports the file input handler")
+		}
+		// plan the queries one name at a time, and print them instead of
+		// sending results anywhere
+		gc.QueryPlan = new(zdns.QueryPlan)
+		gc.Threads = 1
+		gc.AutoThreads = false
+		gc.ShuffleInput = false
+		gc.FilterStatuses = nil
+		gc.SampleRate = 1
+		gc.OutputHandlers = []string{"file"}
+		gc.MetricsListen = ""
+	}
+
+	if gc.MetricsListen != "" {
+		gc.Metrics = zdns.NewMetrics()
+		if err := gc.Metrics.Listen(gc.MetricsListen); err != nil {
+			log.Fatalf("Unable to start metrics endpoint (%s): %s", gc.MetricsListen, err.Error())
+		}
+	}
+
 	// allow the factory to initialize itself
 	if err := factory.Initialize(&gc); err != nil {
 		log.Fatal("Factory was unable to initialize:", err.Error())
 	}
-	// run it.
-	if err := zdns.DoLookups(&factory, &gc); err != nil {
-		log.Fatal("Unable to run lookups:", err.Error())
-	}
+	// run it. Output that couldn't be written is reported after the factory
+	// has finalized, so that the cache and metrics are still saved.
+	lookupErr := zdns.DoLookups(&factory, &gc)
+	gc.TCPPool.Close()
 	// allow the factory to initialize itself
 	if err := factory.Finalize(); err != nil {
 		log.Fatal("Factory was unable to finalize:", err.Error())
 	}
+	if lookupErr != nil {
+		log.Fatal("Unable to run lookups:", lookupErr.Error())
+	}
+}
+
+// hasOutputHandler reports whether name is among the --output-handler list.
+func hasOutputHandler(gc *zdns.GlobalConf, name string) bool {
+	for _, h := range gc.OutputHandlers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stringList collects the values of a flag that may be given more than once.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }